@@ -29,6 +29,19 @@ type Font struct {
 	Style Style
 	// Weight is the text weight.
 	Weight Weight
+	// Variations sets the axis values used to instantiate variable fonts. Fonts
+	// without variation axes ignore it, and variations with tags that are not
+	// four bytes long are ignored.
+	Variations []Variation
+}
+
+// Variation is the value of a single variation axis of a variable font.
+type Variation struct {
+	// Tag is the four letter tag of the axis, such as "wght", "wdth", "opsz",
+	// "slnt" or a custom axis tag.
+	Tag string
+	// Value is the position on the axis, in the design units of the axis.
+	Value float32
 }
 
 // Face is an opaque handle to a typeface. The concrete implementation depends
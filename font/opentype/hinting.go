@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package opentype
+
+import (
+	"bytes"
+
+	"github.com/go-text/typesetting/opentype/loader"
+)
+
+// HintingFont returns a standalone font holding the TrueType outlines, metrics
+// and hinting instructions of the font, for hinting its glyphs with a TrueType
+// instruction interpreter such as github.com/golang/freetype/truetype. Glyphs
+// keep their indices, and the font maps no characters. Fonts of collections are
+// extracted from the collection.
+//
+// HintingFont returns nil for fonts without TrueType outlines, such as fonts
+// with CFF outlines. The outlines are those of the default instance of
+// variable fonts.
+func (f Face) HintingFont() []byte {
+	if f.src == nil {
+		return nil
+	}
+	lds, err := loader.NewLoaders(bytes.NewReader(f.src.data))
+	if err != nil || f.src.index >= len(lds) {
+		return nil
+	}
+	return hintingFont(lds[f.src.index])
+}
+
+var (
+	// hintingTables are the tables required to hint glyphs.
+	hintingTables = []loader.Tag{
+		tagHead,
+		loader.MustNewTag("hhea"),
+		loader.MustNewTag("hmtx"),
+		tagMaxp,
+		tagLoca,
+		tagGlyf,
+	}
+	// hintingPrograms are the optional tables of the hinting instructions and
+	// their values, and the OS/2 table read for vertical metrics.
+	hintingPrograms = []loader.Tag{
+		loader.MustNewTag("cvt "),
+		loader.MustNewTag("fpgm"),
+		loader.MustNewTag("prep"),
+		loader.MustNewTag("OS/2"),
+	}
+)
+
+// hintingFont returns the hinting font of the font in ld, or nil if it has no
+// TrueType outlines.
+func hintingFont(ld *loader.Loader) []byte {
+	tabs := make(map[loader.Tag][]byte)
+	for _, tag := range hintingTables {
+		t, err := ld.RawTable(tag)
+		if err != nil {
+			return nil
+		}
+		tabs[tag] = t
+	}
+	for _, tag := range hintingPrograms {
+		if t, err := ld.RawTable(tag); err == nil {
+			tabs[tag] = t
+		}
+	}
+	// Interpreters require a character map, even an empty one.
+	tabs[tagCmap] = encodeCmap(nil)
+	const trueTypeVersion = 0x00010000
+	return writeFont(trueTypeVersion, tabs)
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package opentype
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-text/typesetting/opentype/loader"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestHintingFont(t *testing.T) {
+	face, err := Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := face.HintingFont()
+	if src == nil {
+		t.Fatal("expected a hinting font")
+	}
+	fonts, err := ParseCollection(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fonts[0].Face.(Face).HintingFont(), src) {
+		t.Error("expected the font of a collection to have the same hinting font")
+	}
+	hinting, err := truetype.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got truetype.GlyphBuf
+	gid := orig.Index('g')
+	if err := want.Load(orig, fixed.I(13), gid, font.HintingFull); err != nil {
+		t.Fatal(err)
+	}
+	if err := got.Load(hinting, fixed.I(13), gid, font.HintingFull); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Points) != len(want.Points) {
+		t.Fatalf("expected %d points, got %d", len(want.Points), len(got.Points))
+	}
+	for i := range want.Points {
+		if got.Points[i] != want.Points[i] {
+			t.Errorf("point %d: expected %v, got %v", i, want.Points[i], got.Points[i])
+		}
+	}
+}
+
+func TestHintingFontCFF(t *testing.T) {
+	version, tabs, err := readTables(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(tabs, tagGlyf)
+	delete(tabs, tagLoca)
+	tabs[tagCFF] = []byte{1, 0, 4, 4}
+	ld, err := loader.NewLoader(bytes.NewReader(writeFont(version, tabs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src := hintingFont(ld); src != nil {
+		t.Error("expected no hinting font for CFF outlines")
+	}
+}
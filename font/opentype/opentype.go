@@ -20,6 +20,7 @@ import (
 	fontapi "github.com/go-text/typesetting/opentype/api/font"
 	"github.com/go-text/typesetting/opentype/api/metadata"
 	"github.com/go-text/typesetting/opentype/loader"
+	"github.com/go-text/typesetting/opentype/tables"
 )
 
 // Face is a thread-safe representation of a loaded font. For efficiency, applications
@@ -27,7 +28,38 @@ import (
 // text shapers.
 type Face struct {
 	face font.Font
-	font giofont.Font
+	// font is the metadata of the font. It is a pointer to keep Face
+	// comparable.
+	font *giofont.Font
+	// axes lists the variation axes of the font. It is a pointer to keep
+	// Face comparable.
+	axes *[]Axis
+	// color holds the tables of the color glyphs of the font, if any.
+	color *colorTables
+	// src is the font file of the face, kept to extract its hinting font.
+	src *source
+}
+
+// source locates a font within a font file or collection.
+type source struct {
+	data  []byte
+	index int
+}
+
+// colorTables holds the raw COLR and CPAL tables of a font.
+type colorTables struct {
+	colr, cpal []byte
+}
+
+// Axis describes a variation axis of a variable font. Fonts are instantiated
+// at particular axis values through the Variations field of [giofont.Font].
+type Axis struct {
+	// Tag is the four letter tag of the axis, such as "wght".
+	Tag string
+	// Min and Max are the range of values supported by the axis, in design units.
+	Min, Max float32
+	// Default is the position of the default instance of the font on the axis.
+	Default float32
 }
 
 // Parse constructs a Face from source bytes.
@@ -41,8 +73,11 @@ func Parse(src []byte) (Face, error) {
 		return Face{}, fmt.Errorf("failed parsing truetype font: %w", err)
 	}
 	return Face{
-		face: font,
-		font: md,
+		face:  font,
+		font:  &md,
+		axes:  parseAxes(ld),
+		color: parseColorTables(ld),
+		src:   &source{data: src},
 	}, nil
 }
 
@@ -64,8 +99,11 @@ func ParseCollection(src []byte) ([]giofont.FontFace, error) {
 			return nil, fmt.Errorf("reading font %d of collection: %s", i, err)
 		}
 		ff := Face{
-			face: face,
-			font: md,
+			face:  face,
+			font:  &md,
+			axes:  parseAxes(ld),
+			color: parseColorTables(ld),
+			src:   &source{data: src, index: i},
 		}
 		out[i] = giofont.FontFace{
 			Face: ff,
@@ -104,6 +142,57 @@ func parseLoader(ld *loader.Loader) (font.Font, giofont.Font, error) {
 	return ft, data, nil
 }
 
+// parseAxes parses the variation axes of the font in the loader, if any.
+func parseAxes(ld *loader.Loader) *[]Axis {
+	raw, err := ld.RawTable(loader.MustNewTag("fvar"))
+	if err != nil {
+		return nil
+	}
+	fvar, _, err := tables.ParseFvar(raw)
+	if err != nil || len(fvar.FvarRecords.Axis) == 0 {
+		return nil
+	}
+	axes := make([]Axis, 0, len(fvar.FvarRecords.Axis))
+	for _, a := range fvar.FvarRecords.Axis {
+		axes = append(axes, Axis{
+			Tag:     a.Tag.String(),
+			Min:     a.Minimum,
+			Max:     a.Maximum,
+			Default: a.Default,
+		})
+	}
+	return &axes
+}
+
+// parseColorTables reads the color tables of the font in the loader, if any.
+func parseColorTables(ld *loader.Loader) *colorTables {
+	colr, err := ld.RawTable(loader.MustNewTag("COLR"))
+	if err != nil {
+		return nil
+	}
+	cpal, _ := ld.RawTable(loader.MustNewTag("CPAL"))
+	return &colorTables{colr: colr, cpal: cpal}
+}
+
+// ColorTables returns the raw COLR and CPAL tables of the font, which describe its
+// color glyphs. It returns nil tables for fonts without color glyphs. The tables
+// must not be modified.
+func (f Face) ColorTables() (colr, cpal []byte) {
+	if f.color == nil {
+		return nil, nil
+	}
+	return f.color.colr, f.color.cpal
+}
+
+// Axes returns the variation axes of the font. It returns nil for fonts that
+// are not variable.
+func (f Face) Axes() []Axis {
+	if f.axes == nil {
+		return nil
+	}
+	return append([]Axis(nil), *f.axes...)
+}
+
 // Face returns a thread-unsafe wrapper for this Face suitable for use by a single shaper.
 // Face many be invoked any number of times and is safe so long as each return value is
 // only used by one goroutine.
@@ -116,7 +205,10 @@ func (f Face) Face() font.Face {
 // BUG(whereswaldon): the only Variant that can be detected automatically is
 // "Mono".
 func (f Face) Font() giofont.Font {
-	return f.font
+	if f.font == nil {
+		return giofont.Font{}
+	}
+	return *f.font
 }
 
 func gioStyle(s metadata.Style) giofont.Style {
@@ -0,0 +1,658 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package opentype
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	fontapi "github.com/go-text/typesetting/opentype/api/font"
+	"github.com/go-text/typesetting/opentype/loader"
+	"github.com/go-text/typesetting/opentype/tables"
+)
+
+// GlyphSet selects the glyphs of a font kept by Subset.
+type GlyphSet struct {
+	// Runes are the characters whose glyphs are kept.
+	Runes []rune
+	// Glyphs are the indices of the glyphs to keep, such as the indices
+	// reported by text.Shaper.GlyphFace for laid out text.
+	Glyphs []uint16
+}
+
+// Subset returns the font in src reduced to the glyphs of set, in a form
+// loadable by Parse. Collections are not supported.
+//
+// Glyphs keep their indices, such that the shaping and metrics tables remain
+// valid and are preserved. Besides the selected glyphs, the subset keeps the
+// glyphs they may be substituted with by the GSUB table, the components of
+// composite glyphs, the layers of COLRv0 color glyphs and the glyphs painted by
+// COLRv1 color glyphs. The character map of the subset only maps the characters
+// of kept glyphs, and the outlines of the other glyphs are removed. Only
+// TrueType outlines can be removed, and Subset returns an error for fonts with
+// CFF outlines.
+func Subset(src []byte, set GlyphSet) ([]byte, error) {
+	version, tabs, err := readTables(src)
+	if err != nil {
+		return nil, err
+	}
+	_, cff := tabs[tagCFF]
+	_, cff2 := tabs[tagCFF2]
+	if cff || cff2 {
+		return nil, errors.New("opentype: subsetting fonts with CFF outlines is not supported")
+	}
+	ld, err := loader.NewLoader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	ft, err := fontapi.NewFont(ld)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing truetype font: %w", err)
+	}
+	maxp := tabs[tagMaxp]
+	if len(maxp) < 6 {
+		return nil, errors.New("opentype: invalid maxp table")
+	}
+	s := subsetter{keep: make([]bool, binary.BigEndian.Uint16(maxp[4:]))}
+	s.add(0) // .notdef
+	for _, r := range set.Runes {
+		if gid, ok := ft.Cmap.Lookup(r); ok {
+			s.add(uint32(gid))
+		}
+	}
+	for _, g := range set.Glyphs {
+		s.add(uint32(g))
+	}
+	var glyphs [][]byte
+	if _, ok := tabs[tagGlyf]; ok {
+		glyphs, err = readGlyphs(tabs[tagHead], tabs[tagLoca], tabs[tagGlyf], len(s.keep))
+		if err != nil {
+			return nil, err
+		}
+	}
+	for {
+		s.added = false
+		s.closeGSUB(ft.GSUB)
+		s.closeColor(tabs[tagCOLR])
+		for gid, g := range glyphs {
+			if s.keep[gid] {
+				s.closeComposite(g)
+			}
+		}
+		if !s.added {
+			break
+		}
+	}
+
+	tabs[tagCmap] = s.cmap(ft)
+	if glyphs != nil {
+		head := append([]byte(nil), tabs[tagHead]...)
+		tabs[tagGlyf], tabs[tagLoca] = s.glyf(glyphs)
+		// The rewritten loca table is in the long format.
+		binary.BigEndian.PutUint16(head[50:], 1)
+		tabs[tagHead] = head
+		if gvar, ok := tabs[tagGvar]; ok {
+			if gvar, err = s.gvar(gvar); err != nil {
+				return nil, err
+			}
+			tabs[tagGvar] = gvar
+		}
+	}
+	// The signature of the font no longer applies.
+	delete(tabs, loader.MustNewTag("DSIG"))
+	return writeFont(version, tabs), nil
+}
+
+var (
+	tagCFF  = loader.MustNewTag("CFF ")
+	tagCFF2 = loader.MustNewTag("CFF2")
+	tagCmap = loader.MustNewTag("cmap")
+	tagCOLR = loader.MustNewTag("COLR")
+	tagGlyf = loader.MustNewTag("glyf")
+	tagGvar = loader.MustNewTag("gvar")
+	tagHead = loader.MustNewTag("head")
+	tagLoca = loader.MustNewTag("loca")
+	tagMaxp = loader.MustNewTag("maxp")
+)
+
+// subsetter tracks the glyphs kept by Subset.
+type subsetter struct {
+	keep []bool
+	// added reports whether a glyph was kept since it was last reset.
+	added bool
+}
+
+func (s *subsetter) add(gid uint32) {
+	if gid < uint32(len(s.keep)) && !s.keep[gid] {
+		s.keep[gid] = true
+		s.added = true
+	}
+}
+
+func (s *subsetter) kept(gid uint32) bool {
+	return gid < uint32(len(s.keep)) && s.keep[gid]
+}
+
+// closeGSUB keeps the glyphs that kept glyphs may be substituted with.
+// Substitutions are followed regardless of their context.
+func (s *subsetter) closeGSUB(gsub fontapi.GSUB) {
+	for _, lookup := range gsub.Lookups {
+		for _, sub := range lookup.Subtables {
+			s.closeSubstitution(sub)
+		}
+	}
+}
+
+func (s *subsetter) closeSubstitution(sub tables.GSUBLookup) {
+	cov := sub.Cov()
+	for gid, keep := range s.keep {
+		if !keep {
+			continue
+		}
+		idx, ok := cov.Index(tables.GlyphID(gid))
+		if !ok {
+			continue
+		}
+		switch sub := sub.(type) {
+		case tables.SingleSubs:
+			switch data := sub.Data.(type) {
+			case tables.SingleSubstData1:
+				s.add(uint32(uint16(int(gid) + int(data.DeltaGlyphID))))
+			case tables.SingleSubstData2:
+				if idx < len(data.SubstituteGlyphIDs) {
+					s.add(uint32(data.SubstituteGlyphIDs[idx]))
+				}
+			}
+		case tables.MultipleSubs:
+			if idx < len(sub.Sequences) {
+				for _, g := range sub.Sequences[idx].SubstituteGlyphIDs {
+					s.add(uint32(g))
+				}
+			}
+		case tables.AlternateSubs:
+			if idx < len(sub.AlternateSets) {
+				for _, g := range sub.AlternateSets[idx].AlternateGlyphIDs {
+					s.add(uint32(g))
+				}
+			}
+		case tables.LigatureSubs:
+			if idx >= len(sub.LigatureSets) {
+				continue
+			}
+		ligatures:
+			for _, lig := range sub.LigatureSets[idx].Ligatures {
+				for _, c := range lig.ComponentGlyphIDs {
+					if !s.kept(uint32(c)) {
+						continue ligatures
+					}
+				}
+				s.add(uint32(lig.LigatureGlyph))
+			}
+		case tables.ReverseChainSingleSubs:
+			if idx < len(sub.SubstituteGlyphIDs) {
+				s.add(uint32(sub.SubstituteGlyphIDs[idx]))
+			}
+		}
+	}
+}
+
+// closeColor keeps the layers of kept COLRv0 color glyphs, and the glyphs
+// painted by kept COLRv1 color glyphs.
+func (s *subsetter) closeColor(colr []byte) {
+	if len(colr) < 14 {
+		return
+	}
+	be := binary.BigEndian
+	if be.Uint16(colr) >= 1 && len(colr) >= 22 {
+		s.closeColorV1(colr, be.Uint32(colr[14:]), be.Uint32(colr[18:]))
+	}
+	numBase := int(be.Uint16(colr[2:]))
+	baseOff, layerOff := int(be.Uint32(colr[4:])), int(be.Uint32(colr[8:]))
+	numLayers := int(be.Uint16(colr[12:]))
+	for i := 0; i < numBase; i++ {
+		rec := baseOff + 6*i
+		if rec+6 > len(colr) {
+			return
+		}
+		if !s.kept(uint32(be.Uint16(colr[rec:]))) {
+			continue
+		}
+		first, n := int(be.Uint16(colr[rec+2:])), int(be.Uint16(colr[rec+4:]))
+		for l := first; l < first+n && l < numLayers; l++ {
+			layer := layerOff + 4*l
+			if layer+4 > len(colr) {
+				return
+			}
+			s.add(uint32(be.Uint16(colr[layer:])))
+		}
+	}
+}
+
+// closeColorV1 keeps the glyphs referenced by the paint graphs of the kept
+// glyphs of the COLRv1 base glyph list at offset list, whose layer list is at
+// offset layerList.
+func (s *subsetter) closeColorV1(colr []byte, list, layerList uint32) {
+	be := binary.BigEndian
+	l := int(list)
+	if list == 0 || l+4 > len(colr) {
+		return
+	}
+	// visited tracks the paints already walked, which may be shared and may
+	// even form cycles.
+	visited := make(map[uint32]bool)
+	n := int(be.Uint32(colr[l:]))
+	for i := 0; i < n; i++ {
+		rec := l + 4 + 6*i
+		if rec+6 > len(colr) {
+			return
+		}
+		if s.kept(uint32(be.Uint16(colr[rec:]))) {
+			s.closePaint(colr, layerList, list+be.Uint32(colr[rec+2:]), visited)
+		}
+	}
+}
+
+// closePaint keeps the glyphs of the PaintGlyph and PaintColrGlyph paints of the
+// paint graph at offset off. The color glyphs of PaintColrGlyph paints are kept,
+// such that their paints are walked by the next round of closeColor.
+func (s *subsetter) closePaint(colr []byte, layerList, off uint32, visited map[uint32]bool) {
+	o := int(off)
+	if visited[off] || o >= len(colr) {
+		return
+	}
+	visited[off] = true
+	be := binary.BigEndian
+	need := func(n int) bool { return o+n <= len(colr) }
+	// child walks the paint at the 24 bit offset at byte at of the paint.
+	child := func(at int) {
+		c := colr[o+at:]
+		s.closePaint(colr, layerList, off+(uint32(c[0])<<16|uint32(c[1])<<8|uint32(c[2])), visited)
+	}
+	switch format := colr[o]; {
+	case format == 1: // PaintColrLayers
+		l := int(layerList)
+		if !need(6) || layerList == 0 || l+4 > len(colr) {
+			return
+		}
+		n, first := int(colr[o+1]), int(be.Uint32(colr[o+2:]))
+		for i := first; i < first+n && i < int(be.Uint32(colr[l:])); i++ {
+			p := l + 4 + 4*i
+			if p+4 > len(colr) {
+				return
+			}
+			s.closePaint(colr, layerList, layerList+be.Uint32(colr[p:]), visited)
+		}
+	case format == 10: // PaintGlyph
+		if need(6) {
+			s.add(uint32(be.Uint16(colr[o+4:])))
+			child(1)
+		}
+	case format == 11: // PaintColrGlyph
+		if need(3) {
+			s.add(uint32(be.Uint16(colr[o+1:])))
+		}
+	case format >= 12 && format <= 31: // Transformations of a single paint.
+		if need(4) {
+			child(1)
+		}
+	case format == 32: // PaintComposite
+		if need(8) {
+			child(1)
+			child(5)
+		}
+	}
+}
+
+// Flags of the components of composite glyphs.
+const (
+	componentArgsAreWords = 0x0001
+	componentHasScale     = 0x0008
+	componentMoreFollow   = 0x0020
+	componentHasXYScale   = 0x0040
+	componentHasTwoByTwo  = 0x0080
+)
+
+// closeComposite keeps the components of the glyph with outline data g.
+func (s *subsetter) closeComposite(g []byte) {
+	be := binary.BigEndian
+	// Composite glyphs have a negative number of contours, and their
+	// components follow the 10 byte glyph header.
+	if len(g) < 10 || int16(be.Uint16(g)) >= 0 {
+		return
+	}
+	for off := 10; off+4 <= len(g); {
+		flags := be.Uint16(g[off:])
+		s.add(uint32(be.Uint16(g[off+2:])))
+		off += 4
+		if flags&componentArgsAreWords != 0 {
+			off += 4
+		} else {
+			off += 2
+		}
+		switch {
+		case flags&componentHasScale != 0:
+			off += 2
+		case flags&componentHasXYScale != 0:
+			off += 4
+		case flags&componentHasTwoByTwo != 0:
+			off += 8
+		}
+		if flags&componentMoreFollow == 0 {
+			break
+		}
+	}
+}
+
+// readGlyphs splits the glyf table into the outline data of each of the n
+// glyphs, as located by the loca table.
+func readGlyphs(head, loca, glyf []byte, n int) ([][]byte, error) {
+	if len(head) < 54 {
+		return nil, errors.New("opentype: invalid head table")
+	}
+	be := binary.BigEndian
+	long := be.Uint16(head[50:]) != 0
+	offset := func(i int) (int, bool) {
+		if long {
+			if 4*i+4 > len(loca) {
+				return 0, false
+			}
+			return int(be.Uint32(loca[4*i:])), true
+		}
+		if 2*i+2 > len(loca) {
+			return 0, false
+		}
+		return 2 * int(be.Uint16(loca[2*i:])), true
+	}
+	glyphs := make([][]byte, n)
+	for i := range glyphs {
+		start, ok1 := offset(i)
+		end, ok2 := offset(i + 1)
+		if !ok1 || !ok2 || start > end || end > len(glyf) {
+			return nil, errors.New("opentype: invalid loca table")
+		}
+		glyphs[i] = glyf[start:end]
+	}
+	return glyphs, nil
+}
+
+// glyf returns the glyf and long loca tables of the kept glyphs.
+func (s *subsetter) glyf(glyphs [][]byte) (glyf, loca []byte) {
+	be := binary.BigEndian
+	loca = make([]byte, 0, 4*(len(glyphs)+1))
+	for gid, g := range glyphs {
+		loca = be.AppendUint32(loca, uint32(len(glyf)))
+		if s.keep[gid] {
+			glyf = append(glyf, g...)
+			for len(glyf)%4 != 0 {
+				glyf = append(glyf, 0)
+			}
+		}
+	}
+	loca = be.AppendUint32(loca, uint32(len(glyf)))
+	return glyf, loca
+}
+
+// gvar returns the gvar table with the variations of the removed glyphs
+// removed.
+func (s *subsetter) gvar(gvar []byte) ([]byte, error) {
+	be := binary.BigEndian
+	const headerLength = 20
+	if len(gvar) < headerLength {
+		return nil, errors.New("opentype: invalid gvar table")
+	}
+	count := int(be.Uint16(gvar[12:]))
+	long := be.Uint16(gvar[14:])&1 != 0
+	dataOff := int(be.Uint32(gvar[16:]))
+	sharedOff := int(be.Uint32(gvar[8:]))
+	sharedLen := int(be.Uint16(gvar[4:])) * int(be.Uint16(gvar[6:])) * 2
+	offset := func(i int) (int, bool) {
+		if long {
+			o := headerLength + 4*i
+			if o+4 > len(gvar) {
+				return 0, false
+			}
+			return int(be.Uint32(gvar[o:])), true
+		}
+		o := headerLength + 2*i
+		if o+2 > len(gvar) {
+			return 0, false
+		}
+		return 2 * int(be.Uint16(gvar[o:])), true
+	}
+	if sharedOff+sharedLen > len(gvar) {
+		return nil, errors.New("opentype: invalid gvar table")
+	}
+	// Lay out the header, the long offsets, the shared tuples and the
+	// variation data.
+	out := append([]byte(nil), gvar[:headerLength]...)
+	be.PutUint16(out[14:], be.Uint16(gvar[14:])|1)
+	newSharedOff := headerLength + 4*(count+1)
+	newDataOff := newSharedOff + sharedLen
+	be.PutUint32(out[8:], uint32(newSharedOff))
+	be.PutUint32(out[16:], uint32(newDataOff))
+	var data []byte
+	for gid := 0; gid < count; gid++ {
+		out = be.AppendUint32(out, uint32(len(data)))
+		start, ok1 := offset(gid)
+		end, ok2 := offset(gid + 1)
+		if !ok1 || !ok2 || start > end || dataOff+end > len(gvar) {
+			return nil, errors.New("opentype: invalid gvar table")
+		}
+		if s.kept(uint32(gid)) {
+			data = append(data, gvar[dataOff+start:dataOff+end]...)
+		}
+	}
+	out = be.AppendUint32(out, uint32(len(data)))
+	out = append(out, gvar[sharedOff:sharedOff+sharedLen]...)
+	return append(out, data...), nil
+}
+
+// cmap returns a cmap table mapping the characters of the kept glyphs.
+func (s *subsetter) cmap(ft *fontapi.Font) []byte {
+	type mapping struct {
+		r   rune
+		gid uint32
+	}
+	var maps []mapping
+	for it := ft.Cmap.Iter(); it.Next(); {
+		r, gid := it.Char()
+		if gid != 0 && s.kept(uint32(gid)) {
+			maps = append(maps, mapping{r, uint32(gid)})
+		}
+	}
+	sort.Slice(maps, func(i, j int) bool { return maps[i].r < maps[j].r })
+	// Group runs of consecutive characters of consecutive glyphs.
+	var groups []cmapGroup
+	for _, m := range maps {
+		if n := len(groups); n > 0 {
+			g := &groups[n-1]
+			if m.r == g.end+1 && m.gid == g.gid+uint32(m.r-g.start) {
+				g.end = m.r
+				continue
+			}
+		}
+		groups = append(groups, cmapGroup{start: m.r, end: m.r, gid: m.gid})
+	}
+	return encodeCmap(groups)
+}
+
+// cmapGroup maps the characters from start to end to consecutive glyphs from
+// gid.
+type cmapGroup struct {
+	start, end rune
+	gid        uint32
+}
+
+// encodeCmap returns a cmap table of the groups, with a format 4 subtable for
+// the basic multilingual plane and a format 12 subtable for all characters. The
+// format 4 subtable is left out if its segments don't fit its 16 bit length.
+func encodeCmap(groups []cmapGroup) []byte {
+	be := binary.BigEndian
+	f12 := be.AppendUint16(nil, 12)
+	f12 = be.AppendUint16(f12, 0)
+	f12 = be.AppendUint32(f12, uint32(16+12*len(groups)))
+	f12 = be.AppendUint32(f12, 0)
+	f12 = be.AppendUint32(f12, uint32(len(groups)))
+	for _, g := range groups {
+		f12 = be.AppendUint32(f12, uint32(g.start))
+		f12 = be.AppendUint32(f12, uint32(g.end))
+		f12 = be.AppendUint32(f12, g.gid)
+	}
+
+	// Format 4, with an extra segment for the required final 0xFFFF entry.
+	var segs []cmapGroup
+	for _, g := range groups {
+		if g.start >= 0xFFFF {
+			break
+		}
+		if g.end >= 0xFFFF {
+			g.end = 0xFFFE
+		}
+		segs = append(segs, g)
+	}
+	segs = append(segs, cmapGroup{start: 0xFFFF, end: 0xFFFF, gid: 0})
+	segX2 := 2 * len(segs)
+	if 16+4*segX2 > 0xFFFF {
+		const headerLength = 4 + 8
+		cmap := be.AppendUint16(nil, 0)
+		cmap = be.AppendUint16(cmap, 1)
+		// Windows Unicode full repertoire.
+		cmap = be.AppendUint16(cmap, 3)
+		cmap = be.AppendUint16(cmap, 10)
+		cmap = be.AppendUint32(cmap, headerLength)
+		return append(cmap, f12...)
+	}
+	searchRange := 2
+	entrySelector := 0
+	for searchRange*2 <= segX2 {
+		searchRange *= 2
+		entrySelector++
+	}
+	f4 := be.AppendUint16(nil, 4)
+	f4 = be.AppendUint16(f4, uint16(16+4*segX2))
+	f4 = be.AppendUint16(f4, 0)
+	f4 = be.AppendUint16(f4, uint16(segX2))
+	f4 = be.AppendUint16(f4, uint16(searchRange))
+	f4 = be.AppendUint16(f4, uint16(entrySelector))
+	f4 = be.AppendUint16(f4, uint16(segX2-searchRange))
+	for _, g := range segs {
+		f4 = be.AppendUint16(f4, uint16(g.end))
+	}
+	f4 = be.AppendUint16(f4, 0)
+	for _, g := range segs {
+		f4 = be.AppendUint16(f4, uint16(g.start))
+	}
+	for _, g := range segs {
+		delta := uint16(g.gid) - uint16(g.start)
+		if g.start == 0xFFFF {
+			delta = 1
+		}
+		f4 = be.AppendUint16(f4, delta)
+	}
+	for range segs {
+		f4 = be.AppendUint16(f4, 0)
+	}
+
+	const headerLength = 4 + 2*8
+	cmap := be.AppendUint16(nil, 0)
+	cmap = be.AppendUint16(cmap, 2)
+	// Windows Unicode BMP.
+	cmap = be.AppendUint16(cmap, 3)
+	cmap = be.AppendUint16(cmap, 1)
+	cmap = be.AppendUint32(cmap, headerLength)
+	// Windows Unicode full repertoire.
+	cmap = be.AppendUint16(cmap, 3)
+	cmap = be.AppendUint16(cmap, 10)
+	cmap = be.AppendUint32(cmap, uint32(headerLength+len(f4)))
+	cmap = append(cmap, f4...)
+	return append(cmap, f12...)
+}
+
+// readTables returns the sfnt version and the tables of the font in src.
+func readTables(src []byte) (uint32, map[loader.Tag][]byte, error) {
+	be := binary.BigEndian
+	if len(src) < 12 {
+		return 0, nil, errors.New("opentype: invalid font")
+	}
+	version := be.Uint32(src)
+	if version == uint32(loader.MustNewTag("ttcf")) {
+		return 0, nil, errors.New("opentype: subsetting font collections is not supported")
+	}
+	n := int(be.Uint16(src[4:]))
+	tabs := make(map[loader.Tag][]byte, n)
+	for i := 0; i < n; i++ {
+		rec := 12 + 16*i
+		if rec+16 > len(src) {
+			return 0, nil, errors.New("opentype: invalid table directory")
+		}
+		off, length := be.Uint32(src[rec+8:]), be.Uint32(src[rec+12:])
+		if uint64(off)+uint64(length) > uint64(len(src)) {
+			return 0, nil, errors.New("opentype: invalid table directory")
+		}
+		tabs[loader.Tag(be.Uint32(src[rec:]))] = src[off : off+length]
+	}
+	return version, tabs, nil
+}
+
+// writeFont encodes tabs into a font file of the sfnt version, with tables
+// aligned to 4 bytes and valid checksums.
+func writeFont(version uint32, tabs map[loader.Tag][]byte) []byte {
+	be := binary.BigEndian
+	tags := make([]loader.Tag, 0, len(tabs))
+	for tag := range tabs {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	searchRange, entrySelector := 1, 0
+	for searchRange*2 <= len(tags) {
+		searchRange *= 2
+		entrySelector++
+	}
+	out := be.AppendUint32(nil, version)
+	out = be.AppendUint16(out, uint16(len(tags)))
+	out = be.AppendUint16(out, uint16(searchRange*16))
+	out = be.AppendUint16(out, uint16(entrySelector))
+	out = be.AppendUint16(out, uint16((len(tags)-searchRange)*16))
+	// The tables follow the 12 byte header and the 16 byte table records.
+	off := 12 + 16*len(tags)
+	headOff := -1
+	for _, tag := range tags {
+		t := tabs[tag]
+		if tag == tagHead && len(t) >= 12 {
+			// The checksum of head is computed with a zero adjustment.
+			t = append([]byte(nil), t...)
+			be.PutUint32(t[8:], 0)
+			tabs[tag] = t
+			headOff = off
+		}
+		out = be.AppendUint32(out, uint32(tag))
+		out = be.AppendUint32(out, checksum(t))
+		out = be.AppendUint32(out, uint32(off))
+		out = be.AppendUint32(out, uint32(len(t)))
+		off += (len(t) + 3) &^ 3
+	}
+	for _, tag := range tags {
+		out = append(out, tabs[tag]...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	if headOff != -1 {
+		be.PutUint32(out[headOff+8:], 0xB1B0AFBA-checksum(out))
+	}
+	return out
+}
+
+// checksum computes the checksum of an OpenType table or file.
+func checksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
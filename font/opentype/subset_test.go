@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package opentype
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/go-text/typesetting/opentype/api"
+	fontapi "github.com/go-text/typesetting/opentype/api/font"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestSubsetRoundTrip(t *testing.T) {
+	face, err := Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Subset(goregular.TTF, GlyphSet{Runes: []rune("Hi")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src) >= len(goregular.TTF)/4 {
+		t.Errorf("expected a subset much smaller than %d bytes, got %d", len(goregular.TTF), len(src))
+	}
+	sub, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sub.Font(), face.Font()) {
+		t.Errorf("expected subset font %+v, got %+v", face.Font(), sub.Font())
+	}
+	orig, subFace := face.Face(), sub.Face()
+	for _, r := range "Hi" {
+		gid, ok := orig.NominalGlyph(r)
+		if !ok {
+			t.Fatalf("no glyph for %q", r)
+		}
+		if subGID, ok := subFace.NominalGlyph(r); !ok || subGID != gid {
+			t.Errorf("expected %q to map to glyph %d, got %d", r, gid, subGID)
+		}
+		if want, got := orig.GlyphData(gid), subFace.GlyphData(gid); !reflect.DeepEqual(want, got) {
+			t.Errorf("expected the outline of %q to be kept", r)
+		}
+		if want, got := orig.HorizontalAdvance(gid), subFace.HorizontalAdvance(gid); want != got {
+			t.Errorf("expected the advance %v of %q, got %v", want, r, got)
+		}
+	}
+	gid, _ := orig.NominalGlyph('x')
+	if _, ok := subFace.NominalGlyph('x'); ok {
+		t.Error("expected 'x' to be unmapped")
+	}
+	if out, ok := subFace.GlyphData(gid).(api.GlyphOutline); !ok || len(out.Segments) > 0 {
+		t.Errorf("expected the outline of 'x' to be removed, got %v", out)
+	}
+}
+
+func TestSubsetCFF(t *testing.T) {
+	version, tabs, err := readTables(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tabs[tagCFF] = []byte{1, 0, 4, 4}
+	if _, err := Subset(writeFont(version, tabs), GlyphSet{Runes: []rune("a")}); err == nil {
+		t.Error("expected an error for a font with CFF outlines")
+	}
+}
+
+// TestSubsetLargeCmap ensures that character maps with more segments than fit a
+// format 4 subtable are encoded in a format 12 subtable alone.
+func TestSubsetLargeCmap(t *testing.T) {
+	var groups []cmapGroup
+	for i := 0; i < 10000; i++ {
+		r := rune(2 * i)
+		groups = append(groups, cmapGroup{start: r, end: r, gid: uint32(1 + i%500)})
+	}
+	cmap := encodeCmap(groups)
+	if n := binary.BigEndian.Uint16(cmap[2:]); n != 1 {
+		t.Errorf("expected a single subtable, got %d", n)
+	}
+	version, tabs, err := readTables(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tabs[tagCmap] = cmap
+	face, err := Parse(writeFont(version, tabs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 1, 8500, 9999} {
+		gid, ok := face.Face().NominalGlyph(rune(2 * i))
+		if want := fontapi.GID(1 + i%500); !ok || gid != want {
+			t.Errorf("expected %U to map to glyph %d, got %d", 2*i, want, gid)
+		}
+	}
+}
+
+// table encodes big-endian values of the sizes of their types, and uint32s
+// wrapped in offset24 as 24 bit offsets.
+func table(values ...any) []byte {
+	var b []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case uint8:
+			b = append(b, v)
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case int16:
+			b = binary.BigEndian.AppendUint16(b, uint16(v))
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case offset24:
+			b = append(b, byte(v>>16), byte(v>>8), byte(v))
+		default:
+			panic("unsupported value")
+		}
+	}
+	return b
+}
+
+type offset24 uint32
+
+func TestSubsetColorV1(t *testing.T) {
+	colr := table(
+		// Header, with the base glyph list at 34 and the layer list at 56.
+		uint16(1), uint16(0), uint32(0), uint32(0), uint16(0),
+		uint32(34), uint32(56), uint32(0), uint32(0), uint32(0),
+		// Base glyph list, with the paint of glyph 1 at 50 and of glyph 3 at 77.
+		uint32(2), uint16(1), uint32(16), uint16(3), uint32(43),
+		// PaintColrLayers of the 2 layers of the layer list.
+		uint8(1), uint8(2), uint32(0),
+		// Layer list, with layers at 68 and 74.
+		uint32(2), uint32(12), uint32(18),
+		// PaintGlyph 2 filled with the PaintSolid at 91.
+		uint8(10), offset24(23), uint16(2),
+		// PaintColrGlyph 3.
+		uint8(11), uint16(3),
+		// PaintTranslate of the PaintGlyph at 85.
+		uint8(14), offset24(8), int16(10), int16(-20),
+		// PaintGlyph 4 filled with the PaintSolid at 91.
+		uint8(10), offset24(6), uint16(4),
+		// PaintSolid.
+		uint8(2), uint16(0), uint16(1<<14),
+	)
+	s := subsetter{keep: make([]bool, 6)}
+	s.add(1)
+	for s.added {
+		s.added = false
+		s.closeColor(colr)
+	}
+	if want := []bool{false, true, true, true, true, false}; !reflect.DeepEqual(s.keep, want) {
+		t.Errorf("expected kept glyphs %v, got %v", want, s.keep)
+	}
+
+	// Truncated tables don't crash.
+	for n := 14; n < len(colr); n++ {
+		s := subsetter{keep: make([]bool, 6)}
+		s.add(1)
+		s.closeColor(colr[:n])
+	}
+}
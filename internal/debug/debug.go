@@ -11,14 +11,19 @@ import (
 )
 
 const (
-	debugVariable = "GIODEBUG"
-	textSubsystem = "text"
-	silentFeature = "silent"
+	debugVariable   = "GIODEBUG"
+	textSubsystem   = "text"
+	fallbackFeature = "text.fallback"
+	silentFeature   = "silent"
 )
 
 // Text controls whether the text subsystem has debug logging enabled.
 var Text atomic.Bool
 
+// TextFallback controls whether the text subsystem logs the faces chosen for
+// text not displayed with its preferred face, and the runes no face covers.
+var TextFallback atomic.Bool
+
 var parseOnce sync.Once
 
 // Parse processes the current value of GIODEBUG. If it is unset, it does nothing.
@@ -38,6 +43,8 @@ func Parse() {
 			switch part {
 			case textSubsystem:
 				Text.Store(true)
+			case fallbackFeature:
+				TextFallback.Store(true)
 			case silentFeature:
 				silent = true
 			default:
@@ -50,8 +57,9 @@ func Parse() {
 	A comma-delimited list of debug subsystems to enable. Currently recognized systems:
 
 	- %s: text debug info including system font resolution
+	- %s: font fallback decisions and runes without glyphs
 	- %s: silence this usage message even if GIODEBUG contains invalid content
-`, debugVariable, textSubsystem, silentFeature)
+`, debugVariable, textSubsystem, fallbackFeature, silentFeature)
 		}
 	})
 }
@@ -0,0 +1,467 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"cmp"
+	"image"
+	"image/color"
+	"math"
+	"os"
+
+	"github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/opentype/api"
+	"github.com/go-text/typesetting/opentype/loader"
+	"golang.org/x/exp/slices"
+
+	"gioui.org/f32"
+	"gioui.org/font/opentype"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text/internal/colr"
+)
+
+// colorCache caches the layers of color glyphs.
+type colorCache = lru[GlyphID, []colorLayer]
+
+// colorTable returns the color glyphs of the face at faceIdx, or nil if it has
+// none. The color tables of the faces of the collection are provided by their
+// opentype.Face, and those of system faces are read from their font file.
+func (s *shaperImpl) colorTable(faceIdx int) *colr.Table {
+	if t, ok := s.colorTables[faceIdx]; ok {
+		return t
+	}
+	if s.colorTables == nil {
+		s.colorTables = make(map[int]*colr.Table)
+	}
+	ft := s.faces[faceIdx].Font
+	var colrData, cpal []byte
+	if src, ok := s.sources[ft]; ok {
+		if src, ok := src.(opentype.Face); ok {
+			colrData, cpal = src.ColorTables()
+		}
+	} else {
+		colrData, cpal = readColorTables(s.fontMap.FontLocation(ft).File, s.fontMap.FontLocation(ft).Index)
+	}
+	var t *colr.Table
+	if colrData != nil {
+		var err error
+		t, err = colr.Parse(colrData, cpal)
+		if err != nil {
+			s.logger.Printf("failed parsing color glyphs: %v", err)
+		}
+	}
+	s.colorTables[faceIdx] = t
+	return t
+}
+
+// readColorTables reads the COLR and CPAL tables of the font at index of the font
+// file at path.
+func readColorTables(path string, index uint16) (colrData, cpal []byte) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil
+	}
+	defer f.Close()
+	lds, err := loader.NewLoaders(f)
+	if err != nil || int(index) >= len(lds) {
+		return nil, nil
+	}
+	ld := lds[index]
+	colrData, err = ld.RawTable(loader.MustNewTag("COLR"))
+	if err != nil {
+		return nil, nil
+	}
+	cpal, _ = ld.RawTable(loader.MustNewTag("CPAL"))
+	return colrData, cpal
+}
+
+// colorGlyph returns the paint graph of the glyph gid of the face at faceIdx, if
+// it is a color glyph.
+func (s *shaperImpl) colorGlyph(faceIdx int, gid api.GID) (colr.Paint, bool) {
+	t := s.colorTable(faceIdx)
+	if t == nil || gid > 0xFFFF {
+		return nil, false
+	}
+	return t.Glyph(uint16(gid))
+}
+
+// colorLayer is a layer of a color glyph, in pixels relative to the origin of
+// the glyph.
+type colorLayer struct {
+	// clips are the outlines the layer is clipped to, outermost first.
+	clips []clip.PathSpec
+	// fill paints the layer within its clips. It is empty for layers painted
+	// with the color of the text.
+	fill op.CallOp
+	// alpha is the opacity of a layer painted with the color of the text.
+	alpha float32
+}
+
+// colorGlyphLayers returns the layers of the color glyph c of face, at the pixels
+// per em of id, in stacking order.
+func (s *shaperImpl) colorGlyphLayers(id GlyphID, face font.Face, c colr.Paint) []colorLayer {
+	if layers, ok := s.colorGlyphCache.Get(id); ok {
+		return layers
+	}
+	ppem, _, _ := splitGlyphID(id)
+	scale := fixedToFloat(ppem) / float32(face.Upem())
+	b := colorBuilder{ops: new(op.Ops), face: face}
+	// Font units point up.
+	b.add(c, f32.Affine2D{}.Scale(f32.Point{}, f32.Point{X: scale, Y: -scale}), nil, image.Rectangle{})
+	s.colorGlyphCache.Put(id, b.layers)
+	return b.layers
+}
+
+// colorBuilder flattens the paint graph of a color glyph into layers.
+type colorBuilder struct {
+	ops    *op.Ops
+	face   font.Face
+	layers []colorLayer
+}
+
+// add appends the layers of c to b. The paint is transformed to pixels by m and
+// clipped to clips, whose intersection is within bounds. Paints that fill the
+// current clip are only painted within a glyph outline. Radial and sweep
+// gradients are rendered to images at most maxGradientSize pixels wide and high,
+// and gradient stops of the text color are painted with its alpha over black.
+func (b *colorBuilder) add(c colr.Paint, m f32.Affine2D, clips []clip.PathSpec, bounds image.Rectangle) {
+	switch c := c.(type) {
+	case colr.Layers:
+		for _, l := range c {
+			b.add(l, m, clips, bounds)
+		}
+	case colr.Transform:
+		b.add(c.Paint, m.Mul(c.Transform), clips, bounds)
+	case colr.Composite:
+		// Only the simple compositing operators are supported, and the others are
+		// approximated by painting the source over the backdrop.
+		switch c.Mode {
+		case compositeClear:
+		case compositeSrc:
+			b.add(c.Source, m, clips, bounds)
+		case compositeDest:
+			b.add(c.Backdrop, m, clips, bounds)
+		default:
+			b.add(c.Backdrop, m, clips, bounds)
+			b.add(c.Source, m, clips, bounds)
+		}
+	case colr.Glyph:
+		outline, ok := b.face.GlyphData(api.GID(c.GID)).(api.GlyphOutline)
+		if !ok {
+			return
+		}
+		var p clip.Path
+		p.Begin(b.ops)
+		glyphBounds := addOutline(&p, outline, m)
+		if len(clips) > 0 {
+			glyphBounds = glyphBounds.Intersect(bounds)
+		}
+		// Copy the clips, which are shared by the layers of the paint.
+		clips = append(clips[:len(clips):len(clips)], p.End())
+		b.add(c.Paint, m, clips, glyphBounds)
+	case colr.Solid:
+		if len(clips) == 0 {
+			return
+		}
+		if c.Foreground {
+			b.layers = append(b.layers, colorLayer{clips: clips, alpha: float32(c.Color.A) / 0xff})
+			return
+		}
+		b.fill(clips, func(ops *op.Ops) {
+			paint.ColorOp{Color: c.Color}.Add(ops)
+			paint.PaintOp{}.Add(ops)
+		})
+	case colr.LinearGradient:
+		if len(clips) > 0 {
+			b.fill(clips, func(ops *op.Ops) {
+				t := op.Affine(m).Push(ops)
+				paintLinearGradient(ops, c)
+				t.Pop()
+			})
+		}
+	case colr.RadialGradient:
+		if len(clips) > 0 {
+			b.fillImage(clips, gradientImage(bounds, m.Invert(), c.Stops, radialOffset(c)))
+		}
+	case colr.SweepGradient:
+		if len(clips) > 0 {
+			b.fillImage(clips, gradientImage(bounds, m.Invert(), c.Stops, sweepOffset(c)))
+		}
+	}
+}
+
+// fill appends a layer clipped to clips and painted by the operations of f.
+func (b *colorBuilder) fill(clips []clip.PathSpec, f func(ops *op.Ops)) {
+	r := op.Record(b.ops)
+	f(b.ops)
+	b.layers = append(b.layers, colorLayer{clips: clips, fill: r.Stop()})
+}
+
+// fillImage appends a layer clipped to clips and painted with the image img.
+func (b *colorBuilder) fillImage(clips []clip.PathSpec, img gradient) {
+	if img.img == nil {
+		return
+	}
+	b.fill(clips, func(ops *op.Ops) {
+		t := op.Affine(img.transform).Push(ops)
+		paint.NewImageOp(img.img).Add(ops)
+		paint.PaintOp{}.Add(ops)
+		t.Pop()
+	})
+}
+
+// add paints the layer with its fill, or with material if it is painted with
+// the color of the text. An empty material paints it black.
+func (l colorLayer) add(ops *op.Ops, material op.CallOp) {
+	var stack [4]clip.Stack
+	clips := stack[:0]
+	for _, c := range l.clips {
+		clips = append(clips, clip.Outline{Path: c}.Op().Push(ops))
+	}
+	switch {
+	case l.fill != (op.CallOp{}):
+		l.fill.Add(ops)
+	default:
+		opacity := paint.PushOpacity(ops, l.alpha)
+		if material == (op.CallOp{}) {
+			paint.ColorOp{Color: color.NRGBA{A: 0xff}}.Add(ops)
+		} else {
+			material.Add(ops)
+		}
+		paint.PaintOp{}.Add(ops)
+		opacity.Pop()
+	}
+	for i := len(clips) - 1; i >= 0; i-- {
+		clips[i].Pop()
+	}
+}
+
+// Compositing operators of COLRv1.
+const (
+	compositeClear = 0
+	compositeSrc   = 1
+	compositeDest  = 2
+)
+
+// paintLinearGradient fills the current clip with g. Gradients with more than two
+// stops are painted as a sequence of two-stop gradients, each clipped to the half
+// plane beyond its first stop.
+func paintLinearGradient(ops *op.Ops, g colr.LinearGradient) {
+	if len(g.Stops) == 0 {
+		return
+	}
+	if len(g.Stops) == 1 {
+		paint.ColorOp{Color: g.Stops[0].Color.Color}.Add(ops)
+		paint.PaintOp{}.Add(ops)
+		return
+	}
+	// Rotate P1 around P0 such that the gradient is perpendicular to the line
+	// from P0 to P2.
+	p0, p1 := g.P0, g.P1
+	if perp := (f32.Point{X: g.P0.Y - g.P2.Y, Y: g.P2.X - g.P0.X}); perp != (f32.Point{}) {
+		d := g.P1.Sub(g.P0)
+		p1 = p0.Add(perp.Mul(dot(d, perp) / dot(perp, perp)))
+	}
+	at := func(offset float32) f32.Point {
+		return p0.Add(p1.Sub(p0).Mul(offset))
+	}
+	axis := p1.Sub(p0)
+	if axis == (f32.Point{}) {
+		paint.ColorOp{Color: g.Stops[len(g.Stops)-1].Color.Color}.Add(ops)
+		paint.PaintOp{}.Add(ops)
+		return
+	}
+	for i := 0; i < len(g.Stops)-1; i++ {
+		from, to := g.Stops[i], g.Stops[i+1]
+		var cl clip.Stack
+		if i > 0 {
+			cl = clip.Outline{Path: halfPlane(ops, at(from.Offset), axis)}.Op().Push(ops)
+		}
+		paint.LinearGradientOp{
+			Stop1:  at(from.Offset),
+			Color1: from.Color.Color,
+			Stop2:  at(to.Offset),
+			Color2: to.Color.Color,
+		}.Add(ops)
+		paint.PaintOp{}.Add(ops)
+		if i > 0 {
+			cl.Pop()
+		}
+	}
+}
+
+// halfPlaneSize is the extent of the half planes of halfPlane, in font units. It
+// exceeds the size of any glyph.
+const halfPlaneSize = 1 << 16
+
+// halfPlane returns a path enclosing the points beyond origin in the direction of
+// dir.
+func halfPlane(ops *op.Ops, origin, dir f32.Point) clip.PathSpec {
+	n := dir.Mul(halfPlaneSize / float32(math.Sqrt(float64(dot(dir, dir)))))
+	side := f32.Point{X: -n.Y, Y: n.X}
+	var p clip.Path
+	p.Begin(ops)
+	p.MoveTo(origin.Add(side))
+	p.LineTo(origin.Add(side).Add(n))
+	p.LineTo(origin.Sub(side).Add(n))
+	p.LineTo(origin.Sub(side))
+	p.Close()
+	return p.End()
+}
+
+// maxGradientSize is the maximum width and height in pixels of the images of
+// gradients. Larger gradients are scaled up from images of this size.
+const maxGradientSize = 256
+
+// gradient is an image of a gradient, and the transformation of the image to
+// pixels.
+type gradient struct {
+	img       *image.NRGBA
+	transform f32.Affine2D
+}
+
+// gradientImage renders the gradient of stops within the pixels of bounds.
+// offset maps the points of the gradient, transformed from pixels by m, to
+// their offset along the gradient, or reports false for points outside the
+// gradient. Offsets beyond the stops take the color of the nearest stop.
+func gradientImage(bounds image.Rectangle, m f32.Affine2D, stops []colr.ColorStop, offset func(p f32.Point) (float32, bool)) gradient {
+	if bounds.Empty() || len(stops) == 0 {
+		return gradient{}
+	}
+	stops = slices.Clone(stops)
+	slices.SortStableFunc(stops, func(a, b colr.ColorStop) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	size := image.Pt(min(bounds.Dx(), maxGradientSize), min(bounds.Dy(), maxGradientSize))
+	scale := f32.Point{
+		X: float32(bounds.Dx()) / float32(size.X),
+		Y: float32(bounds.Dy()) / float32(size.Y),
+	}
+	toPixels := f32.Affine2D{}.Scale(f32.Point{}, scale).Offset(f32.Point{X: float32(bounds.Min.X), Y: float32(bounds.Min.Y)})
+	img := image.NewNRGBA(image.Rectangle{Max: size})
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			p := m.Transform(toPixels.Transform(f32.Point{X: float32(x) + .5, Y: float32(y) + .5}))
+			if t, ok := offset(p); ok {
+				img.SetNRGBA(x, y, stopColor(stops, t))
+			}
+		}
+	}
+	return gradient{img: img, transform: toPixels}
+}
+
+// stopColor returns the color at offset t of the sorted stops.
+func stopColor(stops []colr.ColorStop, t float32) color.NRGBA {
+	if t <= stops[0].Offset {
+		return stops[0].Color.Color
+	}
+	for i := 1; i < len(stops); i++ {
+		from, to := stops[i-1], stops[i]
+		if t > to.Offset {
+			continue
+		}
+		f := (t - from.Offset) / (to.Offset - from.Offset)
+		lerp := func(a, b uint8) uint8 {
+			return uint8(math.Round(float64(float32(a) + (float32(b)-float32(a))*f)))
+		}
+		c0, c1 := from.Color.Color, to.Color.Color
+		return color.NRGBA{R: lerp(c0.R, c1.R), G: lerp(c0.G, c1.G), B: lerp(c0.B, c1.B), A: lerp(c0.A, c1.A)}
+	}
+	return stops[len(stops)-1].Color.Color
+}
+
+// radialOffset returns the function mapping points to their offset along g: the
+// largest offset of the interpolated circles passing through the point, among
+// those of non-negative radius.
+func radialOffset(g colr.RadialGradient) func(p f32.Point) (float32, bool) {
+	dc := g.C1.Sub(g.C0)
+	dr := g.R1 - g.R0
+	a := dot(dc, dc) - dr*dr
+	valid := func(t float32) bool { return g.R0+t*dr >= 0 }
+	return func(p f32.Point) (float32, bool) {
+		// Solve |p - c0 - t*dc| = r0 + t*dr for t.
+		dp := p.Sub(g.C0)
+		b := dot(dp, dc) + g.R0*dr
+		c := dot(dp, dp) - g.R0*g.R0
+		if math.Abs(float64(a)) < 1e-6 {
+			if b == 0 {
+				return 0, false
+			}
+			t := c / (2 * b)
+			return t, valid(t)
+		}
+		disc := b*b - a*c
+		if disc < 0 {
+			return 0, false
+		}
+		sq := float32(math.Sqrt(float64(disc)))
+		t0, t1 := (b-sq)/a, (b+sq)/a
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		if valid(t1) {
+			return t1, true
+		}
+		return t0, valid(t0)
+	}
+}
+
+// sweepOffset returns the function mapping points to their offset along g, by
+// their angle around its center.
+func sweepOffset(g colr.SweepGradient) func(p f32.Point) (float32, bool) {
+	return func(p f32.Point) (float32, bool) {
+		d := p.Sub(g.Center)
+		angle := float32(math.Atan2(float64(d.Y), float64(d.X)))
+		if angle < 0 {
+			angle += 2 * math.Pi
+		}
+		if g.EndAngle == g.StartAngle {
+			if angle < g.StartAngle {
+				return 0, true
+			}
+			return 1, true
+		}
+		return (angle - g.StartAngle) / (g.EndAngle - g.StartAngle), true
+	}
+}
+
+// addOutline adds outline to p, transformed by m, and returns the pixels covered
+// by its points.
+func addOutline(p *clip.Path, outline api.GlyphOutline, m f32.Affine2D) image.Rectangle {
+	lo := f32.Point{X: float32(math.Inf(1)), Y: float32(math.Inf(1))}
+	hi := lo.Mul(-1)
+	pt := func(a api.SegmentPoint) f32.Point {
+		p := m.Transform(f32.Point{X: a.X, Y: a.Y})
+		lo.X, lo.Y = float32(math.Min(float64(lo.X), float64(p.X))), float32(math.Min(float64(lo.Y), float64(p.Y)))
+		hi.X, hi.Y = float32(math.Max(float64(hi.X), float64(p.X))), float32(math.Max(float64(hi.Y), float64(p.Y)))
+		return p
+	}
+	for _, seg := range outline.Segments {
+		switch seg.Op {
+		case api.SegmentOpMoveTo:
+			p.MoveTo(pt(seg.Args[0]))
+		case api.SegmentOpLineTo:
+			p.LineTo(pt(seg.Args[0]))
+		case api.SegmentOpQuadTo:
+			p.QuadTo(pt(seg.Args[0]), pt(seg.Args[1]))
+		case api.SegmentOpCubeTo:
+			p.CubeTo(pt(seg.Args[0]), pt(seg.Args[1]), pt(seg.Args[2]))
+		}
+	}
+	if lo.X > hi.X || lo.Y > hi.Y {
+		return image.Rectangle{}
+	}
+	return image.Rectangle{
+		Min: image.Pt(int(math.Floor(float64(lo.X))), int(math.Floor(float64(lo.Y)))),
+		Max: image.Pt(int(math.Ceil(float64(hi.X))), int(math.Ceil(float64(hi.Y)))),
+	}
+}
+
+func dot(a, b f32.Point) float32 {
+	return a.X*b.X + a.Y*b.Y
+}
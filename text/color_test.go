@@ -0,0 +1,181 @@
+package text
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/go-text/typesetting/opentype/loader"
+	"golang.org/x/exp/slices"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"gioui.org/f32"
+	"gioui.org/font/opentype"
+	"gioui.org/op"
+	"gioui.org/text/internal/colr"
+)
+
+// colorFont returns the font of colorFontSrc.
+func colorFont(t *testing.T) opentype.Face {
+	t.Helper()
+	face, err := opentype.Parse(colorFontSrc(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if colr, _ := face.ColorTables(); colr == nil {
+		t.Fatal("expected color tables")
+	}
+	return face
+}
+
+// colorFontSrc returns goregular with a COLRv0 table making 'A' a color glyph of
+// a red 'A' layer above a text colored 'B' layer.
+func colorFontSrc(t *testing.T) []byte {
+	t.Helper()
+	plain, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gidA, _ := plain.Face().NominalGlyph('A')
+	gidB, _ := plain.Face().NominalGlyph('B')
+	be := binary.BigEndian
+	colr := be.AppendUint16(nil, 0)
+	colr = be.AppendUint16(colr, 1)
+	colr = be.AppendUint32(colr, 14)
+	colr = be.AppendUint32(colr, 20)
+	colr = be.AppendUint16(colr, 2)
+	for _, v := range []uint16{uint16(gidA), 0, 2, uint16(gidB), 0xFFFF, uint16(gidA), 0} {
+		colr = be.AppendUint16(colr, v)
+	}
+	cpal := []byte{0, 0, 0, 1, 0, 1, 0, 1, 0, 0, 0, 14, 0, 0, 0, 0, 0xff, 0xff}
+	return withTables(goregular.TTF,
+		loader.Table{Tag: loader.MustNewTag("COLR"), Content: colr},
+		loader.Table{Tag: loader.MustNewTag("CPAL"), Content: cpal},
+	)
+}
+
+// withTables returns the font src with the tables added.
+func withTables(src []byte, tables ...loader.Table) []byte {
+	// Copy the tables of src from its table directory.
+	be := binary.BigEndian
+	n := int(be.Uint16(src[4:]))
+	for i := 0; i < n; i++ {
+		rec := src[12+16*i:]
+		off, length := be.Uint32(rec[8:]), be.Uint32(rec[12:])
+		tag := loader.Tag(be.Uint32(rec))
+		tables = append(tables, loader.Table{Tag: tag, Content: src[off : off+length]})
+	}
+	slices.SortFunc(tables, func(a, b loader.Table) int { return int(a.Tag) - int(b.Tag) })
+	return loader.WriteTTF(tables)
+}
+
+func TestColorGlyphs(t *testing.T) {
+	shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: colorFont(t)}}))
+	gs := layoutGlyphs(shaper, "AB")
+	if len(gs) != 2 {
+		t.Fatalf("expected 2 glyphs, got %d", len(gs))
+	}
+	_, faceIdx, gidA := splitGlyphID(gs[0].ID)
+	_, _, gidB := splitGlyphID(gs[1].ID)
+	if _, ok := shaper.shaper.colorGlyph(faceIdx, gidA); !ok {
+		t.Error("expected 'A' to be a color glyph")
+	}
+	if _, ok := shaper.shaper.colorGlyph(faceIdx, gidB); ok {
+		t.Error("expected 'B' to be a plain glyph")
+	}
+
+	// Color glyphs are painted by Bitmaps, and cached.
+	if call := shaper.Bitmaps(gs[:1]); call == (op.CallOp{}) {
+		t.Error("expected operations painting the color glyph")
+	}
+	if _, ok := shaper.shaper.colorGlyphCache.Get(gs[0].ID); !ok {
+		t.Error("expected the color glyph to be cached")
+	}
+	// Its layers are painted in order, the text colored 'B' below the red 'A'.
+	b := shaper.bitmaps(gs[:1])
+	if len(b.calls) != 2 || len(b.text) != 1 {
+		t.Fatalf("expected a text colored layer between 2 calls, got %d calls and %d layers", len(b.calls), len(b.text))
+	}
+	if l := b.text[0].layer; len(l.clips) != 1 || l.alpha != 1 {
+		t.Errorf("expected an opaque text colored layer clipped to 'B', got %+v", l)
+	}
+
+	// Removing the face drops its color glyphs.
+	shaper.RemoveFaces(shaper.shaper.collection...)
+	if _, ok := shaper.shaper.colorGlyphCache.Get(gs[0].ID); ok {
+		t.Error("expected the color glyph cache to be invalidated")
+	}
+}
+
+func TestColorLayers(t *testing.T) {
+	face := colorFont(t).Face()
+	gidA, _ := face.NominalGlyph('A')
+	gidB, _ := face.NominalGlyph('B')
+	red := colr.Solid{Color: color.NRGBA{R: 0xff, A: 0xff}}
+	paint := colr.Layers{
+		colr.Glyph{GID: uint16(gidB), Paint: colr.Solid{Foreground: true, Color: color.NRGBA{A: 0x80}}},
+		colr.Glyph{GID: uint16(gidA), Paint: red},
+		colr.Glyph{GID: uint16(gidA), Paint: colr.Glyph{GID: uint16(gidB), Paint: colr.Solid{Foreground: true, Color: color.NRGBA{A: 0xff}}}},
+		// Paints outside glyphs paint nothing.
+		red,
+	}
+	b := colorBuilder{ops: new(op.Ops), face: face}
+	b.add(paint, f32.Affine2D{}.Scale(f32.Point{}, f32.Point{X: .01, Y: -.01}), nil, image.Rectangle{})
+	if len(b.layers) != 3 {
+		t.Fatalf("expected 3 layers, got %d", len(b.layers))
+	}
+	for i, exp := range []struct {
+		clips int
+		text  bool
+		alpha float32
+	}{{1, true, 0x80 / 255.}, {1, false, 0}, {2, true, 1}} {
+		l := b.layers[i]
+		if len(l.clips) != exp.clips || (l.fill == op.CallOp{}) != exp.text || l.alpha != exp.alpha {
+			t.Errorf("layer %d: expected %d clips, text colored %v and alpha %v, got %+v", i, exp.clips, exp.text, exp.alpha, l)
+		}
+	}
+}
+
+func TestColorGradients(t *testing.T) {
+	stops := []colr.ColorStop{
+		{Offset: 1, Color: colr.Solid{Color: color.NRGBA{B: 0xff, A: 0xff}}},
+		{Offset: 0, Color: colr.Solid{Color: color.NRGBA{R: 0xff, A: 0xff}}},
+	}
+	blue := color.NRGBA{B: 0xff, A: 0xff}
+	bounds := image.Rect(0, 0, 100, 100)
+
+	// A radial gradient from the center to the edges.
+	radial := colr.RadialGradient{C0: f32.Pt(50, 50), C1: f32.Pt(50, 50), R1: 50, Stops: stops}
+	img := gradientImage(bounds, f32.Affine2D{}, stops, radialOffset(radial)).img
+	if c := img.NRGBAAt(50, 50); c.R < 0xf0 {
+		t.Errorf("expected red at the center, got %v", c)
+	}
+	if c := img.NRGBAAt(0, 0); c != blue {
+		t.Errorf("expected blue beyond the outer circle, got %v", c)
+	}
+	if c := img.NRGBAAt(75, 50); c.R == 0 || c.B == 0 {
+		t.Errorf("expected a blend between the circles, got %v", c)
+	}
+
+	// A sweep gradient around the center, counter-clockwise from the positive X
+	// axis in gradient space, where Y points up.
+	sweep := colr.SweepGradient{Center: f32.Pt(50, 50), EndAngle: 2 * math.Pi, Stops: stops}
+	img = gradientImage(bounds, f32.Affine2D{}, stops, sweepOffset(sweep)).img
+	if c := img.NRGBAAt(99, 49); c.B < 0xf0 {
+		t.Errorf("expected blue just before the end angle, got %v", c)
+	}
+	if c := img.NRGBAAt(99, 51); c.R < 0xf0 {
+		t.Errorf("expected red just after the start angle, got %v", c)
+	}
+
+	// Large gradients are rendered at a bounded size.
+	g := gradientImage(image.Rect(10, 10, 1010, 20), f32.Affine2D{}, stops, sweepOffset(sweep))
+	if s := g.img.Bounds().Size(); s != image.Pt(maxGradientSize, 10) {
+		t.Errorf("expected a %dx10 image, got %v", maxGradientSize, s)
+	}
+	if p := g.transform.Transform(f32.Pt(maxGradientSize, 10)); p != f32.Pt(1010, 20) {
+		t.Errorf("expected the image to be scaled to the bounds, got %v", p)
+	}
+}
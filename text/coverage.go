@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/go-text/typesetting/di"
+	"github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/shaping"
+
+	giofont "gioui.org/font"
+	"gioui.org/internal/debug"
+)
+
+// FaceRun is a sequence of runes displayed with a single face.
+type FaceRun struct {
+	// Runes is the range of runes of the text in the run.
+	Runes Range
+	// Face is the face chosen for the run. Its Font field describes the face,
+	// and its Face field is the face of the shaper's collection, or nil for
+	// system fonts. Face is zero if the shaper has no faces at all.
+	Face FontFace
+}
+
+// Coverage describes the faces the shaper chooses to display a text.
+type Coverage struct {
+	// Runs are the runs of the text displayed with a single face, in logical
+	// order.
+	Runs []FaceRun
+	// Uncovered holds the indices of the runes of the text that no face
+	// provides a glyph for. They are displayed as the .notdef glyph of the face
+	// of their run, usually a box.
+	Uncovered []int
+}
+
+// Coverage reports the faces chosen to display str in font, and the runes of str
+// that no available face covers, allowing callers to diagnose text displayed with
+// unexpected fonts or with missing glyphs. Each rune gets the face it would get if
+// str were laid out, but the runs are only split where the face changes. Layout
+// further splits them by text direction and script.
+func (l *Shaper) Coverage(font giofont.Font, str string) Coverage {
+	l.init()
+	txt := []rune(str)
+	input := toInput(nil, 0, langConfig{Direction: di.DirectionLTR}, txt)
+	inputs := l.shaper.splitByFaces([]shaping.Input{input}, []spanStyle{{font: font, runes: len(txt)}}, nil)
+	var cov Coverage
+	cov.Runs, cov.Uncovered = l.shaper.faceRuns(inputs, nil, nil)
+	return cov
+}
+
+// faceRuns appends the runs of the inputs resulting from splitByFaces to runs, and
+// the indices of the runes not covered by the face of their input to uncovered.
+func (s *shaperImpl) faceRuns(inputs []shaping.Input, runs []FaceRun, uncovered []int) ([]FaceRun, []int) {
+	for _, in := range inputs {
+		if in.RunStart == in.RunEnd {
+			continue
+		}
+		run := FaceRun{Runes: Range{Offset: in.RunStart, Count: in.RunEnd - in.RunStart}}
+		if in.Face != nil {
+			run.Face = FontFace{
+				Font: s.faceMeta[s.faceToIndex[in.Face.Font]],
+				Face: s.sources[in.Face.Font],
+			}
+		}
+		for i := in.RunStart; i < in.RunEnd; i++ {
+			if !covers(in.Face, in.Text[i]) {
+				uncovered = append(uncovered, i)
+			}
+		}
+		runs = append(runs, run)
+	}
+	return runs, uncovered
+}
+
+// covers reports whether face provides a glyph for r. Control and formatting
+// characters are not displayed, and are covered by any face.
+func covers(face font.Face, r rune) bool {
+	if unicode.In(r, unicode.Cc, unicode.Cf, unicode.Zl, unicode.Zp, unicode.Variation_Selector) {
+		return true
+	}
+	if face == nil {
+		return false
+	}
+	_, ok := face.NominalGlyph(r)
+	return ok
+}
+
+// logFallback logs the runs of inputs, split by splitByFaces from text styled by
+// font, displayed with a face other than the preferred face of font, as well as
+// their uncovered runes.
+func (s *shaperImpl) logFallback(font giofont.Font, inputs []shaping.Input) {
+	if !debug.TextFallback.Load() {
+		return
+	}
+	runs, uncovered := s.faceRuns(inputs, nil, nil)
+	preferred := ""
+	if len(s.queryFamilies) > 0 {
+		preferred = s.queryFamilies[0]
+	}
+	for _, run := range runs {
+		txt := string(inputs[0].Text[run.Runes.Offset : run.Runes.Offset+run.Runes.Count])
+		if run.Face.Face == nil {
+			s.logger.Fallbackf("fallback: no face for %q in %s", txt, describeFont(font))
+			continue
+		}
+		if !strings.EqualFold(string(run.Face.Font.Typeface), preferred) {
+			s.logger.Fallbackf("fallback: %q in %s uses %s", txt, describeFont(font), describeFont(run.Face.Font))
+		}
+	}
+	for _, i := range uncovered {
+		s.logger.Fallbackf("fallback: no glyph for %q (%U) in %s", inputs[0].Text[i], inputs[0].Text[i], describeFont(font))
+	}
+}
+
+// describeFont formats font for debug messages.
+func describeFont(font giofont.Font) string {
+	return fmt.Sprintf("%s(style:%s, weight:%d)", font.Typeface, font.Style, font.Weight)
+}
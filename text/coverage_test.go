@@ -0,0 +1,92 @@
+package text
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	nsareg "eliasnaur.com/font/noto/sans/arabic/regular"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+
+	giofont "gioui.org/font"
+	"gioui.org/font/opentype"
+	"gioui.org/internal/debug"
+)
+
+func coverageCollection(t *testing.T) []FontFace {
+	t.Helper()
+	ltrFace, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtlFace, err := opentype.Parse(nsareg.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []FontFace{
+		{Font: giofont.Font{Typeface: "Latin"}, Face: ltrFace},
+		{Font: giofont.Font{Typeface: "Arabic"}, Face: rtlFace},
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	collection := coverageCollection(t)
+	shaper := NewShaper(NoSystemFonts(), WithCollection(collection))
+	// U+2603 SNOWMAN is covered by neither face.
+	cov := shaper.Coverage(giofont.Font{}, "Hi سماء ☃\n")
+	expected := Coverage{
+		Runs: []FaceRun{
+			{Runes: Range{Offset: 0, Count: 3}, Face: collection[0]},
+			{Runes: Range{Offset: 3, Count: 5}, Face: collection[1]},
+			{Runes: Range{Offset: 8, Count: 2}, Face: collection[0]},
+		},
+		Uncovered: []int{8},
+	}
+	if !reflect.DeepEqual(cov, expected) {
+		t.Errorf("expected coverage %+v, got %+v", expected, cov)
+	}
+
+	// The preferred typeface is used where it covers the text.
+	cov = shaper.Coverage(giofont.Font{Typeface: "Arabic"}, "سماء 12")
+	if len(cov.Runs) != 1 || cov.Runs[0].Face.Face != collection[1].Face || len(cov.Uncovered) != 0 {
+		t.Errorf("expected a single run of the Arabic face, got %+v", cov)
+	}
+
+	// An empty shaper covers nothing.
+	empty := NewShaper(NoSystemFonts())
+	cov = empty.Coverage(giofont.Font{}, "ab")
+	expected = Coverage{
+		Runs:      []FaceRun{{Runes: Range{Count: 2}}},
+		Uncovered: []int{0, 1},
+	}
+	if !reflect.DeepEqual(cov, expected) {
+		t.Errorf("expected coverage %+v, got %+v", expected, cov)
+	}
+}
+
+func TestFallbackLogging(t *testing.T) {
+	var buf bytes.Buffer
+	out := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(out)
+	debug.TextFallback.Store(true)
+	defer debug.TextFallback.Store(false)
+
+	shaper := NewShaper(NoSystemFonts(), WithCollection(coverageCollection(t)))
+	shaper.LayoutString(Parameters{PxPerEm: fixed.I(16), Locale: english}, "Hi سماء ☃")
+	logged := buf.String()
+	for _, msg := range []string{
+		`fallback: "سماء" in (style:Regular, weight:0) uses Arabic(style:Regular, weight:0)`,
+		`fallback: no glyph for '☃' (U+2603) in (style:Regular, weight:0)`,
+	} {
+		if !strings.Contains(logged, msg) {
+			t.Errorf("expected log to contain %q, got:\n%s", msg, logged)
+		}
+	}
+	if strings.Contains(logged, `"Hi "`) {
+		t.Errorf("expected no fallback for text displayed with the preferred face, got:\n%s", logged)
+	}
+}
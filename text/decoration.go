@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"math"
+	"strings"
+
+	"github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/opentype/api"
+	"golang.org/x/image/math/fixed"
+
+	"gioui.org/f32"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+)
+
+// Decoration is a set of lines drawn along text.
+type Decoration uint8
+
+const (
+	// Underline draws a line below the baseline of the text.
+	Underline Decoration = 1 << iota
+	// Strikethrough draws a line through the middle of the text.
+	Strikethrough
+	// Overline draws a line along the top of the text.
+	Overline
+)
+
+func (d Decoration) String() string {
+	if d == 0 {
+		return "None"
+	}
+	var names []string
+	for _, n := range []struct {
+		d    Decoration
+		name string
+	}{
+		{Underline, "Underline"},
+		{Strikethrough, "Strikethrough"},
+		{Overline, "Overline"},
+	} {
+		if d&n.d != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Decorations converts the provided glyphs into a path enclosing the decoration
+// lines of d. The lines are positioned and sized according to the metrics of the
+// face of each glyph, and are interrupted where the face, size or line of the
+// glyphs change. The path is aligned with the path returned by Shape for the same
+// glyphs.
+func (l *Shaper) Decorations(gs []Glyph, d Decoration) clip.PathSpec {
+	l.init()
+	// Mix the decoration into the key, so that the paths of the same glyphs with
+	// distinct decorations never share a key.
+	key := l.decorationCache.hashGlyphs(gs) ^ uint64(d)*0x9E3779B97F4A7C15
+	path, ok := l.decorationCache.Get(key, gs)
+	if ok {
+		return path
+	}
+	pathOps := new(op.Ops)
+	path = l.shaper.Decorations(pathOps, gs, d)
+	l.decorationCache.Put(key, gs, path)
+	return path
+}
+
+// Decorations converts the provided glyphs into a path enclosing the decoration
+// lines of d, relative to the dot of the first glyph.
+func (s *shaperImpl) Decorations(pathOps *op.Ops, gs []Glyph, d Decoration) clip.PathSpec {
+	var builder clip.Path
+	builder.Begin(pathOps)
+	for _, r := range s.decorationRects(gs, d, nil) {
+		builder.MoveTo(r.Min)
+		builder.LineTo(f32.Point{X: r.Max.X, Y: r.Min.Y})
+		builder.LineTo(r.Max)
+		builder.LineTo(f32.Point{X: r.Min.X, Y: r.Max.Y})
+		builder.Close()
+	}
+	return builder.End()
+}
+
+// decorationRect is a decoration line, relative to the dot of the first glyph of
+// the decorated glyphs.
+type decorationRect struct {
+	Min, Max f32.Point
+}
+
+// decorationRects appends the decoration lines of d for gs to rects. The glyphs
+// are divided into segments of glyphs of a line sharing a face and size, and each
+// segment is decorated with the metrics of its face.
+func (s *shaperImpl) decorationRects(gs []Glyph, d Decoration, rects []decorationRect) []decorationRect {
+	if len(gs) == 0 || d == 0 {
+		return rects
+	}
+	origin := f32.Point{X: fixedToFloat(gs[0].X), Y: float32(gs[0].Y)}
+	for start := 0; start < len(gs); {
+		end := start + 1
+		for end < len(gs) && continuesDecoration(gs[end-1], gs[end]) {
+			end++
+		}
+		rects = s.decorateSegment(rects, gs[start:end], d, origin)
+		start = end
+	}
+	return rects
+}
+
+// continuesDecoration reports whether the decoration lines of the glyph a extend
+// to the following glyph b.
+func continuesDecoration(a, b Glyph) bool {
+	ppemA, faceA, _ := splitGlyphID(a.ID)
+	ppemB, faceB, _ := splitGlyphID(b.ID)
+	if a.Flags&FlagLineBreak != 0 || ppemA != ppemB || faceA != faceB {
+		return false
+	}
+	if a.Flags&FlagVertical != 0 {
+		return a.X == b.X
+	}
+	return a.Y == b.Y
+}
+
+// decorateSegment appends the decoration lines of seg to rects.
+func (s *shaperImpl) decorateSegment(rects []decorationRect, seg []Glyph, d Decoration, origin f32.Point) []decorationRect {
+	ppem, faceIdx, _ := splitGlyphID(seg[0].ID)
+	if faceIdx >= len(s.faces) || s.faces[faceIdx] == nil {
+		return rects
+	}
+	// start and end delimit the segment along the line, and ascent is the
+	// largest ascent of its glyphs. Glyphs of right-to-left runs are not
+	// ordered by position.
+	var start, end, ascent fixed.Int26_6
+	first := true
+	for _, g := range seg {
+		if g.Flags&FlagParagraphBreak != 0 {
+			continue
+		}
+		pos := g.X
+		if g.Flags&FlagVertical != 0 {
+			pos = fixed.I(int(g.Y))
+		}
+		if first {
+			start, end, ascent = pos, pos+g.Advance, g.Ascent
+			first = false
+			continue
+		}
+		if pos < start {
+			start = pos
+		}
+		if e := pos + g.Advance; e > end {
+			end = e
+		}
+		if g.Ascent > ascent {
+			ascent = g.Ascent
+		}
+	}
+	if first || start == end {
+		return rects
+	}
+	m := decorationMetricsOf(s.faces[faceIdx], fixedToFloat(ppem))
+	dot := seg[0]
+	if dot.Flags&FlagVertical != 0 {
+		// Vertical lines are drawn along the column, with underlines to the left
+		// and overlines to the right of the glyphs.
+		x := fixedToFloat(dot.X) - origin.X
+		y0, y1 := fixedToFloat(start)-origin.Y, fixedToFloat(end)-origin.Y
+		column := func(left, width float32) decorationRect {
+			return decorationRect{Min: f32.Point{X: left, Y: y0}, Max: f32.Point{X: left + width, Y: y1}}
+		}
+		if d&Underline != 0 {
+			rects = append(rects, column(snap(x-fixedToFloat(dot.Descent)), m.underlineThickness))
+		}
+		if d&Strikethrough != 0 {
+			rects = append(rects, column(snap(x-m.strikeThickness/2), m.strikeThickness))
+		}
+		if d&Overline != 0 {
+			rects = append(rects, column(snap(x+fixedToFloat(ascent))-m.underlineThickness, m.underlineThickness))
+		}
+		return rects
+	}
+	baseline := float32(dot.Y) - origin.Y
+	x0, x1 := fixedToFloat(start)-origin.X, fixedToFloat(end)-origin.X
+	row := func(top, height float32) decorationRect {
+		return decorationRect{Min: f32.Point{X: x0, Y: top}, Max: f32.Point{X: x1, Y: top + height}}
+	}
+	if d&Underline != 0 {
+		rects = append(rects, row(snap(baseline-m.underlinePosition), m.underlineThickness))
+	}
+	if d&Strikethrough != 0 {
+		rects = append(rects, row(snap(baseline-m.strikePosition), m.strikeThickness))
+	}
+	if d&Overline != 0 {
+		rects = append(rects, row(snap(baseline-fixedToFloat(ascent)), m.underlineThickness))
+	}
+	return rects
+}
+
+// decorationMetrics are the positions above the baseline of the top of the
+// decoration lines of a face, and their thicknesses, in pixels.
+type decorationMetrics struct {
+	underlinePosition, underlineThickness float32
+	strikePosition, strikeThickness       float32
+}
+
+// decorationMetricsOf returns the decoration metrics of face at ppem pixels per em,
+// from its post and OS/2 tables. Conventional values replace the metrics missing
+// from the face. Thicknesses are rounded to whole pixels, to draw crisp lines.
+func decorationMetricsOf(face font.Face, ppem float32) decorationMetrics {
+	upem := float32(face.Upem())
+	m := decorationMetrics{
+		underlinePosition:  face.LineMetric(api.UnderlinePosition),
+		underlineThickness: face.LineMetric(api.UnderlineThickness),
+		strikePosition:     face.LineMetric(api.StrikethroughPosition),
+		strikeThickness:    face.LineMetric(api.StrikethroughThickness),
+	}
+	if m.underlineThickness <= 0 {
+		m.underlineThickness = upem / 14
+	}
+	if m.underlinePosition == 0 {
+		m.underlinePosition = -upem / 10
+	}
+	if m.strikeThickness <= 0 {
+		m.strikeThickness = m.underlineThickness
+	}
+	if m.strikePosition == 0 {
+		// Strike through the middle of the lowercase letters.
+		xHeight := face.LineMetric(api.XHeight)
+		if xHeight <= 0 {
+			xHeight = upem / 2
+		}
+		m.strikePosition = (xHeight + m.strikeThickness) / 2
+	}
+	scale := ppem / upem
+	m.underlinePosition *= scale
+	m.strikePosition *= scale
+	m.underlineThickness = snapThickness(m.underlineThickness * scale)
+	m.strikeThickness = snapThickness(m.strikeThickness * scale)
+	return m
+}
+
+// snapThickness rounds the thickness v to whole pixels, but at least one.
+func snapThickness(v float32) float32 {
+	if v = snap(v); v < 1 {
+		v = 1
+	}
+	return v
+}
+
+// snap rounds v to the nearest pixel.
+func snap(v float32) float32 {
+	return float32(math.Round(float64(v)))
+}
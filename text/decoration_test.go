@@ -0,0 +1,109 @@
+package text
+
+import (
+	"testing"
+
+	"github.com/go-text/typesetting/opentype/api"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+
+	"gioui.org/font/opentype"
+)
+
+func TestDecorations(t *testing.T) {
+	face, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: face}}))
+	gs := layoutGlyphs(shaper, "Hello world")
+	rects := shaper.shaper.decorationRects(gs, Underline|Strikethrough|Overline, nil)
+	if len(rects) != 3 {
+		t.Fatalf("expected 3 decoration lines, got %v", rects)
+	}
+	width := fixedToFloat(gs[len(gs)-1].X + gs[len(gs)-1].Advance - gs[0].X)
+	for _, r := range rects {
+		if r.Min.X != 0 || r.Max.X != width {
+			t.Errorf("expected line to span [0, %v], got %v", width, r)
+		}
+		if r.Max.Y-r.Min.Y < 1 {
+			t.Errorf("expected line thickness of at least 1px, got %v", r)
+		}
+	}
+	under, strike, over := rects[0], rects[1], rects[2]
+	// The metrics of the face position the lines.
+	ot := face.Face()
+	scale := 16 / float32(ot.Upem())
+	if want := snap(-ot.LineMetric(api.UnderlinePosition) * scale); under.Min.Y != want {
+		t.Errorf("expected underline at %v, got %v", want, under)
+	}
+	if want := snap(-ot.LineMetric(api.StrikethroughPosition) * scale); strike.Min.Y != want {
+		t.Errorf("expected strikethrough at %v, got %v", want, strike)
+	}
+	if want := snap(-fixedToFloat(gs[0].Ascent)); over.Min.Y != want {
+		t.Errorf("expected overline at %v, got %v", want, over)
+	}
+	if !(over.Max.Y <= strike.Min.Y && strike.Max.Y <= 0 && under.Min.Y > 0) {
+		t.Errorf("expected overline above strikethrough above baseline above underline, got %v", rects)
+	}
+}
+
+func TestDecorationsPerLine(t *testing.T) {
+	shaper := NewShaper(NoSystemFonts(), WithCollection(coverageCollection(t)))
+	params := Parameters{PxPerEm: fixed.I(16), MaxWidth: 60, Locale: english}
+
+	// Wrapped lines are decorated separately.
+	shaper.LayoutString(params, "one two three")
+	var gs []Glyph
+	lines := 0
+	for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+		gs = append(gs, g)
+		if g.Flags&FlagLineBreak != 0 {
+			lines++
+		}
+	}
+	rects := shaper.shaper.decorationRects(gs, Underline, nil)
+	if lines < 2 || len(rects) != lines {
+		t.Fatalf("expected an underline for each of %d lines, got %v", lines, rects)
+	}
+	for i := 1; i < len(rects); i++ {
+		if rects[i].Min.Y <= rects[i-1].Min.Y {
+			t.Errorf("expected underline %d below underline %d, got %v", i, i-1, rects)
+		}
+	}
+
+	// Runs of distinct faces are decorated with their own metrics, and together
+	// cover the line.
+	gs = layoutGlyphs(shaper, "Hi سماء ok")
+	rects = shaper.shaper.decorationRects(gs, Underline, nil)
+	if len(rects) != 3 {
+		t.Fatalf("expected an underline for each of 3 runs, got %v", rects)
+	}
+	var covered float32
+	for _, r := range rects {
+		covered += r.Max.X - r.Min.X
+	}
+	last := gs[len(gs)-1]
+	if width := fixedToFloat(last.X + last.Advance - gs[0].X); covered != width {
+		t.Errorf("expected underlines to cover the width %v of the line, got %v", width, covered)
+	}
+
+	// Truncators are decorated like the text they replace.
+	params.MaxLines = 1
+	shaper.LayoutString(params, "one two three")
+	gs = gs[:0]
+	for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+		gs = append(gs, g)
+	}
+	rects = shaper.shaper.decorationRects(gs, Underline, nil)
+	end := fixedToFloat(gs[len(gs)-1].X + gs[len(gs)-1].Advance - gs[0].X)
+	if gs[len(gs)-1].Flags&FlagTruncator == 0 || len(rects) != 1 || rects[0].Max.X != end {
+		t.Errorf("expected a single underline ending with the truncator at %v, got %v", end, rects)
+	}
+
+	// Text without faces is not decorated.
+	empty := NewShaper(NoSystemFonts())
+	if rects := empty.shaper.decorationRects(layoutGlyphs(empty, "abc"), Underline, nil); len(rects) != 0 {
+		t.Errorf("expected no decorations without faces, got %v", rects)
+	}
+}
@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/fontscan"
+	"github.com/go-text/typesetting/opentype/api"
+	"github.com/go-text/typesetting/opentype/api/metadata"
+	"github.com/go-text/typesetting/shaping"
+	"golang.org/x/exp/slices"
+
+	giofont "gioui.org/font"
+)
+
+// AddFaces makes faces available to the text laid out by the shaper, in addition
+// to its collection. The faces take part in font fallback after the faces already
+// available, and their typefaces become default typefaces like those of the
+// collection.
+//
+// Only the cached layouts that may now use the faces are discarded: those of
+// text requesting the typeface of an added face and those of text not entirely
+// displayed with its preferred faces.
+func (l *Shaper) AddFaces(faces ...FontFace) {
+	l.init()
+	var families []string
+	for _, f := range faces {
+		l.shaper.Load(f)
+		l.shaper.defaultFaces = append(l.shaper.defaultFaces, string(f.Font.Typeface))
+		families = append(families, metadata.NormalizeFamily(string(f.Font.Typeface)))
+	}
+	l.shaper.hasQuery = false
+	l.layoutCache.Invalidate(func(_ layoutKey, doc document) bool {
+		return doc.usage.fallback || doc.usage.requests(families)
+	})
+}
+
+// RemoveFaces removes faces provided by [WithCollection] or [AddFaces] from the
+// shaper. Faces are identified by both their Font and Face. Text laid out later
+// falls back to the remaining faces.
+//
+// Only the cached layouts and glyph shapes using the removed faces are discarded.
+func (l *Shaper) RemoveFaces(faces ...FontFace) {
+	l.init()
+	removed := l.shaper.removeFaces(faces)
+	if len(removed) == 0 {
+		return
+	}
+	l.invalidateFaces(removed)
+}
+
+// invalidateFaces discards the cached layouts and glyphs using the removed
+// faces, whose indices may be reused by other faces.
+func (l *Shaper) invalidateFaces(removed map[int]bool) {
+	l.layoutCache.Invalidate(func(_ layoutKey, doc document) bool {
+		return doc.usage.uses(removed)
+	})
+	l.pathCache.invalidateFaces(removed)
+	l.bitmapShapeCache.invalidateFaces(removed)
+	l.decorationCache.invalidateFaces(removed)
+	l.shaper.bitmapGlyphCache.Invalidate(func(id GlyphID, _ bitmap) bool {
+		_, faceIdx, _ := splitGlyphID(id)
+		return removed[faceIdx]
+	})
+	l.shaper.colorGlyphCache.Invalidate(func(id GlyphID, _ []colorLayer) bool {
+		_, faceIdx, _ := splitGlyphID(id)
+		return removed[faceIdx]
+	})
+	l.shaper.hintedCache.Invalidate(func(id GlyphID, _ api.GlyphOutline) bool {
+		_, faceIdx, _ := splitGlyphID(id)
+		return removed[faceIdx]
+	})
+	l.lcdCache.invalidateFaces(removed)
+	for idx := range removed {
+		delete(l.shaper.colorTables, idx)
+		delete(l.shaper.hinters, idx)
+	}
+}
+
+// GlyphFace returns the face providing the glyph id and the index of the glyph
+// within it, for example to reduce the face to the glyphs of laid out text with
+// [gioui.org/font/opentype.Subset]. It reports false for glyphs of system fonts
+// and of removed faces, which are not provided by a FontFace.
+func (l *Shaper) GlyphFace(id GlyphID) (face giofont.Face, index uint16, ok bool) {
+	l.init()
+	_, faceIdx, gid := splitGlyphID(id)
+	if faceIdx >= len(l.shaper.faces) || l.shaper.faces[faceIdx] == nil || gid > 0xFFFF {
+		return nil, 0, false
+	}
+	face, ok = l.shaper.sources[l.shaper.faces[faceIdx].Font]
+	if !ok {
+		return nil, 0, false
+	}
+	return face, uint16(gid), true
+}
+
+// faceUsage describes the faces used to lay out a document, to determine whether
+// it is affected by the faces added to or removed from a shaper.
+type faceUsage struct {
+	// faces are the indices of the faces used.
+	faces []int
+	// families are the normalized families requested by the text.
+	families []string
+	// fallback reports whether some text was displayed with a face outside its
+	// requested families, or not covered by any face.
+	fallback bool
+}
+
+// record adds the faces chosen for inputs, split by splitByFaces from text
+// requesting families, to u.
+func (u *faceUsage) record(s *shaperImpl, families []string, inputs []shaping.Input) {
+	for _, family := range families {
+		family = metadata.NormalizeFamily(family)
+		if !slices.Contains(u.families, family) {
+			u.families = append(u.families, family)
+		}
+	}
+	for _, in := range inputs {
+		if in.RunStart == in.RunEnd {
+			// The empty string takes its metrics from its face.
+			if in.Face != nil {
+				if idx, ok := s.faceToIndex[in.Face.Font]; ok && !slices.Contains(u.faces, idx) {
+					u.faces = append(u.faces, idx)
+				}
+			}
+			continue
+		}
+		if in.Face == nil {
+			u.fallback = true
+			continue
+		}
+		idx := s.faceToIndex[in.Face.Font]
+		if !slices.Contains(u.faces, idx) {
+			u.faces = append(u.faces, idx)
+		}
+		family := metadata.NormalizeFamily(string(s.faceMeta[idx].Typeface))
+		if !slices.Contains(u.families, family) {
+			u.fallback = true
+		}
+		for i := in.RunStart; i < in.RunEnd && !u.fallback; i++ {
+			u.fallback = !covers(in.Face, in.Text[i])
+		}
+	}
+}
+
+// uses reports whether u includes any of the faces.
+func (u faceUsage) uses(faces map[int]bool) bool {
+	for _, idx := range u.faces {
+		if faces[idx] {
+			return true
+		}
+	}
+	return false
+}
+
+// requests reports whether u requests any of the normalized families.
+func (u faceUsage) requests(families []string) bool {
+	for _, family := range families {
+		if slices.Contains(u.families, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFaces removes the faces from the collection of s, and returns the indices
+// of the faces no longer available, including their variable instances.
+func (s *shaperImpl) removeFaces(faces []FontFace) map[int]bool {
+	isRemoved := func(f FontFace) bool {
+		for _, r := range faces {
+			if newFontKey(r.Font) == newFontKey(f.Font) && r.Face.Face().Font == f.Face.Face().Font {
+				return true
+			}
+		}
+		return false
+	}
+	var remaining []FontFace
+	for _, f := range s.collection {
+		if !isRemoved(f) {
+			remaining = append(remaining, f)
+		}
+	}
+	if len(remaining) == len(s.collection) {
+		return nil
+	}
+	// Faces may be provided more than once with different metadata, and remain
+	// available as long as any of them remains.
+	kept := make(map[font.Font]bool)
+	for _, f := range remaining {
+		kept[f.Face.Face().Font] = true
+	}
+	removed := make(map[int]bool)
+	removeFont := func(ft font.Font) {
+		if idx, ok := s.removeFace(ft); ok {
+			removed[idx] = true
+		}
+	}
+	for _, f := range s.collection {
+		ft := f.Face.Face().Font
+		if kept[ft] {
+			continue
+		}
+		removeFont(ft)
+		for key, inst := range s.instances {
+			if key.font == ft {
+				removeFont(inst.Font)
+				delete(s.instances, key)
+			}
+		}
+	}
+	// The font map can't forget faces, so rebuild it from the remaining faces.
+	s.collection = nil
+	s.defaultFaces = nil
+	s.fontMap = fontscan.NewFontMap(s.logger)
+	for _, f := range remaining {
+		s.Load(f)
+		s.defaultFaces = append(s.defaultFaces, string(f.Font.Typeface))
+	}
+	if s.systemFontsLoaded {
+		s.systemFonts = true
+		s.loadSystemFonts("rebuilding the font map")
+	}
+	s.hasQuery = false
+	return removed
+}
+
+// invalidateFaces removes the cached values of glyphs from any of the faces.
+func (c *glyphLRU[V]) invalidateFaces(faces map[int]bool) {
+	c.cache.Invalidate(func(_ uint64, v glyphValue[V]) bool {
+		for _, g := range v.glyphs {
+			if _, faceIdx, _ := splitGlyphID(g.ID); faces[faceIdx] {
+				return true
+			}
+		}
+		return false
+	})
+}
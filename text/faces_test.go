@@ -0,0 +1,188 @@
+package text
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-text/typesetting/opentype/api"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+
+	giofont "gioui.org/font"
+	"gioui.org/font/opentype"
+)
+
+// layoutGlyphs lays out str with shaper and returns its glyphs.
+func layoutGlyphs(shaper *Shaper, str string) []Glyph {
+	shaper.LayoutString(Parameters{PxPerEm: fixed.I(16), MaxWidth: 1000, Locale: english}, str)
+	var gs []Glyph
+	for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+func TestAddFaces(t *testing.T) {
+	collection := coverageCollection(t)
+	shaper := NewShaper(NoSystemFonts(), WithCollection(collection[:1]))
+	layoutGlyphs(shaper, "Hi")
+	layoutGlyphs(shaper, "سماء")
+	if cov := shaper.Coverage(giofont.Font{}, "سماء"); len(cov.Uncovered) != 4 {
+		t.Fatalf("expected Arabic text to be uncovered, got %+v", cov)
+	}
+	if n := len(shaper.layoutCache.m); n != 2 {
+		t.Fatalf("expected 2 cached layouts, got %d", n)
+	}
+
+	shaper.AddFaces(collection[1])
+	// Only the layout displayed with fallback faces is discarded.
+	if n := len(shaper.layoutCache.m); n != 1 {
+		t.Errorf("expected 1 cached layout after adding a face, got %d", n)
+	}
+	cov := shaper.Coverage(giofont.Font{}, "سماء")
+	if len(cov.Uncovered) != 0 || len(cov.Runs) != 1 || cov.Runs[0].Face.Face != collection[1].Face {
+		t.Errorf("expected Arabic text to use the added face, got %+v", cov)
+	}
+	for _, g := range layoutGlyphs(shaper, "سماء") {
+		if _, faceIdx, _ := splitGlyphID(g.ID); faceIdx != 1 && g.Flags&FlagParagraphBreak == 0 {
+			t.Errorf("expected glyph %+v from the added face", g)
+		}
+	}
+}
+
+func TestRemoveFaces(t *testing.T) {
+	collection := coverageCollection(t)
+	shaper := NewShaper(NoSystemFonts(), WithCollection(collection))
+	latin := layoutGlyphs(shaper, "Hi")
+	arabic := layoutGlyphs(shaper, "سماء")
+	shaper.Shape(latin)
+	shaper.Shape(arabic)
+	if n := len(shaper.pathCache.cache.m); n != 2 {
+		t.Fatalf("expected 2 cached paths, got %d", n)
+	}
+
+	shaper.RemoveFaces(collection[1])
+	if n := len(shaper.layoutCache.m); n != 1 {
+		t.Errorf("expected 1 cached layout after removing a face, got %d", n)
+	}
+	if _, ok := shaper.pathCache.Get(shaper.pathCache.hashGlyphs(latin), latin); !ok {
+		t.Errorf("expected the path of the remaining face to stay cached")
+	}
+	if _, ok := shaper.pathCache.Get(shaper.pathCache.hashGlyphs(arabic), arabic); ok {
+		t.Errorf("expected the path of the removed face to be discarded")
+	}
+	if cov := shaper.Coverage(giofont.Font{}, "سماء"); len(cov.Uncovered) != 4 {
+		t.Errorf("expected Arabic text to be uncovered after removing its face, got %+v", cov)
+	}
+	for _, g := range layoutGlyphs(shaper, "سماء") {
+		if _, faceIdx, _ := splitGlyphID(g.ID); faceIdx == 1 {
+			t.Errorf("expected no glyph from the removed face, got %+v", g)
+		}
+	}
+
+	// Removing a face again has no effect.
+	shaper.RemoveFaces(collection[1])
+	if cov := shaper.Coverage(giofont.Font{}, "Hi"); len(cov.Runs) != 1 || cov.Runs[0].Face.Face != collection[0].Face {
+		t.Errorf("expected Latin text to use the remaining face, got %+v", cov)
+	}
+}
+
+// TestRemoveFirstFace ensures that the empty string is laid out with a remaining
+// face once the first face is removed, and that the index of the removed face is
+// reused.
+func TestRemoveFirstFace(t *testing.T) {
+	collection := coverageCollection(t)
+	shaper := NewShaper(NoSystemFonts(), WithCollection(collection))
+	empty := layoutGlyphs(shaper, "")
+	shaper.RemoveFaces(collection[0])
+	gs := layoutGlyphs(shaper, "")
+	if len(gs) != 1 || gs[0].Ascent == 0 || gs[0].Ascent == empty[0].Ascent {
+		t.Errorf("expected the empty string to use the metrics of the remaining face, got %+v", gs)
+	}
+
+	faces := len(shaper.shaper.faces)
+	shaper.AddFaces(collection[0])
+	if n := len(shaper.shaper.faces); n != faces {
+		t.Errorf("expected the added face to reuse the index of the removed face, got %d faces", n)
+	}
+	for _, g := range layoutGlyphs(shaper, "Hi") {
+		if _, faceIdx, _ := splitGlyphID(g.ID); faceIdx != 0 {
+			t.Errorf("expected glyph %+v from the face at index 0", g)
+		}
+	}
+}
+
+func TestSubsetGlyphFaces(t *testing.T) {
+	face, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: face}}))
+	const txt = "Héllo"
+	gs := layoutGlyphs(shaper, txt)
+	var set opentype.GlyphSet
+	for _, g := range gs {
+		f, idx, ok := shaper.GlyphFace(g.ID)
+		if !ok || f != face {
+			t.Fatalf("expected glyph %v to be provided by the face", g.ID)
+		}
+		set.Glyphs = append(set.Glyphs, idx)
+	}
+	src, err := opentype.Subset(goregular.TTF, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src) >= len(goregular.TTF)/4 {
+		t.Errorf("expected a subset much smaller than %d bytes, got %d", len(goregular.TTF), len(src))
+	}
+	sub, err := opentype.Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sub.Font(), face.Font()) {
+		t.Errorf("expected subset font %+v, got %+v", face.Font(), sub.Font())
+	}
+	// The subset lays out the text identically.
+	subShaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: sub}}))
+	subGs := layoutGlyphs(subShaper, txt)
+	if len(subGs) != len(gs) {
+		t.Fatalf("expected %d glyphs in subset layout, got %d", len(gs), len(subGs))
+	}
+	for i := range gs {
+		if subGs[i].ID != gs[i].ID || subGs[i].X != gs[i].X || subGs[i].Advance != gs[i].Advance {
+			t.Errorf("glyph %d: expected %+v, got %+v", i, gs[i], subGs[i])
+		}
+	}
+	// The outlines and characters of other glyphs are dropped.
+	ot, subOt := face.Face(), sub.Face()
+	for _, r := range "Hé" {
+		gid, _ := ot.NominalGlyph(r)
+		want := ot.GlyphData(gid).(api.GlyphOutline)
+		got, ok := subOt.GlyphData(gid).(api.GlyphOutline)
+		if !ok || len(got.Segments) != len(want.Segments) {
+			t.Errorf("expected outline of %q to be kept", r)
+		}
+		if subGid, ok := subOt.NominalGlyph(r); !ok || subGid != gid {
+			t.Errorf("expected %q to be kept in the character map", r)
+		}
+	}
+	gidZ, _ := ot.NominalGlyph('Z')
+	if got, ok := subOt.GlyphData(gidZ).(api.GlyphOutline); ok && len(got.Segments) != 0 {
+		t.Error("expected the outline of 'Z' to be dropped")
+	}
+	if _, ok := subOt.NominalGlyph('Z'); ok {
+		t.Error("expected 'Z' to be dropped from the character map")
+	}
+
+	// The layers of color glyphs are kept with them.
+	src, err = opentype.Subset(colorFontSrc(t), opentype.GlyphSet{Runes: []rune{'A'}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, err = opentype.Parse(src); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sub.Face().NominalGlyph('B'); !ok {
+		t.Error("expected the 'B' layer of 'A' to be kept")
+	}
+}
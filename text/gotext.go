@@ -9,13 +9,17 @@ import (
 	"io"
 	"log"
 	"os"
+	"sort"
+	"unicode"
 
 	"github.com/go-text/typesetting/di"
 	"github.com/go-text/typesetting/font"
 	"github.com/go-text/typesetting/fontscan"
 	"github.com/go-text/typesetting/language"
 	"github.com/go-text/typesetting/opentype/api"
+	fontapi "github.com/go-text/typesetting/opentype/api/font"
 	"github.com/go-text/typesetting/opentype/api/metadata"
+	"github.com/go-text/typesetting/opentype/loader"
 	"github.com/go-text/typesetting/shaping"
 	"golang.org/x/exp/slices"
 	"golang.org/x/image/math/fixed"
@@ -29,6 +33,7 @@ import (
 	"gioui.org/op"
 	"gioui.org/op/clip"
 	"gioui.org/op/paint"
+	"gioui.org/text/internal/colr"
 )
 
 // document holds a collection of shaped lines and alignment information for
@@ -39,14 +44,28 @@ type document struct {
 	// alignWidth is the width used when aligning text.
 	alignWidth      int
 	unreadRuneCount int
+	// usage describes the faces used by the lines. It is only recorded for
+	// documents laid out by a shaperImpl, not for documents appended together.
+	usage faceUsage
 }
 
 // append adds the lines of other to the end of l and ensures they
 // are aligned to the same width.
 func (l *document) append(other document) {
+	start := len(l.lines)
 	l.lines = append(l.lines, other.lines...)
 	l.alignWidth = max(l.alignWidth, other.alignWidth)
-	calculateYOffsets(l.lines)
+	if start == 0 {
+		calculateYOffsets(l.lines)
+		return
+	}
+	// Only the appended lines move, which keeps appending the paragraphs of
+	// long documents linear.
+	currentY := l.lines[start-1].yOffset
+	for i := start; i < len(l.lines); i++ {
+		currentY += l.lines[i].lineHeight.Round()
+		l.lines[i].yOffset = currentY
+	}
 }
 
 // reset empties the document in preparation to reuse its memory.
@@ -55,6 +74,7 @@ func (l *document) reset() {
 	l.alignment = Start
 	l.alignWidth = 0
 	l.unreadRuneCount = 0
+	l.usage = faceUsage{}
 }
 
 func max(a, b int) int {
@@ -92,6 +112,12 @@ type line struct {
 	direction system.TextDirection
 	// runeCount is the number of text runes represented by this line's runs.
 	runeCount int
+	// paragraphEnd reports whether the line is the final line of its paragraph.
+	// Such lines are never justified.
+	paragraphEnd bool
+	// trailing is the advance of the whitespace at the logical end of the line,
+	// which is excluded from the width of justified lines.
+	trailing fixed.Int26_6
 
 	yOffset int
 }
@@ -177,8 +203,23 @@ type glyph struct {
 	// bounds describes the visual bounding box of the glyph relative to
 	// its dot.
 	bounds fixed.Rectangle26_6
+	// expansion is the kind of justification opportunity following the glyph.
+	expansion expansion
 }
 
+// expansion describes whether the advance of a glyph can be expanded when
+// justifying a line.
+type expansion uint8
+
+const (
+	expandNone expansion = iota
+	// expandWord marks the final glyph of a word separator.
+	expandWord
+	// expandCharacter marks the final glyph of a character of a script
+	// written without word separators.
+	expandCharacter
+)
+
 type runLayout struct {
 	// VisualPosition describes the relative position of this run of text within
 	// its line. It should be a valid index into the containing line's VisualOrder
@@ -205,6 +246,41 @@ type runLayout struct {
 	// truncator indicates that this run is a text truncator standing in for remaining
 	// text.
 	truncator bool
+	// hyphen indicates that this run is a hyphen inserted at the end of a line
+	// broken within a word.
+	hyphen bool
+	// span is the index of the Span that this run was shaped from.
+	span int
+	// placeholder indicates that this run is the inline object of a
+	// placeholder span.
+	placeholder bool
+	// wordGaps and charGaps count the glyphs of the run that are marked with
+	// expandWord and expandCharacter.
+	wordGaps, charGaps int
+}
+
+// spanStyle describes the style of a range of runes within a paragraph.
+type spanStyle struct {
+	font giofont.Font
+	ppem fixed.Int26_6
+	// runes is the number of runes styled by the span.
+	runes int
+	// index is the index of the Span that the runes originated from.
+	index int
+	// placeholder is the inline object of the span, if any.
+	placeholder Placeholder
+}
+
+// spanAt returns the span containing the rune at runeIdx, or the final span if
+// runeIdx is beyond the end of spans.
+func spanAt(spans []spanStyle, runeIdx int) spanStyle {
+	for _, sp := range spans {
+		if runeIdx < sp.runes {
+			return sp
+		}
+		runeIdx -= sp.runes
+	}
+	return spans[len(spans)-1]
 }
 
 // shaperImpl implements the shaping and line-wrapping of opentype fonts.
@@ -215,8 +291,26 @@ type shaperImpl struct {
 	faceToIndex  map[font.Font]int
 	faceMeta     []giofont.Font
 	defaultFaces []string
-	logger       interface {
+	// collection holds the faces loaded with Load, in order.
+	collection []FontFace
+	// instances maps variable faces and axis values to the faces instantiated
+	// from them. At most maxInstances are kept, and staticFaces records the
+	// faces found to have no variation axes.
+	instances   map[faceInstance]font.Face
+	staticFaces map[font.Font]bool
+	// faceUse records, for each face index, the value of useClock when the face
+	// was last used, to evict the least recently used instances.
+	faceUse  []uint64
+	useClock uint64
+	// freeFaces are the indices of the removed faces, reused by the faces
+	// added later.
+	freeFaces []int
+	// sources maps the faces loaded from the collection, and the instances of
+	// such faces, to the collection face they were loaded from.
+	sources map[font.Font]giofont.Face
+	logger  interface {
 		Printf(format string, args ...any)
+		Fallbackf(format string, args ...any)
 	}
 	parser parser
 
@@ -225,14 +319,51 @@ type shaperImpl struct {
 	wrapper       shaping.LineWrapper
 	bidiParagraph bidi.Paragraph
 
+	// queryFont is the font most recently used to configure the fontMap query,
+	// without its variations, which don't affect the query, and hasQuery
+	// reports whether it is valid.
+	queryFont fontKey
+	hasQuery  bool
+	// queryFamilies are the families of the query, in order of preference.
+	queryFamilies []string
+
+	// systemFonts reports whether the system fonts should be loaded once the
+	// faces of the collection don't suffice, and fontCacheDir is the directory
+	// of their index.
+	systemFonts  bool
+	fontCacheDir string
+	// hinting selects the hinting of glyph outlines. hinters maps face
+	// indices to their hinters, or nil for faces that can't be hinted, and
+	// hintedCache caches the hinted outlines of glyphs.
+	hinting     Hinting
+	hinters     map[int]*hinter
+	hintedCache hintedCache
+	// systemFontsLoaded reports whether the system fonts were added to the font
+	// map, and must be added again if it is rebuilt.
+	systemFontsLoaded bool
+	// useSystemFonts adds the system fonts indexed in cacheDir to the font map.
+	// It is replaced by tests to avoid depending on the fonts of the host.
+	useSystemFonts func(fm *fontscan.FontMap, cacheDir string) error
+
+	// usage records the faces used by the text being laid out.
+	usage faceUsage
+
 	// Scratch buffers used to avoid re-allocating slices during routine internal
 	// shaping operations.
 	splitScratch1, splitScratch2 []shaping.Input
 	outScratchBuf                []shaping.Output
 	scratchRunes                 []rune
+	scratchSpans                 []spanStyle
+	scratchFeatures              []shaping.FontFeature
+	scratchTabs                  []int
 
 	// bitmapGlyphCache caches extracted bitmap glyph images.
 	bitmapGlyphCache bitmapCache
+	// colorTables maps face indices to their color glyphs, or nil for faces
+	// without color glyphs, and colorGlyphCache caches the layers of color
+	// glyphs.
+	colorTables     map[int]*colr.Table
+	colorGlyphCache colorCache
 }
 
 // debugLogger only logs messages if debug.Text is true.
@@ -250,21 +381,24 @@ func (d debugLogger) Printf(format string, args ...any) {
 	}
 }
 
+// Fallbackf logs a message about the faces chosen for text if debug.TextFallback
+// is true.
+func (d debugLogger) Fallbackf(format string, args ...any) {
+	if debug.TextFallback.Load() {
+		d.Logger.Printf(format, args...)
+	}
+}
+
 func newShaperImpl(systemFonts bool, collection []FontFace) *shaperImpl {
 	var shaper shaperImpl
 	shaper.logger = newDebugLogger()
 	shaper.fontMap = fontscan.NewFontMap(shaper.logger)
 	shaper.faceToIndex = make(map[font.Font]int)
-	if systemFonts {
-		str, err := os.UserCacheDir()
-		if err != nil {
-			shaper.logger.Printf("failed resolving font cache dir: %v", err)
-			shaper.logger.Printf("skipping system font load")
-		}
-		if err := shaper.fontMap.UseSystemFonts(str); err != nil {
-			shaper.logger.Printf("failed loading system fonts: %v", err)
-		}
-	}
+	shaper.instances = make(map[faceInstance]font.Face)
+	shaper.staticFaces = make(map[font.Font]bool)
+	shaper.sources = make(map[font.Font]giofont.Face)
+	shaper.systemFonts = systemFonts
+	shaper.useSystemFonts = (*fontscan.FontMap).UseSystemFonts
 	for _, f := range collection {
 		shaper.Load(f)
 		shaper.defaultFaces = append(shaper.defaultFaces, string(f.Font.Typeface))
@@ -278,8 +412,13 @@ func newShaperImpl(systemFonts bool, collection []FontFace) *shaperImpl {
 // in the order in which they are loaded, with the first face being the default.
 func (s *shaperImpl) Load(f FontFace) {
 	desc := opentype.FontToDescription(f.Font)
-	s.fontMap.AddFace(f.Face.Face(), fontscan.Location{File: fmt.Sprint(desc)}, desc)
-	s.addFace(f.Face.Face(), f.Font)
+	face := f.Face.Face()
+	s.fontMap.AddFace(face, fontscan.Location{File: fmt.Sprint(desc)}, desc)
+	s.addFace(face, f.Font)
+	if _, ok := s.sources[face.Font]; !ok {
+		s.sources[face.Font] = f.Face
+	}
+	s.collection = append(s.collection, f)
 }
 
 func (s *shaperImpl) addFace(f font.Face, md giofont.Font) {
@@ -287,10 +426,49 @@ func (s *shaperImpl) addFace(f font.Face, md giofont.Font) {
 		return
 	}
 	s.logger.Printf("loaded face %s(style:%s, weight:%d)", md.Typeface, md.Style, md.Weight)
+	if n := len(s.freeFaces); n > 0 {
+		idx := s.freeFaces[0]
+		s.freeFaces = s.freeFaces[1:]
+		s.faceToIndex[f.Font] = idx
+		s.faces[idx] = f
+		s.faceMeta[idx] = md
+		s.faceUse[idx] = s.useClock
+		return
+	}
 	idx := len(s.faces)
 	s.faceToIndex[f.Font] = idx
 	s.faces = append(s.faces, f)
 	s.faceMeta = append(s.faceMeta, md)
+	s.faceUse = append(s.faceUse, s.useClock)
+}
+
+// firstFace returns the first face of the collection, or if it is empty, the
+// first face that isn't removed. It returns nil if there is no face.
+func (s *shaperImpl) firstFace() font.Face {
+	if len(s.collection) > 0 {
+		return s.collection[0].Face.Face()
+	}
+	for _, f := range s.faces {
+		if f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// removeFace removes the face of ft, freeing its index for reuse by the faces
+// added later. It returns the index, and false if ft is not a face of s.
+func (s *shaperImpl) removeFace(ft font.Font) (int, bool) {
+	delete(s.sources, ft)
+	delete(s.staticFaces, ft)
+	idx, ok := s.faceToIndex[ft]
+	if !ok {
+		return 0, false
+	}
+	s.faces[idx] = nil
+	delete(s.faceToIndex, ft)
+	s.freeFaces = append(s.freeFaces, idx)
+	return idx, true
 }
 
 // splitByScript divides the inputs into new, smaller inputs on script boundaries
@@ -374,11 +552,58 @@ func (s *shaperImpl) splitBidi(input shaping.Input) []shaping.Input {
 	return splitInputs
 }
 
+// loadSystemFonts adds the system fonts to the font map, if requested and not
+// already loaded. Loading is deferred until the faces of the collection don't
+// suffice, because indexing the system fonts may take a while the first time.
+// The index is cached on disk, and the font data of a system face is only
+// loaded when the face is first resolved.
+func (s *shaperImpl) loadSystemFonts(reason string) {
+	if !s.systemFonts {
+		return
+	}
+	s.systemFonts = false
+	s.systemFontsLoaded = true
+	s.logger.Printf("loading system fonts: %s", reason)
+	dir := s.fontCacheDir
+	if dir == "" {
+		var err error
+		dir, err = os.UserCacheDir()
+		if err != nil {
+			s.logger.Printf("failed resolving font cache dir: %v", err)
+			s.logger.Printf("skipping system font load")
+			return
+		}
+	}
+	if err := s.useSystemFonts(s.fontMap, dir); err != nil {
+		s.logger.Printf("failed loading system fonts: %v", err)
+	}
+}
+
+// missingFamily reports whether the collection lacks a family preferred over
+// all the families it has. Every family listed before the first family of the
+// collection must then be looked up among the system fonts.
+func (s *shaperImpl) missingFamily(families []string) bool {
+	if len(families) == 0 {
+		return true
+	}
+	family := metadata.NormalizeFamily(families[0])
+	for _, md := range s.faceMeta {
+		if metadata.NormalizeFamily(string(md.Typeface)) == family {
+			return false
+		}
+	}
+	return true
+}
+
 // ResolveFace allows shaperImpl to implement shaping.FontMap, wrapping its fontMap
 // field and ensuring that any faces loaded as part of the search are registered with
 // ids so that they can be referred to by a GlyphID.
 func (s *shaperImpl) ResolveFace(r rune) font.Face {
 	face := s.fontMap.ResolveFace(r)
+	if s.systemFonts && !covers(face, r) {
+		s.loadSystemFonts(fmt.Sprintf("no face covers %U", r))
+		face = s.fontMap.ResolveFace(r)
+	}
 	if face != nil {
 		family, aspect := s.fontMap.FontMetadata(face.Font)
 		md := opentype.DescriptionToFont(metadata.Description{
@@ -391,9 +616,10 @@ func (s *shaperImpl) ResolveFace(r rune) font.Face {
 	return nil
 }
 
-// splitByFaces divides the inputs by font coverage in the provided faces. It will use the slice provided in buf
-// as the backing storage of the returned slice if buf is non-nil.
-func (s *shaperImpl) splitByFaces(inputs []shaping.Input, buf []shaping.Input) []shaping.Input {
+// splitByFaces divides the inputs by font coverage in the provided faces, resolving
+// faces for each input using the font of the span containing it. It will use the slice
+// provided in buf as the backing storage of the returned slice if buf is non-nil.
+func (s *shaperImpl) splitByFaces(inputs []shaping.Input, spans []spanStyle, buf []shaping.Input) []shaping.Input {
 	var split []shaping.Input
 	if buf == nil {
 		split = make([]shaping.Input, 0, len(inputs))
@@ -401,30 +627,223 @@ func (s *shaperImpl) splitByFaces(inputs []shaping.Input, buf []shaping.Input) [
 		split = buf
 	}
 	for _, input := range inputs {
+		span := spanAt(spans, input.RunStart)
+		if span.placeholder != (Placeholder{}) {
+			// Inline objects are not shaped with a face.
+			split = append(split, input)
+			continue
+		}
+		font := span.font
+		s.setQuery(font)
+		start := len(split)
 		split = append(split, shaping.SplitByFace(input, s)...)
+		if len(font.Variations) > 0 {
+			for i := start; i < len(split); i++ {
+				split[i].Face = s.instance(split[i].Face, font.Variations)
+			}
+		}
+		s.logFallback(font, split[start:])
+		s.usage.record(s, s.queryFamilies, split[start:])
 	}
 	return split
 }
 
+// faceInstance identifies a font instantiated at particular variation axis values.
+type faceInstance struct {
+	font font.Font
+	// variations encodes the axis values.
+	variations string
+}
+
+// maxInstances is the number of variable font instances kept by a shaper. The
+// least recently used instances beyond it are evicted by trimInstances.
+const maxInstances = 256
+
+// instance returns face instantiated at the axis values of variations, registering
+// the instance as a distinct face so that its glyphs and cached paths are never
+// confused with those of other instances. Faces without variation axes are returned
+// unchanged.
+func (s *shaperImpl) instance(face font.Face, variations []giofont.Variation) font.Face {
+	if face == nil || s.staticFaces[face.Font] {
+		return face
+	}
+	key := faceInstance{font: face.Font, variations: variationsKey(variations)}
+	if inst, ok := s.instances[key]; ok {
+		s.faceUse[s.faceToIndex[inst.Font]] = s.useClock
+		return inst
+	}
+	var vars []fontapi.Variation
+	for _, v := range variations {
+		if len(v.Tag) != 4 {
+			continue
+		}
+		vars = append(vars, fontapi.Variation{Tag: loader.MustNewTag(v.Tag), Value: v.Value})
+	}
+	// Copy the font so that the instance is distinct from the original face in
+	// both the harfbuzz font cache and faceToIndex. The font data is read-only and
+	// safe to share.
+	ft := *face.Font
+	inst := &fontapi.Face{Font: &ft}
+	inst.SetVariations(vars)
+	if len(inst.Coords) == 0 {
+		// The face is not variable.
+		s.staticFaces[face.Font] = true
+		return face
+	}
+	md := s.faceMeta[s.faceToIndex[face.Font]]
+	md.Variations = slices.Clone(variations)
+	s.addFace(inst, md)
+	if src, ok := s.sources[face.Font]; ok {
+		s.sources[inst.Font] = src
+	}
+	s.instances[key] = inst
+	return inst
+}
+
+// useFaces records that the faces of gs are in use, keeping their instances
+// from being evicted.
+func (s *shaperImpl) useFaces(gs []Glyph) {
+	if len(s.instances) == 0 {
+		return
+	}
+	last := -1
+	for _, g := range gs {
+		if _, idx, _ := splitGlyphID(g.ID); idx != last && idx < len(s.faceUse) {
+			s.faceUse[idx] = s.useClock
+			last = idx
+		}
+	}
+}
+
+// useFaceIndices records that the faces of the indices are in use.
+func (s *shaperImpl) useFaceIndices(faces []int) {
+	for _, idx := range faces {
+		if idx < len(s.faceUse) {
+			s.faceUse[idx] = s.useClock
+		}
+	}
+}
+
+// trimInstances starts a new period of use of the faces, evicting the least
+// recently used instances beyond maxInstances. It returns the indices of the
+// evicted faces, which are reused by the faces added later.
+func (s *shaperImpl) trimInstances() map[int]bool {
+	s.useClock++
+	if len(s.instances) <= maxInstances {
+		return nil
+	}
+	keys := make([]faceInstance, 0, len(s.instances))
+	for key := range s.instances {
+		keys = append(keys, key)
+	}
+	used := func(key faceInstance) uint64 {
+		return s.faceUse[s.faceToIndex[s.instances[key].Font]]
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return used(keys[i]) < used(keys[j])
+	})
+	removed := make(map[int]bool)
+	for _, key := range keys[:len(keys)-maxInstances] {
+		if idx, ok := s.removeFace(s.instances[key].Font); ok {
+			removed[idx] = true
+		}
+		delete(s.instances, key)
+	}
+	return removed
+}
+
+// splitBySpans divides the inputs on the boundaries of the provided spans and sets the
+// size of each input to that of its span. It will use the slice provided in buf as the
+// backing storage of the returned slice if buf is non-nil.
+func splitBySpans(inputs []shaping.Input, spans []spanStyle, buf []shaping.Input) []shaping.Input {
+	var split []shaping.Input
+	if buf == nil {
+		split = make([]shaping.Input, 0, len(inputs))
+	} else {
+		split = buf
+	}
+	for _, input := range inputs {
+		if input.RunStart == input.RunEnd {
+			input.Size = spanAt(spans, input.RunStart).ppem
+			split = append(split, input)
+			continue
+		}
+		spanStart := 0
+		for _, sp := range spans {
+			spanEnd := spanStart + sp.runes
+			start, end := max(spanStart, input.RunStart), min(spanEnd, input.RunEnd)
+			if start < end {
+				current := input
+				current.RunStart, current.RunEnd = start, end
+				current.Size = sp.ppem
+				split = append(split, current)
+			}
+			spanStart = spanEnd
+			if spanStart >= input.RunEnd {
+				break
+			}
+		}
+	}
+	return split
+}
+
+// setQuery configures the font map to resolve faces matching font.
+func (s *shaperImpl) setQuery(font giofont.Font) {
+	query := fontKey{typeface: font.Typeface, style: font.Style, weight: font.Weight}
+	if s.hasQuery && s.queryFont == query {
+		return
+	}
+	s.hasQuery = true
+	s.queryFont = query
+	families := s.defaultFaces
+	if font.Typeface != "" {
+		parsed, err := s.parser.parse(string(font.Typeface))
+		if err != nil {
+			s.logger.Printf("Unable to parse typeface %q: %v", font.Typeface, err)
+		} else {
+			families = parsed
+		}
+	}
+	s.queryFamilies = families
+	if s.systemFonts && s.missingFamily(families) {
+		s.loadSystemFonts(fmt.Sprintf("no face of families %q", families))
+	}
+	s.fontMap.SetQuery(fontscan.Query{
+		Families: families,
+		Aspect:   opentype.FontToDescription(font).Aspect,
+	})
+}
+
 // shapeText invokes the text shaper and returns the raw text data in the shaper's native
-// format. It does not wrap lines.
-func (s *shaperImpl) shapeText(ppem fixed.Int26_6, lc system.Locale, txt []rune) []shaping.Output {
+// format. The spans must cover every rune of txt and determine the font and size of
+// the runes they cover. The features are applied to all of txt. It does not wrap lines.
+func (s *shaperImpl) shapeText(lc system.Locale, features []shaping.FontFeature, txt []rune, spans []spanStyle) []shaping.Output {
 	lcfg := langConfig{
 		Language:  language.NewLanguage(lc.Language),
 		Direction: mapDirection(lc.Direction),
 	}
 	// Create an initial input.
-	input := toInput(nil, ppem, lcfg, txt)
-	if input.RunStart == input.RunEnd && len(s.faces) > 0 {
+	input := toInput(nil, spans[0].ppem, lcfg, txt)
+	input.FontFeatures = features
+	if input.RunStart == input.RunEnd {
 		// Give the empty string a face. This is a necessary special case because
 		// the face splitting process works by resolving faces for each rune, and
 		// the empty string contains no runes.
-		input.Face = s.faces[0]
+		input.Face = s.firstFace()
 	}
 	// Break input on font glyph coverage.
 	inputs := s.splitBidi(input)
-	inputs = s.splitByFaces(inputs, s.splitScratch1[:0])
+	inputs = splitBySpans(inputs, spans, s.splitScratch2[:0])
+	inputs = s.splitByFaces(inputs, spans, s.splitScratch1[:0])
 	inputs = splitByScript(inputs, lcfg.Direction, s.splitScratch2[:0])
+	vertical := lc.Direction.Axis() == system.Vertical
+	if vertical {
+		// Vertical text is set upright, in logical order.
+		for i := range inputs {
+			inputs[i].Direction = di.DirectionTTB
+			inputs[i].Direction.SetSideways(false)
+		}
+	}
 	// Shape all inputs.
 	if needed := len(inputs) - len(s.outScratchBuf); needed > 0 {
 		s.outScratchBuf = slices.Grow(s.outScratchBuf, needed)
@@ -433,6 +852,8 @@ func (s *shaperImpl) shapeText(ppem fixed.Int26_6, lc system.Locale, txt []rune)
 	for _, input := range inputs {
 		if input.Face != nil {
 			s.outScratchBuf = append(s.outScratchBuf, s.shaper.Shape(input))
+		} else if p := spanAt(spans, input.RunStart).placeholder; p != (Placeholder{}) {
+			s.outScratchBuf = append(s.outScratchBuf, placeholderOutput(input, p))
 		} else {
 			s.outScratchBuf = append(s.outScratchBuf, shaping.Output{
 				// Use the text size as the advance of the entire fake run so that
@@ -474,9 +895,61 @@ func (s *shaperImpl) shapeText(ppem fixed.Int26_6, lc system.Locale, txt []rune)
 			})
 		}
 	}
+	if vertical {
+		toInlineAdvances(s.outScratchBuf)
+	}
 	return s.outScratchBuf
 }
 
+// placeholderOutput returns the output of the inline object described by p, a
+// single glyph with the dimensions of the object.
+func placeholderOutput(input shaping.Input, p Placeholder) shaping.Output {
+	bounds := shaping.Bounds{Ascent: p.Ascent, Descent: -p.Descent}
+	return shaping.Output{
+		Advance: p.Width,
+		Size:    input.Size,
+		Glyphs: []shaping.Glyph{
+			{
+				Width:        p.Width,
+				Height:       -(p.Ascent + p.Descent),
+				YBearing:     p.Ascent,
+				XAdvance:     p.Width,
+				ClusterIndex: input.RunStart,
+				RuneCount:    input.RunEnd - input.RunStart,
+				GlyphCount:   1,
+			},
+		},
+		LineBounds:  bounds,
+		GlyphBounds: bounds,
+		Direction:   input.Direction,
+		Runes: shaping.Range{
+			Offset: input.RunStart,
+			Count:  input.RunEnd - input.RunStart,
+		},
+	}
+}
+
+// toInlineAdvances converts the outputs of vertical text to the horizontal form
+// expected by the line wrapper and the layout of lines, with each glyph advancing
+// along the X axis by its vertical advance. The glyph offsets are left vertical,
+// and are interpreted by toGioGlyphs.
+func toInlineAdvances(outputs []shaping.Output) {
+	for i := range outputs {
+		out := &outputs[i]
+		out.Direction = di.DirectionLTR
+		if out.Face == nil {
+			// Runs without a face already advance by their size.
+			continue
+		}
+		for j := range out.Glyphs {
+			g := &out.Glyphs[j]
+			// Vertical advances point down, toward negative Y.
+			g.XAdvance, g.YAdvance = -g.YAdvance, 0
+		}
+		out.RecomputeAdvance()
+	}
+}
+
 func wrapPolicyToGoText(p WrapPolicy) shaping.LineBreakPolicy {
 	switch p {
 	case WrapGraphemes:
@@ -489,35 +962,170 @@ func wrapPolicyToGoText(p WrapPolicy) shaping.LineBreakPolicy {
 }
 
 // shapeAndWrapText invokes the text shaper and returns wrapped lines in the shaper's native format.
-func (s *shaperImpl) shapeAndWrapText(params Parameters, txt []rune) (_ []shaping.Line, truncated int) {
+// If spans is empty, the entire text is styled by the Font and PxPerEm of params.
+func (s *shaperImpl) shapeAndWrapText(params Parameters, txt []rune, spans []spanStyle) (_ []shaping.Line, truncated int) {
 	wc := shaping.WrapConfig{
 		TruncateAfterLines: params.MaxLines,
 		TextContinues:      params.forceTruncate,
 		BreakPolicy:        wrapPolicyToGoText(params.WrapPolicy),
 	}
-	families := s.defaultFaces
-	if params.Font.Typeface != "" {
-		parsed, err := s.parser.parse(string(params.Font.Typeface))
-		if err != nil {
-			s.logger.Printf("Unable to parse typeface %q: %v", params.Font.Typeface, err)
-		} else {
-			families = parsed
-		}
+	paramSpan := spanStyle{font: params.Font, ppem: params.PxPerEm, runes: len(txt)}
+	if len(spans) == 0 {
+		s.scratchSpans = append(s.scratchSpans[:0], paramSpan)
+		spans = s.scratchSpans
 	}
-	s.fontMap.SetQuery(fontscan.Query{
-		Families: families,
-		Aspect:   opentype.FontToDescription(params.Font).Aspect,
-	})
+	s.scratchFeatures = toFontFeatures(params.Features, s.scratchFeatures[:0])
 	if wc.TruncateAfterLines > 0 {
 		if len(params.Truncator) == 0 {
 			params.Truncator = "…"
 		}
+		truncator := []rune(params.Truncator)
+		paramSpan.runes = len(truncator)
 		// We only permit a single run as the truncator, regardless of whether more were generated.
 		// Just use the first one.
-		wc.Truncator = s.shapeText(params.PxPerEm, params.Locale, []rune(params.Truncator))[0]
+		truncatorOutput := s.shapeText(params.Locale, s.scratchFeatures, truncator, []spanStyle{paramSpan})[:1]
+		applySpacing(truncatorOutput, truncator, params.LetterSpacing, params.WordSpacing)
+		wc.Truncator = truncatorOutput[0]
+	}
+	useTabStops := params.TabWidth > 0 || len(params.TabStops) > 0
+	s.scratchTabs = s.scratchTabs[:0]
+	if useTabStops {
+		s.scratchTabs = replaceTabs(txt, s.scratchTabs)
 	}
+	outputs := s.shapeText(params.Locale, s.scratchFeatures, txt, spans)
+	applySpacing(outputs, txt, params.LetterSpacing, params.WordSpacing)
 	// Wrap outputs into lines.
-	return s.wrapper.WrapParagraph(wc, params.MaxWidth, txt, shaping.NewSliceIterator(s.shapeText(params.PxPerEm, params.Locale, txt)))
+	return s.wrapParagraph(wc, params, txt, outputs)
+}
+
+// defaultTabWidth is the distance between tab stops, in spaces, if none is
+// configured.
+const defaultTabWidth = 8
+
+// replaceTabs replaces the tabs of txt with spaces so that they shape as blank space,
+// and appends their rune indices to buf.
+func replaceTabs(txt []rune, buf []int) []int {
+	for i, r := range txt {
+		if r == '\t' {
+			txt[i] = ' '
+			buf = append(buf, i)
+		}
+	}
+	return buf
+}
+
+// applyTabStops adjusts the advance of the tabs in line so that the text following
+// each tab starts at the next tab stop. Positions are measured from the start of the
+// line, with the runs of the line laid end to end in logical order. tabs holds the
+// sorted rune indices of the tabs, and the rune indices of line are relative to
+// offset.
+func applyTabStops(line []shaping.Output, tabs []int, offset, width int, stops []fixed.Int26_6) {
+	if width <= 0 {
+		width = defaultTabWidth
+	}
+	var x fixed.Int26_6
+	for i := range line {
+		out := &line[i]
+		if out.Direction.IsVertical() {
+			continue
+		}
+		tabAdvance := fixed.Int26_6(width) * spaceAdvance(out)
+		for k := range out.Glyphs {
+			j := k
+			if out.Direction.Progression() == di.TowardTopLeft {
+				// Traverse right-to-left runs in logical order.
+				j = len(out.Glyphs) - 1 - k
+			}
+			g := &out.Glyphs[j]
+			if _, isTab := slices.BinarySearch(tabs, offset+g.ClusterIndex); isTab && g.GlyphCount == 1 {
+				g.XAdvance = nextTabStop(x, stops, tabAdvance) - x
+			}
+			x += g.XAdvance
+		}
+		out.RecomputeAdvance()
+	}
+}
+
+// nextTabStop returns the position of the first tab stop after x. Stops repeat every
+// interval after the final explicit stop.
+func nextTabStop(x fixed.Int26_6, stops []fixed.Int26_6, interval fixed.Int26_6) fixed.Int26_6 {
+	var last fixed.Int26_6
+	for _, stop := range stops {
+		if stop > x {
+			return stop
+		}
+		last = stop
+	}
+	if interval <= 0 {
+		return x
+	}
+	return last + ((x-last)/interval+1)*interval
+}
+
+// spaceAdvance returns the advance of the space glyph of the face that shaped out.
+func spaceAdvance(out *shaping.Output) fixed.Int26_6 {
+	if out.Face == nil {
+		return out.Size / 2
+	}
+	gid, ok := out.Face.NominalGlyph(' ')
+	if !ok {
+		return out.Size / 2
+	}
+	return floatToFixed(out.Face.HorizontalAdvance(gid) * fixedToFloat(out.Size) / float32(out.Face.Upem()))
+}
+
+// applySpacing adds letterSpacing to the advance of every glyph cluster in the
+// outputs, and wordSpacing to the advance of every word separator. The spacing
+// is applied to the final glyph of each cluster so that clusters remain intact,
+// and it is applied before wrapping so that line breaking accounts for it.
+func applySpacing(outputs []shaping.Output, txt []rune, letterSpacing, wordSpacing fixed.Int26_6) {
+	if letterSpacing == 0 && wordSpacing == 0 {
+		return
+	}
+	for i := range outputs {
+		out := &outputs[i]
+		for j := range out.Glyphs {
+			g := &out.Glyphs[j]
+			if j+1 < len(out.Glyphs) && out.Glyphs[j+1].ClusterIndex == g.ClusterIndex {
+				continue
+			}
+			spacing := letterSpacing
+			if g.ClusterIndex < len(txt) && isWordSeparator(txt[g.ClusterIndex]) {
+				spacing += wordSpacing
+			}
+			if out.Direction.IsVertical() {
+				g.YAdvance += spacing
+			} else {
+				g.XAdvance += spacing
+			}
+		}
+		out.RecomputeAdvance()
+	}
+}
+
+// isWordSeparator reports whether r separates words for the purpose of word
+// spacing. The set of separators matches that of the CSS word-spacing property.
+func isWordSeparator(r rune) bool {
+	switch r {
+	case ' ', '\u00A0', '\u1361', '\U00010100', '\U00010101', '\U0001039F', '\U0001091F':
+		return true
+	}
+	return false
+}
+
+// toFontFeatures converts features into the go-text representation, appending
+// them to buf. Features with malformed tags are skipped.
+func toFontFeatures(features []Feature, buf []shaping.FontFeature) []shaping.FontFeature {
+	for _, f := range features {
+		if len(f.Tag) != 4 {
+			continue
+		}
+		buf = append(buf, shaping.FontFeature{
+			Tag:   loader.MustNewTag(f.Tag),
+			Value: f.Value,
+		})
+	}
+	return buf
 }
 
 // replaceControlCharacters replaces problematic unicode
@@ -576,6 +1184,14 @@ func calculateYOffsets(lines []line) {
 
 // LayoutRunes shapes and wraps the text, and returns the result in Gio's shaped text format.
 func (s *shaperImpl) LayoutRunes(params Parameters, txt []rune) document {
+	return s.LayoutSpans(params, txt, nil)
+}
+
+// LayoutSpans shapes and wraps the text, styling its runes according to spans, and returns
+// the result in Gio's shaped text format. If spans is empty, the Font and PxPerEm of params
+// style the entire text.
+func (s *shaperImpl) LayoutSpans(params Parameters, txt []rune, spans []spanStyle) document {
+	s.usage = faceUsage{}
 	hasNewline := len(txt) > 0 && txt[len(txt)-1] == '\n'
 	var ls []shaping.Line
 	var truncated int
@@ -587,7 +1203,7 @@ func (s *shaperImpl) LayoutRunes(params Parameters, txt []rune) document {
 		// on the final line (if we hit the limit).
 		params.forceTruncate = true
 	}
-	ls, truncated = s.shapeAndWrapText(params, replaceControlCharacters(txt))
+	ls, truncated = s.shapeAndWrapText(params, replaceControlCharacters(txt), spans)
 
 	hasTruncator := truncated > 0 || (params.forceTruncate && params.MaxLines == len(ls))
 	if hasTruncator && hasNewline {
@@ -602,15 +1218,39 @@ func (s *shaperImpl) LayoutRunes(params Parameters, txt []rune) document {
 	maxHeight := fixed.Int26_6(0)
 	for i := range ls {
 		otLine := toLine(s.faceToIndex, ls[i], params.Locale.Direction)
+		if len(spans) > 0 {
+			for j := range otLine.runs {
+				if otLine.runs[j].hyphen {
+					// Hyphens are styled like the word they break.
+					otLine.runs[j].span = otLine.runs[j-1].span
+					continue
+				}
+				span := spanAt(spans, ls[i][j].Runes.Offset)
+				otLine.runs[j].span = span.index
+				if span.placeholder != (Placeholder{}) && ls[i][j].Face == nil {
+					otLine.runs[j].placeholder = true
+					for k := range otLine.runs[j].Glyphs {
+						// Refer to no face, so that the object is not painted.
+						g := &otLine.runs[j].Glyphs[k]
+						g.id = newGlyphID(otLine.runs[j].PPEM, placeholderFace, 0)
+					}
+				}
+			}
+		}
+		markExpansions(&otLine, txt, s.scratchTabs)
 		if otLine.lineHeight > maxHeight {
 			maxHeight = otLine.lineHeight
 		}
 		if isFinalLine := i == len(ls)-1; isFinalLine {
+			otLine.paragraphEnd = true
 			if hasNewline {
 				otLine.insertTrailingSyntheticNewline(len(txt))
 			}
 			if hasTruncator {
 				otLine.setTruncatedCount(truncated)
+				if len(spans) > 0 {
+					otLine.runs[len(otLine.runs)-1].span = spans[len(spans)-1].index
+				}
 			}
 		}
 		textLines[i] = otLine
@@ -631,9 +1271,69 @@ func (s *shaperImpl) LayoutRunes(params Parameters, txt []rune) document {
 		lines:      textLines,
 		alignment:  params.Alignment,
 		alignWidth: alignWidth(params.MinWidth, textLines),
+		usage:      s.usage,
+	}
+}
+
+// markExpansions records the justification opportunities of l, the glyphs whose
+// advance may be expanded to justify the line, and measures its trailing whitespace.
+// txt is the text of the paragraph containing l and tabs holds the sorted rune indices
+// of tabs positioned by tab stops, which are never expanded.
+func markExpansions(l *line, txt []rune, tabs []int) {
+	// Find the logical end of the content of the line, excluding trailing whitespace.
+	contentEnd := 0
+	for _, run := range l.runs {
+		for _, g := range run.Glyphs {
+			if g.glyphCount > 0 {
+				contentEnd = max(contentEnd, g.clusterIndex+g.runeCount)
+			}
+		}
+	}
+	contentEnd = min(contentEnd, len(txt))
+	for contentEnd > 0 && unicode.IsSpace(txt[contentEnd-1]) {
+		contentEnd--
+	}
+	for i := range l.runs {
+		run := &l.runs[i]
+		if run.truncator || run.hyphen {
+			continue
+		}
+		for j := range run.Glyphs {
+			g := &run.Glyphs[j]
+			c := g.clusterIndex
+			if g.glyphCount == 0 || c >= len(txt) {
+				continue
+			}
+			if c >= contentEnd {
+				l.trailing += g.xAdvance
+				continue
+			}
+			if j+1 < len(run.Glyphs) && run.Glyphs[j+1].clusterIndex == c {
+				// Only the final glyph of a cluster is expanded.
+				continue
+			}
+			if _, isTab := slices.BinarySearch(tabs, c); isTab {
+				continue
+			}
+			switch {
+			case isWordSeparator(txt[c]):
+				g.expansion = expandWord
+				run.wordGaps++
+			case c+g.runeCount < contentEnd && isUnspacedScript(txt[c]):
+				g.expansion = expandCharacter
+				run.charGaps++
+			}
+		}
 	}
 }
 
+// isUnspacedScript reports whether r belongs to a script written without spaces
+// between words, whose lines are justified by expanding the gaps between characters.
+func isUnspacedScript(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Bopomofo) ||
+		(r >= '\u3000' && r <= '\u303F') || (r >= '\uFF00' && r <= '\uFFEF')
+}
+
 func alignWidth(minWidth int, lines []line) int {
 	for _, l := range lines {
 		minWidth = max(minWidth, l.width.Ceil())
@@ -644,75 +1344,86 @@ func alignWidth(minWidth int, lines []line) int {
 // Shape converts the provided glyphs into a path. The path will enclose the forms
 // of all vector glyphs.
 func (s *shaperImpl) Shape(pathOps *op.Ops, gs []Glyph) clip.PathSpec {
-	var lastPos f32.Point
-	var x fixed.Int26_6
 	var builder clip.Path
 	builder.Begin(pathOps)
-	for i, g := range gs {
-		if i == 0 {
-			x = g.X
-		}
-		ppem, faceIdx, gid := splitGlyphID(g.ID)
-		if faceIdx >= len(s.faces) {
-			continue
-		}
-		face := s.faces[faceIdx]
-		if face == nil {
-			continue
-		}
-		scaleFactor := fixedToFloat(ppem) / float32(face.Upem())
-		glyphData := face.GlyphData(gid)
-		switch glyphData := glyphData.(type) {
-		case api.GlyphOutline:
-			outline := glyphData
-			// Move to glyph position.
-			pos := f32.Point{
-				X: fixedToFloat((g.X - x) - g.Offset.X),
-				Y: -fixedToFloat(g.Offset.Y),
+	if len(gs) == 0 {
+		return builder.End()
+	}
+	// Convert the segments to segments relative to the pen.
+	var pen f32.Point
+	for _, g := range gs {
+		s.glyphOutline(g, gs[0].X, gs[0].Y, func(seg api.SegmentOp, args []f32.Point) {
+			var rel [3]f32.Point
+			for i, a := range args {
+				rel[i] = a.Sub(pen)
 			}
-			builder.Move(pos.Sub(lastPos))
-			lastPos = pos
-			var lastArg f32.Point
-
-			// Convert fonts.Segments to relative segments.
-			for _, fseg := range outline.Segments {
-				nargs := 1
-				switch fseg.Op {
-				case api.SegmentOpQuadTo:
-					nargs = 2
-				case api.SegmentOpCubeTo:
-					nargs = 3
-				}
-				var args [3]f32.Point
-				for i := 0; i < nargs; i++ {
-					a := f32.Point{
-						X: fseg.Args[i].X * scaleFactor,
-						Y: -fseg.Args[i].Y * scaleFactor,
-					}
-					args[i] = a.Sub(lastArg)
-					if i == nargs-1 {
-						lastArg = a
-					}
-				}
-				switch fseg.Op {
-				case api.SegmentOpMoveTo:
-					builder.Move(args[0])
-				case api.SegmentOpLineTo:
-					builder.Line(args[0])
-				case api.SegmentOpQuadTo:
-					builder.Quad(args[0], args[1])
-				case api.SegmentOpCubeTo:
-					builder.Cube(args[0], args[1], args[2])
-				default:
-					panic("unsupported segment op")
-				}
+			pen = args[len(args)-1]
+			switch seg {
+			case api.SegmentOpMoveTo:
+				builder.Move(rel[0])
+			case api.SegmentOpLineTo:
+				builder.Line(rel[0])
+			case api.SegmentOpQuadTo:
+				builder.Quad(rel[0], rel[1])
+			case api.SegmentOpCubeTo:
+				builder.Cube(rel[0], rel[1], rel[2])
+			default:
+				panic("unsupported segment op")
 			}
-			lastPos = lastPos.Add(lastArg)
-		}
+		})
 	}
 	return builder.End()
 }
 
+// glyphOutline calls f for the segments of the outline of the vector glyph g,
+// hinted as configured, with their points in pixels relative to the origin (x,
+// y) and the Y axis pointing down. Color and bitmap glyphs have no outline.
+func (s *shaperImpl) glyphOutline(g Glyph, x fixed.Int26_6, y int32, f func(seg api.SegmentOp, args []f32.Point)) {
+	ppem, faceIdx, gid := splitGlyphID(g.ID)
+	if faceIdx >= len(s.faces) {
+		return
+	}
+	face := s.faces[faceIdx]
+	if face == nil {
+		return
+	}
+	if _, ok := s.colorGlyph(faceIdx, gid); ok {
+		// Color glyphs are painted by Bitmaps.
+		return
+	}
+	outline, ok := face.GlyphData(gid).(api.GlyphOutline)
+	if !ok {
+		return
+	}
+	scaleFactor := fixedToFloat(ppem) / float32(face.Upem())
+	offY := fixedToFloat(g.Offset.Y)
+	if hinted, ok := s.hintedOutline(g.ID); ok {
+		outline, scaleFactor = hinted, 1
+		offY = float32(g.Offset.Y.Round())
+	}
+	pos := f32.Point{
+		X: fixedToFloat((g.X - x) - g.Offset.X),
+		Y: float32(g.Y-y) - offY,
+	}
+	var args [3]f32.Point
+	for _, fseg := range outline.Segments {
+		nargs := 1
+		switch fseg.Op {
+		case api.SegmentOpQuadTo:
+			nargs = 2
+		case api.SegmentOpCubeTo:
+			nargs = 3
+		}
+		for i := 0; i < nargs; i++ {
+			args[i] = pos.Add(f32.Point{
+				X: fseg.Args[i].X * scaleFactor,
+				Y: -fseg.Args[i].Y * scaleFactor,
+			})
+		}
+		f(fseg.Op, args[:nargs])
+	}
+}
+
 func fixedToFloat(i fixed.Int26_6) float32 {
 	return float32(i) / 64.0
 }
@@ -721,16 +1432,50 @@ func floatToFixed(f float32) fixed.Int26_6 {
 	return fixed.Int26_6(f * 64)
 }
 
-// Bitmaps returns an op.CallOp that will display all bitmap glyphs within gs.
+// bitmapPaint paints the bitmap and color glyphs of a line. The layers of color
+// glyphs painted with the color of the text are interleaved with the calls that
+// paint the other glyphs and layers, to keep their stacking order.
+type bitmapPaint struct {
+	// calls paint the glyphs and layers before each text layer, and after
+	// the last.
+	calls []op.CallOp
+	// text are the layers painted with the color of the text.
+	text []textLayer
+}
+
+// textLayer is a layer of a color glyph painted with the color of the text.
+type textLayer struct {
+	// off is the position of the glyph.
+	off   f32.Point
+	layer colorLayer
+}
+
+// add paints b, with material for the layers of color glyphs painted with the
+// color of the text. An empty material paints them black.
+func (b bitmapPaint) add(ops *op.Ops, material op.CallOp) {
+	for i, call := range b.calls {
+		call.Add(ops)
+		if i < len(b.text) {
+			t := b.text[i]
+			off := op.Affine(f32.Affine2D{}.Offset(t.off)).Push(ops)
+			t.layer.add(ops, material)
+			off.Pop()
+		}
+	}
+}
+
+// Bitmaps returns a bitmapPaint that will display all bitmap and color glyphs within gs.
 // The positioning of the bitmaps uses the same logic as Shape(), so the returned
-// CallOp can be added at the same offset as the path data returned by Shape()
+// paint can be added at the same offset as the path data returned by Shape()
 // and will align correctly.
-func (s *shaperImpl) Bitmaps(ops *op.Ops, gs []Glyph) op.CallOp {
+func (s *shaperImpl) Bitmaps(ops *op.Ops, gs []Glyph) bitmapPaint {
 	var x fixed.Int26_6
+	var y int32
+	var b bitmapPaint
 	bitmapMacro := op.Record(ops)
 	for i, g := range gs {
 		if i == 0 {
-			x = g.X
+			x, y = g.X, g.Y
 		}
 		_, faceIdx, gid := splitGlyphID(g.ID)
 		if faceIdx >= len(s.faces) {
@@ -740,6 +1485,24 @@ func (s *shaperImpl) Bitmaps(ops *op.Ops, gs []Glyph) op.CallOp {
 		if face == nil {
 			continue
 		}
+		if c, ok := s.colorGlyph(faceIdx, gid); ok {
+			pos := f32.Point{
+				X: fixedToFloat((g.X - x) - g.Offset.X),
+				Y: float32(g.Y-y) - fixedToFloat(g.Offset.Y),
+			}
+			for _, l := range s.colorGlyphLayers(g.ID, face, c) {
+				if l.fill == (op.CallOp{}) {
+					b.calls = append(b.calls, bitmapMacro.Stop())
+					b.text = append(b.text, textLayer{off: pos, layer: l})
+					bitmapMacro = op.Record(ops)
+					continue
+				}
+				off := op.Affine(f32.Affine2D{}.Offset(pos)).Push(ops)
+				l.add(ops, op.CallOp{})
+				off.Pop()
+			}
+			continue
+		}
 		glyphData := face.GlyphData(gid)
 		switch glyphData := glyphData.(type) {
 		case api.GlyphBitmap:
@@ -766,9 +1529,14 @@ func (s *shaperImpl) Bitmaps(ops *op.Ops, gs []Glyph) op.CallOp {
 				imgOp = bitmapData.img
 				imgSize = bitmapData.size
 			}
+			top := g.Offset.Y + g.Bounds.Min.Y
+			if g.Flags&FlagVertical != 0 {
+				// The bounds of vertical glyphs include their offset.
+				top = g.Bounds.Min.Y
+			}
 			off := op.Affine(f32.Affine2D{}.Offset(f32.Point{
 				X: fixedToFloat((g.X - x) - g.Offset.X),
-				Y: fixedToFloat(g.Offset.Y + g.Bounds.Min.Y),
+				Y: float32(g.Y-y) + fixedToFloat(top),
 			})).Push(ops)
 			cl := clip.Rect{Max: imgSize}.Push(ops)
 
@@ -793,7 +1561,8 @@ func (s *shaperImpl) Bitmaps(ops *op.Ops, gs []Glyph) op.CallOp {
 			off.Pop()
 		}
 	}
-	return bitmapMacro.Stop()
+	b.calls = append(b.calls, bitmapMacro.Stop())
+	return b
 }
 
 // langConfig describes the language and writing system of a body of text.
@@ -841,8 +1610,8 @@ func unmapDirection(d di.Direction) system.TextDirection {
 }
 
 // toGioGlyphs converts text shaper glyphs into the minimal representation
-// that Gio needs.
-func toGioGlyphs(in []shaping.Glyph, ppem fixed.Int26_6, faceIdx int) []glyph {
+// that Gio needs. Vertical glyphs are those converted by toInlineAdvances.
+func toGioGlyphs(in []shaping.Glyph, ppem fixed.Int26_6, faceIdx int, vertical bool) []glyph {
 	out := make([]glyph, 0, len(in))
 	for _, g := range in {
 		// To better understand how to calculate the bounding box, see here:
@@ -850,6 +1619,14 @@ func toGioGlyphs(in []shaping.Glyph, ppem fixed.Int26_6, faceIdx int) []glyph {
 		var bounds fixed.Rectangle26_6
 		bounds.Min.X = g.XBearing
 		bounds.Min.Y = -g.YBearing
+		xOffset := g.XOffset
+		if vertical {
+			// The offsets of vertical glyphs move their origin from the dot at the
+			// top center of the glyph to their horizontal baseline, so include them
+			// in the bounds. Shape subtracts the X offset from the dot.
+			bounds.Min = bounds.Min.Add(fixed.Point26_6{X: g.XOffset, Y: -g.YOffset})
+			xOffset = -g.XOffset
+		}
 		bounds.Max = bounds.Min.Add(fixed.Point26_6{X: g.Width, Y: -g.Height})
 		out = append(out, glyph{
 			id:           newGlyphID(ppem, faceIdx, g.GlyphID),
@@ -858,7 +1635,7 @@ func toGioGlyphs(in []shaping.Glyph, ppem fixed.Int26_6, faceIdx int) []glyph {
 			glyphCount:   g.GlyphCount,
 			xAdvance:     g.XAdvance,
 			yAdvance:     g.YAdvance,
-			xOffset:      g.XOffset,
+			xOffset:      xOffset,
 			yOffset:      g.YOffset,
 			bounds:       bounds,
 		})
@@ -886,7 +1663,7 @@ func toLine(faceToIndex map[font.Font]int, o shaping.Line, dir system.TextDirect
 			font = run.Face.Font
 		}
 		line.runs[i] = runLayout{
-			Glyphs: toGioGlyphs(run.Glyphs, run.Size, faceToIndex[font]),
+			Glyphs: toGioGlyphs(run.Glyphs, run.Size, faceToIndex[font], dir.Axis() == system.Vertical),
 			Runes: Range{
 				Count:  run.Runes.Count,
 				Offset: line.runeCount,
@@ -895,6 +1672,7 @@ func toLine(faceToIndex map[font.Font]int, o shaping.Line, dir system.TextDirect
 			face:      run.Face,
 			Advance:   run.Advance,
 			PPEM:      run.Size,
+			hyphen:    isHyphen(run),
 		}
 		line.runeCount += run.Runes.Count
 		line.width += run.Advance
@@ -1,6 +1,7 @@
 package text
 
 import (
+	"encoding/binary"
 	"fmt"
 	"math"
 	"reflect"
@@ -9,6 +10,8 @@ import (
 
 	nsareg "eliasnaur.com/font/noto/sans/arabic/regular"
 	"github.com/go-text/typesetting/font"
+	"github.com/go-text/typesetting/fontscan"
+	"github.com/go-text/typesetting/opentype/loader"
 	"github.com/go-text/typesetting/shaping"
 	"golang.org/x/exp/slices"
 	"golang.org/x/image/font/gofont/goregular"
@@ -300,13 +303,13 @@ func makeTestText(shaper *shaperImpl, primaryDir system.TextDirection, fontSize,
 		PxPerEm:  fixed.I(fontSize),
 		MaxWidth: lineWidth,
 		Locale:   locale,
-	}, []rune(simpleSource))
+	}, []rune(simpleSource), nil)
 	simpleText = copyLines(simpleText)
 	complexText, _ := shaper.shapeAndWrapText(Parameters{
 		PxPerEm:  fixed.I(fontSize),
 		MaxWidth: lineWidth,
 		Locale:   locale,
-	}, []rune(complexSource))
+	}, []rune(complexSource), nil)
 	complexText = copyLines(complexText)
 	testShaper(rtlFace, ltrFace)
 	return simpleText, complexText
@@ -709,3 +712,239 @@ func TestGlyphIDPacking(t *testing.T) {
 		})
 	}
 }
+
+// TestVariationsNonVariableFace ensures that variation axis values are ignored
+// by faces that are not variable.
+func TestVariationsNonVariableFace(t *testing.T) {
+	ltrFace, _ := opentype.Parse(goregular.TTF)
+	shaper := testShaper(ltrFace)
+	params := Parameters{
+		PxPerEm:  fixed.I(20),
+		MaxWidth: 2000,
+		Locale:   english,
+	}
+	plain := shaper.LayoutRunes(params, []rune("Hello"))
+	params.Font.Variations = []giofont.Variation{{Tag: "wght", Value: 700}}
+	varied := shaper.LayoutRunes(params, []rune("Hello"))
+	if len(shaper.faces) != 1 {
+		t.Errorf("expected no instances of a non-variable face, got %d faces", len(shaper.faces))
+	}
+	if !reflect.DeepEqual(plain.lines[0].runs[0].Glyphs, varied.lines[0].runs[0].Glyphs) {
+		t.Errorf("expected variations to have no effect on a non-variable face")
+	}
+}
+
+// variableFont returns goregular with a weight axis from 100 to 900, whose
+// maximum widens every glyph by 200 font units.
+func variableFont(t *testing.T) opentype.Face {
+	t.Helper()
+	be := binary.BigEndian
+	fvar := []byte{0, 1, 0, 0, 0, 16, 0, 2, 0, 1, 0, 20, 0, 0, 0, 8}
+	fvar = append(fvar, "wght"...)
+	for _, v := range []int32{100, 400, 900} {
+		fvar = be.AppendUint32(fvar, uint32(v<<16))
+	}
+	fvar = append(fvar, 0, 0, 1, 0)
+	// An HVAR table without mappings, its item variation store having a region
+	// peaking at the maximum weight and a delta for every glyph.
+	glyphs := int(be.Uint16(tableData(t, goregular.TTF, "maxp")[4:]))
+	hvar := []byte{0, 1, 0, 0, 0, 0, 0, 20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	hvar = append(hvar, 0, 1, 0, 0, 0, 12, 0, 1, 0, 0, 0, 22)
+	hvar = append(hvar, 0, 1, 0, 1, 0, 0, 0x40, 0, 0x40, 0)
+	hvar = be.AppendUint16(hvar, uint16(glyphs))
+	hvar = append(hvar, 0, 1, 0, 1, 0, 0)
+	for i := 0; i < glyphs; i++ {
+		hvar = be.AppendUint16(hvar, 200)
+	}
+	face, err := opentype.Parse(withTables(goregular.TTF,
+		loader.Table{Tag: loader.MustNewTag("fvar"), Content: fvar},
+		loader.Table{Tag: loader.MustNewTag("HVAR"), Content: hvar},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return face
+}
+
+// tableData returns the table of src with the tag.
+func tableData(t *testing.T, src []byte, tag string) []byte {
+	t.Helper()
+	be := binary.BigEndian
+	for i := 0; i < int(be.Uint16(src[4:])); i++ {
+		rec := src[12+16*i:]
+		if string(rec[:4]) == tag {
+			off, length := be.Uint32(rec[8:]), be.Uint32(rec[12:])
+			return src[off : off+length]
+		}
+	}
+	t.Fatalf("no %s table", tag)
+	return nil
+}
+
+// TestVariationsVariableFace ensures that faces are instantiated at the axis
+// values of the variations, and that the instances are reused.
+func TestVariationsVariableFace(t *testing.T) {
+	shaper := testShaper(variableFont(t))
+	params := Parameters{
+		PxPerEm:  fixed.I(20),
+		MaxWidth: 2000,
+		Locale:   english,
+	}
+	layout := func(weight float32) runLayout {
+		params.Font.Variations = []giofont.Variation{{Tag: "wght", Value: weight}}
+		return shaper.LayoutRunes(params, []rune("Hello")).lines[0].runs[0]
+	}
+	regular, bold := layout(400), layout(900)
+	if regular.Advance >= bold.Advance {
+		t.Errorf("expected a wider advance at weight 900, got %v and %v", regular.Advance, bold.Advance)
+	}
+	if len(shaper.faces) != 3 {
+		t.Errorf("expected 2 instances, got %d faces", len(shaper.faces))
+	}
+	if again := layout(900); again.face != bold.face {
+		t.Error("expected the same face for the same variations")
+	}
+	if len(shaper.faces) != 3 {
+		t.Errorf("expected the instances to be reused, got %d faces", len(shaper.faces))
+	}
+}
+
+// TestVariationsEviction ensures that the instances of variable faces are
+// bounded and that the indices of evicted instances are reused.
+func TestVariationsEviction(t *testing.T) {
+	shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: variableFont(t)}}))
+	params := Parameters{
+		PxPerEm:  fixed.I(20),
+		MaxWidth: 2000,
+		Locale:   english,
+	}
+	weight := func(i int) []giofont.Variation {
+		return []giofont.Variation{{Tag: "wght", Value: float32(100 + i)}}
+	}
+	faceIndex := func() int {
+		g, _ := shaper.NextGlyph()
+		_, idx, _ := splitGlyphID(g.ID)
+		return idx
+	}
+	var first int
+	reused := false
+	for i := 0; i < maxInstances+10; i++ {
+		params.Font.Variations = weight(i)
+		shaper.LayoutString(params, "A")
+		idx := faceIndex()
+		if i == 0 {
+			first = idx
+		}
+		reused = reused || i > 0 && idx == first
+	}
+	if n := len(shaper.shaper.instances); n > maxInstances+1 {
+		t.Errorf("expected at most %d instances, got %d", maxInstances+1, n)
+	}
+	if n := len(shaper.shaper.faces); n > maxInstances+2 {
+		t.Errorf("expected evicted face indices to be reused, got %d faces", n)
+	}
+	// The least recently used instance was evicted, and its index reused.
+	if _, ok := shaper.shaper.instances[faceInstance{font: shaper.shaper.faces[0].Font, variations: variationsKey(weight(0))}]; ok {
+		t.Error("expected the least recently used instance to be evicted")
+	}
+	if !reused {
+		t.Errorf("expected the index %d of an evicted instance to be reused", first)
+	}
+}
+
+// TestVariationsCache ensures that layouts are cached by the values of the
+// variations rather than the identity of their slice.
+func TestVariationsCache(t *testing.T) {
+	shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: variableFont(t)}}))
+	params := Parameters{PxPerEm: fixed.I(20), MaxWidth: 2000, Locale: english}
+	layout := func(vars ...giofont.Variation) int {
+		params.Font.Variations = vars
+		shaper.LayoutString(params, "Hello")
+		for _, ok := shaper.NextGlyph(); ok; _, ok = shaper.NextGlyph() {
+		}
+		return len(shaper.layoutCache.m)
+	}
+	if n := layout(giofont.Variation{Tag: "wght", Value: 650}); n != 1 {
+		t.Fatalf("expected 1 cached layout, got %d", n)
+	}
+	if n := layout(giofont.Variation{Tag: "wght", Value: 650}); n != 1 {
+		t.Errorf("expected equal variations to hit the cache, got %d cached layouts", n)
+	}
+	if n := layout(giofont.Variation{Tag: "wght", Value: 700}); n != 2 {
+		t.Errorf("expected different variations to miss the cache, got %d cached layouts", n)
+	}
+}
+
+// fakeSystemFonts replaces the system fonts of shaper with an index holding
+// only the Noto Sans Arabic face, so that tests don't depend on the fonts of the
+// host. It returns the number of times the system fonts were loaded.
+func fakeSystemFonts(t *testing.T, shaper *Shaper) *int {
+	t.Helper()
+	arabicFace, err := opentype.Parse(nsareg.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := opentype.FontToDescription(giofont.Font{Typeface: "Noto Sans Arabic"})
+	loads := new(int)
+	shaper.init()
+	shaper.shaper.useSystemFonts = func(fm *fontscan.FontMap, _ string) error {
+		*loads++
+		fm.AddFace(arabicFace.Face(), fontscan.Location{File: "system"}, desc)
+		return nil
+	}
+	return loads
+}
+
+func TestLazySystemFonts(t *testing.T) {
+	ltrFace, _ := opentype.Parse(goregular.TTF)
+	collection := []FontFace{{Font: giofont.Font{Typeface: "Go"}, Face: ltrFace}}
+	params := Parameters{PxPerEm: fixed.I(16), Locale: english}
+	// layout lays out txt and reports whether a system face provided any of its
+	// glyphs.
+	layout := func(shaper *Shaper, typeface, txt string) bool {
+		params.Font.Typeface = giofont.Typeface(typeface)
+		shaper.LayoutString(params, txt)
+		system := false
+		for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+			_, faceIdx, _ := splitGlyphID(g.ID)
+			system = system || faceIdx != 0
+		}
+		return system
+	}
+
+	shaper := NewShaper(WithCollection(collection))
+	loads := fakeSystemFonts(t, shaper)
+	layout(shaper, "", "Text of the collection.")
+	if *loads != 0 {
+		t.Errorf("expected system fonts not to be loaded for text covered by the collection")
+	}
+	layout(shaper, "Go, sans-serif", "Text of the collection.")
+	layout(shaper, "Go, Noto Sans Arabic", "Text of the collection.")
+	if *loads != 0 {
+		t.Errorf("expected system fonts not to be loaded for a preferred typeface of the collection")
+	}
+	if !layout(shaper, "", "سماء") {
+		t.Errorf("expected a system face for runes not covered by the collection")
+	}
+	if *loads != 1 {
+		t.Errorf("expected system fonts to be loaded once, got %d loads", *loads)
+	}
+
+	for _, typeface := range []string{"monospace", "Noto Sans Arabic, Go"} {
+		shaper = NewShaper(WithCollection(collection))
+		loads = fakeSystemFonts(t, shaper)
+		layout(shaper, typeface, "Text")
+		if *loads != 1 {
+			t.Errorf("expected system fonts to be loaded for typeface %q not in the collection, got %d loads", typeface, *loads)
+		}
+	}
+	if !layout(shaper, "Noto Sans Arabic, Go", "سماء") {
+		t.Errorf("expected the system face of the preferred typeface")
+	}
+
+	shaper = NewShaper(NoSystemFonts(), WithCollection(collection))
+	loads = fakeSystemFonts(t, shaper)
+	if layout(shaper, "Noto Sans Arabic", "سماء") || *loads != 0 {
+		t.Errorf("expected no system fonts with NoSystemFonts, got %d loads", *loads)
+	}
+}
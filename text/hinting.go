@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"os"
+
+	"github.com/go-text/typesetting/opentype/api"
+	"github.com/golang/freetype/truetype"
+	xfont "golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+
+	"gioui.org/font/opentype"
+)
+
+// Hinting selects how the outlines of glyphs are fitted to the pixel grid, for
+// sharper text at small sizes.
+//
+// Glyphs are hinted by interpreting the TrueType hinting instructions of their
+// font. Glyphs of fonts with CFF outlines, of variable font instances and of
+// system fonts that can't be read are drawn unhinted. Hinting doesn't change
+// the positions of glyphs along lines: they keep their fractional positions,
+// preserving the spacing of the text.
+type Hinting uint8
+
+const (
+	// HintingNone draws glyph outlines unmodified.
+	HintingNone Hinting = iota
+	// HintingVertical hints glyph outlines vertically, keeping the
+	// horizontal coordinates of their unhinted outlines. It aligns the
+	// baseline, x-height and horizontal stems of glyphs to pixels, and suits
+	// the fractional glyph positions and subpixel coverage of the shaper.
+	// The vertical offsets of glyphs are rounded to whole pixels.
+	HintingVertical
+	// HintingFull hints glyph outlines in both directions. The vertical stems
+	// of glyphs are aligned to pixels relative to their origin, and thus
+	// only to the pixel grid for glyphs positioned at whole pixels, such as
+	// the first glyph of lines.
+	HintingFull
+)
+
+// WithHinting configures the hinting of the glyphs of the shaper. Applications
+// may select the hinting of each window by using a separate shaper for each
+// window, as is required anyway. The default is HintingNone.
+func WithHinting(h Hinting) ShaperOption {
+	return func(s *Shaper) {
+		s.config.hinting = h
+	}
+}
+
+// hintedCache caches hinted glyph outlines, in pixels.
+type hintedCache = lru[GlyphID, api.GlyphOutline]
+
+// hinter hints the glyphs of a face.
+type hinter struct {
+	font *truetype.Font
+	// glyph and unhinted hold the last glyph loaded.
+	glyph, unhinted truetype.GlyphBuf
+}
+
+// hintedOutline returns the outline of the glyph id hinted as configured, in
+// pixels, or false if the glyph can't be hinted.
+func (s *shaperImpl) hintedOutline(id GlyphID) (api.GlyphOutline, bool) {
+	if s.hinting == HintingNone {
+		return api.GlyphOutline{}, false
+	}
+	if outline, ok := s.hintedCache.Get(id); ok {
+		return outline, true
+	}
+	ppem, faceIdx, gid := splitGlyphID(id)
+	h := s.faceHinter(faceIdx)
+	if h == nil || gid > 0xFFFF {
+		return api.GlyphOutline{}, false
+	}
+	outline, err := h.load(ppem, truetype.Index(gid), s.hinting)
+	if err != nil {
+		s.logger.Printf("failed hinting glyph %d: %v", gid, err)
+		return api.GlyphOutline{}, false
+	}
+	s.hintedCache.Put(id, outline)
+	return outline, true
+}
+
+// faceHinter returns the hinter of the face at faceIdx, or nil if its glyphs
+// can't be hinted.
+func (s *shaperImpl) faceHinter(faceIdx int) *hinter {
+	if h, ok := s.hinters[faceIdx]; ok {
+		return h
+	}
+	if s.hinters == nil {
+		s.hinters = make(map[int]*hinter)
+	}
+	var h *hinter
+	if src := s.hintingFont(faceIdx); src != nil {
+		f, err := truetype.Parse(src)
+		if err != nil {
+			s.logger.Printf("failed parsing hinting instructions: %v", err)
+		} else {
+			h = &hinter{font: f}
+		}
+	}
+	s.hinters[faceIdx] = h
+	return h
+}
+
+// hintingFont returns the hinting font of the face at faceIdx, or nil if it
+// has none. The hinting fonts of the faces of the collection are provided by
+// their opentype.Face, and those of system faces are read from their font file.
+// Variable font instances have none, because their instructions would hint the
+// outlines of the default instance.
+func (s *shaperImpl) hintingFont(faceIdx int) []byte {
+	face := s.faces[faceIdx]
+	if len(face.Coords) > 0 {
+		return nil
+	}
+	var src opentype.Face
+	if f, ok := s.sources[face.Font]; ok {
+		src, ok = f.(opentype.Face)
+		if !ok {
+			return nil
+		}
+	} else {
+		loc := s.fontMap.FontLocation(face.Font)
+		if loc.File == "" {
+			return nil
+		}
+		data, err := os.ReadFile(loc.File)
+		if err != nil {
+			return nil
+		}
+		faces, err := opentype.ParseCollection(data)
+		if err != nil || int(loc.Index) >= len(faces) {
+			return nil
+		}
+		src = faces[loc.Index].Face.(opentype.Face)
+	}
+	return src.HintingFont()
+}
+
+// load returns the outline of the glyph i at ppem pixels per em, hinted as
+// configured by mode, in pixels.
+func (h *hinter) load(ppem fixed.Int26_6, i truetype.Index, mode Hinting) (api.GlyphOutline, error) {
+	if err := h.glyph.Load(h.font, ppem, i, xfont.HintingFull); err != nil {
+		return api.GlyphOutline{}, err
+	}
+	points := h.glyph.Points
+	if mode == HintingVertical {
+		if err := h.unhinted.Load(h.font, ppem, i, xfont.HintingNone); err != nil {
+			return api.GlyphOutline{}, err
+		}
+		// Hinting moves points without adding or removing any.
+		if len(h.unhinted.Points) == len(points) {
+			for j := range points {
+				points[j].X = h.unhinted.Points[j].X
+			}
+		}
+	}
+	return trueTypeOutline(points, h.glyph.Ends), nil
+}
+
+// trueTypeOutline converts the contours of a TrueType glyph, made of on-curve
+// points and the control points of quadratic curves, to an outline in pixels.
+// Consecutive control points imply an on-curve point midway between them.
+func trueTypeOutline(points []truetype.Point, ends []int) api.GlyphOutline {
+	var out api.GlyphOutline
+	add := func(op api.SegmentOp, args ...api.SegmentPoint) {
+		seg := api.Segment{Op: op}
+		copy(seg.Args[:], args)
+		out.Segments = append(out.Segments, seg)
+	}
+	pt := func(p truetype.Point) api.SegmentPoint {
+		return api.SegmentPoint{X: fixedToFloat(p.X), Y: fixedToFloat(p.Y)}
+	}
+	mid := func(a, b api.SegmentPoint) api.SegmentPoint {
+		return api.SegmentPoint{X: (a.X + b.X) / 2, Y: (a.Y + b.Y) / 2}
+	}
+	onCurve := func(p truetype.Point) bool {
+		return p.Flags&1 != 0
+	}
+	start := 0
+	for _, end := range ends {
+		contour := points[start:end]
+		start = end
+		if len(contour) == 0 {
+			continue
+		}
+		// Start at an on-curve point, and end the contour there. Contours of
+		// control points alone start midway between the last and first.
+		first := -1
+		for j, p := range contour {
+			if onCurve(p) {
+				first = j
+				break
+			}
+		}
+		var startPt api.SegmentPoint
+		if first == -1 {
+			startPt = mid(pt(contour[len(contour)-1]), pt(contour[0]))
+			first = len(contour) - 1
+		} else {
+			startPt = pt(contour[first])
+		}
+		add(api.SegmentOpMoveTo, startPt)
+		var ctrl api.SegmentPoint
+		hasCtrl := false
+		for j := 1; j <= len(contour); j++ {
+			p := contour[(first+j)%len(contour)]
+			switch {
+			case onCurve(p) && hasCtrl:
+				add(api.SegmentOpQuadTo, ctrl, pt(p))
+				hasCtrl = false
+			case onCurve(p):
+				add(api.SegmentOpLineTo, pt(p))
+			default:
+				if hasCtrl {
+					add(api.SegmentOpQuadTo, ctrl, mid(ctrl, pt(p)))
+				}
+				ctrl, hasCtrl = pt(p), true
+			}
+		}
+		if hasCtrl {
+			add(api.SegmentOpQuadTo, ctrl, startPt)
+		}
+	}
+	return out
+}
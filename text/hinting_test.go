@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-text/typesetting/opentype/api"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+
+	"gioui.org/font/opentype"
+)
+
+func TestHinting(t *testing.T) {
+	face, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := func(h Hinting, ppem fixed.Int26_6) (*Shaper, []Glyph) {
+		shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: face}}), WithHinting(h))
+		shaper.LayoutString(Parameters{PxPerEm: ppem, MaxWidth: 1000, Locale: english}, "Hello")
+		var gs []Glyph
+		for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+			gs = append(gs, g)
+		}
+		return shaper, gs
+	}
+	ft := face.Face()
+	for _, ppem := range []fixed.Int26_6{fixed.I(11), fixed.I(13), fixed.I(17) + 32} {
+		plain, plainGlyphs := layout(HintingNone, ppem)
+		if _, ok := plain.shaper.hintedOutline(plainGlyphs[0].ID); ok {
+			t.Errorf("ppem %v: expected no hinting by default", ppem)
+		}
+		shaper, gs := layout(HintingVertical, ppem)
+		for i, g := range gs {
+			if g.X != plainGlyphs[i].X || g.Advance != plainGlyphs[i].Advance {
+				t.Errorf("ppem %v: expected hinting to keep the position of glyph %d", ppem, i)
+			}
+		}
+		// The top of H is at the cap height, which is hinted to a whole pixel.
+		hinted, ok := shaper.shaper.hintedOutline(gs[0].ID)
+		if !ok {
+			t.Fatalf("ppem %v: expected a hinted outline", ppem)
+		}
+		_, _, gid := splitGlyphID(gs[0].ID)
+		unhinted := ft.GlyphData(gid).(api.GlyphOutline)
+		if len(unhinted.Segments) != len(hinted.Segments) {
+			t.Fatalf("ppem %v: expected %d segments, got %d", ppem, len(unhinted.Segments), len(hinted.Segments))
+		}
+		scale := fixedToFloat(ppem) / float32(ft.Upem())
+		var top, hintedTop float32
+		for i, seg := range hinted.Segments {
+			p, u := seg.Args[0], unhinted.Segments[i].Args[0]
+			top, hintedTop = float32(math.Max(float64(top), float64(u.Y*scale))), float32(math.Max(float64(hintedTop), float64(p.Y)))
+			if d := math.Abs(float64(p.X - u.X*scale)); d > 1.0/64 {
+				t.Errorf("ppem %v: expected vertical hinting to keep x coordinate %v, got %v", ppem, u.X*scale, p.X)
+			}
+		}
+		if hintedTop != float32(math.Round(float64(hintedTop))) || hintedTop == top {
+			t.Errorf("ppem %v: expected the cap height %v to be hinted to a whole pixel, got %v", ppem, top, hintedTop)
+		}
+	}
+}
+
+func TestTrueTypeOutline(t *testing.T) {
+	on := func(x, y int) truetype.Point {
+		return truetype.Point{X: fixed.I(x), Y: fixed.I(y), Flags: 1}
+	}
+	off := func(x, y int) truetype.Point {
+		return truetype.Point{X: fixed.I(x), Y: fixed.I(y)}
+	}
+	pt := func(x, y float32) api.SegmentPoint {
+		return api.SegmentPoint{X: x, Y: y}
+	}
+	seg := func(op api.SegmentOp, args ...api.SegmentPoint) api.Segment {
+		s := api.Segment{Op: op}
+		copy(s.Args[:], args)
+		return s
+	}
+	points := []truetype.Point{
+		// A contour starting with a control point, with consecutive control
+		// points.
+		off(0, 0), on(2, 0), off(4, 0), off(4, 4), on(2, 4),
+		// A contour of control points alone.
+		off(0, 0), off(2, 0), off(2, 2), off(0, 2),
+	}
+	got := trueTypeOutline(points, []int{5, 9})
+	want := []api.Segment{
+		seg(api.SegmentOpMoveTo, pt(2, 0)),
+		seg(api.SegmentOpQuadTo, pt(4, 0), pt(4, 2)),
+		seg(api.SegmentOpQuadTo, pt(4, 4), pt(2, 4)),
+		seg(api.SegmentOpQuadTo, pt(0, 0), pt(2, 0)),
+		seg(api.SegmentOpMoveTo, pt(0, 1)),
+		seg(api.SegmentOpQuadTo, pt(0, 0), pt(1, 0)),
+		seg(api.SegmentOpQuadTo, pt(2, 0), pt(2, 1)),
+		seg(api.SegmentOpQuadTo, pt(2, 2), pt(1, 2)),
+		seg(api.SegmentOpQuadTo, pt(0, 2), pt(0, 1)),
+	}
+	if len(got.Segments) != len(want) {
+		t.Fatalf("expected %d segments, got %v", len(want), got.Segments)
+	}
+	for i := range want {
+		if got.Segments[i] != want[i] {
+			t.Errorf("segment %d: expected %v, got %v", i, want[i], got.Segments[i])
+		}
+	}
+}
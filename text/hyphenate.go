@@ -0,0 +1,281 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"unicode"
+
+	"github.com/go-text/typesetting/language"
+	"github.com/go-text/typesetting/shaping"
+
+	"gioui.org/io/system"
+	"gioui.org/text/internal/hyphen"
+)
+
+// wrapParagraph wraps the shaped outputs of txt into lines, hyphenating words if
+// params requests it and positioning the tabs of s.scratchTabs.
+func (s *shaperImpl) wrapParagraph(wc shaping.WrapConfig, params Parameters, txt []rune, outputs []shaping.Output) ([]shaping.Line, int) {
+	var h *hyphen.Hyphenator
+	// Vertical text is hyphenated by none of the supported languages.
+	if params.WrapPolicy == WrapHyphenated && len(txt) > 0 && params.Locale.Direction.Axis() == system.Horizontal {
+		h = hyphen.ForLanguage(params.Locale.Language)
+	}
+	if h == nil && len(s.scratchTabs) == 0 {
+		return s.wrapper.WrapParagraph(wc, params.MaxWidth, txt, shaping.NewSliceIterator(outputs))
+	}
+	return s.wrapLines(wc, params, txt, outputs, h)
+}
+
+// wrapLines wraps outputs like the line wrapper, but positions the tabs of
+// s.scratchTabs relative to the start of their line, and if h is non-nil, moves a
+// hyphenated prefix of the first word of each line to the end of the previous line
+// if it fits there along with a hyphen.
+//
+// The line wrapper cannot break lines within words on its own, nor position tabs
+// before knowing where their line starts, so wrapping restarts after each
+// hyphenated line and after each line followed by tabs, with a copy of the
+// remaining text. The copy also keeps outputs unmodified by the wrapper, so that
+// hyphenated lines can be cut from them.
+func (s *shaperImpl) wrapLines(wc shaping.WrapConfig, params Parameters, txt []rune, outputs []shaping.Output, h *hyphen.Hyphenator) (_ []shaping.Line, truncated int) {
+	var (
+		lines  []shaping.Line
+		start  int
+		breaks []int
+	)
+	tabs := s.scratchTabs
+	for {
+		runs := rebaseRuns(outputs, start)
+		tabbed := len(tabs) > 0 && tabs[len(tabs)-1] >= start
+		if tabbed {
+			applyTabStops(runs, tabs, start, params.TabWidth, params.TabStops)
+		}
+		config := wc
+		if config.TruncateAfterLines > 0 {
+			config.TruncateAfterLines -= len(lines)
+		}
+		s.wrapper.Prepare(config, txt[start:], shaping.NewSliceIterator(runs))
+		restart := false
+		lineStart := start
+		for !restart {
+			wl, done := s.wrapper.WrapNextLine(params.MaxWidth)
+			lineEnd := start + wl.NextLine
+			if !done && h != nil {
+				var line shaping.Line
+				if tabbed {
+					// Measure the line with its tabs positioned.
+					line, breaks = s.hyphenateLine(runs, txt[start:], lineStart-start, lineEnd-start, params.MaxWidth, params.Locale, h, breaks[:0])
+					if line != nil {
+						line = rebaseLine(line, start, wc.Truncator)
+					}
+				} else {
+					line, breaks = s.hyphenateLine(outputs, txt, lineStart, lineEnd, params.MaxWidth, params.Locale, h, breaks[:0])
+				}
+				if line != nil {
+					lines = append(lines, line)
+					start = lineStart + lineRunes(line)
+					restart = true
+					continue
+				}
+			}
+			if wl.Line != nil {
+				lines = append(lines, rebaseLine(wl.Line, start, wc.Truncator))
+			}
+			if done {
+				return lines, wl.Truncated
+			}
+			lineStart = lineEnd
+			if tabbed {
+				start = lineEnd
+				restart = true
+			}
+		}
+	}
+}
+
+// hyphenateLine returns the line of outputs starting at rune lineStart and extended
+// past lineEnd with a prefix of the word at lineEnd and a hyphen, if the word can be
+// hyphenated such that the line fits within maxWidth. Otherwise, it returns nil.
+// breaks is used as scratch space for hyphenation points, and is returned for reuse.
+func (s *shaperImpl) hyphenateLine(outputs []shaping.Output, txt []rune, lineStart, lineEnd, maxWidth int, lc system.Locale, h *hyphen.Hyphenator, breaks []int) (shaping.Line, []int) {
+	// Only hyphenate whole words, not words already broken by the line wrapper.
+	if lineEnd <= lineStart || lineEnd >= len(txt) || !isWordRune(txt[lineEnd]) || isWordRune(txt[lineEnd-1]) {
+		return nil, breaks
+	}
+	wordEnd := lineEnd
+	for wordEnd < len(txt) && isWordRune(txt[wordEnd]) {
+		wordEnd++
+	}
+	breaks = h.Hyphenate(txt[lineEnd:wordEnd], breaks)
+	if len(breaks) == 0 {
+		return nil, breaks
+	}
+	var hyphen shaping.Output
+	for _, out := range outputs {
+		if out.Runes.Offset <= lineEnd && lineEnd < out.Runes.Offset+out.Runes.Count {
+			hyphen = s.shapeHyphen(out, lc)
+		}
+	}
+	if len(hyphen.Glyphs) == 0 {
+		return nil, breaks
+	}
+	// Prefer the longest prefix that fits.
+	for i := len(breaks) - 1; i >= 0; i-- {
+		end := lineEnd + breaks[i]
+		if !isClusterBoundary(outputs, end) {
+			continue
+		}
+		line := cutLine(outputs, lineStart, end)
+		width := hyphen.Advance
+		for _, run := range line {
+			width += run.Advance
+		}
+		if width.Ceil() > maxWidth {
+			continue
+		}
+		hyphen.Runes.Offset = end
+		for j := range hyphen.Glyphs {
+			hyphen.Glyphs[j].ClusterIndex = end
+		}
+		return append(line, hyphen), breaks
+	}
+	return nil, breaks
+}
+
+// shapeHyphen shapes a hyphen in the style of run. The hyphen represents no runes of
+// the text.
+func (s *shaperImpl) shapeHyphen(run shaping.Output, lc system.Locale) shaping.Output {
+	if run.Face == nil {
+		return shaping.Output{}
+	}
+	r := '‐'
+	if _, ok := run.Face.NominalGlyph(r); !ok {
+		r = '-'
+	}
+	out := s.shaper.Shape(shaping.Input{
+		Text:      []rune{r},
+		RunStart:  0,
+		RunEnd:    1,
+		Direction: run.Direction,
+		Face:      run.Face,
+		Size:      run.Size,
+		Script:    language.Common,
+		Language:  language.NewLanguage(lc.Language),
+	})
+	for i := range out.Glyphs {
+		out.Glyphs[i].RuneCount = 0
+	}
+	out.Runes = shaping.Range{}
+	return out
+}
+
+// isHyphen reports whether run is a hyphen inserted by shapeHyphen. Hyphens are the
+// only runs with glyphs that represent no runes.
+func isHyphen(run shaping.Output) bool {
+	return run.Runes.Count == 0 && len(run.Glyphs) > 0
+}
+
+// isWordRune reports whether r is part of a word that may be hyphenated.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.Is(unicode.Mn, r)
+}
+
+// isClusterBoundary reports whether rune index i of the text shaped into outputs
+// starts a glyph cluster.
+func isClusterBoundary(outputs []shaping.Output, i int) bool {
+	for _, out := range outputs {
+		if i == out.Runes.Offset {
+			return true
+		}
+		if i < out.Runes.Offset || i >= out.Runes.Offset+out.Runes.Count {
+			continue
+		}
+		for _, g := range out.Glyphs {
+			if g.ClusterIndex == i {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// cutLine returns the runs of outputs representing the runes [start, end).
+func cutLine(outputs []shaping.Output, start, end int) shaping.Line {
+	var line shaping.Line
+	for _, out := range outputs {
+		if out.Runes.Offset+out.Runes.Count <= start || out.Runes.Offset >= end {
+			continue
+		}
+		line = append(line, cutOutput(out, start, end))
+	}
+	return line
+}
+
+// cutOutput returns the portion of out representing the runes [start, end), which
+// must be glyph cluster boundaries.
+func cutOutput(out shaping.Output, start, end int) shaping.Output {
+	lo, hi := len(out.Glyphs), 0
+	for i, g := range out.Glyphs {
+		if g.ClusterIndex >= start && g.ClusterIndex < end {
+			lo = min(lo, i)
+			hi = max(hi, i+1)
+		}
+	}
+	out.Glyphs = out.Glyphs[lo:max(lo, hi)]
+	runesEnd := min(end, out.Runes.Offset+out.Runes.Count)
+	out.Runes.Offset = max(start, out.Runes.Offset)
+	out.Runes.Count = runesEnd - out.Runes.Offset
+	out.RecomputeAdvance()
+	return out
+}
+
+// lineRunes returns the number of runes represented by line.
+func lineRunes(line shaping.Line) int {
+	n := 0
+	for _, run := range line {
+		n += run.Runes.Count
+	}
+	return n
+}
+
+// rebaseRuns returns copies of the portions of outputs representing the runes
+// from start, with rune indices relative to start.
+func rebaseRuns(outputs []shaping.Output, start int) []shaping.Output {
+	var runs []shaping.Output
+	for _, out := range outputs {
+		if out.Runes.Offset+out.Runes.Count <= start {
+			continue
+		}
+		out = cutOutput(out, start, out.Runes.Offset+out.Runes.Count)
+		glyphs := make([]shaping.Glyph, len(out.Glyphs))
+		copy(glyphs, out.Glyphs)
+		for i := range glyphs {
+			glyphs[i].ClusterIndex -= start
+		}
+		out.Glyphs = glyphs
+		out.Runes.Offset -= start
+		runs = append(runs, out)
+	}
+	return runs
+}
+
+// rebaseLine returns a copy of a line wrapped from the runs of rebaseRuns, with
+// rune indices relative to the start of the paragraph. The truncator run, if any,
+// is left unmodified.
+func rebaseLine(line shaping.Line, start int, truncator shaping.Output) shaping.Line {
+	out := make(shaping.Line, len(line))
+	copy(out, line)
+	for i := range out {
+		run := &out[i]
+		if len(run.Glyphs) > 0 && len(truncator.Glyphs) > 0 && &run.Glyphs[0] == &truncator.Glyphs[0] {
+			continue
+		}
+		// The glyphs belong to the copies made by rebaseRuns, and each glyph
+		// is part of a single line.
+		for j := range run.Glyphs {
+			run.Glyphs[j].ClusterIndex += start
+		}
+		run.Runes.Offset += start
+	}
+	return out
+}
@@ -0,0 +1,488 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+// Package colr decodes the color glyphs of the COLR and CPAL tables of OpenType
+// fonts, in both the layered COLRv0 format and the paint graphs of COLRv1.
+//
+// Variable COLRv1 paints are decoded at their default values.
+package colr
+
+import (
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"math"
+
+	"gioui.org/f32"
+)
+
+// Table holds the color glyphs of a font.
+type Table struct {
+	colr    []byte
+	palette []color.NRGBA
+	// v0 and layers hold the base glyph and layer records of COLRv0.
+	v0, layers []byte
+	// v1 and layerList are the offsets of the base glyph list and the layer
+	// list of COLRv1, or zero.
+	v1, layerList uint32
+}
+
+// Paint is a node of the paint graph of a color glyph. Paints are all in font
+// units, with the Y axis pointing up.
+type Paint interface {
+	isPaint()
+}
+
+// Layers paints its paints in order, each over the previous.
+type Layers []Paint
+
+// Solid fills the current clip with a single color.
+type Solid struct {
+	// Color is the color of the paint, or the alpha multiplier of the text color
+	// if Foreground is set.
+	Color color.NRGBA
+	// Foreground reports whether the paint uses the color of the text.
+	Foreground bool
+}
+
+// ColorStop is a color at an offset along a gradient.
+type ColorStop struct {
+	Offset float32
+	Color  Solid
+}
+
+// LinearGradient fills the current clip with a gradient varying along the line
+// from P0 to P1, rotated such that its lines of constant color are parallel to
+// the line from P0 to P2.
+type LinearGradient struct {
+	P0, P1, P2 f32.Point
+	Stops      []ColorStop
+}
+
+// RadialGradient fills the current clip with a gradient between the circles at
+// C0 and C1 with radii R0 and R1.
+type RadialGradient struct {
+	C0, C1 f32.Point
+	R0, R1 float32
+	Stops  []ColorStop
+}
+
+// SweepGradient fills the current clip with a gradient varying with the angle
+// around Center, from StartAngle to EndAngle in radians counter-clockwise.
+type SweepGradient struct {
+	Center               f32.Point
+	StartAngle, EndAngle float32
+	Stops                []ColorStop
+}
+
+// Glyph clips Paint to the outline of the glyph GID.
+type Glyph struct {
+	GID   uint16
+	Paint Paint
+}
+
+// Transform paints Paint transformed by Transform.
+type Transform struct {
+	Transform f32.Affine2D
+	Paint     Paint
+}
+
+// Composite paints Source over Backdrop, combined according to the Mode
+// compositing operator of the COLR specification.
+type Composite struct {
+	Source, Backdrop Paint
+	Mode             uint8
+}
+
+func (Layers) isPaint()         {}
+func (Solid) isPaint()          {}
+func (LinearGradient) isPaint() {}
+func (RadialGradient) isPaint() {}
+func (SweepGradient) isPaint()  {}
+func (Glyph) isPaint()          {}
+func (Transform) isPaint()      {}
+func (Composite) isPaint()      {}
+
+// foregroundIndex is the palette index of the text color.
+const foregroundIndex = 0xFFFF
+
+// maxDepth limits the nesting of paints, which may otherwise form cycles.
+const maxDepth = 64
+
+var errInvalid = errors.New("colr: invalid table")
+
+// Parse decodes the COLR table colr using the first palette of the CPAL table
+// cpal.
+func Parse(colr, cpal []byte) (*Table, error) {
+	t := &Table{colr: colr}
+	if len(colr) < 14 {
+		return nil, errInvalid
+	}
+	version := u16(colr, 0)
+	numBase, baseOff := int(u16(colr, 2)), int(u32(colr, 4))
+	layerOff, numLayers := int(u32(colr, 8)), int(u16(colr, 12))
+	if baseOff+numBase*6 > len(colr) || layerOff+numLayers*4 > len(colr) {
+		return nil, errInvalid
+	}
+	t.v0 = colr[baseOff : baseOff+numBase*6]
+	t.layers = colr[layerOff : layerOff+numLayers*4]
+	if version >= 1 {
+		if len(colr) < 22 {
+			return nil, errInvalid
+		}
+		t.v1, t.layerList = u32(colr, 14), u32(colr, 18)
+	}
+	palette, err := parsePalette(cpal)
+	if err != nil {
+		return nil, err
+	}
+	t.palette = palette
+	return t, nil
+}
+
+// parsePalette decodes the first palette of the CPAL table cpal.
+func parsePalette(cpal []byte) ([]color.NRGBA, error) {
+	if len(cpal) == 0 {
+		return nil, nil
+	}
+	if len(cpal) < 12 {
+		return nil, errInvalid
+	}
+	numEntries, numPalettes := int(u16(cpal, 2)), int(u16(cpal, 4))
+	recordsOff := int(u32(cpal, 8))
+	if numPalettes == 0 {
+		return nil, nil
+	}
+	if len(cpal) < 14 {
+		return nil, errInvalid
+	}
+	start := recordsOff + int(u16(cpal, 12))*4
+	if start+numEntries*4 > len(cpal) {
+		return nil, errInvalid
+	}
+	palette := make([]color.NRGBA, numEntries)
+	for i := range palette {
+		r := cpal[start+i*4:]
+		palette[i] = color.NRGBA{B: r[0], G: r[1], R: r[2], A: r[3]}
+	}
+	return palette, nil
+}
+
+// Glyph returns the paint graph of the color glyph gid, and whether the font has
+// a color glyph for gid. COLRv1 glyphs take precedence over COLRv0 glyphs.
+func (t *Table) Glyph(gid uint16) (Paint, bool) {
+	if t.v1 != 0 {
+		if off, ok := t.baseGlyphPaint(gid); ok {
+			p, err := t.parsePaint(off, 0)
+			return p, err == nil && p != nil
+		}
+	}
+	return t.glyphV0(gid)
+}
+
+// glyphV0 returns the layers of the COLRv0 glyph gid.
+func (t *Table) glyphV0(gid uint16) (Paint, bool) {
+	rec, ok := search(t.v0, 6, gid)
+	if !ok {
+		return nil, false
+	}
+	first, n := int(u16(rec, 2)), int(u16(rec, 4))
+	if (first+n)*4 > len(t.layers) {
+		return nil, false
+	}
+	layers := make(Layers, n)
+	for i := range layers {
+		l := t.layers[(first+i)*4:]
+		layers[i] = Glyph{GID: u16(l, 0), Paint: t.solid(u16(l, 2), 1)}
+	}
+	return layers, true
+}
+
+// baseGlyphPaint returns the offset of the paint of the COLRv1 glyph gid.
+func (t *Table) baseGlyphPaint(gid uint16) (uint32, bool) {
+	list := int(t.v1)
+	if list+4 > len(t.colr) {
+		return 0, false
+	}
+	n := int(u32(t.colr, list))
+	if list+4+n*6 > len(t.colr) {
+		return 0, false
+	}
+	rec, ok := search(t.colr[list+4:list+4+n*6], 6, gid)
+	if !ok {
+		return 0, false
+	}
+	return t.v1 + u32(rec, 2), true
+}
+
+// solid returns the paint of the palette entry idx, with its alpha multiplied by
+// alpha.
+func (t *Table) solid(idx uint16, alpha float32) Solid {
+	var s Solid
+	if idx == foregroundIndex {
+		s.Foreground = true
+		s.Color = color.NRGBA{A: 0xff}
+	} else if int(idx) < len(t.palette) {
+		s.Color = t.palette[idx]
+	}
+	s.Color.A = uint8(math.Round(float64(s.Color.A) * float64(clamp(alpha, 0, 1))))
+	return s
+}
+
+// parsePaint decodes the paint at offset off of the COLR table.
+func (t *Table) parsePaint(off uint32, depth int) (Paint, error) {
+	if depth > maxDepth {
+		return nil, errInvalid
+	}
+	d := t.colr
+	o := int(off)
+	if o >= len(d) {
+		return nil, errInvalid
+	}
+	// need reports whether the paint holds n bytes.
+	need := func(n int) bool { return o+n <= len(d) }
+	child := func(at int) (Paint, error) {
+		return t.parsePaint(off+u24(d, o+at), depth+1)
+	}
+	// transformed parses the child paint at offset 1 and transforms it.
+	transformed := func(m f32.Affine2D) (Paint, error) {
+		p, err := child(1)
+		if err != nil {
+			return nil, err
+		}
+		return Transform{Transform: m, Paint: p}, nil
+	}
+	switch format := d[o]; format {
+	case 1: // PaintColrLayers
+		if !need(6) {
+			break
+		}
+		n, first := int(d[o+1]), int(u32(d, o+2))
+		list := int(t.layerList)
+		if list == 0 || list+4 > len(d) || first+n > int(u32(d, list)) || list+4+(first+n)*4 > len(d) {
+			break
+		}
+		layers := make(Layers, 0, n)
+		for i := 0; i < n; i++ {
+			p, err := t.parsePaint(t.layerList+u32(d, list+4+(first+i)*4), depth+1)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, p)
+		}
+		return layers, nil
+	case 2, 3: // PaintSolid, PaintVarSolid
+		if !need(5) {
+			break
+		}
+		return t.solid(u16(d, o+1), f2dot14(d, o+3)), nil
+	case 4, 5: // PaintLinearGradient, PaintVarLinearGradient
+		if !need(16) {
+			break
+		}
+		stops, err := t.colorLine(off+u24(d, o+1), format == 5)
+		if err != nil {
+			return nil, err
+		}
+		return LinearGradient{
+			P0:    point(d, o+4),
+			P1:    point(d, o+8),
+			P2:    point(d, o+12),
+			Stops: stops,
+		}, nil
+	case 6, 7: // PaintRadialGradient, PaintVarRadialGradient
+		if !need(16) {
+			break
+		}
+		stops, err := t.colorLine(off+u24(d, o+1), format == 7)
+		if err != nil {
+			return nil, err
+		}
+		return RadialGradient{
+			C0:    point(d, o+4),
+			R0:    float32(u16(d, o+8)),
+			C1:    point(d, o+10),
+			R1:    float32(u16(d, o+14)),
+			Stops: stops,
+		}, nil
+	case 8, 9: // PaintSweepGradient, PaintVarSweepGradient
+		if !need(12) {
+			break
+		}
+		stops, err := t.colorLine(off+u24(d, o+1), format == 9)
+		if err != nil {
+			return nil, err
+		}
+		return SweepGradient{
+			Center:     point(d, o+4),
+			StartAngle: f2dot14(d, o+8) * math.Pi,
+			EndAngle:   f2dot14(d, o+10) * math.Pi,
+			Stops:      stops,
+		}, nil
+	case 10: // PaintGlyph
+		if !need(6) {
+			break
+		}
+		p, err := child(1)
+		if err != nil {
+			return nil, err
+		}
+		return Glyph{GID: u16(d, o+4), Paint: p}, nil
+	case 11: // PaintColrGlyph
+		if !need(3) {
+			break
+		}
+		base, ok := t.baseGlyphPaint(u16(d, o+1))
+		if !ok {
+			break
+		}
+		return t.parsePaint(base, depth+1)
+	case 12, 13: // PaintTransform, PaintVarTransform
+		if !need(7) {
+			break
+		}
+		a := o + int(u24(d, o+4))
+		if a+24 > len(d) {
+			break
+		}
+		return transformed(f32.NewAffine2D(
+			fixed1616(d, a), fixed1616(d, a+8), fixed1616(d, a+16),
+			fixed1616(d, a+4), fixed1616(d, a+12), fixed1616(d, a+20),
+		))
+	case 14, 15: // PaintTranslate, PaintVarTranslate
+		if !need(8) {
+			break
+		}
+		return transformed(f32.NewAffine2D(1, 0, fword(d, o+4), 0, 1, fword(d, o+6)))
+	case 16, 17, 18, 19, 20, 21, 22, 23: // PaintScale and variants
+		// n is the size of the paint without its center.
+		n := 8
+		if uniform := format >= 20; uniform {
+			n = 6
+		}
+		aroundCenter := (format-16)/2%2 == 1
+		if !need(n) || aroundCenter && !need(n+4) {
+			break
+		}
+		sx, sy := f2dot14(d, o+4), f2dot14(d, o+6)
+		if n == 6 {
+			sy = sx
+		}
+		var center f32.Point
+		if aroundCenter {
+			center = point(d, o+n)
+		}
+		return transformed(around(center, f32.NewAffine2D(sx, 0, 0, 0, sy, 0)))
+	case 24, 25, 26, 27: // PaintRotate and variants
+		if !need(6) {
+			break
+		}
+		var center f32.Point
+		if format >= 26 {
+			if !need(10) {
+				break
+			}
+			center = point(d, o+6)
+		}
+		sin, cos := math.Sincos(float64(f2dot14(d, o+4)) * math.Pi)
+		s, c := float32(sin), float32(cos)
+		return transformed(around(center, f32.NewAffine2D(c, -s, 0, s, c, 0)))
+	case 28, 29, 30, 31: // PaintSkew and variants
+		if !need(8) {
+			break
+		}
+		var center f32.Point
+		if format >= 30 {
+			if !need(12) {
+				break
+			}
+			center = point(d, o+8)
+		}
+		tx := float32(math.Tan(float64(-f2dot14(d, o+4)) * math.Pi))
+		ty := float32(math.Tan(float64(f2dot14(d, o+6)) * math.Pi))
+		return transformed(around(center, f32.NewAffine2D(1, tx, 0, ty, 1, 0)))
+	case 32: // PaintComposite
+		if !need(8) {
+			break
+		}
+		src, err := child(1)
+		if err != nil {
+			return nil, err
+		}
+		backdrop, err := child(5)
+		if err != nil {
+			return nil, err
+		}
+		return Composite{Source: src, Backdrop: backdrop, Mode: d[o+4]}, nil
+	}
+	return nil, errInvalid
+}
+
+// colorLine decodes the color stops of the color line at offset off, whose stops
+// carry variation indices if variable is set.
+func (t *Table) colorLine(off uint32, variable bool) ([]ColorStop, error) {
+	d, o := t.colr, int(off)
+	if o+3 > len(d) {
+		return nil, errInvalid
+	}
+	size := 6
+	if variable {
+		size = 10
+	}
+	n := int(u16(d, o+1))
+	if o+3+n*size > len(d) {
+		return nil, errInvalid
+	}
+	stops := make([]ColorStop, n)
+	for i := range stops {
+		s := o + 3 + i*size
+		stops[i] = ColorStop{
+			Offset: f2dot14(d, s),
+			Color:  t.solid(u16(d, s+2), f2dot14(d, s+4)),
+		}
+	}
+	return stops, nil
+}
+
+// around returns m applied around center.
+func around(center f32.Point, m f32.Affine2D) f32.Affine2D {
+	toCenter := f32.Affine2D{}.Offset(center)
+	return toCenter.Mul(m).Mul(toCenter.Invert())
+}
+
+// search returns the record for gid among the records of the given size, sorted by
+// their leading glyph ID.
+func search(records []byte, size int, gid uint16) ([]byte, bool) {
+	lo, hi := 0, len(records)/size
+	for lo < hi {
+		mid := (lo + hi) / 2
+		rec := records[mid*size:]
+		switch g := u16(rec, 0); {
+		case g < gid:
+			lo = mid + 1
+		case g > gid:
+			hi = mid
+		default:
+			return rec[:size], true
+		}
+	}
+	return nil, false
+}
+
+func clamp(v, lo, hi float32) float32 {
+	return float32(math.Max(float64(lo), math.Min(float64(hi), float64(v))))
+}
+
+func u16(b []byte, i int) uint16 { return binary.BigEndian.Uint16(b[i:]) }
+func u32(b []byte, i int) uint32 { return binary.BigEndian.Uint32(b[i:]) }
+
+func u24(b []byte, i int) uint32 {
+	return uint32(b[i])<<16 | uint32(b[i+1])<<8 | uint32(b[i+2])
+}
+
+func fword(b []byte, i int) float32 { return float32(int16(u16(b, i))) }
+
+func f2dot14(b []byte, i int) float32 { return float32(int16(u16(b, i))) / (1 << 14) }
+
+func fixed1616(b []byte, i int) float32 { return float32(int32(u32(b, i))) / (1 << 16) }
+
+func point(b []byte, i int) f32.Point { return f32.Point{X: fword(b, i), Y: fword(b, i+2)} }
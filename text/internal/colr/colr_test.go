@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package colr
+
+import (
+	"encoding/binary"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"gioui.org/f32"
+)
+
+// table encodes big-endian values of the sizes of their types, and uint32s
+// wrapped in offset24 as 24 bit offsets.
+func table(values ...any) []byte {
+	var b []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case uint8:
+			b = append(b, v)
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case int16:
+			b = binary.BigEndian.AppendUint16(b, uint16(v))
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case offset24:
+			b = append(b, byte(v>>16), byte(v>>8), byte(v))
+		default:
+			panic("unsupported value")
+		}
+	}
+	return b
+}
+
+type offset24 uint32
+
+var (
+	red = color.NRGBA{R: 0xff, A: 0xff}
+	// cpal holds a single palette of red.
+	cpal = table(
+		uint16(0), uint16(1), uint16(1), uint16(1), uint32(14), uint16(0),
+		uint8(0), uint8(0), uint8(0xff), uint8(0xff),
+	)
+)
+
+func TestParseV0(t *testing.T) {
+	colr := table(
+		// Header.
+		uint16(0), uint16(2), uint32(14), uint32(26), uint16(3),
+		// Base glyph records.
+		uint16(3), uint16(0), uint16(2),
+		uint16(4), uint16(2), uint16(1),
+		// Layer records.
+		uint16(10), uint16(0),
+		uint16(11), uint16(0xFFFF),
+		uint16(12), uint16(0),
+	)
+	tab, err := Parse(colr, cpal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := tab.Glyph(3)
+	if !ok {
+		t.Fatal("expected a color glyph")
+	}
+	want := Layers{
+		Glyph{GID: 10, Paint: Solid{Color: red}},
+		Glyph{GID: 11, Paint: Solid{Color: color.NRGBA{A: 0xff}, Foreground: true}},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("expected paint %+v, got %+v", want, p)
+	}
+	if _, ok := tab.Glyph(4); !ok {
+		t.Error("expected a color glyph")
+	}
+	if _, ok := tab.Glyph(5); ok {
+		t.Error("expected no color glyph")
+	}
+}
+
+func TestParseV1(t *testing.T) {
+	colr := table(
+		// Header, with the base glyph list at 34 and the layer list at 50.
+		uint16(1), uint16(0), uint32(0), uint32(0), uint16(0),
+		uint32(34), uint32(50), uint32(0), uint32(0), uint32(0),
+		// Base glyph list, with the paint of glyph 5 at 44.
+		uint32(1), uint16(5), uint32(10),
+		// PaintColrLayers of the 2 layers of the layer list.
+		uint8(1), uint8(2), uint32(0),
+		// Layer list, with layers at 62 and 73.
+		uint32(2), uint32(12), uint32(23),
+		// PaintGlyph 7 filled with a PaintSolid of red.
+		uint8(10), offset24(6), uint16(7),
+		uint8(2), uint16(0), uint16(1<<14),
+		// PaintTranslate of a PaintGlyph 8 filled with a PaintLinearGradient.
+		uint8(14), offset24(8), int16(10), int16(-20),
+		uint8(10), offset24(6), uint16(8),
+		uint8(4), offset24(16), int16(0), int16(0), int16(100), int16(0), int16(0), int16(100),
+		// ColorLine from red to the text color at half opacity.
+		uint8(0), uint16(2),
+		uint16(0), uint16(0), uint16(1<<14),
+		uint16(1<<14), uint16(0xFFFF), uint16(1<<13),
+	)
+	tab, err := Parse(colr, cpal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := tab.Glyph(5)
+	if !ok {
+		t.Fatal("expected a color glyph")
+	}
+	want := Layers{
+		Glyph{GID: 7, Paint: Solid{Color: red}},
+		Transform{
+			Transform: f32.NewAffine2D(1, 0, 10, 0, 1, -20),
+			Paint: Glyph{GID: 8, Paint: LinearGradient{
+				P0: f32.Pt(0, 0), P1: f32.Pt(100, 0), P2: f32.Pt(0, 100),
+				Stops: []ColorStop{
+					{Offset: 0, Color: Solid{Color: red}},
+					{Offset: 1, Color: Solid{Color: color.NRGBA{A: 0x80}, Foreground: true}},
+				},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("expected paint %+v, got %+v", want, p)
+	}
+	if _, ok := tab.Glyph(6); ok {
+		t.Error("expected no color glyph")
+	}
+
+	// Truncated tables are invalid rather than crashing.
+	for n := 34; n < len(colr); n++ {
+		if tab, err := Parse(colr[:n], cpal); err == nil {
+			tab.Glyph(5)
+		}
+	}
+}
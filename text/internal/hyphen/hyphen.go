@@ -0,0 +1,190 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+// Package hyphen finds the points at which words may be hyphenated, using
+// Liang's algorithm and the language-specific patterns of the hyph-utf8
+// project. The patterns keep their original licenses, listed in
+// patterns/NOTICE.
+package hyphen
+
+import (
+	"bufio"
+	"embed"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed patterns
+var patternFiles embed.FS
+
+// Hyphenator hyphenates the words of a single language.
+type Hyphenator struct {
+	// patterns maps the letters of each pattern to the values of the gaps
+	// before, between and after them.
+	patterns map[string][]uint8
+	// exceptions maps words to their permitted hyphenation points, overriding
+	// the patterns.
+	exceptions map[string][]int
+	// maxLen is the length in runes of the longest pattern.
+	maxLen int
+	// leftMin and rightMin are the minimum number of runes kept before and
+	// after a hyphenation point.
+	leftMin, rightMin int
+}
+
+// language describes the patterns available for a language.
+type language struct {
+	// name is the name of the pattern files of the language.
+	name              string
+	leftMin, rightMin int
+}
+
+// languages maps lower case BCP-47 tags to their patterns.
+var languages = map[string]language{
+	"en":    {name: "en-us", leftMin: 2, rightMin: 3},
+	"en-us": {name: "en-us", leftMin: 2, rightMin: 3},
+	"en-gb": {name: "en-gb", leftMin: 2, rightMin: 3},
+	"fr":    {name: "fr", leftMin: 2, rightMin: 3},
+	"pt":    {name: "pt", leftMin: 2, rightMin: 3},
+}
+
+var (
+	mu          sync.Mutex
+	hyphenators = make(map[string]*Hyphenator)
+)
+
+// ForLanguage returns the Hyphenator for the BCP-47 language tag lang. Subtags
+// without patterns of their own fall back to their parent language, such that
+// "en-AU" uses the patterns of "en". ForLanguage returns nil if no patterns are
+// available for lang.
+func ForLanguage(lang string) *Hyphenator {
+	tag := strings.ToLower(strings.ReplaceAll(lang, "_", "-"))
+	for {
+		if l, ok := languages[tag]; ok {
+			mu.Lock()
+			defer mu.Unlock()
+			h, ok := hyphenators[l.name]
+			if !ok {
+				h = load(l)
+				hyphenators[l.name] = h
+			}
+			return h
+		}
+		i := strings.LastIndexByte(tag, '-')
+		if i == -1 {
+			return nil
+		}
+		tag = tag[:i]
+	}
+}
+
+// load parses the embedded pattern files of l.
+func load(l language) *Hyphenator {
+	h := &Hyphenator{
+		patterns:   make(map[string][]uint8),
+		exceptions: make(map[string][]int),
+		leftMin:    l.leftMin,
+		rightMin:   l.rightMin,
+	}
+	pats, err := patternFiles.ReadFile("patterns/hyph-" + l.name + ".pat.txt")
+	if err != nil {
+		panic(err)
+	}
+	forEachLine(string(pats), h.addPattern)
+	// Not every language has exceptions.
+	if hyps, err := patternFiles.ReadFile("patterns/hyph-" + l.name + ".hyp.txt"); err == nil {
+		forEachLine(string(hyps), h.addException)
+	}
+	return h
+}
+
+// forEachLine invokes f for each line of src that is neither empty nor a
+// comment.
+func forEachLine(src string, f func(line string)) {
+	sc := bufio.NewScanner(strings.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '%' {
+			continue
+		}
+		f(line)
+	}
+}
+
+// addPattern adds a pattern such as "hy3ph", where digits give the value of
+// the gap between the surrounding letters.
+func (h *Hyphenator) addPattern(pat string) {
+	var (
+		letters strings.Builder
+		values  = make([]uint8, 1, len(pat)+1)
+	)
+	for _, r := range pat {
+		if r >= '0' && r <= '9' {
+			values[len(values)-1] = uint8(r - '0')
+			continue
+		}
+		letters.WriteRune(r)
+		values = append(values, 0)
+	}
+	key := letters.String()
+	h.patterns[key] = values
+	h.maxLen = max(h.maxLen, utf8.RuneCountInString(key))
+}
+
+// addException adds a word such as "ta-ble" whose hyphenation points are given
+// explicitly.
+func (h *Hyphenator) addException(word string) {
+	var (
+		letters strings.Builder
+		breaks  []int
+		n       int
+	)
+	for _, r := range word {
+		if r == '-' {
+			breaks = append(breaks, n)
+			continue
+		}
+		letters.WriteRune(r)
+		n++
+	}
+	h.exceptions[letters.String()] = breaks
+}
+
+// Hyphenate appends to breaks the indices of the runes of word before which word
+// may be hyphenated, in increasing order.
+func (h *Hyphenator) Hyphenate(word []rune, breaks []int) []int {
+	if len(word) < h.leftMin+h.rightMin {
+		return breaks
+	}
+	// Pad the word with the boundary markers used by the patterns.
+	padded := make([]rune, 0, len(word)+2)
+	padded = append(padded, '.')
+	for _, r := range word {
+		padded = append(padded, unicode.ToLower(r))
+	}
+	padded = append(padded, '.')
+	if exc, ok := h.exceptions[string(padded[1:len(padded)-1])]; ok {
+		return append(breaks, exc...)
+	}
+	// values[i] is the value of the gap before padded[i].
+	values := make([]uint8, len(padded)+1)
+	for i := range padded {
+		for j := i + 1; j <= len(padded) && j-i <= h.maxLen; j++ {
+			pat, ok := h.patterns[string(padded[i:j])]
+			if !ok {
+				continue
+			}
+			for k, v := range pat {
+				values[i+k] = max(values[i+k], v)
+			}
+		}
+	}
+	// The gap before word[i] is the gap before padded[i+1].
+	for i := h.leftMin; i <= len(word)-h.rightMin; i++ {
+		if values[i+1]%2 == 1 {
+			breaks = append(breaks, i)
+		}
+	}
+	return breaks
+}
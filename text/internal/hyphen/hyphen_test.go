@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package hyphen
+
+import (
+	"strings"
+	"testing"
+)
+
+// hyphenate returns word with a hyphen inserted at each hyphenation point.
+func hyphenate(h *Hyphenator, word string) string {
+	runes := []rune(word)
+	var b strings.Builder
+	last := 0
+	for _, i := range h.Hyphenate(runes, nil) {
+		b.WriteString(string(runes[last:i]))
+		b.WriteString("-")
+		last = i
+	}
+	b.WriteString(string(runes[last:]))
+	return b.String()
+}
+
+func TestHyphenate(t *testing.T) {
+	for _, tc := range []struct {
+		lang, word, expected string
+	}{
+		{lang: "en", word: "hyphenation", expected: "hy-phen-a-tion"},
+		{lang: "en-US", word: "Hyphenation", expected: "Hy-phen-a-tion"},
+		{lang: "en", word: "academies", expected: "acad-e-mies"},
+		{lang: "en", word: "computer", expected: "com-puter"},
+		{lang: "en", word: "table", expected: "ta-ble"},
+		{lang: "en", word: "cat", expected: "cat"},
+		{lang: "en_GB", word: "university", expected: "uni-versity"},
+		{lang: "fr", word: "ordinateur", expected: "or-di-na-teur"},
+	} {
+		h := ForLanguage(tc.lang)
+		if h == nil {
+			t.Errorf("no patterns for %q", tc.lang)
+			continue
+		}
+		if got := hyphenate(h, tc.word); got != tc.expected {
+			t.Errorf("%s: hyphenated %q as %q, expected %q", tc.lang, tc.word, got, tc.expected)
+		}
+	}
+}
+
+func TestForLanguage(t *testing.T) {
+	if h := ForLanguage("zz"); h != nil {
+		t.Errorf("expected no patterns for an unknown language")
+	}
+	if ForLanguage("en-AU") != ForLanguage("en") {
+		t.Errorf("expected en-AU to fall back to the patterns of en")
+	}
+	if ForLanguage("en-GB") == ForLanguage("en") {
+		t.Errorf("expected en-GB to use its own patterns")
+	}
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/go-text/typesetting/opentype/api"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+
+	"gioui.org/f32"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+)
+
+// Subpixel is the order of the color subpixels of the pixels of a display, for
+// drawing text with subpixel (LCD) coverage. Subpixel coverage triples the
+// horizontal resolution of text on displays whose pixels are made of
+// horizontal red, green and blue stripes.
+type Subpixel uint8
+
+const (
+	// SubpixelNone draws text with grayscale coverage.
+	SubpixelNone Subpixel = iota
+	// SubpixelRGB is the order of displays with red subpixels on the left.
+	SubpixelRGB
+	// SubpixelBGR is the order of displays with blue subpixels on the left.
+	SubpixelBGR
+)
+
+// WithSubpixel configures the order of the subpixels of the display for the
+// glyphs drawn by [Shaper.LCD]. Applications may select the subpixel order of
+// each window by using a separate shaper for each window, as is required
+// anyway. The default is SubpixelNone.
+func WithSubpixel(order Subpixel) ShaperOption {
+	return func(s *Shaper) {
+		s.config.subpixel = order
+	}
+}
+
+// lcdFilter spreads the coverage of each subpixel over its neighbors to reduce
+// color fringes, as the default LCD filter of FreeType. Its weights sum to 256.
+var lcdFilter = [...]int{8, 77, 86, 77, 8}
+
+// lcdPaint paints the vector glyphs of a line with subpixel coverage.
+type lcdPaint struct {
+	call op.CallOp
+	// fg, bg and frac are the text and background colors and the fractional
+	// position of the first glyph of the line, which determine call besides
+	// the glyphs.
+	fg, bg color.NRGBA
+	frac   fixed.Int26_6
+}
+
+type lcdCache = glyphLRU[lcdPaint]
+
+// LCD returns an op.CallOp that paints the vector glyphs of gs in the color fg,
+// with a coverage computed for each color subpixel of the display as configured
+// by [WithSubpixel]. It replaces filling the path returned by Shape for the
+// same gs slice, and aligns with it.
+//
+// The color channels of the glyphs are blended separately with the background,
+// which the renderers can't do: the glyphs are rasterized to an opaque image
+// blended with bg, which must be the opaque color behind the text. The image is
+// aligned to pixels when the op.CallOp is added at an offset whose fractional
+// part matches that of the position of the first glyph, as in widget.Label,
+// without scaling or rotation.
+//
+// LCD returns an empty op.CallOp if the shaper has no subpixel order or bg
+// isn't opaque. All glyphs are expected to be from a single line of text.
+func (l *Shaper) LCD(gs []Glyph, fg, bg color.NRGBA) op.CallOp {
+	l.init()
+	if l.config.subpixel == SubpixelNone || bg.A != 0xff || len(gs) == 0 {
+		return op.CallOp{}
+	}
+	l.shaper.useFaces(gs)
+	frac := gs[0].X - fixed.I(gs[0].X.Floor())
+	key := l.lcdCache.hashGlyphs(gs)
+	colors := uint64(packColor(fg))<<32 | uint64(packColor(bg))
+	key = (key^colors)*6585573582091643 + uint64(frac)
+	if p, ok := l.lcdCache.Get(key, gs); ok && p.fg == fg && p.bg == bg && p.frac == frac {
+		return p.call
+	}
+	ops := new(op.Ops)
+	p := lcdPaint{
+		call: l.shaper.LCD(ops, gs, l.config.subpixel, fg, bg),
+		fg:   fg,
+		bg:   bg,
+		frac: frac,
+	}
+	l.lcdCache.Put(key, gs, p)
+	return p.call
+}
+
+func packColor(c color.NRGBA) uint32 {
+	return uint32(c.R)<<24 | uint32(c.G)<<16 | uint32(c.B)<<8 | uint32(c.A)
+}
+
+// LCD rasterizes the vector glyphs of gs with subpixel coverage in the order of
+// the subpixels, blending fg over bg, and returns an op.CallOp painting the
+// image at the position of the path of Shape.
+func (s *shaperImpl) LCD(ops *op.Ops, gs []Glyph, order Subpixel, fg, bg color.NRGBA) op.CallOp {
+	// Rasterize relative to the pixel containing the origin of the first
+	// glyph, to align the image to pixels.
+	x := fixed.I(gs[0].X.Floor())
+	type segment struct {
+		op   api.SegmentOp
+		args [3]f32.Point
+	}
+	var segs []segment
+	lo := f32.Pt(math.MaxFloat32, math.MaxFloat32)
+	hi := f32.Pt(-math.MaxFloat32, -math.MaxFloat32)
+	for _, g := range gs {
+		s.glyphOutline(g, x, gs[0].Y, func(seg api.SegmentOp, args []f32.Point) {
+			sg := segment{op: seg}
+			copy(sg.args[:], args)
+			segs = append(segs, sg)
+			// The control points bound the curves.
+			for _, a := range args {
+				lo.X, lo.Y = float32(math.Min(float64(lo.X), float64(a.X))), float32(math.Min(float64(lo.Y), float64(a.Y)))
+				hi.X, hi.Y = float32(math.Max(float64(hi.X), float64(a.X))), float32(math.Max(float64(hi.Y), float64(a.Y)))
+			}
+		})
+	}
+	m := op.Record(ops)
+	if len(segs) == 0 {
+		return m.Stop()
+	}
+	// Pad the image for the filter, which spreads coverage by 2 subpixels.
+	bounds := image.Rectangle{
+		Min: image.Pt(int(math.Floor(float64(lo.X)))-1, int(math.Floor(float64(lo.Y)))),
+		Max: image.Pt(int(math.Ceil(float64(hi.X)))+1, int(math.Ceil(float64(hi.Y)))),
+	}
+	size := bounds.Size()
+	z := vector.NewRasterizer(3*size.X, size.Y)
+	z.DrawOp = draw.Src
+	pt := func(p f32.Point) (float32, float32) {
+		return 3 * (p.X - float32(bounds.Min.X)), p.Y - float32(bounds.Min.Y)
+	}
+	for i, sg := range segs {
+		switch sg.op {
+		case api.SegmentOpMoveTo:
+			if i > 0 {
+				z.ClosePath()
+			}
+			z.MoveTo(pt(sg.args[0]))
+		case api.SegmentOpLineTo:
+			z.LineTo(pt(sg.args[0]))
+		case api.SegmentOpQuadTo:
+			bx, by := pt(sg.args[0])
+			cx, cy := pt(sg.args[1])
+			z.QuadTo(bx, by, cx, cy)
+		case api.SegmentOpCubeTo:
+			bx, by := pt(sg.args[0])
+			cx, cy := pt(sg.args[1])
+			dx, dy := pt(sg.args[2])
+			z.CubeTo(bx, by, cx, cy, dx, dy)
+		}
+	}
+	z.ClosePath()
+	cov := image.NewAlpha(image.Rect(0, 0, 3*size.X, size.Y))
+	z.Draw(cov, cov.Bounds(), image.Opaque, image.Point{})
+	img := lcdImage(cov, order, fg, bg)
+
+	off := op.Affine(f32.Affine2D{}.Offset(f32.Point{
+		X: float32(bounds.Min.X) - fixedToFloat(gs[0].X-x),
+		Y: float32(bounds.Min.Y),
+	})).Push(ops)
+	imgOp := paint.NewImageOp(img)
+	imgOp.Filter = paint.FilterNearest
+	imgOp.Add(ops)
+	cl := clip.Rect{Max: size}.Push(ops)
+	paint.PaintOp{}.Add(ops)
+	cl.Pop()
+	off.Pop()
+	return m.Stop()
+}
+
+// lcdImage filters the coverage of the subpixels in cov, three per pixel, and
+// blends fg over bg with the coverage of each subpixel of the color channel it
+// displays. Pixels without coverage are transparent.
+func lcdImage(cov *image.Alpha, order Subpixel, fg, bg color.NRGBA) *image.NRGBA {
+	size := cov.Bounds().Size()
+	img := image.NewNRGBA(image.Rect(0, 0, size.X/3, size.Y))
+	fgc := [3]int{int(fg.R), int(fg.G), int(fg.B)}
+	bgc := [3]int{int(bg.R), int(bg.G), int(bg.B)}
+	const full = 255 * 256
+	for y := 0; y < size.Y; y++ {
+		row := cov.Pix[y*cov.Stride : y*cov.Stride+size.X]
+		for px := 0; px < size.X/3; px++ {
+			var c [3]int
+			for i := range c {
+				for j, w := range lcdFilter {
+					if k := 3*px + i + j - len(lcdFilter)/2; k >= 0 && k < len(row) {
+						c[i] += w * int(row[k])
+					}
+				}
+			}
+			if c == [3]int{} {
+				continue
+			}
+			if order == SubpixelBGR {
+				c[0], c[2] = c[2], c[0]
+			}
+			o := img.PixOffset(px, y)
+			for i := range c {
+				a := c[i] * int(fg.A) / 255
+				img.Pix[o+i] = uint8((fgc[i]*a + bgc[i]*(full-a)) / full)
+			}
+			img.Pix[o+3] = 0xff
+		}
+	}
+	return img
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+
+	"gioui.org/font/opentype"
+	"gioui.org/op"
+)
+
+func TestLCDImage(t *testing.T) {
+	// A shape covering the subpixels from the middle of the third pixel.
+	cov := image.NewAlpha(image.Rect(0, 0, 3*6, 1))
+	for x := 3*2 + 1; x < 3*6; x++ {
+		cov.Pix[x] = 0xff
+	}
+	fg := color.NRGBA{A: 0xff}
+	bg := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	img := lcdImage(cov, SubpixelRGB, fg, bg)
+	if px := img.NRGBAAt(0, 0); px.A != 0 {
+		t.Errorf("expected an uncovered pixel to be transparent, got %v", px)
+	}
+	if px := img.NRGBAAt(4, 0); px != fg {
+		t.Errorf("expected a covered pixel to have the text color, got %v", px)
+	}
+	edge := img.NRGBAAt(2, 0)
+	if edge.A != 0xff || !(edge.R > edge.G && edge.G > edge.B) {
+		t.Errorf("expected the red subpixel of the edge to be lighter than the blue subpixel, got %v", edge)
+	}
+	bgr := lcdImage(cov, SubpixelBGR, fg, bg).NRGBAAt(2, 0)
+	if bgr.R != edge.B || bgr.B != edge.R || bgr.G != edge.G {
+		t.Errorf("expected BGR to swap the red and blue coverage of %v, got %v", edge, bgr)
+	}
+	// The text color is blended with the background by its alpha.
+	half := lcdImage(cov, SubpixelRGB, color.NRGBA{A: 0x80}, bg).NRGBAAt(4, 0)
+	if half.R < 0x7e || half.R > 0x80 || half.A != 0xff {
+		t.Errorf("expected translucent text to be blended with the background, got %v", half)
+	}
+}
+
+func TestShaperLCD(t *testing.T) {
+	face, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := func(shaper *Shaper) []Glyph {
+		shaper.LayoutString(Parameters{PxPerEm: fixed.I(13), MaxWidth: 1000, Locale: english}, "Hello")
+		var gs []Glyph
+		for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+			gs = append(gs, g)
+		}
+		return gs
+	}
+	fg := color.NRGBA{A: 0xff}
+	bg := color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	plain := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: face}}))
+	if call := plain.LCD(layout(plain), fg, bg); call != (op.CallOp{}) {
+		t.Error("expected no subpixel coverage without a subpixel order")
+	}
+	shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: face}}), WithSubpixel(SubpixelRGB))
+	gs := layout(shaper)
+	call := shaper.LCD(gs, fg, bg)
+	if call == (op.CallOp{}) {
+		t.Fatal("expected subpixel coverage")
+	}
+	if shaper.LCD(gs, fg, bg) != call {
+		t.Error("expected the glyphs to be cached")
+	}
+	if shaper.LCD(gs, color.NRGBA{R: 0xff, A: 0xff}, bg) == call {
+		t.Error("expected the glyphs of another color to be drawn again")
+	}
+	if call := shaper.LCD(gs, fg, color.NRGBA{A: 0x80}); call != (op.CallOp{}) {
+		t.Error("expected no subpixel coverage over a translucent background")
+	}
+}
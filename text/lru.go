@@ -6,9 +6,7 @@ import (
 	"image"
 	"sync/atomic"
 
-	giofont "gioui.org/font"
 	"gioui.org/io/system"
-	"gioui.org/op"
 	"gioui.org/op/clip"
 	"gioui.org/op/paint"
 	"golang.org/x/image/math/fixed"
@@ -58,6 +56,16 @@ func (l *lru[K, V]) Put(k K, v V) {
 	}
 }
 
+// Invalidate removes the entries for which drop returns true.
+func (l *lru[K, V]) Invalidate(drop func(k K, v V) bool) {
+	for k, e := range l.m {
+		if drop(k, e.v) {
+			l.remove(e)
+			delete(l.m, k)
+		}
+	}
+}
+
 // remove cuts e out of the lru linked list.
 func (l *lru[K, V]) remove(e *entry[K, V]) {
 	e.next.prev = e.prev
@@ -93,7 +101,7 @@ type glyphLRU[V any] struct {
 
 var seed uint32
 
-// hashGlyphs computes a hash key based on the ID and X offset of
+// hashGlyphs computes a hash key based on the ID and X and Y offsets of
 // every glyph in the slice.
 func (c *glyphLRU[V]) hashGlyphs(gs []Glyph) uint64 {
 	if c.seed == 0 {
@@ -104,10 +112,12 @@ func (c *glyphLRU[V]) hashGlyphs(gs []Glyph) uint64 {
 	}
 
 	h := c.seed
-	firstX := gs[0].X
+	firstX, firstY := gs[0].X, gs[0].Y
 	for _, g := range gs {
 		h += uint64(g.X - firstX)
 		h *= 6585573582091643
+		h += uint64(g.Y - firstY)
+		h *= 6585573582091643
 		h += uint64(g.ID)
 		h *= 3650802748644053
 	}
@@ -126,12 +136,18 @@ func (c *glyphLRU[V]) Get(key uint64, gs []Glyph) (V, bool) {
 func (c *glyphLRU[V]) Put(key uint64, glyphs []Glyph, v V) {
 	gids := make([]glyphInfo, len(glyphs))
 	firstX := fixed.I(0)
+	firstY := int32(0)
 	for i, glyph := range glyphs {
 		if i == 0 {
-			firstX = glyph.X
+			firstX, firstY = glyph.X, glyph.Y
+		}
+		// Cache glyph offsets relative to the first glyph.
+		gids[i] = glyphInfo{
+			ID:       glyph.ID,
+			X:        glyph.X - firstX,
+			Y:        glyph.Y - firstY,
+			vertical: glyph.Flags&FlagVertical != 0,
 		}
-		// Cache glyph X offsets relative to the first glyph.
-		gids[i] = glyphInfo{ID: glyph.ID, X: glyph.X - firstX}
 	}
 	val := glyphValue[V]{
 		glyphs: gids,
@@ -142,25 +158,38 @@ func (c *glyphLRU[V]) Put(key uint64, glyphs []Glyph, v V) {
 
 type pathCache = glyphLRU[clip.PathSpec]
 
-type bitmapShapeCache = glyphLRU[op.CallOp]
+type bitmapShapeCache = glyphLRU[bitmapPaint]
 
 type glyphInfo struct {
 	ID GlyphID
 	X  fixed.Int26_6
+	Y  int32
+	// vertical distinguishes glyphs of vertical text, which are offset
+	// differently.
+	vertical bool
 }
 
 type layoutKey struct {
-	ppem               fixed.Int26_6
-	maxWidth, minWidth int
-	maxLines           int
-	str                string
-	truncator          string
-	locale             system.Locale
-	font               giofont.Font
-	forceTruncate      bool
-	wrapPolicy         WrapPolicy
-	lineHeight         fixed.Int26_6
-	lineHeightScale    float32
+	ppem            fixed.Int26_6
+	maxWidth        int
+	maxLines        int
+	str             string
+	truncator       string
+	locale          system.Locale
+	font            fontKey
+	forceTruncate   bool
+	wrapPolicy      WrapPolicy
+	lineHeight      fixed.Int26_6
+	lineHeightScale float32
+	letterSpacing   fixed.Int26_6
+	wordSpacing     fixed.Int26_6
+	// spans encodes the styles of the spans of str, if any.
+	spans string
+	// features encodes the OpenType features of the text, if any.
+	features string
+	// tabWidth and tabStops encode the tab stop settings of the text.
+	tabWidth int
+	tabStops string
 }
 
 const maxSize = 1000
@@ -170,12 +199,14 @@ func gidsEqual(a []glyphInfo, glyphs []Glyph) bool {
 		return false
 	}
 	firstX := fixed.Int26_6(0)
+	firstY := int32(0)
 	for i := range a {
 		if i == 0 {
-			firstX = glyphs[i].X
+			firstX, firstY = glyphs[i].X, glyphs[i].Y
 		}
-		// Cache glyph X offsets relative to the first glyph.
-		if a[i].ID != glyphs[i].ID || a[i].X != (glyphs[i].X-firstX) {
+		// Cache glyph offsets relative to the first glyph.
+		g := glyphs[i]
+		if a[i].ID != g.ID || a[i].X != (g.X-firstX) || a[i].Y != (g.Y-firstY) || a[i].vertical != (g.Flags&FlagVertical != 0) {
 			return false
 		}
 	}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package text
+
+import (
+	"image"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// Measurement describes the dimensions of laid out text.
+type Measurement struct {
+	// Lines describes each line of the text, in order. Text ending with a
+	// newline ends with an empty line.
+	Lines []LineMetrics
+	// Bounds encloses the logical extents of the glyphs of all lines, in the
+	// coordinates of the glyphs of the text. Its size is the size of the text
+	// when displayed by widgets such as widget.Label.
+	Bounds image.Rectangle
+	// Truncated is the number of runes replaced by the truncator, if any.
+	Truncated int
+}
+
+// LineMetrics describes the dimensions of a line of laid out text.
+type LineMetrics struct {
+	// Baseline is the position of the baseline of the line along the axis
+	// perpendicular to the line. It is the Y coordinate of the glyphs of
+	// horizontal lines and the X coordinate of the glyphs of vertical lines.
+	Baseline int
+	// Ascent and Descent are the extents of the line above and below its
+	// baseline.
+	Ascent, Descent fixed.Int26_6
+	// Width is the length of the line along its direction.
+	Width fixed.Int26_6
+	// Bounds encloses the logical extents of the glyphs of the line.
+	Bounds image.Rectangle
+	// Runes is the number of runes represented by the line.
+	Runes int
+}
+
+// Measure lays out str like LayoutString and returns its dimensions, without
+// affecting the glyphs returned by NextGlyph. The layout is cached, so laying
+// out the same text with the same parameters afterwards is cheap.
+func (l *Shaper) Measure(params Parameters, str string) Measurement {
+	l.init()
+	saved := l.iteration
+	l.iteration = l.measuring
+	defer func() { l.measuring, l.iteration = l.iteration, saved }()
+	l.layoutText(params, nil, str, nil)
+	return l.measure(params.MaxLines)
+}
+
+// MeasureSpans is Measure for text laid out by LayoutSpans.
+func (l *Shaper) MeasureSpans(params Parameters, spans []Span) Measurement {
+	l.init()
+	saved := l.iteration
+	l.iteration = l.measuring
+	defer func() { l.measuring, l.iteration = l.iteration, saved }()
+	l.LayoutSpans(params, spans)
+	return l.measure(params.MaxLines)
+}
+
+// measure consumes the glyphs of the laid out text and returns their dimensions.
+// Like the widgets displaying text truncated to maxLines, it ignores the
+// paragraph break ending the last line.
+func (l *Shaper) measure(maxLines int) Measurement {
+	var m Measurement
+	var cur LineMetrics
+	// start and end delimit the current line along its direction.
+	var start, end fixed.Int26_6
+	empty := true
+	endLine := func() {
+		cur.Width = end - start
+		if len(m.Lines) == 0 {
+			m.Bounds = cur.Bounds
+		} else {
+			m.Bounds = extend(m.Bounds, cur.Bounds)
+		}
+		m.Lines = append(m.Lines, cur)
+		empty = true
+	}
+	linesSeen := 0
+	for g, ok := l.NextGlyph(); ok; g, ok = l.NextGlyph() {
+		if g.Flags&FlagTruncator != 0 && g.Flags&FlagClusterBreak != 0 {
+			m.Truncated = int(g.Runes)
+		}
+		if g.Flags&FlagLineBreak != 0 {
+			linesSeen++
+		}
+		if maxLines > 0 && linesSeen == maxLines && g.Flags&FlagParagraphBreak != 0 {
+			break
+		}
+		// logical is the logical extent of the glyph, and pos its position
+		// along the line.
+		logical := image.Rectangle{
+			Min: image.Pt(g.X.Floor(), int(g.Y)+(-g.Ascent).Floor()),
+			Max: image.Pt((g.X + g.Advance).Ceil(), int(g.Y)+g.Descent.Ceil()),
+		}
+		pos := g.X
+		vertical := g.Flags&FlagVertical != 0
+		if vertical {
+			// Vertical glyphs advance downward, and ascend to the right.
+			logical = image.Rectangle{
+				Min: image.Pt((g.X - g.Descent).Floor(), int(g.Y)),
+				Max: image.Pt((g.X + g.Ascent).Ceil(), int(g.Y)+g.Advance.Ceil()),
+			}
+			pos = fixed.I(int(g.Y))
+		}
+		if empty {
+			cur = LineMetrics{Ascent: g.Ascent, Descent: g.Descent, Bounds: logical}
+			cur.Baseline = int(g.Y)
+			if vertical {
+				cur.Baseline = g.X.Round()
+			}
+			start, end = pos, pos+g.Advance
+			empty = false
+		} else {
+			cur.Bounds = extend(cur.Bounds, logical)
+			if pos < start {
+				start = pos
+			}
+			if e := pos + g.Advance; e > end {
+				end = e
+			}
+		}
+		cur.Runes += int(g.Runes)
+		if g.Flags&FlagLineBreak != 0 {
+			endLine()
+		}
+	}
+	if !empty {
+		endLine()
+	}
+	return m
+}
+
+// extend returns the smallest rectangle enclosing a and b. Unlike
+// image.Rectangle.Union, it accounts for empty rectangles, such as the extent
+// of glyphs without advance.
+func extend(a, b image.Rectangle) image.Rectangle {
+	a.Min.X = min(a.Min.X, b.Min.X)
+	a.Min.Y = min(a.Min.Y, b.Min.Y)
+	a.Max.X = max(a.Max.X, b.Max.X)
+	a.Max.Y = max(a.Max.Y, b.Max.Y)
+	return a
+}
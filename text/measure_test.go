@@ -0,0 +1,105 @@
+package text
+
+import (
+	"image"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/image/math/fixed"
+)
+
+func TestMeasure(t *testing.T) {
+	shaper := NewShaper(NoSystemFonts(), WithCollection(coverageCollection(t)[:1]))
+	params := Parameters{PxPerEm: fixed.I(16), MaxWidth: 100, Locale: english}
+	const str = "The quick brown fox jumps over the lazy dog\nagain"
+
+	m := shaper.Measure(params, str)
+	// Compare the measurement with the glyphs of the text.
+	shaper.LayoutString(params, str)
+	var lines []image.Rectangle
+	var bounds image.Rectangle
+	runes := 0
+	newLine := true
+	for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+		r := image.Rect(g.X.Floor(), int(g.Y)-g.Ascent.Ceil(), (g.X + g.Advance).Ceil(), int(g.Y)+g.Descent.Ceil())
+		if newLine {
+			lines = append(lines, r)
+		} else {
+			lines[len(lines)-1] = extend(lines[len(lines)-1], r)
+		}
+		bounds = extend(bounds, r)
+		newLine = g.Flags&FlagLineBreak != 0
+		runes += int(g.Runes)
+	}
+	if len(m.Lines) != len(lines) || len(lines) < 3 {
+		t.Fatalf("expected %d wrapped lines, got %+v", len(lines), m.Lines)
+	}
+	if m.Bounds != bounds {
+		t.Errorf("expected bounds %v, got %v", bounds, m.Bounds)
+	}
+	total := 0
+	for i, l := range m.Lines {
+		if l.Bounds != lines[i] {
+			t.Errorf("line %d: expected bounds %v, got %v", i, lines[i], l.Bounds)
+		}
+		if l.Width <= 0 || l.Width > fixed.I(params.MaxWidth) {
+			t.Errorf("line %d: expected a width within the maximum width, got %v", i, l.Width)
+		}
+		if l.Baseline-l.Ascent.Ceil() != l.Bounds.Min.Y {
+			t.Errorf("line %d: expected ascent %v above baseline %d, got bounds %v", i, l.Ascent, l.Baseline, l.Bounds)
+		}
+		total += l.Runes
+	}
+	if total != utf8.RuneCountInString(str) || runes != total {
+		t.Errorf("expected lines of %d runes, got %d", utf8.RuneCountInString(str), total)
+	}
+
+	// Text ending with a newline ends with an empty line.
+	m = shaper.Measure(params, "one\n")
+	if len(m.Lines) != 2 || m.Lines[1].Width != 0 || m.Lines[1].Runes != 0 {
+		t.Errorf("expected an empty trailing line, got %+v", m.Lines)
+	}
+
+	// Truncated text is measured to its last line.
+	params.MaxLines = 2
+	m = shaper.Measure(params, str)
+	if len(m.Lines) != 2 || m.Truncated == 0 {
+		t.Errorf("expected 2 lines and truncated runes, got %+v", m)
+	}
+}
+
+func TestMeasureIteration(t *testing.T) {
+	shaper := NewShaper(NoSystemFonts(), WithCollection(coverageCollection(t)[:1]))
+	params := Parameters{PxPerEm: fixed.I(16), MaxWidth: 100, Locale: english}
+	collect := func() []Glyph {
+		var gs []Glyph
+		for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+			gs = append(gs, g)
+		}
+		return gs
+	}
+	shaper.LayoutString(params, "hello world")
+	want := collect()
+
+	// Measuring doesn't disturb the iteration over the glyphs of laid out text.
+	shaper.LayoutString(params, "hello world")
+	first, _ := shaper.NextGlyph()
+	shaper.Measure(params, "other text\nwith lines")
+	got := append([]Glyph{first}, collect()...)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d glyphs, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("glyph %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+
+	// Measured text is cached for laying it out.
+	shaper.Measure(params, "cached\ntext")
+	n := len(shaper.layoutCache.m)
+	shaper.LayoutString(params, "cached\ntext")
+	if len(shaper.layoutCache.m) != n {
+		t.Errorf("expected measured paragraphs to be cached")
+	}
+}
@@ -4,6 +4,7 @@ package text
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"strings"
 	"unicode/utf8"
@@ -35,6 +36,11 @@ const (
 	// breaking any word across lines on UAX#29 grapheme cluster boundaries to maximize the number of
 	// grapheme clusters on each line.
 	WrapGraphemes
+	// WrapHyphenated behaves like WrapHeuristically, but also breaks words at the
+	// hyphenation points of the language of the Locale of the text, inserting a
+	// hyphen glyph at the end of the hyphenated line. Text in languages without
+	// hyphenation patterns is wrapped like WrapHeuristically.
+	WrapHyphenated
 )
 
 // Parameters are static text shaping attributes applied to the entire shaped text.
@@ -61,6 +67,24 @@ type Parameters struct {
 	MinWidth, MaxWidth int
 	// Locale provides primary direction and language information for the shaped text.
 	Locale system.Locale
+	// LetterSpacing is extra space added after every grapheme cluster of the text.
+	// Negative values bring the clusters closer together.
+	LetterSpacing fixed.Int26_6
+	// WordSpacing is extra space added to every word separator of the text, in
+	// addition to any LetterSpacing.
+	WordSpacing fixed.Int26_6
+	// TabWidth and TabStops position the text following tab characters. Tab stops
+	// are enabled if either is set; otherwise tabs are shaped like any other rune.
+	// TabStops lists explicit stop positions in ascending order, measured from the
+	// start of each line. Beyond the final explicit stop, stops repeat every
+	// TabWidth spaces, or every 8 spaces if TabWidth is zero. A tab advances the
+	// text to the next stop after its position.
+	TabWidth int
+	TabStops []fixed.Int26_6
+	// Features configures the OpenType features used to shape the text, such as
+	// tabular numbers or standard ligatures. Features not listed keep the default
+	// behavior of the font.
+	Features []Feature
 
 	// LineHeightScale is a scaling factor applied to the LineHeight of a paragraph. If zero, a default
 	// value of 1.2 will be used.
@@ -80,6 +104,58 @@ type Parameters struct {
 
 type FontFace = giofont.FontFace
 
+// Span is a sequence of text sharing a single style. Spans laid out together
+// with [Shaper.LayoutSpans] are wrapped, aligned and ordered as a single body
+// of text, allowing a paragraph to mix fonts and sizes.
+type Span struct {
+	// Font describes the preferred typeface of the span.
+	Font giofont.Font
+	// PxPerEm is the pixels-per-em to shape the span with. If zero, the
+	// PxPerEm of the Parameters is used.
+	PxPerEm fixed.Int26_6
+	// Content is the text of the span. It may contain newlines, which
+	// break paragraphs as in any other text.
+	Content string
+	// Placeholder, if non-zero, makes the span an inline object, such as an
+	// image or a widget, that occupies the space described by Placeholder.
+	// Its Content is ignored and the span counts as the single rune U+FFFC
+	// (OBJECT REPLACEMENT CHARACTER). The object is laid out as a single glyph
+	// with FlagPlaceholder set, and isn't painted by the Shaper.
+	Placeholder Placeholder
+}
+
+// Placeholder describes the dimensions of an inline object laid out within
+// text. The object rests on the baseline of its line, like the glyphs of a
+// font, and lines are tall enough to contain it.
+type Placeholder struct {
+	// Width is the extent of the object along the line.
+	Width fixed.Int26_6
+	// Ascent and Descent are the extents of the object above and below
+	// the baseline.
+	Ascent, Descent fixed.Int26_6
+}
+
+// objectReplacement is the text standing in for the inline object of a
+// placeholder span.
+const objectReplacement = "\uFFFC"
+
+// Feature is an OpenType feature setting. Features select optional font
+// behavior, such as "tnum" for tabular numbers, "smcp" for small capitals or
+// "zero" for slashed zeros, and can disable default behavior such as the "liga"
+// and "calt" ligatures.
+//
+// See https://learn.microsoft.com/en-us/typography/opentype/spec/featurelist
+// for the list of registered features.
+type Feature struct {
+	// Tag is the four letter tag of the feature. Features with tags of
+	// any other length are ignored.
+	Tag string
+	// Value is the value of the feature. For most features, 1 enables the
+	// feature and 0 disables it. Some features, such as "salt", accept other
+	// values to choose among alternates.
+	Value uint32
+}
+
 // Glyph describes a shaped font glyph. Many fields are distances relative
 // to the "dot", which is a point on the baseline (the line upon which glyphs
 // visually rest) for the line of text containing the glyph.
@@ -124,6 +200,11 @@ type Glyph struct {
 	Runes uint16
 	// Flags encode special properties of this glyph.
 	Flags Flags
+	// Span is the index of the Span this glyph was shaped from when the text
+	// was laid out with LayoutSpans, allowing callers to paint each span with
+	// its own material. Truncator glyphs report the final span. Span is always
+	// zero for text laid out with Layout or LayoutString.
+	Span int
 }
 
 type Flags uint16
@@ -155,6 +236,19 @@ const (
 	// FlagTruncator and FlagClusterBreak will have a Runes field accounting for all
 	// runes truncated.
 	FlagTruncator
+	// FlagHyphen indicates that the glyph is a hyphen inserted by the shaper at the
+	// end of a line broken within a word. It represents no runes of the text, and
+	// should be excluded from text copied from the glyphs.
+	FlagHyphen
+	// FlagVertical indicates that the glyph is part of a vertical line of text.
+	// The Advance of a vertical glyph extends down from its dot, and its Ascent
+	// and Descent extend to the right and left of the dot. The X coordinate of
+	// the dot is the center of the line.
+	FlagVertical
+	// FlagPlaceholder indicates that the glyph represents the inline object of a
+	// Span with a Placeholder. The Bounds of the glyph are the dimensions of the
+	// object relative to the dot, and the Span field identifies its span.
+	FlagPlaceholder
 )
 
 func (f Flags) String() string {
@@ -194,6 +288,21 @@ func (f Flags) String() string {
 	} else {
 		b.WriteString("_")
 	}
+	if f&FlagHyphen != 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("_")
+	}
+	if f&FlagVertical != 0 {
+		b.WriteString("V")
+	} else {
+		b.WriteString("_")
+	}
+	if f&FlagPlaceholder != 0 {
+		b.WriteString("O")
+	} else {
+		b.WriteString("_")
+	}
 	return b.String()
 }
 
@@ -211,18 +320,34 @@ type GlyphID uint64
 type Shaper struct {
 	config struct {
 		disableSystemFonts bool
+		fontCacheDir       string
 		collection         []FontFace
+		hinting            Hinting
+		subpixel           Subpixel
 	}
 	initialized      bool
 	shaper           shaperImpl
 	pathCache        pathCache
 	bitmapShapeCache bitmapShapeCache
+	lcdCache         lcdCache
+	decorationCache  pathCache
 	layoutCache      layoutCache
 
 	reader    *bufio.Reader
 	paragraph []byte
+	// spanText and spanStyles hold the concatenated content and styles of the
+	// spans provided to LayoutSpans.
+	spanText   []byte
+	spanStyles []spanStyle
 
 	// Iterator state.
+	iteration
+	// measuring is the iteration state of Measure, kept to reuse its memory.
+	measuring iteration
+}
+
+// iteration is the state of the iteration over the glyphs of laid out text.
+type iteration struct {
 	brokeParagraph   bool
 	pararagraphStart Glyph
 	txt              document
@@ -231,6 +356,11 @@ type Shaper struct {
 	glyph            int
 	// advance is the width of glyphs from the current run that have already been displayed.
 	advance fixed.Int26_6
+	// gaps is the number of justification opportunities from the current run that
+	// have already been displayed.
+	gaps int
+	// justified describes the expansion of the current line, if it is justified.
+	justified justification
 	// done tracks whether iteration is over.
 	done bool
 	err  error
@@ -246,6 +376,21 @@ func NoSystemFonts() ShaperOption {
 	}
 }
 
+// WithFontCacheDir configures the directory in which the index of the system fonts
+// is cached. Applications may want to use the directory returned by [app.DataDir].
+// If dir is empty or the option is not given, the user cache directory is used.
+//
+// The system fonts are only indexed once the faces provided by [WithCollection]
+// don't suffice to display some text, and their font data is loaded once each
+// face is first used.
+//
+// [app.DataDir]: https://pkg.go.dev/gioui.org/app#DataDir
+func WithFontCacheDir(dir string) ShaperOption {
+	return func(s *Shaper) {
+		s.config.fontCacheDir = dir
+	}
+}
+
 // WithCollection can be used to provide a collection of pre-loaded fonts to the shaper.
 func WithCollection(collection []FontFace) ShaperOption {
 	return func(s *Shaper) {
@@ -274,23 +419,59 @@ func (l *Shaper) init() {
 	l.initialized = true
 	l.reader = bufio.NewReader(nil)
 	l.shaper = *newShaperImpl(!l.config.disableSystemFonts, l.config.collection)
+	l.shaper.fontCacheDir = l.config.fontCacheDir
+	l.shaper.hinting = l.config.hinting
 }
 
 // Layout text from an io.Reader according to a set of options. Results can be retrieved by
 // iteratively calling NextGlyph.
 func (l *Shaper) Layout(params Parameters, txt io.Reader) {
 	l.init()
-	l.layoutText(params, txt, "")
+	l.layoutText(params, txt, "", nil)
 }
 
 // LayoutString is Layout for strings.
 func (l *Shaper) LayoutString(params Parameters, str string) {
 	l.init()
-	l.layoutText(params, nil, str)
+	l.layoutText(params, nil, str, nil)
+}
+
+// LayoutSpans lays out a sequence of styled spans as a single body of text. The
+// Font and PxPerEm of params style the truncator, if any. Results can be retrieved
+// by iteratively calling NextGlyph, and the Span field of each glyph is the index
+// within spans of the span that produced it. Spans with a Placeholder reserve
+// space for inline objects, whose positions are reported by the glyphs with
+// FlagPlaceholder.
+func (l *Shaper) LayoutSpans(params Parameters, spans []Span) {
+	l.init()
+	l.spanText = l.spanText[:0]
+	l.spanStyles = l.spanStyles[:0]
+	for i, sp := range spans {
+		content := sp.Content
+		if sp.Placeholder != (Placeholder{}) {
+			content = objectReplacement
+		}
+		if len(content) == 0 {
+			continue
+		}
+		ppem := sp.PxPerEm
+		if ppem == 0 {
+			ppem = params.PxPerEm
+		}
+		l.spanText = append(l.spanText, content...)
+		l.spanStyles = append(l.spanStyles, spanStyle{
+			font:        sp.Font,
+			ppem:        ppem,
+			runes:       utf8.RuneCountInString(content),
+			index:       i,
+			placeholder: sp.Placeholder,
+		})
+	}
+	l.layoutText(params, nil, string(l.spanText), l.spanStyles)
 }
 
 func (l *Shaper) reset(align Alignment) {
-	l.line, l.run, l.glyph, l.advance = 0, 0, 0, 0
+	l.line, l.run, l.glyph, l.advance, l.gaps = 0, 0, 0, 0, 0
 	l.done = false
 	l.txt.reset()
 	l.txt.alignment = align
@@ -298,11 +479,15 @@ func (l *Shaper) reset(align Alignment) {
 
 // layoutText lays out a large text document by breaking it into paragraphs and laying
 // out each of them separately. This allows the shaping results to be cached independently
-// by paragraph. Only one of txt and str should be provided.
-func (l *Shaper) layoutText(params Parameters, txt io.Reader, str string) {
+// by paragraph. Only one of txt and str should be provided. If spans is non-empty, it
+// styles the runes of str.
+func (l *Shaper) layoutText(params Parameters, txt io.Reader, str string, spans []spanStyle) {
 	l.reset(params.Alignment)
+	if removed := l.shaper.trimInstances(); len(removed) > 0 {
+		l.invalidateFaces(removed)
+	}
 	if txt == nil && len(str) == 0 {
-		l.txt.append(l.layoutParagraph(params, "", nil))
+		l.txt.append(l.layoutParagraph(params, "", nil, nil))
 		return
 	}
 	l.reader.Reset(txt)
@@ -343,7 +528,11 @@ func (l *Shaper) layoutText(params Parameters, txt io.Reader, str string) {
 		}
 		if len(str[:endByte]) > 0 || (len(l.paragraph) > 0 || len(l.txt.lines) == 0) {
 			params.forceTruncate = truncating && !done
-			lines := l.layoutParagraph(params, str[:endByte], l.paragraph)
+			var paragraphSpans []spanStyle
+			if len(spans) > 0 {
+				paragraphSpans, spans = splitSpans(spans, utf8.RuneCountInString(str[:endByte]))
+			}
+			lines := l.layoutParagraph(params, str[:endByte], l.paragraph, paragraphSpans)
 			if truncating {
 				params.MaxLines -= len(lines.lines)
 				if params.MaxLines == 0 {
@@ -374,35 +563,130 @@ func (l *Shaper) layoutText(params Parameters, txt io.Reader, str string) {
 	}
 }
 
+// splitSpans returns spans covering the first n runes styled by spans, as well as
+// the spans styling the remaining runes.
+func splitSpans(spans []spanStyle, n int) (head, tail []spanStyle) {
+	for i, sp := range spans {
+		if n < sp.runes {
+			head = append(spans[:i:i], sp)
+			head[i].runes = n
+			if n == 0 {
+				head = head[:i]
+			}
+			rest := sp
+			rest.runes -= n
+			tail = append([]spanStyle{rest}, spans[i+1:]...)
+			return head, tail
+		}
+		n -= sp.runes
+	}
+	return spans, nil
+}
+
+// spanKey encodes the styles of spans for use in a layout cache key.
+func spanKey(spans []spanStyle) string {
+	if len(spans) == 0 {
+		return ""
+	}
+	var b []byte
+	for _, sp := range spans {
+		b = fmt.Appendf(b, "%d:%d:%d:%+v:%v;", sp.index, sp.runes, sp.ppem, sp.font, sp.placeholder)
+	}
+	return string(b)
+}
+
+// fontKey is the comparable form of a font, for cache keys and comparisons.
+type fontKey struct {
+	typeface giofont.Typeface
+	style    giofont.Style
+	weight   giofont.Weight
+	// variations encodes the variation axis values of the font.
+	variations string
+}
+
+func newFontKey(f giofont.Font) fontKey {
+	return fontKey{
+		typeface:   f.Typeface,
+		style:      f.Style,
+		weight:     f.Weight,
+		variations: variationsKey(f.Variations),
+	}
+}
+
+// variationsKey encodes variations for comparing them in a cache key.
+func variationsKey(variations []giofont.Variation) string {
+	if len(variations) == 0 {
+		return ""
+	}
+	var b []byte
+	for _, v := range variations {
+		b = fmt.Appendf(b, "%s=%g;", v.Tag, v.Value)
+	}
+	return string(b)
+}
+
+// featuresKey encodes features for comparing them in a cache key.
+func featuresKey(features []Feature) string {
+	if len(features) == 0 {
+		return ""
+	}
+	var b []byte
+	for _, f := range features {
+		b = fmt.Appendf(b, "%s=%d;", f.Tag, f.Value)
+	}
+	return string(b)
+}
+
+// tabStopsKey encodes stops for comparing them in a cache key.
+func tabStopsKey(stops []fixed.Int26_6) string {
+	if len(stops) == 0 {
+		return ""
+	}
+	var b []byte
+	for _, stop := range stops {
+		b = fmt.Appendf(b, "%d;", stop)
+	}
+	return string(b)
+}
+
 // layoutParagraph shapes and wraps a paragraph using the provided parameters.
 // It accepts the paragraph data in either string or rune format, preferring the
-// string in order to hit the shaper cache more quickly.
-func (l *Shaper) layoutParagraph(params Parameters, asStr string, asBytes []byte) document {
+// string in order to hit the shaper cache more quickly. If spans is non-empty, it
+// styles the runes of the paragraph.
+func (l *Shaper) layoutParagraph(params Parameters, asStr string, asBytes []byte, spans []spanStyle) document {
 	if l == nil {
 		return document{}
 	}
 	if len(asStr) == 0 && len(asBytes) > 0 {
 		asStr = string(asBytes)
 	}
-	// Alignment is not part of the cache key because changing it does not impact shaping.
+	// Alignment and MinWidth are not part of the cache key because changing
+	// them does not impact shaping.
 	lk := layoutKey{
 		ppem:            params.PxPerEm,
 		maxWidth:        params.MaxWidth,
-		minWidth:        params.MinWidth,
 		maxLines:        params.MaxLines,
 		truncator:       params.Truncator,
 		locale:          params.Locale,
-		font:            params.Font,
+		font:            newFontKey(params.Font),
 		forceTruncate:   params.forceTruncate,
 		wrapPolicy:      params.WrapPolicy,
 		str:             asStr,
 		lineHeight:      params.LineHeight,
 		lineHeightScale: params.LineHeightScale,
+		spans:           spanKey(spans),
+		features:        featuresKey(params.Features),
+		letterSpacing:   params.LetterSpacing,
+		wordSpacing:     params.WordSpacing,
+		tabWidth:        params.TabWidth,
+		tabStops:        tabStopsKey(params.TabStops),
 	}
-	if l, ok := l.layoutCache.Get(lk); ok {
-		return l
+	if doc, ok := l.layoutCache.Get(lk); ok {
+		l.shaper.useFaceIndices(doc.usage.faces)
+		doc.alignWidth = alignWidth(params.MinWidth, doc.lines)
+		return doc
 	}
-	lines := l.shaper.LayoutRunes(params, []rune(asStr))
+	lines := l.shaper.LayoutSpans(params, []rune(asStr), spans)
 	l.layoutCache.Put(lk, lines)
 	return lines
 }
@@ -432,25 +716,33 @@ func (l *Shaper) NextGlyph() (_ Glyph, ok bool) {
 			continue
 		}
 		run := line.runs[l.run]
-		align := l.txt.alignment.Align(line.direction, line.width, l.txt.alignWidth)
+		if l.run == 0 && l.glyph == 0 {
+			l.justified.layout(l.txt.alignment, line, l.txt.alignWidth)
+		}
+		align := l.txt.alignment.Align(line.direction, line.width+l.justified.total, l.txt.alignWidth)
 		if l.line == 0 && l.run == 0 && len(run.Glyphs) == 0 {
 			// The very first run is empty, which will only happen when the
 			// entire text is a shaped empty string. Return a single synthetic
 			// glyph to provide ascent/descent information to the caller.
 			l.done = true
-			return Glyph{
+			glyph := Glyph{
 				X:       align,
 				Y:       int32(line.yOffset),
 				Runes:   0,
 				Flags:   FlagLineBreak | FlagClusterBreak | FlagRunBreak,
 				Ascent:  line.ascent,
 				Descent: line.descent,
-			}, true
+			}
+			if line.direction.Axis() == system.Vertical {
+				glyph = l.txt.toVertical(glyph, line)
+			}
+			return glyph, true
 		}
 		if l.glyph == len(run.Glyphs) {
 			l.run++
 			l.glyph = 0
 			l.advance = 0
+			l.gaps = 0
 			continue
 		}
 		glyphIdx := l.glyph
@@ -460,23 +752,33 @@ func (l *Shaper) NextGlyph() (_ Glyph, ok bool) {
 			glyphIdx = len(run.Glyphs) - 1 - glyphIdx
 		}
 		g := run.Glyphs[glyphIdx]
+		advance := g.xAdvance
+		if l.justified.expands(g.expansion) {
+			// Justification opportunities are numbered in visual order.
+			gap := l.gaps
+			if rtl {
+				gap = l.justified.runGaps(run) - 1 - gap
+			}
+			advance += l.justified.expand(l.run, gap)
+			l.gaps++
+		}
 		if rtl {
 			// Modify the advance prior to computing runOffset to ensure that the
 			// current glyph's width is subtracted in RTL.
-			l.advance += g.xAdvance
+			l.advance += advance
 		}
 		// runOffset computes how far into the run the dot should be positioned.
 		runOffset := l.advance
 		if rtl {
-			runOffset = run.Advance - l.advance
+			runOffset = run.Advance + l.justified.runExpansion(l.run, run) - l.advance
 		}
 		glyph := Glyph{
 			ID:      g.id,
-			X:       align + run.X + runOffset,
+			X:       align + run.X + l.justified.shift(l.run) + runOffset,
 			Y:       int32(line.yOffset),
 			Ascent:  line.ascent,
 			Descent: line.descent,
-			Advance: g.xAdvance,
+			Advance: advance,
 			Runes:   uint16(g.runeCount),
 			Offset: fixed.Point26_6{
 				X: g.xOffset,
@@ -484,12 +786,22 @@ func (l *Shaper) NextGlyph() (_ Glyph, ok bool) {
 			},
 			Bounds: g.bounds,
 		}
+		if line.direction.Axis() == system.Vertical {
+			glyph = l.txt.toVertical(glyph, line)
+		}
 		if run.truncator {
 			glyph.Flags |= FlagTruncator
 		}
+		if run.hyphen {
+			glyph.Flags |= FlagHyphen
+		}
+		if run.placeholder {
+			glyph.Flags |= FlagPlaceholder
+		}
+		glyph.Span = run.span
 		l.glyph++
 		if !rtl {
-			l.advance += g.xAdvance
+			l.advance += advance
 		}
 
 		endOfRun := l.glyph == len(run.Glyphs)
@@ -540,16 +852,142 @@ func (l *Shaper) NextGlyph() (_ Glyph, ok bool) {
 				// taking text alignment into account.
 				l.pararagraphStart.X = l.txt.alignment.Align(line.direction, 0, l.txt.alignWidth)
 				l.pararagraphStart.Y = glyph.Y + int32((glyph.Ascent + glyph.Descent).Ceil())
+				if line.direction.Axis() == system.Vertical {
+					// The next line is to the left of this one.
+					l.pararagraphStart.Y = int32(l.pararagraphStart.X.Round())
+					l.pararagraphStart.X = glyph.X - fixed.I((glyph.Ascent + glyph.Descent).Ceil())
+					l.pararagraphStart.Flags |= FlagVertical
+				}
 			}
 		}
 		return glyph, true
 	}
 }
 
+// toVertical converts g, positioned along line as if line were horizontal, to
+// vertical text. Vertical lines are stacked from right to left, and their glyphs
+// advance downward from the center of the line. Glyphs are positioned at whole
+// pixels along the line, and their advances are rounded to match.
+func (d *document) toVertical(g Glyph, line line) Glyph {
+	last := d.lines[len(d.lines)-1]
+	right := last.yOffset + last.descent.Ceil()
+	start, end := g.X.Round(), (g.X + g.Advance).Round()
+	g.X = fixed.I(right - line.yOffset)
+	g.Y = int32(start)
+	g.Advance = fixed.I(end - start)
+	g.Flags |= FlagVertical
+	return g
+}
+
+// justification describes the expansion of a justified line.
+type justification struct {
+	// gap is the expansion of each justification opportunity, and rem is the
+	// number of opportunities, the first in visual order, expanded by one more
+	// unit to fill the line exactly.
+	gap fixed.Int26_6
+	rem int
+	// characters reports whether the gaps between characters are expanded in
+	// addition to word separators.
+	characters bool
+	// total is the sum of all expansions of the line.
+	total fixed.Int26_6
+	// shifts holds the offset of each run of the line due to the expansion of
+	// the runs visually preceding it, and firstGaps holds the index of the first
+	// justification opportunity of each run in visual order.
+	shifts    []fixed.Int26_6
+	firstGaps []int
+}
+
+// layout computes the justification of line within a space of width alignWidth.
+// It resets j to no expansion if the line is not justified.
+func (j *justification) layout(align Alignment, line line, alignWidth int) {
+	j.gap, j.rem, j.total = 0, 0, 0
+	j.characters = false
+	j.shifts = j.shifts[:0]
+	j.firstGaps = j.firstGaps[:0]
+	if (align != Justify && align != JustifyCharacters) || line.paragraphEnd {
+		return
+	}
+	j.characters = align == JustifyCharacters
+	var gaps int
+	for _, run := range line.runs {
+		gaps += j.runGaps(run)
+	}
+	extra := fixed.I(alignWidth) - (line.width - line.trailing)
+	if gaps == 0 || extra <= 0 {
+		return
+	}
+	// Distribute the space evenly across all gaps, and the remainder across
+	// the first gaps.
+	j.gap = extra / fixed.Int26_6(gaps)
+	j.rem = int(extra % fixed.Int26_6(gaps))
+	for range line.runs {
+		j.shifts = append(j.shifts, 0)
+		j.firstGaps = append(j.firstGaps, 0)
+	}
+	var shift fixed.Int26_6
+	var first int
+	for _, runIdx := range line.visualOrder {
+		run := line.runs[runIdx]
+		j.shifts[runIdx] = shift
+		j.firstGaps[runIdx] = first
+		shift += j.runExpansion(runIdx, run)
+		first += j.runGaps(run)
+	}
+	j.total = shift
+}
+
+// expands reports whether glyphs with expansion e are expanded.
+func (j *justification) expands(e expansion) bool {
+	return e == expandWord || e == expandCharacter && j.characters
+}
+
+// runGaps returns the number of justification opportunities of run.
+func (j *justification) runGaps(run runLayout) int {
+	if j.characters {
+		return run.wordGaps + run.charGaps
+	}
+	return run.wordGaps
+}
+
+// expand returns the expansion of the justification opportunity with index
+// gap, in visual order, of the run at index runIdx.
+func (j *justification) expand(runIdx, gap int) fixed.Int26_6 {
+	if runIdx >= len(j.firstGaps) {
+		return 0
+	}
+	if j.firstGaps[runIdx]+gap < j.rem {
+		return j.gap + 1
+	}
+	return j.gap
+}
+
+// runExpansion returns the total expansion of the glyphs of run, at index
+// runIdx.
+func (j *justification) runExpansion(runIdx int, run runLayout) fixed.Int26_6 {
+	if runIdx >= len(j.firstGaps) {
+		return 0
+	}
+	n := j.runGaps(run)
+	return fixed.Int26_6(n)*j.gap + fixed.Int26_6(min(max(j.rem-j.firstGaps[runIdx], 0), n))
+}
+
+// shift returns the offset of the run at index runIdx due to the expansion of the
+// runs visually preceding it.
+func (j *justification) shift(runIdx int) fixed.Int26_6 {
+	if runIdx >= len(j.shifts) {
+		return 0
+	}
+	return j.shifts[runIdx]
+}
+
 const (
 	facebits = 16
 	sizebits = 16
 	gidbits  = 64 - facebits - sizebits
+	// placeholderFace is the face index of the glyphs of inline objects,
+	// which refers to no face.
+	placeholderFace = 1<<facebits - 1
 )
 
 // newGlyphID encodes a face and a glyph id into a GlyphID.
@@ -579,9 +1017,10 @@ func splitGlyphID(g GlyphID) (fixed.Int26_6, int, font.GID) {
 
 // Shape converts the provided glyphs into a path. The path will enclose the forms
 // of all vector glyphs.
-// All glyphs are expected to be from a single line of text (their Y offsets are ignored).
+// All glyphs are expected to be from a single line of text.
 func (l *Shaper) Shape(gs []Glyph) clip.PathSpec {
 	l.init()
+	l.shaper.useFaces(gs)
 	key := l.pathCache.hashGlyphs(gs)
 	shape, ok := l.pathCache.Get(key, gs)
 	if ok {
@@ -593,19 +1032,42 @@ func (l *Shaper) Shape(gs []Glyph) clip.PathSpec {
 	return shape
 }
 
-// Bitmaps extracts bitmap glyphs from the provided slice and creates an op.CallOp to present
+// Bitmaps extracts bitmap and color glyphs from the provided slice and creates an op.CallOp to present
 // them. The returned op.CallOp will align correctly with the return value of Shape() for the
 // same gs slice.
-// All glyphs are expected to be from a single line of text (their Y offsets are ignored).
+// The layers of color glyphs painted with the color of the text are painted black;
+// use PaintBitmaps to paint them with the material of the text.
+// All glyphs are expected to be from a single line of text.
 func (l *Shaper) Bitmaps(gs []Glyph) op.CallOp {
+	b := l.bitmaps(gs)
+	if len(b.text) == 0 {
+		return b.calls[0]
+	}
+	ops := new(op.Ops)
+	m := op.Record(ops)
+	b.add(ops, op.CallOp{})
+	return m.Stop()
+}
+
+// PaintBitmaps paints the bitmap and color glyphs of gs like the op.CallOp
+// returned by Bitmaps, except that the layers of color glyphs painted with the
+// color of the text are painted with material, in their stacking order.
+// material is an op.CallOp that sets the paint material, as for the text itself.
+func (l *Shaper) PaintBitmaps(ops *op.Ops, gs []Glyph, material op.CallOp) {
+	l.bitmaps(gs).add(ops, material)
+}
+
+// bitmaps returns the cached bitmapPaint of gs.
+func (l *Shaper) bitmaps(gs []Glyph) bitmapPaint {
 	l.init()
+	l.shaper.useFaces(gs)
 	key := l.bitmapShapeCache.hashGlyphs(gs)
-	call, ok := l.bitmapShapeCache.Get(key, gs)
+	b, ok := l.bitmapShapeCache.Get(key, gs)
 	if ok {
-		return call
+		return b
 	}
 	callOps := new(op.Ops)
-	call = l.shaper.Bitmaps(callOps, gs)
-	l.bitmapShapeCache.Put(key, gs, call)
-	return call
+	b = l.shaper.Bitmaps(callOps, gs)
+	l.bitmapShapeCache.Put(key, gs, b)
+	return b
 }
@@ -2,6 +2,7 @@ package text
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -581,3 +582,592 @@ func TestShapeStringRuneAccounting(t *testing.T) {
 		})
 	}
 }
+
+// TestLayoutSpans ensures that styled spans are shaped with their own sizes,
+// wrapped together, and reported on the glyphs they produce.
+func TestLayoutSpans(t *testing.T) {
+	ltrFace, _ := opentype.Parse(goregular.TTF)
+	collection := []FontFace{{Face: ltrFace}}
+	cache := NewShaper(NoSystemFonts(), WithCollection(collection))
+	spans := []Span{
+		{Content: "Lorem ipsum "},
+		{Content: "dolor sit\namet, ", PxPerEm: fixed.I(20)},
+		{Content: ""},
+		{Content: "consectetur adipiscing elit"},
+	}
+	cache.LayoutSpans(Parameters{
+		PxPerEm:  fixed.I(10),
+		MaxWidth: 100,
+		Locale:   english,
+	}, spans)
+	runes := 0
+	lines := 0
+	lastSpan := 0
+	for g, ok := cache.NextGlyph(); ok; g, ok = cache.NextGlyph() {
+		if g.Span < lastSpan {
+			t.Errorf("glyph %d: span %d is before span %d", runes, g.Span, lastSpan)
+		}
+		lastSpan = g.Span
+		if g.Span == 2 {
+			t.Errorf("glyph %d: empty span should produce no glyphs", runes)
+		}
+		if g.Flags&FlagParagraphBreak == 0 {
+			want := fixed.I(10)
+			if g.Span == 1 {
+				want = fixed.I(20)
+			}
+			if ppem, _, _ := splitGlyphID(g.ID); ppem != want {
+				t.Errorf("glyph %d of span %d: expected ppem %v, got %v", runes, g.Span, want, ppem)
+			}
+		}
+		runes += int(g.Runes)
+		if g.Flags&FlagLineBreak != 0 {
+			lines++
+		}
+	}
+	var expected int
+	for _, sp := range spans {
+		expected += len([]rune(sp.Content))
+	}
+	if runes != expected {
+		t.Errorf("expected %d runes, got %d", expected, runes)
+	}
+	if lastSpan != 3 {
+		t.Errorf("expected final glyph from span 3, got %d", lastSpan)
+	}
+	if lines < 4 {
+		t.Errorf("expected spans to wrap across at least 4 lines, got %d", lines)
+	}
+
+	// Laying out the same content with different span styles must not hit the cache.
+	cache.LayoutSpans(Parameters{PxPerEm: fixed.I(10), MaxWidth: 100, Locale: english}, []Span{{Content: "Lorem"}})
+	small, _ := cache.NextGlyph()
+	cache.LayoutSpans(Parameters{PxPerEm: fixed.I(10), MaxWidth: 100, Locale: english}, []Span{{Content: "Lorem", PxPerEm: fixed.I(20)}})
+	large, _ := cache.NextGlyph()
+	if small.Advance >= large.Advance {
+		t.Errorf("expected larger span to have larger advance, got %v and %v", small.Advance, large.Advance)
+	}
+}
+
+// TestLayoutPlaceholders checks that placeholder spans reserve the space of
+// their objects and report their positions.
+func TestLayoutPlaceholders(t *testing.T) {
+	ltrFace, _ := opentype.Parse(goregular.TTF)
+	shaper := NewShaper(NoSystemFonts(), WithCollection([]FontFace{{Face: ltrFace}}))
+	obj := Placeholder{Width: fixed.I(30), Ascent: fixed.I(40), Descent: fixed.I(5)}
+	layout := func(maxWidth int) []Glyph {
+		shaper.LayoutSpans(Parameters{PxPerEm: fixed.I(10), MaxWidth: maxWidth, Locale: english}, []Span{
+			{Content: "ab "},
+			{Content: "ignored", Placeholder: obj},
+			{Content: " cd"},
+		})
+		var gs []Glyph
+		for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+			gs = append(gs, g)
+		}
+		return gs
+	}
+	gs := layout(1000)
+	if len(gs) != 7 {
+		t.Fatalf("expected 7 glyphs, got %d", len(gs))
+	}
+	runes := 0
+	for _, g := range gs {
+		runes += int(g.Runes)
+	}
+	if runes != 7 {
+		t.Errorf("expected the placeholder to count as a single rune, got %d runes", runes)
+	}
+	p := gs[3]
+	if p.Flags&FlagPlaceholder == 0 || p.Span != 1 || p.Runes != 1 {
+		t.Fatalf("expected a placeholder glyph of span 1, got %+v", p)
+	}
+	if p.Advance != obj.Width {
+		t.Errorf("expected advance %v, got %v", obj.Width, p.Advance)
+	}
+	want := fixed.Rectangle26_6{Min: fixed.Point26_6{Y: -obj.Ascent}, Max: fixed.Point26_6{X: obj.Width, Y: obj.Descent}}
+	if p.Bounds != want {
+		t.Errorf("expected bounds %v, got %v", want, p.Bounds)
+	}
+	if next := gs[4]; next.X != p.X+p.Advance {
+		t.Errorf("expected the following glyph at %v, got %v", p.X+p.Advance, next.X)
+	}
+	for i, g := range gs {
+		if i != 3 && g.Flags&FlagPlaceholder != 0 {
+			t.Errorf("glyph %d: unexpected placeholder flag", i)
+		}
+		if g.Ascent < obj.Ascent || g.Descent < obj.Descent {
+			t.Errorf("glyph %d: expected the line to contain the object, got ascent %v descent %v", i, g.Ascent, g.Descent)
+		}
+	}
+	if _, _, ok := shaper.GlyphFace(p.ID); ok {
+		t.Error("expected the placeholder to refer to no face, so that it is not painted")
+	}
+
+	// Objects wrap like words.
+	gs = layout(40)
+	if p := gs[3]; p.Flags&FlagPlaceholder == 0 || p.X != 0 || p.Y <= gs[0].Y {
+		t.Errorf("expected the placeholder to start the second line, got %+v", p)
+	}
+}
+
+// TestCacheFeatures ensures that shaping with different OpenType features
+// does not reuse cached layouts.
+func TestCacheFeatures(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	params := Parameters{PxPerEm: fixed.I(10), MaxWidth: 200, Locale: english}
+	layout := func(features ...Feature) int {
+		params.Features = features
+		cache.LayoutString(params, "office 1/2")
+		for _, ok := cache.NextGlyph(); ok; _, ok = cache.NextGlyph() {
+		}
+		return len(cache.layoutCache.m)
+	}
+	if n := layout(); n != 1 {
+		t.Fatalf("expected 1 cached layout, got %d", n)
+	}
+	if n := layout(Feature{Tag: "liga", Value: 0}); n != 2 {
+		t.Errorf("expected disabling ligatures to miss the cache, got %d cached layouts", n)
+	}
+	if n := layout(Feature{Tag: "liga", Value: 0}); n != 2 {
+		t.Errorf("expected identical features to hit the cache, got %d cached layouts", n)
+	}
+	if n := layout(Feature{Tag: "liga", Value: 0}, Feature{Tag: "tnum", Value: 1}); n != 3 {
+		t.Errorf("expected additional features to miss the cache, got %d cached layouts", n)
+	}
+}
+
+// TestCacheMinWidth ensures that layouts differing only by their minimum width
+// share the cached shaping, and are aligned within their own minimum width.
+func TestCacheMinWidth(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	params := Parameters{PxPerEm: fixed.I(10), MaxWidth: 200, Locale: english, Alignment: End}
+	layout := func(minWidth int) (Glyph, int) {
+		params.MinWidth = minWidth
+		cache.LayoutString(params, "text")
+		g, _ := cache.NextGlyph()
+		for _, ok := cache.NextGlyph(); ok; _, ok = cache.NextGlyph() {
+		}
+		return g, len(cache.layoutCache.m)
+	}
+	narrow, _ := layout(50)
+	wide, n := layout(100)
+	if n != 1 {
+		t.Errorf("expected changing the minimum width to hit the cache, got %d cached layouts", n)
+	}
+	if got, want := wide.X-narrow.X, fixed.I(50); got != want {
+		t.Errorf("expected the text aligned to the end of the minimum width, got X %v then %v, want a difference of %v", narrow.X, wide.X, want)
+	}
+}
+
+func TestToFontFeatures(t *testing.T) {
+	features := toFontFeatures([]Feature{{Tag: "tnum", Value: 1}, {Tag: "bad", Value: 1}, {Tag: "liga"}}, nil)
+	if len(features) != 2 {
+		t.Fatalf("expected malformed tag to be skipped, got %v", features)
+	}
+	if got := features[0].Tag.String(); got != "tnum" || features[0].Value != 1 {
+		t.Errorf("expected tnum=1, got %s=%d", got, features[0].Value)
+	}
+	if got := features[1].Tag.String(); got != "liga" || features[1].Value != 0 {
+		t.Errorf("expected liga=0, got %s=%d", got, features[1].Value)
+	}
+}
+
+// TestLetterAndWordSpacing ensures that spacing widens the text and is accounted
+// for when wrapping lines.
+func TestLetterAndWordSpacing(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	const txt = "hello world"
+	measure := func(params Parameters) (width fixed.Int26_6, lines int) {
+		cache.LayoutString(params, txt)
+		for g, ok := cache.NextGlyph(); ok; g, ok = cache.NextGlyph() {
+			width += g.Advance
+			if g.Flags&FlagLineBreak != 0 {
+				lines++
+			}
+		}
+		return width, lines
+	}
+	params := Parameters{PxPerEm: fixed.I(10), MaxWidth: 1000, Locale: english}
+	plain, _ := measure(params)
+	params.LetterSpacing = fixed.I(2)
+	lettered, _ := measure(params)
+	if expected := plain + fixed.I(2*len(txt)); lettered != expected {
+		t.Errorf("expected letter spaced width %v, got %v", expected, lettered)
+	}
+	params.WordSpacing = fixed.I(5)
+	worded, _ := measure(params)
+	if expected := lettered + fixed.I(5); worded != expected {
+		t.Errorf("expected word spaced width %v, got %v", expected, worded)
+	}
+
+	params = Parameters{PxPerEm: fixed.I(10), MaxWidth: plain.Ceil(), Locale: english}
+	if _, lines := measure(params); lines != 1 {
+		t.Errorf("expected unspaced text to fit on 1 line, got %d", lines)
+	}
+	params.LetterSpacing = fixed.I(2)
+	if _, lines := measure(params); lines != 2 {
+		t.Errorf("expected letter spaced text to wrap onto 2 lines, got %d", lines)
+	}
+}
+
+// TestTabStops ensures that text following a tab is positioned at the next tab
+// stop, measured from the start of each line.
+func TestTabStops(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	positions := func(params Parameters, txt string) map[int]fixed.Int26_6 {
+		cache.LayoutString(params, txt)
+		// Record the position of the glyph following each tab.
+		pos := make(map[int]fixed.Int26_6)
+		runes := 0
+		for g, ok := cache.NextGlyph(); ok; g, ok = cache.NextGlyph() {
+			if r := []rune(txt)[runes]; runes > 0 && []rune(txt)[runes-1] == '\t' && r != '\t' {
+				pos[runes] = g.X
+			}
+			runes += int(g.Runes)
+		}
+		return pos
+	}
+	params := Parameters{
+		PxPerEm:  fixed.I(10),
+		MaxWidth: 1000,
+		Locale:   english,
+		TabStops: []fixed.Int26_6{fixed.I(30), fixed.I(100)},
+		TabWidth: 4,
+	}
+	got := positions(params, "a\tb\tc\td\te")
+	space := cache.shaper.faces[0]
+	gid, _ := space.NominalGlyph(' ')
+	interval := 4 * floatToFixed(space.HorizontalAdvance(gid)*10/float32(space.Upem()))
+	expected := map[int]fixed.Int26_6{
+		2: fixed.I(30),
+		4: fixed.I(100),
+		6: fixed.I(100) + interval,
+		8: fixed.I(100) + 2*interval,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected text after tabs at %v, got %v", expected, got)
+	}
+
+	// Stops are measured from the start of each wrapped line.
+	params.MaxWidth = 35
+	params.TabStops = []fixed.Int26_6{fixed.I(20)}
+	got = positions(params, "abcd efgh\tx")
+	if x := got[10]; x != fixed.I(20) {
+		t.Errorf("expected text after tab on wrapped line at %v, got %v", fixed.I(20), x)
+	}
+}
+
+// TestTabStopsWrapping ensures that lines are wrapped with their tabs positioned
+// relative to the start of the line.
+func TestTabStopsWrapping(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	const txt = "ab\tcd ef\tgh ij\tkl mn\top"
+	space := cache.shaper.faces[0]
+	gid, _ := space.NominalGlyph(' ')
+	interval := 2 * floatToFixed(space.HorizontalAdvance(gid)*10/float32(space.Upem()))
+	for maxWidth := 25; maxWidth <= 80; maxWidth++ {
+		params := Parameters{
+			PxPerEm:  fixed.I(10),
+			MaxWidth: maxWidth,
+			Locale:   english,
+			TabWidth: 2,
+		}
+		cache.LayoutString(params, txt)
+		runes, lines := 0, 1
+		for g, ok := cache.NextGlyph(); ok; g, ok = cache.NextGlyph() {
+			r := []rune(txt)[runes]
+			if r != ' ' && r != '\t' && g.X+g.Advance > fixed.I(maxWidth) {
+				t.Errorf("width %d: rune %d on line %d ends at %v, beyond the maximum width", maxWidth, runes, lines, g.X+g.Advance)
+			}
+			if runes > 0 && []rune(txt)[runes-1] == '\t' && g.X > 0 && g.X%interval != 0 {
+				t.Errorf("width %d: text after tab on line %d at %v, not at a tab stop", maxWidth, lines, g.X)
+			}
+			if g.Flags&FlagLineBreak != 0 {
+				lines++
+			}
+			runes += int(g.Runes)
+		}
+	}
+}
+
+// TestCacheTabStops ensures that shaping with different tab stops does not
+// reuse cached layouts.
+func TestCacheTabStops(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	params := Parameters{PxPerEm: fixed.I(10), MaxWidth: 200, Locale: english}
+	layout := func(stops ...fixed.Int26_6) int {
+		params.TabStops = stops
+		cache.LayoutString(params, "a\tb")
+		for _, ok := cache.NextGlyph(); ok; _, ok = cache.NextGlyph() {
+		}
+		return len(cache.layoutCache.m)
+	}
+	if n := layout(fixed.I(30)); n != 1 {
+		t.Fatalf("expected 1 cached layout, got %d", n)
+	}
+	if n := layout(fixed.I(30)); n != 1 {
+		t.Errorf("expected identical stops to hit the cache, got %d cached layouts", n)
+	}
+	if n := layout(fixed.I(30), fixed.I(60)); n != 2 {
+		t.Errorf("expected additional stops to miss the cache, got %d cached layouts", n)
+	}
+	if n := layout(fixed.I(40)); n != 3 {
+		t.Errorf("expected moved stops to miss the cache, got %d cached layouts", n)
+	}
+}
+
+func TestNextTabStop(t *testing.T) {
+	stops := []fixed.Int26_6{fixed.I(10), fixed.I(25)}
+	for _, tc := range []struct {
+		x, expected fixed.Int26_6
+	}{
+		{x: 0, expected: fixed.I(10)},
+		{x: fixed.I(10), expected: fixed.I(25)},
+		{x: fixed.I(24), expected: fixed.I(25)},
+		{x: fixed.I(25), expected: fixed.I(33)},
+		{x: fixed.I(40), expected: fixed.I(41)},
+	} {
+		if got := nextTabStop(tc.x, stops, fixed.I(8)); got != tc.expected {
+			t.Errorf("next stop after %v: expected %v, got %v", tc.x, tc.expected, got)
+		}
+	}
+	if got := nextTabStop(fixed.I(30), stops, 0); got != fixed.I(30) {
+		t.Errorf("expected no stop beyond explicit stops without an interval, got %v", got)
+	}
+}
+
+// TestJustify ensures that justified lines other than the last line of each
+// paragraph are expanded to fill the available width.
+func TestJustify(t *testing.T) {
+	arabicFace, _ := opentype.Parse(nsareg.TTF)
+	collection := append(gofont.Collection(), font.FontFace{Face: arabicFace})
+	cache := NewShaper(NoSystemFonts(), WithCollection(collection))
+	type lineExtent struct {
+		// start and end are the visual bounds of the line, excluding spaces at
+		// either end.
+		start, end fixed.Int26_6
+		// contiguous reports whether each glyph ends where the next begins.
+		contiguous bool
+		// paragraphEnd reports whether the line ends its paragraph.
+		paragraphEnd bool
+	}
+	isSpace := func(g Glyph) bool {
+		_, faceIdx, gid := splitGlyphID(g.ID)
+		space, _ := cache.shaper.faces[faceIdx].NominalGlyph(' ')
+		return gid == space
+	}
+	layout := func(params Parameters, txt string) []lineExtent {
+		cache.LayoutString(params, txt)
+		var (
+			lines []lineExtent
+			line  []Glyph
+		)
+		flush := func() {
+			// RTL lines iterate from right to left.
+			slices.SortFunc(line, func(a, b Glyph) int { return int(a.X - b.X) })
+			ext := lineExtent{contiguous: true}
+			first, last := 0, len(line)-1
+			for first < last && isSpace(line[first]) {
+				first++
+			}
+			for last > first && isSpace(line[last]) {
+				last--
+			}
+			ext.start = line[first].X
+			ext.end = line[last].X + line[last].Advance
+			for i := 1; i < len(line); i++ {
+				if line[i-1].X+line[i-1].Advance != line[i].X {
+					ext.contiguous = false
+				}
+			}
+			lines = append(lines, ext)
+			line = line[:0]
+		}
+		for g, ok := cache.NextGlyph(); ok; g, ok = cache.NextGlyph() {
+			line = append(line, g)
+			if g.Flags&FlagLineBreak != 0 {
+				flush()
+				lines[len(lines)-1].paragraphEnd = g.Flags&FlagParagraphBreak != 0
+			}
+		}
+		if len(line) > 0 {
+			flush()
+		}
+		lines[len(lines)-1].paragraphEnd = true
+		return lines
+	}
+	const maxWidth = 100
+	for _, tc := range []struct {
+		name   string
+		locale system.Locale
+		align  Alignment
+		txt    string
+	}{
+		{name: "ltr", locale: english, align: Justify, txt: "the quick brown fox jumps over the lazy dog\nthe quick brown fox"},
+		{name: "rtl", locale: arabic, align: Justify, txt: "الحب سماء لا تمطر غير الأحلام الحب سماء لا تمطر"},
+		{name: "cjk", locale: english, align: JustifyCharacters, txt: "日本語の文章は単語の間に空白を入れずに書かれる。"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := Parameters{PxPerEm: fixed.I(10), MinWidth: maxWidth, MaxWidth: maxWidth, Locale: tc.locale, Alignment: tc.align}
+			lines := layout(params, tc.txt)
+			params.Alignment = Start
+			unjustified := layout(params, tc.txt)
+			if len(lines) != len(unjustified) || len(lines) < 2 {
+				t.Fatalf("expected the same number of lines (at least 2), got %d and %d", len(lines), len(unjustified))
+			}
+			for i, l := range lines {
+				if !l.contiguous {
+					t.Errorf("line %d: glyphs are not contiguous", i)
+				}
+			}
+			for i, l := range lines {
+				if l.paragraphEnd {
+					if l != unjustified[i] {
+						t.Errorf("line %d: expected paragraph end to be unjustified, got %+v, expected %+v", i, l, unjustified[i])
+					}
+					continue
+				}
+				// The expansion is distributed to the last unit, so that the last
+				// glyph reaches the width exactly.
+				if l.start != 0 || l.end != fixed.I(maxWidth) {
+					t.Errorf("line %d: expected justified extent [0,%d], got [%v,%v]", i, maxWidth, l.start, l.end)
+				}
+			}
+		})
+	}
+}
+
+// TestHyphenation ensures that WrapHyphenated breaks words at hyphenation points,
+// inserting hyphens that represent no runes.
+func TestHyphenation(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	const txt = "Typographers appreciate hyphenation in narrow columns of considerable text."
+	type result struct {
+		lines, hyphens, runes int
+		maxWidth              fixed.Int26_6
+		hyphenRunes           []int
+	}
+	layout := func(params Parameters) result {
+		cache.LayoutString(params, txt)
+		var (
+			res   result
+			lineX []fixed.Int26_6
+		)
+		for g, ok := cache.NextGlyph(); ok; g, ok = cache.NextGlyph() {
+			lineX = append(lineX, g.X+g.Advance)
+			if g.Flags&FlagHyphen != 0 {
+				res.hyphens++
+				res.hyphenRunes = append(res.hyphenRunes, res.runes)
+				if g.Runes != 0 {
+					t.Errorf("expected hyphen to represent no runes, got %d", g.Runes)
+				}
+				if g.Flags&FlagLineBreak == 0 {
+					t.Errorf("expected hyphen at the end of a line")
+				}
+			}
+			res.runes += int(g.Runes)
+			if g.Flags&FlagLineBreak != 0 {
+				res.lines++
+				for _, x := range lineX {
+					if x > res.maxWidth {
+						res.maxWidth = x
+					}
+				}
+				lineX = lineX[:0]
+			}
+		}
+		return res
+	}
+	params := Parameters{PxPerEm: fixed.I(10), MaxWidth: 80, Locale: english}
+	plain := layout(params)
+	params.WrapPolicy = WrapHyphenated
+	hyphenated := layout(params)
+	if hyphenated.hyphens == 0 {
+		t.Fatalf("expected hyphenated lines")
+	}
+	if plain.hyphens != 0 {
+		t.Errorf("expected no hyphens with WrapHeuristically, got %d", plain.hyphens)
+	}
+	if hyphenated.runes != len([]rune(txt)) {
+		t.Errorf("expected glyphs to represent %d runes, got %d", len([]rune(txt)), hyphenated.runes)
+	}
+	if hyphenated.lines > plain.lines {
+		t.Errorf("expected hyphenation not to add lines, got %d, expected at most %d", hyphenated.lines, plain.lines)
+	}
+	if hyphenated.maxWidth > fixed.I(params.MaxWidth) {
+		t.Errorf("expected lines to fit within %d, got %v", params.MaxWidth, hyphenated.maxWidth)
+	}
+	runes := []rune(txt)
+	for _, i := range hyphenated.hyphenRunes {
+		if !isWordRune(runes[i-1]) || !isWordRune(runes[i]) {
+			t.Errorf("expected hyphen within a word, got %q|%q", string(runes[:i]), string(runes[i:]))
+		}
+	}
+
+	// Languages without patterns are not hyphenated.
+	params.Locale = system.Locale{Language: "zz"}
+	if res := layout(params); res.hyphens != 0 {
+		t.Errorf("expected no hyphens without patterns, got %d", res.hyphens)
+	}
+
+	// Hyphenation respects truncation.
+	params.Locale = english
+	params.MaxLines = 2
+	if res := layout(params); res.lines != 2 || res.maxWidth > fixed.I(params.MaxWidth) {
+		t.Errorf("expected 2 lines within %d, got %d lines of width %v", params.MaxWidth, res.lines, res.maxWidth)
+	}
+}
+
+func TestVerticalLayout(t *testing.T) {
+	cache := NewShaper(NoSystemFonts(), WithCollection(gofont.Collection()))
+	const txt = "vertical text wraps into columns\nfrom right to left"
+	params := Parameters{
+		PxPerEm:  fixed.I(10),
+		MaxWidth: 60,
+		Locale:   system.Locale{Language: "ja", Direction: system.TTB},
+	}
+	cache.LayoutString(params, txt)
+	var (
+		runes, lines int
+		lineX        = fixed.I(-1)
+		lineEnd      int32
+		prevX        fixed.Int26_6
+	)
+	for g, ok := cache.NextGlyph(); ok; g, ok = cache.NextGlyph() {
+		runes += int(g.Runes)
+		if g.Flags&FlagVertical == 0 {
+			t.Fatalf("expected vertical glyph, got flags %v", g.Flags)
+		}
+		if lineX < 0 {
+			lineX, lineEnd = g.X, g.Y
+			if lines > 0 && lineX >= prevX {
+				t.Errorf("line %d: expected line left of the previous line at x=%v, got x=%v", lines, prevX, lineX)
+			}
+			if lineX-g.Descent < 0 {
+				t.Errorf("line %d: expected line within the text, got x=%v with descent %v", lines, lineX, g.Descent)
+			}
+		}
+		if g.X != lineX {
+			t.Errorf("line %d: expected glyphs at x=%v, got x=%v", lines, lineX, g.X)
+		}
+		if g.Y < lineEnd-1 {
+			t.Errorf("line %d: expected glyph below y=%d, got y=%d", lines, lineEnd, g.Y)
+		}
+		lineEnd = g.Y + int32(g.Advance.Round())
+		// Trailing whitespace may extend past the end of the line.
+		if !g.Bounds.Empty() && lineEnd > int32(params.MaxWidth)+1 {
+			t.Errorf("line %d: expected glyph within %d, got y=%d", lines, params.MaxWidth, lineEnd)
+		}
+		if g.Flags&FlagLineBreak != 0 {
+			lines++
+			prevX, lineX = lineX, fixed.I(-1)
+		}
+	}
+	if runes != len([]rune(txt)) {
+		t.Errorf("expected glyphs to represent %d runes, got %d", len([]rune(txt)), runes)
+	}
+	if lines < 4 {
+		t.Errorf("expected text to wrap into several lines, got %d", lines)
+	}
+}
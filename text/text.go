@@ -15,6 +15,14 @@ const (
 	Start Alignment = iota
 	End
 	Middle
+	// Justify aligns text to both edges by expanding the gaps between words. The
+	// final line of each paragraph, and lines without gaps to expand, are aligned
+	// to the start.
+	Justify
+	// JustifyCharacters is like Justify, but additionally expands the gaps between
+	// the characters of scripts written without spaces between words, such as
+	// Chinese and Japanese.
+	JustifyCharacters
 )
 
 func (a Alignment) String() string {
@@ -25,6 +33,10 @@ func (a Alignment) String() string {
 		return "End"
 	case Middle:
 		return "Middle"
+	case Justify:
+		return "Justify"
+	case JustifyCharacters:
+		return "JustifyCharacters"
 	default:
 		panic("invalid Alignment")
 	}
@@ -32,9 +44,13 @@ func (a Alignment) String() string {
 
 // Align returns the x offset that should be applied to text with width so that it
 // appears correctly aligned within a space of size maxWidth and with the primary
-// text direction dir.
+// text direction dir. Justified alignments align text to the start; the expansion of
+// justified lines is applied by the [Shaper].
 func (a Alignment) Align(dir system.TextDirection, width fixed.Int26_6, maxWidth int) fixed.Int26_6 {
 	mw := fixed.I(maxWidth)
+	if a == Justify || a == JustifyCharacters {
+		a = Start
+	}
 	if dir.Progression() == system.TowardOrigin {
 		switch a {
 		case Start:
@@ -4,25 +4,47 @@ package widget
 
 import (
 	"io"
+	"strings"
 	"unicode/utf8"
 
 	"golang.org/x/text/runes"
 )
 
-// editBuffer implements a gap buffer for text editing.
+// editBuffer implements a piece table for text editing. The text is a sequence
+// of pieces, each referring to a portion of either the original content or an
+// append-only buffer of inserted content. The pieces are stored in a balanced
+// tree ordered by their position within the text, so that reading and editing
+// the text cost time logarithmic in the number of pieces rather than linear in
+// the size of the text.
 type editBuffer struct {
-	// The gap start and end in bytes.
-	gapstart, gapend int
-	text             []byte
+	// original is the content the buffer was last reset to.
+	original string
+	// added holds the content inserted since then.
+	added []byte
+	root  *piece
+	// seed is the state of the generator of piece priorities.
+	seed uint32
 
 	// changed tracks whether the buffer content
 	// has changed since the last call to Changed.
 	changed bool
 }
 
-var _ textSource = (*editBuffer)(nil)
+// piece is a node of the treap of pieces. Nodes are ordered by their position
+// in the text, and every node has a higher priority than its children.
+type piece struct {
+	// added selects the added content as the source of the piece, rather than
+	// the original content.
+	added bool
+	// start and length locate the content of the piece in its source, in bytes.
+	start, length int
+	// size is the total length in bytes of the pieces within the subtree.
+	size        int
+	priority    uint32
+	left, right *piece
+}
 
-const minSpace = 5
+var _ textSource = (*editBuffer)(nil)
 
 func (e *editBuffer) Changed() bool {
 	c := e.changed
@@ -30,99 +52,232 @@ func (e *editBuffer) Changed() bool {
 	return c
 }
 
-func (e *editBuffer) deleteRunes(caret, count int) (bytes int, runes int) {
-	e.moveGap(caret, 0)
-	for ; count < 0 && e.gapstart > 0; count++ {
-		_, s := utf8.DecodeLastRune(e.text[:e.gapstart])
-		e.gapstart -= s
-		bytes += s
-		runes++
-		e.changed = e.changed || s > 0
+func (e *editBuffer) Size() int64 {
+	return int64(e.root.sizeOf())
+}
+
+func (e *editBuffer) ReadAt(p []byte, offset int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
 	}
-	for ; count > 0 && e.gapend < len(e.text); count-- {
-		_, s := utf8.DecodeRune(e.text[e.gapend:])
-		e.gapend += s
-		e.changed = e.changed || s > 0
+	if offset >= e.Size() {
+		return 0, io.EOF
 	}
-	return
+	return e.read(e.root, p, int(offset)), nil
 }
 
-// moveGap moves the gap to the caret position. After returning,
-// the gap is guaranteed to be at least space bytes long.
-func (e *editBuffer) moveGap(caret, space int) {
-	if e.gapLen() < space {
-		if space < minSpace {
-			space = minSpace
+// read copies the content of the subtree n starting at byte offset off into p,
+// and returns the number of bytes copied.
+func (e *editBuffer) read(n *piece, p []byte, off int) int {
+	total := 0
+	for n != nil && len(p) > 0 {
+		leftSize := n.left.sizeOf()
+		if off < leftSize {
+			c := e.read(n.left, p, off)
+			total += c
+			p = p[c:]
+			off = leftSize
+		}
+		if off < leftSize+n.length && len(p) > 0 {
+			start := n.start + off - leftSize
+			end := n.start + n.length
+			var c int
+			if n.added {
+				c = copy(p, e.added[start:end])
+			} else {
+				c = copy(p, e.original[start:end])
+			}
+			total += c
+			p = p[c:]
+			off = leftSize + n.length
 		}
-		txt := make([]byte, int(e.Size())+space)
-		// Expand to capacity.
-		txt = txt[:cap(txt)]
-		gaplen := len(txt) - int(e.Size())
-		if caret > e.gapstart {
-			copy(txt, e.text[:e.gapstart])
-			copy(txt[caret+gaplen:], e.text[caret:])
-			copy(txt[e.gapstart:], e.text[e.gapend:caret+e.gapLen()])
+		off -= leftSize + n.length
+		n = n.right
+	}
+	return total
+}
+
+// String returns the content of the buffer. The content is copied only if the
+// buffer was modified since String was last called: String compacts the pieces
+// into a single piece of the returned content.
+func (e *editBuffer) String() string {
+	if e.root == nil {
+		return ""
+	}
+	if n := e.root; !n.added && n.left == nil && n.right == nil {
+		return e.original[n.start : n.start+n.length]
+	}
+	var b strings.Builder
+	b.Grow(int(e.Size()))
+	e.walk(e.root, func(n *piece) {
+		if n.added {
+			b.Write(e.added[n.start : n.start+n.length])
 		} else {
-			copy(txt, e.text[:caret])
-			copy(txt[e.gapstart+gaplen:], e.text[e.gapend:])
-			copy(txt[caret+gaplen:], e.text[caret:e.gapstart])
+			b.WriteString(e.original[n.start : n.start+n.length])
+		}
+	})
+	e.original = b.String()
+	e.added = e.added[:0]
+	e.root = e.newPiece(false, 0, len(e.original))
+	return e.original
+}
+
+// walk calls f for the pieces of the subtree n in order.
+func (e *editBuffer) walk(n *piece, f func(n *piece)) {
+	for n != nil {
+		e.walk(n.left, f)
+		f(n)
+		n = n.right
+	}
+}
+
+func (e *editBuffer) ReplaceRunes(byteOffset, runeCount int64, s string) {
+	if !utf8.ValidString(s) {
+		s = runes.ReplaceIllFormed().String(s)
+	}
+	off := int(byteOffset)
+	size := int(e.Size())
+	deleted := e.runeBytes(off, int(runeCount))
+	if off == 0 && deleted == size {
+		// Replacing the entire content, such as when setting the text of an
+		// editor, discards the pieces. Keep the new content without copying.
+		e.original = s
+		e.added = e.added[:0]
+		e.root = nil
+		if len(s) > 0 {
+			e.root = e.newPiece(false, 0, len(s))
 		}
-		e.text = txt
-		e.gapstart = caret
-		e.gapend = e.gapstart + gaplen
-	} else {
-		if caret > e.gapstart {
-			copy(e.text[e.gapstart:], e.text[e.gapend:caret+e.gapLen()])
+		e.changed = e.changed || size > 0 || len(s) > 0
+		return
+	}
+	left, rest := e.split(e.root, off)
+	_, right := e.split(rest, deleted)
+	if len(s) > 0 {
+		if last := left.last(); last != nil && last.added && last.start+last.length == len(e.added) {
+			// Extend the piece of the previous insertion, which is the common
+			// case of typing.
+			left.extendLast(len(s))
 		} else {
-			copy(e.text[caret+e.gapLen():], e.text[caret:e.gapstart])
+			left = e.merge(left, e.newPiece(true, len(e.added), len(s)))
 		}
-		l := e.gapLen()
-		e.gapstart = caret
-		e.gapend = e.gapstart + l
+		e.added = append(e.added, s...)
 	}
+	e.root = e.merge(left, right)
+	e.changed = e.changed || deleted > 0 || len(s) > 0
 }
 
-func (e *editBuffer) Size() int64 {
-	return int64(len(e.text) - e.gapLen())
+// runeBytes returns the length in bytes of the count runes starting at byte
+// offset off, clamped to the end of the content.
+func (e *editBuffer) runeBytes(off, count int) int {
+	var buf [512]byte
+	total := 0
+	for count > 0 {
+		n := e.read(e.root, buf[:], off+total)
+		if n == 0 {
+			break
+		}
+		b := buf[:n]
+		for count > 0 && len(b) > 0 {
+			if !utf8.FullRune(b) && n == len(buf) {
+				// Read the truncated rune again with the following chunk.
+				break
+			}
+			_, s := utf8.DecodeRune(b)
+			b = b[s:]
+			total += s
+			count--
+		}
+	}
+	return total
 }
 
-func (e *editBuffer) gapLen() int {
-	return e.gapend - e.gapstart
+// newPiece returns a piece of the length bytes at start in the added content
+// if added is set, or in the original content otherwise.
+func (e *editBuffer) newPiece(added bool, start, length int) *piece {
+	// Generate priorities with a xorshift generator.
+	if e.seed == 0 {
+		e.seed = 2463534242
+	}
+	e.seed ^= e.seed << 13
+	e.seed ^= e.seed >> 17
+	e.seed ^= e.seed << 5
+	return &piece{added: added, start: start, length: length, size: length, priority: e.seed}
 }
 
-func (e *editBuffer) ReadAt(p []byte, offset int64) (int, error) {
-	if len(p) == 0 {
-		return 0, nil
+// split splits the subtree n into the subtrees of its first off bytes and of
+// the remaining bytes. A piece spanning off is split in two.
+func (e *editBuffer) split(n *piece, off int) (*piece, *piece) {
+	if n == nil {
+		return nil, nil
 	}
-	if offset == e.Size() {
-		return 0, io.EOF
+	leftSize := n.left.sizeOf()
+	switch {
+	case off <= leftSize:
+		l, r := e.split(n.left, off)
+		n.left = r
+		n.update()
+		return l, n
+	case off >= leftSize+n.length:
+		l, r := e.split(n.right, off-leftSize-n.length)
+		n.right = l
+		n.update()
+		return n, r
+	default:
+		head := off - leftSize
+		tail := e.newPiece(n.added, n.start+head, n.length-head)
+		tail.right = n.right
+		tail.update()
+		n.length = head
+		n.right = nil
+		n.update()
+		return n, tail
 	}
-	var total int
-	if offset < int64(e.gapstart) {
-		n := copy(p, e.text[offset:e.gapstart])
-		p = p[n:]
-		total += n
-		offset += int64(n)
+}
+
+// merge returns the subtree of the pieces of l followed by the pieces of r.
+func (e *editBuffer) merge(l, r *piece) *piece {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case l.priority > r.priority:
+		l.right = e.merge(l.right, r)
+		l.update()
+		return l
+	default:
+		r.left = e.merge(l, r.left)
+		r.update()
+		return r
 	}
-	if offset >= int64(e.gapstart) {
-		n := copy(p, e.text[offset+int64(e.gapLen()):])
-		total += n
+}
+
+func (n *piece) sizeOf() int {
+	if n == nil {
+		return 0
 	}
-	return total, nil
+	return n.size
 }
 
-func (e *editBuffer) ReplaceRunes(byteOffset, runeCount int64, s string) {
-	e.deleteRunes(int(byteOffset), int(runeCount))
-	e.prepend(int(byteOffset), s)
+// update recomputes the size of n from its children.
+func (n *piece) update() {
+	n.size = n.left.sizeOf() + n.length + n.right.sizeOf()
 }
 
-func (e *editBuffer) prepend(caret int, s string) {
-	if !utf8.ValidString(s) {
-		s = runes.ReplaceIllFormed().String(s)
+// last returns the final piece of the subtree n, if any.
+func (n *piece) last() *piece {
+	for n != nil && n.right != nil {
+		n = n.right
 	}
+	return n
+}
 
-	e.moveGap(caret, len(s))
-	copy(e.text[caret:], s)
-	e.gapstart += len(s)
-	e.changed = e.changed || len(s) > 0
+// extendLast lengthens the final piece of the subtree n by length bytes.
+func (n *piece) extendLast(length int) {
+	for ; n != nil; n = n.right {
+		n.size += length
+		if n.right == nil {
+			n.length += length
+		}
+	}
 }
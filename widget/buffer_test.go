@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+import (
+	"io"
+	"math/rand"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestEditBuffer compares the piece table to edits of a string.
+func TestEditBuffer(t *testing.T) {
+	inserts := []string{"", "a", "héllo", "日本語", "\n", "a longer piece of text"}
+	rng := rand.New(rand.NewSource(1))
+	b := new(editBuffer)
+	var model []rune
+	for i := 0; i < 1000; i++ {
+		s := inserts[rng.Intn(len(inserts))]
+		start := rng.Intn(len(model) + 1)
+		count := rng.Intn(5)
+		if rng.Intn(50) == 0 {
+			// Replace the entire content.
+			start, count = 0, len(model)
+		}
+		off := len(string(model[:start]))
+		b.ReplaceRunes(int64(off), int64(count), s)
+		end := min(start+count, len(model))
+		model = append(model[:start:start], append([]rune(s), model[end:]...)...)
+
+		want := string(model)
+		// String compacts the pieces, so read the content through ReadAt
+		// after most edits to keep editing a table of several pieces.
+		content := make([]byte, b.Size())
+		if n, _ := b.ReadAt(content, 0); string(content[:n]) != want {
+			t.Fatalf("edit %d: got %q, want %q", i, content[:n], want)
+		}
+		if rng.Intn(10) == 0 {
+			if got := b.String(); got != want {
+				t.Fatalf("edit %d: got string %q, want %q", i, got, want)
+			}
+		}
+		if got := b.Size(); got != int64(len(want)) {
+			t.Fatalf("edit %d: got size %d, want %d", i, got, len(want))
+		}
+		if len(want) == 0 {
+			continue
+		}
+		off = rng.Intn(len(want))
+		p := make([]byte, rng.Intn(len(want)-off)+1)
+		n, err := b.ReadAt(p, int64(off))
+		if err != nil || string(p[:n]) != want[off:off+len(p)] {
+			t.Fatalf("edit %d: ReadAt(%d) = %q, %v, want %q", i, off, p[:n], err, want[off:off+len(p)])
+		}
+	}
+	if _, err := b.ReadAt(make([]byte, 1), b.Size()); err != io.EOF {
+		t.Errorf("got error %v reading at the end, want EOF", err)
+	}
+}
+
+func TestEditBufferInvalidUTF8(t *testing.T) {
+	b := new(editBuffer)
+	b.ReplaceRunes(0, 0, "ab")
+	b.ReplaceRunes(1, 0, "\xff")
+	if got := b.String(); !utf8.ValidString(got) || utf8.RuneCountInString(got) != 3 {
+		t.Errorf("got %q, want ill-formed content replaced", got)
+	}
+}
+
+func TestEditBufferStringCompacts(t *testing.T) {
+	b := new(editBuffer)
+	b.ReplaceRunes(0, 0, "hello world")
+	b.ReplaceRunes(5, 0, ",")
+	b.ReplaceRunes(0, 1, "H")
+	const want = "Hello, world"
+	if got := b.String(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if n := b.root; n == nil || n.added || n.left != nil || n.right != nil {
+		t.Errorf("got several pieces after String, want a single piece of the content")
+	}
+	if len(b.added) != 0 {
+		t.Errorf("got %d bytes of added content after String, want none", len(b.added))
+	}
+	b.ReplaceRunes(int64(len(want)), 0, "!")
+	if got := b.String(); got != want+"!" {
+		t.Errorf("got %q after editing the compacted buffer, want %q", got, want+"!")
+	}
+}
@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+import (
+	"image"
+	"sort"
+	"strings"
+
+	"gioui.org/io/key"
+	"golang.org/x/image/math/fixed"
+)
+
+// Caret is a caret of an Editor and its selection. Start is the position of
+// the caret and End is the other end of the selection, as rune offsets. They
+// are equal when nothing is selected.
+type Caret struct {
+	Start, End int
+}
+
+// AddCaret adds a caret at start, selecting the runes up to end, to the
+// carets of the editor. The text inserted and deleted by the user, by Insert
+// and by Delete is inserted and deleted at every caret, and the selections of
+// every caret are copied and painted. The primary caret, reported by
+// Selection, is unchanged. A caret overlapping another is dropped.
+//
+// Input methods track the primary caret only: the selection and the snippet of
+// text reported to them surround the primary caret, and their text replaces the
+// same runes around every caret as around the primary caret.
+func (e *Editor) AddCaret(start, end int) {
+	e.initBuffer()
+	e.carets = append(e.carets, textCaret{
+		start: e.text.moveByGraphemes(start, 0),
+		end:   e.text.moveByGraphemes(end, 0),
+	})
+	e.normalizeCarets()
+}
+
+// Carets returns the carets of the editor ordered by their position,
+// including the primary caret.
+func (e *Editor) Carets() []Caret {
+	e.initBuffer()
+	start, end := e.text.Selection()
+	carets := []Caret{{Start: start, End: end}}
+	for _, c := range e.carets {
+		carets = append(carets, Caret{Start: c.start, End: c.end})
+	}
+	sort.Slice(carets, func(i, j int) bool {
+		return min(carets[i].Start, carets[i].End) < min(carets[j].Start, carets[j].End)
+	})
+	return carets
+}
+
+// ClearCarets removes the carets other than the primary caret.
+func (e *Editor) ClearCarets() {
+	e.carets = e.carets[:0]
+}
+
+// normalizeCarets orders the carets other than the primary caret by position,
+// and drops the carets overlapping another caret. The primary caret is
+// never dropped.
+func (e *Editor) normalizeCarets() {
+	if len(e.carets) == 0 {
+		return
+	}
+	primary := e.text.caret
+	carets := append(e.carets, primary)
+	sort.SliceStable(carets, func(i, j int) bool {
+		return caretStart(carets[i]) < caretStart(carets[j])
+	})
+	kept := carets[:0]
+	p := -1
+	for _, c := range carets {
+		isPrimary := p == -1 && c == primary
+		if n := len(kept); n > 0 && overlaps(kept[n-1], c) {
+			if isPrimary {
+				kept[n-1] = c
+				p = n - 1
+			}
+			continue
+		}
+		if isPrimary {
+			p = len(kept)
+		}
+		kept = append(kept, c)
+	}
+	e.text.caret = kept[p]
+	e.carets = append(kept[:p], kept[p+1:]...)
+}
+
+// caretStart returns the start of the selection of c.
+func caretStart(c textCaret) int {
+	return min(c.start, c.end)
+}
+
+// overlaps reports whether the selection of b, which starts no earlier than
+// the selection of a, overlaps a or is at the same position.
+func overlaps(a, b textCaret) bool {
+	aEnd := max(a.start, a.end)
+	return caretStart(b) < aEnd || caretStart(a) == caretStart(b) && aEnd == max(b.start, b.end)
+}
+
+// forEachCaret calls f with each caret as the primary caret, from the last to
+// the first, so that edits at a caret don't move the carets f has yet to be
+// called with. The modifications are undone as one. f receives the index of
+// the caret in the order of Carets, and whether it is the primary caret.
+func (e *Editor) forEachCaret(f func(i int, primary bool)) {
+	if len(e.carets) == 0 {
+		f(0, true)
+		return
+	}
+	e.normalizeCarets()
+	e.BeginGroup()
+	defer e.EndGroup()
+	// Track every caret in e.carets while editing, so that replace adjusts
+	// them.
+	primary := e.text.caret
+	p := sort.Search(len(e.carets), func(i int) bool {
+		return caretStart(e.carets[i]) > caretStart(primary)
+	})
+	e.carets = append(e.carets, textCaret{})
+	copy(e.carets[p+1:], e.carets[p:])
+	e.carets[p] = primary
+	for i := len(e.carets) - 1; i >= 0; i-- {
+		e.text.caret = e.carets[i]
+		f(i, i == p)
+		e.carets[i] = e.text.caret
+	}
+	e.text.caret = e.carets[p]
+	e.carets = append(e.carets[:p], e.carets[p+1:]...)
+	e.normalizeCarets()
+}
+
+// adjustCarets moves the carets other than the primary caret for the
+// replacement of the runes [start,end) by n runes.
+func (e *Editor) adjustCarets(start, end, n int) {
+	newEnd := start + n
+	adjust := func(pos int) int {
+		switch {
+		case newEnd < pos && pos <= end:
+			pos = newEnd
+		case end < pos:
+			pos += newEnd - end
+		}
+		return pos
+	}
+	for i := range e.carets {
+		c := &e.carets[i]
+		c.start = adjust(c.start)
+		c.end = adjust(c.end)
+		c.hasVisual = false
+	}
+}
+
+// replaceAtCarets replaces the runes of rng, relative to the primary caret,
+// with s at every caret, such as for the text of an input method. It returns
+// the number of runes inserted at the primary caret, and the distance the
+// primary caret moved because of the replacements at the other carets.
+func (e *Editor) replaceAtCarets(rng key.Range, s string) (moves, shift int) {
+	start, end := e.text.Selection()
+	before := max(min(start, end)-min(rng.Start, rng.End), 0)
+	after := max(max(rng.Start, rng.End)-max(start, end), 0)
+	caret := 0
+	e.forEachCaret(func(_ int, primary bool) {
+		start, end := e.text.Selection()
+		from := max(min(start, end)-before, 0)
+		n := e.replace(from, max(start, end)+after, s, true)
+		e.text.SetCaret(from+n, from+n)
+		if primary {
+			moves, caret = n, from+n
+		}
+	})
+	start, _ = e.text.Selection()
+	return moves, start - caret
+}
+
+// copyText returns the selected text of every caret, separated by newlines,
+// or the empty string if no text is selected.
+func (e *Editor) copyText() string {
+	if len(e.carets) == 0 {
+		e.scratch = e.text.SelectedText(e.scratch)
+		return string(e.scratch)
+	}
+	var b strings.Builder
+	selected := false
+	for i, c := range e.Carets() {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if c.Start == c.End {
+			continue
+		}
+		selected = true
+		start, end := e.text.ByteOffset(min(c.Start, c.End)), e.text.ByteOffset(max(c.Start, c.End))
+		if n := int(end - start); cap(e.scratch) < n {
+			e.scratch = make([]byte, n)
+		}
+		n, _ := e.text.ReadAt(e.scratch[:end-start], start)
+		b.Write(e.scratch[:n])
+	}
+	if !selected {
+		return ""
+	}
+	return b.String()
+}
+
+// deleteSelections deletes the selected text of every caret. It returns the
+// number of runes deleted.
+func (e *Editor) deleteSelections() (deletedRunes int) {
+	e.forEachCaret(func(int, bool) {
+		if e.text.SelectionLen() > 0 {
+			deletedRunes += e.delete(1)
+		}
+	})
+	return deletedRunes
+}
+
+// paste inserts s at every caret. If s has a line for each of several carets,
+// such as when it was copied from as many selections, the lines are inserted
+// at the carets in order instead. It returns the number of runes inserted.
+func (e *Editor) paste(s string) (insertedRunes int) {
+	if e.SingleLine {
+		s = strings.ReplaceAll(s, "\n", " ")
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if len(lines) != len(e.carets)+1 {
+		lines = nil
+	}
+	e.forEachCaret(func(i int, _ bool) {
+		if lines != nil {
+			s = lines[i]
+		}
+		insertedRunes += e.insert(s)
+	})
+	return insertedRunes
+}
+
+// selectColumn selects the rectangle with corners at the document coordinates
+// anchor and pos, with a caret on every line the rectangle spans. The caret
+// on the line of pos is the primary caret.
+func (e *Editor) selectColumn(anchor, pos image.Point) {
+	ax, px := fixed.I(anchor.X), fixed.I(pos.X)
+	first := e.text.closestToXY(ax, anchor.Y).lineCol.line
+	last := e.text.closestToXY(px, pos.Y).lineCol.line
+	step := 1
+	if last < first {
+		step = -1
+	}
+	e.carets = e.carets[:0]
+	for line := first; ; line += step {
+		y := e.text.closestToLineCol(line, 0).y
+		c := textCaret{
+			start: e.text.closestToXYGraphemes(px, y).runes,
+			end:   e.text.closestToXYGraphemes(ax, y).runes,
+		}
+		if line == last {
+			e.text.caret = c
+			break
+		}
+		e.carets = append(e.carets, c)
+	}
+	e.normalizeCarets()
+}
@@ -66,8 +66,41 @@ type Editor struct {
 	Filter string
 	// WrapPolicy configures how displayed text will be broken into lines.
 	WrapPolicy text.WrapPolicy
+	// TabWidth and TabStops position the text following tab characters, aligning
+	// code and tab-separated values. See [text.Parameters] for details. TabStops
+	// are measured from the start of each line.
+	TabWidth int
+	TabStops []unit.Sp
+	// LetterSpacing is extra space added between the grapheme clusters of the
+	// text. Negative values tighten the text.
+	LetterSpacing unit.Sp
+	// WordSpacing is extra space added to the spaces between words.
+	WordSpacing unit.Sp
+	// Features configures the OpenType features used to shape the text, such as
+	// tabular numbers or disabled ligatures for code.
+	Features []text.Feature
+	// Decoration selects the lines drawn along the text, such as underlines.
+	Decoration text.Decoration
+	// CaretMovement selects how the left and right arrow keys move the caret
+	// through text mixing directions, such as Hebrew and English.
+	CaretMovement CaretMovement
+	// MaxHistory limits the number of modifications, or groups of
+	// modifications, that can be undone. Zero means no limit.
+	MaxHistory int
+	// ReportEdits enables EditEvents, describing the range of each
+	// modification of the text. They are opt-in because most editors only
+	// need the ChangeEvent, and queueing them costs an event per
+	// modification, even for programmatic ones.
+	ReportEdits bool
 
 	buffer *editBuffer
+	// search tracks the text to find in the editor.
+	search editorSearch
+	// styles tracks the style ranges of the contents.
+	styles editorStyles
+	// carets are the carets other than the primary caret of text, ordered by
+	// position.
+	carets []textCaret
 	// scratch is a byte buffer that is reused to efficiently read portions of text
 	// from the textView.
 	scratch    []byte
@@ -84,6 +117,10 @@ type Editor struct {
 	scroller    gesture.Scroll
 	scrollCaret bool
 	showCaret   bool
+	// columnDrag is set while dragging a column selection from columnAnchor,
+	// in document coordinates.
+	columnDrag   bool
+	columnAnchor image.Point
 
 	clicker gesture.Click
 
@@ -93,10 +130,30 @@ type Editor struct {
 	// is only not len(history) immediately after undo operations occur. It is framed as the "next" value
 	// to make the zero value consistent.
 	nextHistoryIdx int
+	// groupDepth counts the nested calls to BeginGroup without a matching call
+	// to EndGroup, and group is the group of the next modification.
+	groupDepth int
+	group      int
 
 	pending []EditorEvent
 }
 
+// CaretMovement describes how the arrow keys move the caret of an Editor.
+type CaretMovement uint8
+
+const (
+	// CaretLogical moves the caret to the previous or next grapheme cluster
+	// in the order of the text. Within runs of text flowing against the
+	// direction of the paragraph, the caret moves opposite to the arrow key.
+	CaretLogical CaretMovement = iota
+	// CaretVisual moves the caret to the grapheme cluster boundary displayed
+	// next to it in the direction of the arrow key, crossing into adjacent
+	// lines at line edges. Selections extended with the shift key follow the
+	// caret, but still cover a logical range of the text. Movement by word
+	// remains logical.
+	CaretVisual
+)
+
 type offEntry struct {
 	runes int
 	bytes int
@@ -168,6 +225,20 @@ type EditorEvent interface {
 // A ChangeEvent is generated for every user change to the text.
 type ChangeEvent struct{}
 
+// An EditEvent is generated for every modification of the text when ReportEdits
+// is set, and describes a replacement of text. This includes the modifications
+// made by methods such as SetText, Insert, Delete and Undo. The EditEvents of
+// the modifications since the last call to Update are delivered before the
+// single ChangeEvent reporting them. Consecutive replacements of adjacent text
+// may be reported by a single EditEvent.
+type EditEvent struct {
+	// Start is the rune offset of the replaced text.
+	Start int
+	// Deleted is the number of runes replaced, and Inserted is the number
+	// of runes that replaced them.
+	Deleted, Inserted int
+}
+
 // A SubmitEvent is generated when Submit is set
 // and a carriage return key is pressed.
 type SubmitEvent struct {
@@ -188,10 +259,8 @@ const (
 )
 
 func (e *Editor) processEvents(gtx layout.Context) (ev EditorEvent, ok bool) {
-	if len(e.pending) > 0 {
-		out := e.pending[0]
-		e.pending = e.pending[:copy(e.pending, e.pending[1:])]
-		return out, true
+	if ev, ok := e.nextPending(); ok {
+		return ev, ok
 	}
 	selStart, selEnd := e.Selection()
 	defer func() {
@@ -283,11 +352,20 @@ func (e *Editor) processPointerEvent(gtx layout.Context, ev event.Event) (Editor
 		case evt.Kind == gesture.KindPress && evt.Source == pointer.Mouse,
 			evt.Kind == gesture.KindClick && evt.Source != pointer.Mouse:
 			prevCaretPos, _ := e.text.Selection()
+			// Shortcut-click adds a caret, and alt-drag selects a column.
+			addCaret := evt.Modifiers.Contain(key.ModShortcut) && !evt.Modifiers.Contain(key.ModShift)
+			column := evt.Modifiers.Contain(key.ModAlt) && evt.Source == pointer.Mouse
+			if addCaret {
+				e.carets = append(e.carets, e.text.caret)
+			} else {
+				e.ClearCarets()
+			}
 			e.blinkStart = gtx.Now
-			e.text.MoveCoord(image.Point{
+			pos := image.Point{
 				X: int(math.Round(float64(evt.Position.X))),
 				Y: int(math.Round(float64(evt.Position.Y))),
-			})
+			}
+			e.text.MoveCoord(pos)
 			gtx.Execute(key.FocusCmd{Tag: e})
 			if !e.ReadOnly {
 				gtx.Execute(key.SoftKeyboardCmd{Show: true})
@@ -307,6 +385,10 @@ func (e *Editor) processPointerEvent(gtx layout.Context, ev event.Event) (Editor
 				e.text.ClearSelection()
 			}
 			e.dragging = true
+			e.columnDrag = column
+			if column {
+				e.columnAnchor = pos.Add(e.text.ScrollOff())
+			}
 
 			// Process multi-clicks.
 			switch {
@@ -319,6 +401,7 @@ func (e *Editor) processPointerEvent(gtx layout.Context, ev event.Event) (Editor
 				e.text.MoveLineEnd(selectionExtend)
 				e.dragging = false
 			}
+			e.normalizeCarets()
 		}
 	case pointer.Event:
 		release := false
@@ -329,14 +412,21 @@ func (e *Editor) processPointerEvent(gtx layout.Context, ev event.Event) (Editor
 		case evt.Kind == pointer.Drag && evt.Source == pointer.Mouse:
 			if e.dragging {
 				e.blinkStart = gtx.Now
-				e.text.MoveCoord(image.Point{
+				pos := image.Point{
 					X: int(math.Round(float64(evt.Position.X))),
 					Y: int(math.Round(float64(evt.Position.Y))),
-				})
+				}
+				if e.columnDrag {
+					e.selectColumn(e.columnAnchor, pos.Add(e.text.ScrollOff()))
+				} else {
+					e.text.MoveCoord(pos)
+				}
 				e.scrollCaret = true
 
 				if release {
 					e.dragging = false
+					e.columnDrag = false
+					e.normalizeCarets()
 				}
 			}
 		}
@@ -362,6 +452,16 @@ func (e *Editor) processKey(gtx layout.Context) (EditorEvent, bool) {
 	if gtx.Locale.Direction.Progression() != system.FromOrigin {
 		atEnd, atBeginning = atBeginning, atEnd
 	}
+	atLeft, atRight := atBeginning, atEnd
+	if e.CaretMovement == CaretVisual {
+		_, left := e.text.visualNeighbor(false)
+		_, right := e.text.visualNeighbor(true)
+		atLeft, atRight = !left, !right
+	}
+	if len(e.carets) > 0 {
+		// Other carets may move where the primary caret can't.
+		atBeginning, atEnd, atLeft, atRight = false, false, false, false
+	}
 	filters := []event.Filter{
 		key.FocusFilter{Target: e},
 		transfer.TargetFilter{Target: e, Type: "application/text"},
@@ -381,10 +481,11 @@ func (e *Editor) processKey(gtx layout.Context) (EditorEvent, bool) {
 		key.Filter{Focus: e, Name: key.NameEnd, Optional: key.ModShortcut | key.ModShift},
 		key.Filter{Focus: e, Name: key.NamePageDown, Optional: key.ModShift},
 		key.Filter{Focus: e, Name: key.NamePageUp, Optional: key.ModShift},
-		condFilter(!atBeginning, key.Filter{Focus: e, Name: key.NameLeftArrow, Optional: key.ModShortcutAlt | key.ModShift}),
+		condFilter(!atLeft, key.Filter{Focus: e, Name: key.NameLeftArrow, Optional: key.ModShortcutAlt | key.ModShift}),
 		condFilter(!atBeginning, key.Filter{Focus: e, Name: key.NameUpArrow, Optional: key.ModShortcutAlt | key.ModShift}),
-		condFilter(!atEnd, key.Filter{Focus: e, Name: key.NameRightArrow, Optional: key.ModShortcutAlt | key.ModShift}),
+		condFilter(!atRight, key.Filter{Focus: e, Name: key.NameRightArrow, Optional: key.ModShortcutAlt | key.ModShift}),
 		condFilter(!atEnd, key.Filter{Focus: e, Name: key.NameDownArrow, Optional: key.ModShortcutAlt | key.ModShift}),
+		condFilter(len(e.carets) > 0, key.Filter{Focus: e, Name: key.NameEscape}),
 	}
 	// adjust keeps track of runes dropped because of MaxLen.
 	var adjust int
@@ -407,9 +508,8 @@ func (e *Editor) processKey(gtx layout.Context) (EditorEvent, bool) {
 			}
 			if !e.ReadOnly && e.Submit && (ke.Name == key.NameReturn || ke.Name == key.NameEnter) {
 				if !ke.Modifiers.Contain(key.ModShift) {
-					e.scratch = e.text.Text(e.scratch)
 					return SubmitEvent{
-						Text: string(e.scratch),
+						Text: e.buffer.String(),
 					}, true
 				}
 			}
@@ -440,14 +540,19 @@ func (e *Editor) processKey(gtx layout.Context) (EditorEvent, bool) {
 			case e.SingleLine:
 				s = strings.ReplaceAll(s, "\n", " ")
 			}
-			moves += e.replace(ke.Range.Start, ke.Range.End, s, true)
+			if len(e.carets) > 0 {
+				n, shift := e.replaceAtCarets(ke.Range, s)
+				moves += n
+				adjust -= shift
+			} else {
+				moves += e.replace(ke.Range.Start, ke.Range.End, s, true)
+			}
 			adjust += utf8.RuneCountInString(ke.Text) - moves
 			// Reset caret xoff.
 			e.text.MoveCaret(0, 0)
 			if submit {
-				e.scratch = e.text.Text(e.scratch)
 				submitEvent := SubmitEvent{
-					Text: string(e.scratch),
+					Text: e.buffer.String(),
 				}
 				if e.text.Changed() {
 					e.pending = append(e.pending, submitEvent)
@@ -461,7 +566,7 @@ func (e *Editor) processKey(gtx layout.Context) (EditorEvent, bool) {
 			e.scroller.Stop()
 			content, err := io.ReadAll(ke.Open())
 			if err == nil {
-				if e.Insert(string(content)) != 0 {
+				if e.paste(string(content)) != 0 {
 					return ChangeEvent{}, true
 				}
 			}
@@ -500,41 +605,63 @@ func (e *Editor) command(gtx layout.Context, k key.Event) (EditorEvent, bool) {
 			}
 		// Copy or Cut selection -- ignored if nothing selected.
 		case "C", "X":
-			e.scratch = e.text.SelectedText(e.scratch)
-			if text := string(e.scratch); text != "" {
+			if text := e.copyText(); text != "" {
 				gtx.Execute(clipboard.WriteCmd{Type: "application/text", Data: io.NopCloser(strings.NewReader(text))})
 				if k.Name == "X" && !e.ReadOnly {
-					if e.Delete(1) != 0 {
+					if e.deleteSelections() != 0 {
 						return ChangeEvent{}, true
 					}
 				}
 			}
 		// Select all
 		case "A":
+			e.ClearCarets()
 			e.text.SetCaret(0, e.text.Len())
 		case "Z":
 			if !e.ReadOnly {
+				var changed bool
 				if k.Modifiers.Contain(key.ModShift) {
-					if ev, ok := e.redo(); ok {
-						return ev, ok
-					}
+					changed = e.Redo()
 				} else {
-					if ev, ok := e.undo(); ok {
-						return ev, ok
-					}
+					changed = e.Undo()
+				}
+				if changed {
+					return e.nextPending()
 				}
 			}
 		case key.NameHome:
+			e.ClearCarets()
 			e.text.MoveTextStart(selAct)
 		case key.NameEnd:
+			e.ClearCarets()
 			e.text.MoveTextEnd(selAct)
 		}
 		return nil, false
 	}
+	if k.Name == key.NameEscape {
+		e.ClearCarets()
+		return nil, false
+	}
+	var (
+		ev EditorEvent
+		ok bool
+	)
+	// Move or edit at every caret.
+	e.forEachCaret(func(int, bool) {
+		if cev, cok := e.caretCommand(k, direction, moveByWord, selAct); cok {
+			ev, ok = cev, cok
+		}
+	})
+	return ev, ok
+}
+
+// caretCommand moves the caret, or edits the text at the caret, for the key
+// event k.
+func (e *Editor) caretCommand(k key.Event, direction int, moveByWord bool, selAct selectionAction) (EditorEvent, bool) {
 	switch k.Name {
 	case key.NameReturn, key.NameEnter:
 		if !e.ReadOnly {
-			if e.Insert("\n") != 0 {
+			if e.insert("\n") != 0 {
 				return ChangeEvent{}, true
 			}
 		}
@@ -545,7 +672,7 @@ func (e *Editor) command(gtx layout.Context, k key.Event) (EditorEvent, bool) {
 					return ChangeEvent{}, true
 				}
 			} else {
-				if e.Delete(-1) != 0 {
+				if e.delete(-1) != 0 {
 					return ChangeEvent{}, true
 				}
 			}
@@ -557,7 +684,7 @@ func (e *Editor) command(gtx layout.Context, k key.Event) (EditorEvent, bool) {
 					return ChangeEvent{}, true
 				}
 			} else {
-				if e.Delete(1) != 0 {
+				if e.delete(1) != 0 {
 					return ChangeEvent{}, true
 				}
 			}
@@ -569,6 +696,8 @@ func (e *Editor) command(gtx layout.Context, k key.Event) (EditorEvent, bool) {
 	case key.NameLeftArrow:
 		if moveByWord {
 			e.text.MoveWord(-1*direction, selAct)
+		} else if e.CaretMovement == CaretVisual {
+			e.text.MoveVisual(-1, selAct)
 		} else {
 			if selAct == selectionClear {
 				e.text.ClearSelection()
@@ -578,6 +707,8 @@ func (e *Editor) command(gtx layout.Context, k key.Event) (EditorEvent, bool) {
 	case key.NameRightArrow:
 		if moveByWord {
 			e.text.MoveWord(1*direction, selAct)
+		} else if e.CaretMovement == CaretVisual {
+			e.text.MoveVisual(1, selAct)
 		} else {
 			if selAct == selectionClear {
 				e.text.ClearSelection()
@@ -603,6 +734,7 @@ func (e *Editor) initBuffer() {
 	if e.buffer == nil {
 		e.buffer = new(editBuffer)
 		e.text.SetSource(e.buffer)
+		e.text.paragraphSpans = e.fontSpans
 	}
 	e.text.Alignment = e.Alignment
 	e.text.LineHeight = e.LineHeight
@@ -610,6 +742,12 @@ func (e *Editor) initBuffer() {
 	e.text.SingleLine = e.SingleLine
 	e.text.Mask = e.Mask
 	e.text.WrapPolicy = e.WrapPolicy
+	e.text.TabWidth = e.TabWidth
+	e.text.TabStops = e.TabStops
+	e.text.LetterSpacing = e.LetterSpacing
+	e.text.WordSpacing = e.WordSpacing
+	e.text.Features = e.Features
+	e.text.Decoration = e.Decoration
 }
 
 // Update the state of the editor in response to input events. Update consumes editor
@@ -619,7 +757,15 @@ func (e *Editor) initBuffer() {
 func (e *Editor) Update(gtx layout.Context) (EditorEvent, bool) {
 	e.initBuffer()
 	event, ok := e.processEvents(gtx)
-	// Notify IME of selection if it changed.
+	if _, change := event.(ChangeEvent); change && len(e.pending) > 0 {
+		if _, edit := e.pending[0].(EditEvent); edit {
+			// Report the EditEvents of the change first.
+			e.queueChange(event)
+			event, ok = e.nextPending()
+		}
+	}
+	// Notify IME of selection if it changed. Only the primary caret is
+	// reported.
 	newSel := e.ime.selection
 	start, end := e.text.Selection()
 	newSel.rng = key.Range{
@@ -652,6 +798,7 @@ func (e *Editor) Layout(gtx layout.Context, lt *text.Shaper, font font.Font, siz
 		}
 	}
 
+	e.tokenize()
 	e.text.Layout(gtx, lt, font, size)
 	return e.layout(gtx, textMaterial, selectMaterial)
 }
@@ -732,6 +879,7 @@ func (e *Editor) layout(gtx layout.Context, textMaterial, selectMaterial op.Call
 	}
 	semantic.Editor.Add(gtx.Ops)
 	if e.Len() > 0 {
+		e.paintBackgrounds(gtx)
 		e.paintSelection(gtx, selectMaterial)
 		e.paintText(gtx, textMaterial)
 	}
@@ -749,12 +897,19 @@ func (e *Editor) paintSelection(gtx layout.Context, material op.CallOp) {
 		return
 	}
 	e.text.PaintSelection(gtx, material)
+	for _, c := range e.carets {
+		e.text.paintSelection(gtx, material, c.start, c.end)
+	}
 }
 
 // paintText paints the text glyphs using the provided material to set the fill of the
 // glyphs.
 func (e *Editor) paintText(gtx layout.Context, material op.CallOp) {
 	e.initBuffer()
+	if e.hasStyles() {
+		e.paintStyledText(gtx, material)
+		return
+	}
 	e.text.PaintText(gtx, material)
 }
 
@@ -766,6 +921,9 @@ func (e *Editor) paintCaret(gtx layout.Context, material op.CallOp) {
 		return
 	}
 	e.text.PaintCaret(gtx, material)
+	for _, c := range e.carets {
+		e.text.paintCaret(gtx, material, e.text.closestToRune(c.start))
+	}
 }
 
 // Len is the length of the editor contents, in runes.
@@ -774,11 +932,12 @@ func (e *Editor) Len() int {
 	return e.text.Len()
 }
 
-// Text returns the contents of the editor.
+// Text returns the contents of the editor. The contents are copied only if
+// they changed since Text was last called; use WriteTo or Read to access the
+// contents of large documents without copying them after every edit.
 func (e *Editor) Text() string {
 	e.initBuffer()
-	e.scratch = e.text.Text(e.scratch)
-	return string(e.scratch)
+	return e.buffer.String()
 }
 
 func (e *Editor) SetText(s string) {
@@ -791,7 +950,9 @@ func (e *Editor) SetText(s string) {
 	e.SetCaret(0, 0)
 }
 
-// CaretPos returns the line & column numbers of the caret.
+// CaretPos returns the line & column numbers of the caret. Counting the lines
+// lays out the text before the caret, which costs time proportional to the
+// size of that text the first time.
 func (e *Editor) CaretPos() (line, col int) {
 	e.initBuffer()
 	return e.text.CaretPos()
@@ -808,9 +969,17 @@ func (e *Editor) CaretCoords() f32.Point {
 // direction to delete: positive is forward, negative is backward.
 //
 // If there is a selection, it is deleted and counts as a single grapheme
-// cluster.
+// cluster. Runes are deleted at every caret, and the total is returned.
 func (e *Editor) Delete(graphemeClusters int) (deletedRunes int) {
 	e.initBuffer()
+	e.forEachCaret(func(int, bool) {
+		deletedRunes += e.delete(graphemeClusters)
+	})
+	return deletedRunes
+}
+
+// delete is like Delete, at the primary caret only.
+func (e *Editor) delete(graphemeClusters int) (deletedRunes int) {
 	if graphemeClusters == 0 {
 		return 0
 	}
@@ -831,11 +1000,21 @@ func (e *Editor) Delete(graphemeClusters int) (deletedRunes int) {
 	return end - start
 }
 
+// Insert inserts s at every caret, replacing the selections. It returns the
+// total number of runes inserted.
 func (e *Editor) Insert(s string) (insertedRunes int) {
 	e.initBuffer()
 	if e.SingleLine {
 		s = strings.ReplaceAll(s, "\n", " ")
 	}
+	e.forEachCaret(func(int, bool) {
+		insertedRunes += e.insert(s)
+	})
+	return insertedRunes
+}
+
+// insert is like Insert, at the primary caret only.
+func (e *Editor) insert(s string) (insertedRunes int) {
 	start, end := e.text.Selection()
 	moves := e.replace(start, end, s, true)
 	if end < start {
@@ -843,8 +1022,7 @@ func (e *Editor) Insert(s string) (insertedRunes int) {
 	}
 	// Reset xoff.
 	e.text.MoveCaret(0, 0)
-	e.SetCaret(start+moves, start+moves)
-	e.scrollCaret = true
+	e.setCaret(start+moves, start+moves)
 	return moves
 }
 
@@ -861,40 +1039,169 @@ type modification struct {
 	// ReverseContent is the data inserted at StartRune to
 	// apply this operation. It overwrites len([]rune(ApplyContent)) runes.
 	ReverseContent string
+	// Group identifies the modifications that are undone and redone
+	// together.
+	Group int
+}
+
+// Undo reverts the most recent modification, or group of modifications, of
+// the text. It reports whether there was a modification to undo.
+func (e *Editor) Undo() bool {
+	ev, ok := e.undo()
+	if ok {
+		e.queueChange(ev)
+	}
+	return ok
 }
 
-// undo applies the modification at e.history[e.historyIdx] and decrements
-// e.historyIdx.
+// Redo reapplies the most recently undone modification, or group of
+// modifications. It reports whether there was a modification to redo.
+// Modifying the text discards the modifications that can be redone.
+func (e *Editor) Redo() bool {
+	ev, ok := e.redo()
+	if ok {
+		e.queueChange(ev)
+	}
+	return ok
+}
+
+// CanUndo reports whether there is a modification to undo.
+func (e *Editor) CanUndo() bool {
+	return e.nextHistoryIdx > 0
+}
+
+// CanRedo reports whether there is a modification to redo.
+func (e *Editor) CanRedo() bool {
+	return e.nextHistoryIdx < len(e.history)
+}
+
+// BeginGroup starts a group of modifications that are undone and redone as
+// one, such as the steps of a programmatic edit. The group ends at the matching
+// call to EndGroup. Groups may be nested, in which case the outermost group
+// includes the modifications of the inner groups.
+func (e *Editor) BeginGroup() {
+	if e.groupDepth == 0 {
+		e.group++
+	}
+	e.groupDepth++
+}
+
+// EndGroup ends the group of modifications started by the matching call to
+// BeginGroup. EndGroup without a matching BeginGroup has no effect.
+func (e *Editor) EndGroup() {
+	if e.groupDepth == 0 {
+		return
+	}
+	e.groupDepth--
+	if e.groupDepth == 0 {
+		e.group++
+	}
+}
+
+// ClearHistory discards the modifications that can be undone and redone.
+func (e *Editor) ClearHistory() {
+	e.history = e.history[:0]
+	e.nextHistoryIdx = 0
+}
+
+// undo applies the modifications of the group at e.history[e.historyIdx-1] in
+// reverse, and decrements e.historyIdx past them.
 func (e *Editor) undo() (EditorEvent, bool) {
 	e.initBuffer()
 	if len(e.history) < 1 || e.nextHistoryIdx == 0 {
 		return nil, false
 	}
-	mod := e.history[e.nextHistoryIdx-1]
-	replaceEnd := mod.StartRune + utf8.RuneCountInString(mod.ApplyContent)
-	e.replace(mod.StartRune, replaceEnd, mod.ReverseContent, false)
-	caretEnd := mod.StartRune + utf8.RuneCountInString(mod.ReverseContent)
-	e.SetCaret(caretEnd, mod.StartRune)
-	e.nextHistoryIdx--
+	group := e.history[e.nextHistoryIdx-1].Group
+	for e.nextHistoryIdx > 0 && e.history[e.nextHistoryIdx-1].Group == group {
+		mod := e.history[e.nextHistoryIdx-1]
+		replaceEnd := mod.StartRune + utf8.RuneCountInString(mod.ApplyContent)
+		e.replace(mod.StartRune, replaceEnd, mod.ReverseContent, false)
+		caretEnd := mod.StartRune + utf8.RuneCountInString(mod.ReverseContent)
+		e.setCaret(caretEnd, mod.StartRune)
+		e.nextHistoryIdx--
+	}
+	e.normalizeCarets()
 	return ChangeEvent{}, true
 }
 
-// redo applies the modification at e.history[e.historyIdx] and increments
-// e.historyIdx.
+// redo applies the modifications of the group at e.history[e.historyIdx] and
+// increments e.historyIdx past them.
 func (e *Editor) redo() (EditorEvent, bool) {
 	e.initBuffer()
 	if len(e.history) < 1 || e.nextHistoryIdx == len(e.history) {
 		return nil, false
 	}
-	mod := e.history[e.nextHistoryIdx]
-	end := mod.StartRune + utf8.RuneCountInString(mod.ReverseContent)
-	e.replace(mod.StartRune, end, mod.ApplyContent, false)
-	caretEnd := mod.StartRune + utf8.RuneCountInString(mod.ApplyContent)
-	e.SetCaret(caretEnd, mod.StartRune)
-	e.nextHistoryIdx++
+	group := e.history[e.nextHistoryIdx].Group
+	for e.nextHistoryIdx < len(e.history) && e.history[e.nextHistoryIdx].Group == group {
+		mod := e.history[e.nextHistoryIdx]
+		end := mod.StartRune + utf8.RuneCountInString(mod.ReverseContent)
+		e.replace(mod.StartRune, end, mod.ApplyContent, false)
+		caretEnd := mod.StartRune + utf8.RuneCountInString(mod.ApplyContent)
+		e.setCaret(caretEnd, mod.StartRune)
+		e.nextHistoryIdx++
+	}
+	e.normalizeCarets()
 	return ChangeEvent{}, true
 }
 
+// trimHistory discards the oldest modifications of the history beyond the
+// MaxHistory most recent groups.
+func (e *Editor) trimHistory() {
+	if e.MaxHistory <= 0 {
+		return
+	}
+	groups := 0
+	for i := len(e.history) - 1; i >= 0; i-- {
+		if i == len(e.history)-1 || e.history[i].Group != e.history[i+1].Group {
+			groups++
+		}
+		if groups > e.MaxHistory {
+			n := copy(e.history, e.history[i+1:])
+			e.history = e.history[:n]
+			e.nextHistoryIdx -= i + 1
+			return
+		}
+	}
+}
+
+// queueChange queues the ChangeEvent ev after the EditEvents of its
+// modifications, and consumes the change of the contents, which would otherwise
+// be reported again by Update.
+func (e *Editor) queueChange(ev EditorEvent) {
+	e.text.Changed()
+	e.pending = append(e.pending, ev)
+}
+
+// nextPending removes and returns the first queued event, if any.
+func (e *Editor) nextPending() (EditorEvent, bool) {
+	if len(e.pending) == 0 {
+		return nil, false
+	}
+	ev := e.pending[0]
+	e.pending = e.pending[:copy(e.pending, e.pending[1:])]
+	return ev, true
+}
+
+// reportEdit queues an EditEvent for the replacement of deleted runes at start
+// by inserted runes, merging it with a queued EditEvent of adjacent text.
+func (e *Editor) reportEdit(start, deleted, inserted int) {
+	if !e.ReportEdits || deleted == 0 && inserted == 0 {
+		return
+	}
+	if n := len(e.pending); n > 0 {
+		if prev, ok := e.pending[n-1].(EditEvent); ok && start <= prev.Start+prev.Inserted && start+deleted >= prev.Start {
+			// Compute the extent of both edits in the text before prev and
+			// in the text after the edit.
+			oldEnd := max(prev.Start+prev.Deleted, start+deleted-prev.Inserted+prev.Deleted)
+			newEnd := max(start+inserted, prev.Start+prev.Inserted-deleted+inserted)
+			merged := min(prev.Start, start)
+			e.pending[n-1] = EditEvent{Start: merged, Deleted: oldEnd - merged, Inserted: newEnd - merged}
+			return
+		}
+	}
+	e.pending = append(e.pending, EditEvent{Start: start, Deleted: deleted, Inserted: inserted})
+}
+
 // replace the text between start and end with s. Indices are in runes.
 // It returns the number of runes inserted.
 // addHistory controls whether this modification is recorded in the undo
@@ -940,11 +1247,20 @@ func (e *Editor) replace(start, end int, s string, addHistory bool) int {
 			StartRune:      start,
 			ApplyContent:   s,
 			ReverseContent: string(deleted),
+			Group:          e.group,
 		})
 		e.nextHistoryIdx++
+		if e.groupDepth == 0 {
+			e.group++
+		}
+		e.trimHistory()
 	}
 
 	sc = e.text.Replace(start, end, s)
+	e.searchEdited(start, end, sc)
+	e.editStyles(start, end, sc)
+	e.adjustCarets(start, end, sc)
+	e.reportEdit(start, replaceSize, sc)
 	newEnd := start + sc
 	adjust := func(pos int) int {
 		switch {
@@ -983,7 +1299,7 @@ func (e *Editor) deleteWord(distance int) (deletedRunes int) {
 
 	start, end := e.text.Selection()
 	if start != end {
-		deletedRunes = e.Delete(1)
+		deletedRunes = e.delete(1)
 		distance -= sign(distance)
 	}
 	if distance == 0 {
@@ -1027,7 +1343,7 @@ func (e *Editor) deleteWord(distance int) (deletedRunes int) {
 			runes += 1
 		}
 	}
-	deletedRunes += e.Delete(runes * direction)
+	deletedRunes += e.delete(runes * direction)
 	return deletedRunes
 }
 
@@ -1046,9 +1362,16 @@ func (e *Editor) Selection() (start, end int) {
 }
 
 // SetCaret moves the caret to start, and sets the selection end to end. start
-// and end are in runes, and represent offsets into the editor text.
+// and end are in runes, and represent offsets into the editor text. The carets
+// added by AddCaret are removed.
 func (e *Editor) SetCaret(start, end int) {
 	e.initBuffer()
+	e.ClearCarets()
+	e.setCaret(start, end)
+}
+
+// setCaret is like SetCaret, but keeps the other carets.
+func (e *Editor) setCaret(start, end int) {
 	e.text.SetCaret(start, end)
 	e.scrollCaret = true
 	e.scroller.Stop()
@@ -1125,5 +1448,6 @@ func sign(n int) int {
 }
 
 func (s ChangeEvent) isEditorEvent() {}
+func (s EditEvent) isEditorEvent()   {}
 func (s SubmitEvent) isEditorEvent() {}
 func (s SelectEvent) isEditorEvent() {}
@@ -6,9 +6,11 @@ import (
 	"bytes"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
 	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 	"testing/quick"
 	"time"
@@ -78,6 +80,133 @@ func TestEditorHistory(t *testing.T) {
 	assertContents(t, e, text, start, end)
 }
 
+// TestEditorHistoryGroups ensures that groups of modifications are undone and
+// redone as one, and that the history is limited.
+func TestEditorHistoryGroups(t *testing.T) {
+	e := new(Editor)
+	if e.CanUndo() || e.CanRedo() {
+		t.Error("expected no history")
+	}
+	e.Insert("ab")
+	e.BeginGroup()
+	e.Insert("c")
+	e.SetCaret(0, 0)
+	e.BeginGroup()
+	e.Insert("x")
+	e.EndGroup()
+	e.EndGroup()
+	assertContents(t, e, "xabc", 1, 1)
+	if !e.Undo() {
+		t.Fatal("expected a modification to undo")
+	}
+	assertContents(t, e, "ab", 2, 2)
+	if !e.CanUndo() || !e.CanRedo() {
+		t.Error("expected modifications to undo and redo")
+	}
+	if !e.Redo() {
+		t.Fatal("expected a modification to redo")
+	}
+	assertContents(t, e, "xabc", 1, 0)
+	if e.Redo() || e.CanRedo() {
+		t.Error("unexpected modification to redo")
+	}
+
+	// Only the most recent MaxHistory groups can be undone.
+	e.MaxHistory = 2
+	e.SetCaret(1, 1)
+	e.Insert("1")
+	e.Insert("2")
+	e.Insert("3")
+	undone := 0
+	for e.Undo() {
+		undone++
+	}
+	if undone != 2 {
+		t.Errorf("expected 2 modifications to undo, got %d", undone)
+	}
+	assertContents(t, e, "x1abc", 2, 2)
+
+	e.ClearHistory()
+	if e.CanUndo() || e.CanRedo() {
+		t.Error("expected the history to be cleared")
+	}
+
+	// An unmatched EndGroup has no effect.
+	e.EndGroup()
+	e.Insert("y")
+	e.Insert("z")
+	e.Undo()
+	assertContents(t, e, "x1yabc", 3, 3)
+}
+
+func TestEditorEditEvents(t *testing.T) {
+	e := &Editor{ReportEdits: true}
+	gtx := layout.Context{Ops: new(op.Ops), Locale: english}
+	edits := func() []EditEvent {
+		t.Helper()
+		var edits []EditEvent
+		changes := 0
+		for {
+			ev, ok := e.Update(gtx)
+			if !ok {
+				break
+			}
+			switch ev := ev.(type) {
+			case EditEvent:
+				if changes > 0 {
+					t.Error("expected EditEvents before their ChangeEvent")
+				}
+				edits = append(edits, ev)
+			case ChangeEvent:
+				changes++
+			}
+		}
+		if changes != 1 {
+			t.Errorf("expected a single ChangeEvent along with the EditEvents, got %d", changes)
+		}
+		return edits
+	}
+	// Adjacent edits are merged.
+	e.Insert("hello")
+	e.Insert(" world")
+	if got, want := edits(), []EditEvent{{Start: 0, Inserted: 11}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected edits %v, got %v", want, got)
+	}
+	e.SetCaret(0, 5)
+	e.Insert("goodbye")
+	e.SetCaret(12, 13)
+	e.Delete(1)
+	want := []EditEvent{{Start: 0, Deleted: 5, Inserted: 7}, {Start: 12, Deleted: 1}}
+	if got := edits(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected edits %v, got %v", want, got)
+	}
+	// Undo reports its modifications too.
+	e.Undo()
+	if got, want := edits(), []EditEvent{{Start: 12, Inserted: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected edits %v, got %v", want, got)
+	}
+	// Undoing and redoing with the keyboard report the same events.
+	r := new(input.Router)
+	gtx.Source = r.Source()
+	gtx.Execute(key.FocusCmd{Tag: e})
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	e.Layout(gtx, cache, font.Font{}, unit.Sp(10), op.CallOp{}, op.CallOp{})
+	r.Frame(gtx.Ops)
+	r.Queue(key.Event{Name: "Z", Modifiers: key.ModShortcut | key.ModShift, State: key.Press})
+	if got, want := edits(), []EditEvent{{Start: 12, Deleted: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected edits %v, got %v", want, got)
+	}
+	r.Queue(key.Event{Name: "Z", Modifiers: key.ModShortcut, State: key.Press})
+	if got, want := edits(), []EditEvent{{Start: 12, Inserted: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected edits %v, got %v", want, got)
+	}
+	// So does typing.
+	r.Queue(key.EditEvent{Range: key.Range{Start: 0, End: 0}, Text: "ab"})
+	if got, want := edits(), []EditEvent{{Start: 0, Inserted: 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected edits %v, got %v", want, got)
+	}
+}
+
 func assertContents(t *testing.T, e *Editor, contents string, selectionStart, selectionEnd int) {
 	t.Helper()
 	actualContents := e.Text()
@@ -93,6 +222,572 @@ func assertContents(t *testing.T, e *Editor, contents string, selectionStart, se
 	}
 }
 
+func TestEditorSearch(t *testing.T) {
+	e := new(Editor)
+	e.SetText("Foo bar foo, föo baz foo")
+	if err := e.SetSearch(Search{Query: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := e.Matches(), []Match{{8, 11}, {21, 24}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got matches %v, want %v", got, want)
+	}
+	e.SetSearch(Search{Query: "FOO", IgnoreCase: true})
+	if got, want := e.Matches(), []Match{{0, 3}, {8, 11}, {21, 24}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got matches %v, want %v", got, want)
+	}
+	e.SetSearch(Search{Query: `f.o\b`, Regexp: true})
+	if got, want := e.Matches(), []Match{{8, 11}, {13, 16}, {21, 24}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got matches %v, want %v", got, want)
+	}
+	if err := e.SetSearch(Search{Query: "(", Regexp: true}); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+	if len(e.Matches()) != 0 || e.FindNext() {
+		t.Error("expected no matches after an invalid search")
+	}
+
+	e.SetSearch(Search{Query: "foo", IgnoreCase: true})
+	e.SetCaret(5, 5)
+	for _, want := range []int{8, 21, 0, 8} {
+		if !e.FindNext() {
+			t.Fatal("expected a match")
+		}
+		if start, end := e.Selection(); end != want || start != want+3 {
+			t.Errorf("got selection (%d,%d), want match at %d", start, end, want)
+		}
+	}
+	for _, want := range []int{0, 21, 8} {
+		e.FindPrevious()
+		if _, end := e.Selection(); end != want {
+			t.Errorf("got selection at %d, want match at %d", end, want)
+		}
+	}
+
+	// The selected match is replaced, and the next one selected.
+	if !e.ReplaceMatch("qux") {
+		t.Error("expected the selected match to be replaced")
+	}
+	assertContents(t, e, "Foo bar qux, föo baz foo", 24, 21)
+	e.SetCaret(1, 1)
+	if e.ReplaceMatch("qux") {
+		t.Error("unexpected replacement of an unselected match")
+	}
+	assertContents(t, e, "Foo bar qux, föo baz foo", 24, 21)
+
+	// Regular expressions replace with their submatches, and replacing all
+	// matches is undone at once.
+	e.SetSearch(Search{Query: `(\w)(o+)`, Regexp: true})
+	if n := e.ReplaceAll("$2$1"); n != 2 {
+		t.Errorf("replaced %d matches, want 2", n)
+	}
+	if got, want := e.Text(), "ooF bar qux, föo baz oof"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	e.Undo()
+	if got, want := e.Text(), "Foo bar qux, föo baz foo"; got != want {
+		t.Errorf("got %q after undo, want %q", got, want)
+	}
+}
+
+func TestEditorSearchParagraphs(t *testing.T) {
+	e := new(Editor)
+	e.SetText("foo bar\nbaz foo\nfoo\n")
+	e.SetSearch(Search{Query: "foo"})
+	check := func(desc string) {
+		t.Helper()
+		ref := new(Editor)
+		ref.SetText(e.Text())
+		ref.SetSearch(e.Search())
+		ref.search.whole = true
+		if got, want := e.Matches(), ref.Matches(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got matches %v, want %v", desc, got, want)
+		}
+	}
+	check("initial")
+	if n := e.search.paragraphs.sum().count; e.search.whole || n != 4 {
+		t.Fatalf("expected a search of 4 paragraphs, got %d", n)
+	}
+
+	// An edit only discards the matches of its paragraph.
+	e.SetCaret(9, 9)
+	e.Insert("foo")
+	for i := 0; i < 4; i++ {
+		if p, _ := e.search.paragraphs.at(i); p.searched != (i != 1) {
+			t.Errorf("paragraph %d: got searched %v after editing paragraph 1", i, p.searched)
+		}
+	}
+	check("insertion")
+
+	// Removing a line break joins paragraphs, and inserting one splits them.
+	e.SetCaret(7, 8)
+	e.Insert("foo")
+	check("join")
+	if n := e.search.paragraphs.sum().count; n != 3 {
+		t.Errorf("got %d paragraphs after joining 2", n)
+	}
+	e.SetCaret(2, 2)
+	e.Insert("\nf\n")
+	check("split")
+	e.SetCaret(e.Len(), 0)
+	e.Insert("x\nfoo")
+	check("replace all")
+
+	// Queries that may match line breaks, or depend on the start or end of the
+	// contents, search the whole contents.
+	e.SetText("foo\nfoo\n")
+	for _, test := range []struct {
+		query string
+		whole bool
+		want  []Match
+	}{
+		{"o\nf", true, []Match{{2, 5}}},
+		{`^foo`, true, []Match{{0, 3}}},
+		{`foo\s`, true, []Match{{0, 4}, {4, 8}}},
+		{`(?m)^foo$`, false, []Match{{0, 3}, {4, 7}}},
+		{`[^o]o`, true, []Match{{0, 2}, {4, 6}}},
+		{`[^o\n]o`, false, []Match{{0, 2}, {4, 6}}},
+		{`(?s)o.`, true, []Match{{1, 3}, {5, 7}}},
+	} {
+		e.SetSearch(Search{Query: test.query, Regexp: true})
+		if e.search.whole != test.whole {
+			t.Errorf("%q: got whole search %v, want %v", test.query, e.search.whole, test.whole)
+		}
+		if got := e.Matches(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got matches %v, want %v", test.query, got, test.want)
+		}
+	}
+
+	// Replacements expand the submatches of the paragraph of their match.
+	e.SetText("ab\ncd\nab")
+	e.SetSearch(Search{Query: `(\w)(\w)`, Regexp: true})
+	e.ReplaceAll("$2$1")
+	if got, want := e.Text(), "ba\ndc\nba"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEditorVisibleMatches(t *testing.T) {
+	e := new(Editor)
+	var b strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&b, "line %d of foo\n", i)
+	}
+	e.SetText(b.String())
+	e.SetSearch(Search{Query: "foo"})
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(200, 100)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	e.Layout(gtx, cache, font.Font{}, unit.Sp(10), op.CallOp{}, op.CallOp{})
+	start, end := e.text.VisibleRunes()
+	matches := e.VisibleMatches()
+	if len(matches) == 0 || len(matches) > 20 {
+		t.Fatalf("got %d visible matches", len(matches))
+	}
+	for _, m := range matches {
+		if m.End <= start || m.Start >= end {
+			t.Errorf("got match %v outside the visible runes [%d,%d)", m, start, end)
+		}
+	}
+	sum := e.search.paragraphs.sum()
+	if searched := sum.count - sum.stale; searched > 20 {
+		t.Errorf("searched %d paragraphs for the visible matches", searched)
+	}
+	if all := e.Matches(); !reflect.DeepEqual(all[:len(matches)], matches) || len(all) != 1000 {
+		t.Errorf("expected the visible matches to start the %d matches", len(all))
+	}
+}
+
+func TestEditorStyleRanges(t *testing.T) {
+	red := TextStyle{Color: color.NRGBA{R: 0xff, A: 0xff}}
+	blue := TextStyle{Color: color.NRGBA{B: 0xff, A: 0xff}}
+	bg := TextStyle{Background: color.NRGBA{G: 0xff, A: 0xff}}
+	e := new(Editor)
+	e.SetText("hello world")
+	e.SetStyles([]StyleRange{{Start: 0, End: 5, Style: red}, {Start: 6, End: 11, Style: blue}})
+	e.SetCaret(0, 0)
+	e.Insert("¡")
+	e.SetCaret(3, 3)
+	e.Insert("--")
+	want := []StyleRange{{Start: 0, End: 8, Style: red}, {Start: 9, End: 14, Style: blue}}
+	if got := e.Styles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got ranges %v, want %v", got, want)
+	}
+	// Ranges shrink with deletions, and disappear when empty.
+	e.SetCaret(7, 14)
+	e.Delete(1)
+	want = []StyleRange{{Start: 0, End: 7, Style: red}}
+	if got := e.Styles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got ranges %v, want %v", got, want)
+	}
+
+	// Overlapping ranges combine their styles.
+	e.SetStyles([]StyleRange{{Start: 0, End: 4, Style: red}, {Start: 2, End: 6, Style: bg}, {Start: 3, End: 5, Style: blue}})
+	want = []StyleRange{
+		{Start: 0, End: 2, Style: red},
+		{Start: 2, End: 3, Style: red.combine(bg)},
+		{Start: 3, End: 5, Style: blue.combine(bg)},
+		{Start: 5, End: 6, Style: bg},
+	}
+	if got := e.styleSegments(0, e.Len(), nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got segments %v, want %v", got, want)
+	}
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(100, 100)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	e.Layout(gtx, cache, font.Font{}, unit.Sp(10), op.CallOp{}, op.CallOp{})
+	if start, end := e.text.VisibleRunes(); start != 0 || end != e.Len() {
+		t.Errorf("got visible runes [%d,%d), want [0,%d)", start, end, e.Len())
+	}
+}
+
+func TestEditorStyleRangesBidi(t *testing.T) {
+	bold := TextStyle{Color: color.NRGBA{R: 0xff, A: 0xff}, Weight: font.Bold}
+	collection := append(gofont.Collection(), arabicCollection...)
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(collection))
+	for _, locale := range []system.Locale{english, arabic} {
+		e := new(Editor)
+		e.SetText("abc سماء def\nשלום xyz لا fox\nتمط the")
+		// Style ranges crossing the boundaries of bidi runs.
+		e.SetStyles([]StyleRange{{Start: 2, End: 6, Style: bold}, {Start: 15, End: 20, Style: bold}, {Start: 30, End: 34, Style: bold}})
+		gtx := layout.Context{
+			Ops:         new(op.Ops),
+			Constraints: layout.Exact(image.Pt(1000, 100)),
+			Locale:      locale,
+		}
+		e.Layout(gtx, cache, font.Font{}, unit.Sp(10), op.CallOp{}, op.CallOp{})
+		var ranges []runeStyle
+		for _, seg := range e.styleSegments(0, e.Len(), nil) {
+			ranges = append(ranges, runeStyle{start: seg.Start, end: seg.End})
+		}
+		// The glyphs painted with the color of a range are the glyphs shaped
+		// with its font.
+		for i := 0; i < e.text.paragraphs.sum().count; i++ {
+			p, before := e.text.paragraph(i)
+			content := make([]byte, p.bytes)
+			e.text.ReadAt(content, int64(before.bytes))
+			spans := e.fontSpans(before.runes, string(content))
+			index := &p.shaped.index
+			for k, g := range index.glyphs {
+				if g.Flags&text.FlagParagraphBreak != 0 {
+					continue
+				}
+				r := before.runes + index.clusters[k]
+				painted := rangeStyle(ranges, r, -1) == 0
+				shaped := spans[g.Span].Font.Weight == font.Bold
+				if painted != shaped {
+					t.Errorf("%v: glyph of rune %d painted bold %v, shaped bold %v", locale.Direction, r, painted, shaped)
+				}
+			}
+		}
+	}
+}
+
+// commentTokenizer styles block comments, counting the lines it tokenizes.
+type commentTokenizer struct {
+	lines int
+}
+
+func (c *commentTokenizer) Tokenize(line string, state any, ranges []StyleRange) ([]StyleRange, any) {
+	c.lines++
+	comment := TextStyle{Style: font.Italic}
+	inComment := state == true
+	start := 0
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case !inComment && strings.HasPrefix(string(runes[i:]), "/*"):
+			inComment, start = true, i
+			i++
+		case inComment && strings.HasPrefix(string(runes[i:]), "*/"):
+			inComment = false
+			i++
+			ranges = append(ranges, StyleRange{Start: start, End: i + 1, Style: comment})
+		}
+	}
+	if inComment {
+		ranges = append(ranges, StyleRange{Start: start, End: len(runes), Style: comment})
+	}
+	return ranges, inComment
+}
+
+func TestEditorTokenizer(t *testing.T) {
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(200, 200)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	fontSize := unit.Sp(10)
+	fnt := font.Font{}
+	tok := new(commentTokenizer)
+	e := new(Editor)
+	e.SetText("a /* b */ c\nd\ne\n*/ f\ng\n")
+	e.SetTokenizer(tok)
+	layoutEditor := func() {
+		gtx.Ops.Reset()
+		e.Layout(gtx, cache, fnt, fontSize, op.CallOp{}, op.CallOp{})
+	}
+	layoutEditor()
+	if tok.lines != 5 {
+		t.Errorf("tokenized %d lines, want 5", tok.lines)
+	}
+	italic := TextStyle{Style: font.Italic}
+	want := []StyleRange{{Start: 2, End: 9, Style: italic}}
+	if got := e.styleSegments(0, e.Len(), nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got segments %v, want %v", got, want)
+	}
+	// The comment is shaped by paragraph, with the font of its range.
+	if e.text.whole {
+		t.Error("styled text is laid out as a whole")
+	}
+	if glyphSpan(e, 4) == glyphSpan(e, 0) {
+		t.Error("comment shaped with the font of the text")
+	}
+
+	// Editing a line only tokenizes and reshapes that line.
+	tok.lines = 0
+	e.SetCaret(13, 13)
+	e.Insert("x")
+	e.tokenize()
+	if n := e.text.paragraphs.sum().unlaid; n != 0 {
+		t.Errorf("%d paragraphs to reshape, want 0", n)
+	}
+	layoutEditor()
+	if tok.lines != 1 {
+		t.Errorf("tokenized %d lines, want 1", tok.lines)
+	}
+	// Opening a comment tokenizes the following lines until the state at the
+	// start of a line is unchanged.
+	tok.lines = 0
+	e.SetCaret(12, 12)
+	e.Insert("/*")
+	e.tokenize()
+	if n := e.text.paragraphs.sum().unlaid; n != 3 {
+		t.Errorf("%d paragraphs to reshape, want 3", n)
+	}
+	layoutEditor()
+	if tok.lines != 3 {
+		t.Errorf("tokenized %d lines, want 3", tok.lines)
+	}
+	want = []StyleRange{
+		{Start: 2, End: 9, Style: italic},
+		{Start: 12, End: 16, Style: italic},
+		{Start: 17, End: 18, Style: italic},
+		{Start: 19, End: 21, Style: italic},
+	}
+	if got := e.styleSegments(0, e.Len(), nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("got segments %v, want %v", got, want)
+	}
+	// The lines whose ranges changed are reshaped.
+	if n := e.text.paragraphs.sum().unlaid; n != 0 {
+		t.Errorf("%d paragraphs not laid out", n)
+	}
+	if glyphSpan(e, 19) == glyphSpan(e, 22) {
+		t.Error("commented line shaped with the font of the text")
+	}
+
+	e.SetTokenizer(nil)
+	layoutEditor()
+	if glyphSpan(e, 4) != glyphSpan(e, 0) || glyphSpan(e, 19) != glyphSpan(e, 22) {
+		t.Error("text shaped with the fonts of removed ranges")
+	}
+}
+
+// TestEditorStylesRandomEdits compares the ranges moved by random edits, and the
+// incremental tokenization, with their naive computation.
+func TestEditorStylesRandomEdits(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	red := TextStyle{Color: color.NRGBA{R: 0xff, A: 0xff}}
+	e := new(Editor)
+	e.SetText(strings.Repeat("ab /* c\nd */ é\n", 20))
+	e.SetTokenizer(new(commentTokenizer))
+	var want []StyleRange
+	for i := 0; i < 50; i++ {
+		start := rng.Intn(e.Len())
+		want = append(want, StyleRange{Start: start, End: start + 1 + rng.Intn(e.Len()-start), Style: red})
+	}
+	e.SetStyles(want)
+	for i := 0; i < 500; i++ {
+		start := rng.Intn(e.Len() + 1)
+		end := min(e.Len(), start+rng.Intn(4))
+		ins := []string{"", "x", "/*", "*/", "\n", "y\nz"}[rng.Intn(6)]
+		e.SetCaret(start, end)
+		e.Insert(ins)
+		newEnd := start + utf8.RuneCountInString(ins)
+		adjust := func(pos int) int {
+			switch {
+			case newEnd < pos && pos <= end:
+				pos = newEnd
+			case end < pos:
+				pos += newEnd - end
+			}
+			return pos
+		}
+		moved := want[:0]
+		for _, r := range want {
+			r.Start, r.End = adjust(r.Start), adjust(r.End)
+			if r.Start < r.End {
+				moved = append(moved, r)
+			}
+		}
+		want = moved
+		if got := e.Styles(); !reflect.DeepEqual(got, want) && len(got)+len(want) > 0 {
+			t.Fatalf("edit %d: got ranges %v, want %v", i, got, want)
+		}
+		if i%10 != 0 {
+			continue
+		}
+		e.tokenize()
+		ref := new(Editor)
+		ref.SetText(e.Text())
+		ref.SetTokenizer(new(commentTokenizer))
+		ref.SetStyles(want)
+		ref.tokenize()
+		if got, want := e.styleSegments(0, e.Len(), nil), ref.styleSegments(0, e.Len(), nil); !reflect.DeepEqual(got, want) {
+			t.Fatalf("edit %d: got segments %v, want %v", i, got, want)
+		}
+	}
+}
+
+// glyphSpan returns the index of the span, within the spans of its
+// paragraph, that the first glyph of the rune r was shaped from.
+func glyphSpan(e *Editor, r int) int {
+	_, p, before := e.text.paragraphAtRune(r)
+	index := &p.shaped.index
+	for i, c := range index.clusters {
+		if c == r-before.runes {
+			return index.glyphs[i].Span
+		}
+	}
+	return -1
+}
+
+func TestEditorCarets(t *testing.T) {
+	e := new(Editor)
+	e.SetText("one\ntwo\nsix")
+	e.SetCaret(3, 3)
+	e.AddCaret(7, 7)
+	e.AddCaret(11, 11)
+	e.AddCaret(7, 7)
+	want := []Caret{{3, 3}, {7, 7}, {11, 11}}
+	if got := e.Carets(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected carets %v, got %v", want, got)
+	}
+	// Edits apply at every caret, and are undone as one.
+	e.Insert("s")
+	assertContents(t, e, "ones\ntwos\nsixs", 4, 4)
+	e.Delete(-2)
+	assertContents(t, e, "on\ntw\nsi", 2, 2)
+	want = []Caret{{2, 2}, {5, 5}, {8, 8}}
+	if got := e.Carets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected carets %v, got %v", want, got)
+	}
+	e.Undo()
+	if got, want := e.Text(), "ones\ntwos\nsixs"; got != want {
+		t.Errorf("expected %q after undo, got %q", want, got)
+	}
+	// Setting the caret or the text removes the other carets.
+	e.SetCaret(0, 0)
+	if got := len(e.Carets()); got != 1 {
+		t.Errorf("expected 1 caret after SetCaret, got %d", got)
+	}
+	e.AddCaret(3, 3)
+	e.SetText("abc")
+	if got := len(e.Carets()); got != 1 {
+		t.Errorf("expected 1 caret after SetText, got %d", got)
+	}
+	// Carets collapsed by an edit merge.
+	e.SetText("abc")
+	e.SetCaret(1, 1)
+	e.AddCaret(2, 2)
+	e.Delete(-1)
+	assertContents(t, e, "c", 0, 0)
+	if got := len(e.Carets()); got != 1 {
+		t.Errorf("expected 1 caret, got %d", got)
+	}
+
+	r := new(input.Router)
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(1000, 100)),
+		Locale:      english,
+		Source:      r.Source(),
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	layoutEditor := func() {
+		gtx.Ops.Reset()
+		e.Layout(gtx, cache, font.Font{}, 10, op.CallOp{}, op.CallOp{})
+		r.Frame(gtx.Ops)
+	}
+	pos := func(line, col int) f32.Point {
+		return f32.Pt(textWidth(e, line, 0, col), textBaseline(e, line))
+	}
+	e.SetText("abcd\nabcd\nabcd")
+	gtx.Execute(key.FocusCmd{Tag: e})
+	layoutEditor()
+	// Alt-drag selects a column, across lines of the same width.
+	r.Queue(
+		pointer.Event{Kind: pointer.Press, Source: pointer.Mouse, Buttons: pointer.ButtonPrimary, Modifiers: key.ModAlt, Position: pos(0, 1)},
+		pointer.Event{Kind: pointer.Move, Source: pointer.Mouse, Buttons: pointer.ButtonPrimary, Modifiers: key.ModAlt, Position: pos(2, 3)},
+		pointer.Event{Kind: pointer.Release, Source: pointer.Mouse, Modifiers: key.ModAlt, Position: pos(2, 3)},
+	)
+	layoutEditor()
+	want = []Caret{{3, 1}, {8, 6}, {13, 11}}
+	if got := e.Carets(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected carets %v, got %v", want, got)
+	}
+	if got, want := e.copyText(), "bc\nbc\nbc"; got != want {
+		t.Errorf("expected copied text %q, got %q", want, got)
+	}
+	// Cutting deletes only the selections.
+	e.AddCaret(0, 0)
+	e.deleteSelections()
+	if got, want := e.Text(), "ad\nad\nad"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	e.Undo()
+	e.ClearCarets()
+	e.SetCaret(13, 11)
+	e.AddCaret(3, 1)
+	e.AddCaret(8, 6)
+	// Text input applies at every caret.
+	r.Queue(key.EditEvent{Range: key.Range{Start: 11, End: 13}, Text: "-"})
+	layoutEditor()
+	if got, want := e.Text(), "a-d\na-d\na-d"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	// Pasting as many lines as carets inserts a line at each caret.
+	e.paste("1\n2\n3\n")
+	if got, want := e.Text(), "a-1d\na-2d\na-3d"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	r.Queue(key.Event{Name: key.NameEscape, State: key.Press})
+	layoutEditor()
+	if got := len(e.Carets()); got != 1 {
+		t.Errorf("expected 1 caret after escape, got %d", got)
+	}
+	// Shortcut-click adds a caret.
+	e.SetCaret(0, 0)
+	r.Queue(
+		pointer.Event{Kind: pointer.Press, Source: pointer.Mouse, Buttons: pointer.ButtonPrimary, Modifiers: key.ModShortcut, Position: pos(1, 2), Time: time.Second},
+		pointer.Event{Kind: pointer.Release, Source: pointer.Mouse, Modifiers: key.ModShortcut, Position: pos(1, 2), Time: time.Second},
+	)
+	layoutEditor()
+	want = []Caret{{0, 0}, {7, 7}}
+	if got := e.Carets(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected carets %v, got %v", want, got)
+	}
+	if start, _ := e.Selection(); start != 7 {
+		t.Errorf("expected the primary caret at the click, got %d", start)
+	}
+}
+
 // TestEditorReadOnly ensures that mouse and keyboard interactions with readonly
 // editors do nothing but manipulate the text selection.
 func TestEditorReadOnly(t *testing.T) {
@@ -524,6 +1219,118 @@ func TestEditorDimensions(t *testing.T) {
 	}
 }
 
+// TestEditorLetterSpacing ensures that caret positioning and hit testing
+// account for letter and word spacing.
+func TestEditorLetterSpacing(t *testing.T) {
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(1000, 100)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	fontSize := unit.Sp(10)
+	font := font.Font{}
+	const txt = "ab cd"
+
+	plain := new(Editor)
+	plain.SetText(txt)
+	plain.Layout(gtx, cache, font, fontSize, op.CallOp{}, op.CallOp{})
+	spaced := new(Editor)
+	spaced.LetterSpacing = 4
+	spaced.WordSpacing = 8
+	spaced.SetText(txt)
+	spaced.Layout(gtx, cache, font, fontSize, op.CallOp{}, op.CallOp{})
+
+	for col := 0; col <= len(txt); col++ {
+		plain.SetCaret(col, col)
+		spaced.SetCaret(col, col)
+		expected := plain.CaretCoords().X + float32(4*col)
+		if col > 2 {
+			expected += 8
+		}
+		if got := spaced.CaretCoords().X; got != expected {
+			t.Errorf("caret at column %d: expected x=%v, got x=%v", col, expected, got)
+		}
+		// Clicking at the caret position must place the caret at the same column.
+		spaced.text.MoveCoord(image.Pt(int(expected), 5))
+		if _, gotCol := spaced.CaretPos(); gotCol != col {
+			t.Errorf("hit test at x=%v: expected column %d, got %d", expected, col, gotCol)
+		}
+	}
+}
+
+// TestEditorTabStops ensures that a tab is a single cluster spanning the distance
+// to the next tab stop.
+func TestEditorTabStops(t *testing.T) {
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(1000, 100)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	e := new(Editor)
+	e.TabStops = []unit.Sp{40}
+	e.SetText("a\tb")
+	e.Layout(gtx, cache, font.Font{}, unit.Sp(10), op.CallOp{}, op.CallOp{})
+
+	e.SetCaret(1, 1)
+	start := e.CaretCoords().X
+	e.MoveCaret(1, 1)
+	if _, col := e.CaretPos(); col != 2 {
+		t.Fatalf("expected moving over the tab to reach column 2, got %d", col)
+	}
+	if x := e.CaretCoords().X; x != 40 {
+		t.Errorf("expected caret after tab at x=40, got x=%v", x)
+	}
+	for _, tc := range []struct {
+		x   float32
+		col int
+	}{
+		{x: start + 2, col: 1},
+		{x: 38, col: 2},
+	} {
+		e.text.MoveCoord(image.Pt(int(tc.x), 5))
+		if _, col := e.CaretPos(); col != tc.col {
+			t.Errorf("hit test at x=%v: expected column %d, got %d", tc.x, tc.col, col)
+		}
+	}
+}
+
+func TestEditorHyphenation(t *testing.T) {
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(80, 100)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	const txt = "appreciate hyphenation"
+	e := new(Editor)
+	e.WrapPolicy = text.WrapHyphenated
+	e.SetText(txt)
+	e.Layout(gtx, cache, font.Font{}, unit.Sp(10), op.CallOp{}, op.CallOp{})
+
+	// The hyphen doesn't represent any runes of the text.
+	e.SetCaret(0, len([]rune(txt)))
+	if got := e.SelectedText(); got != txt {
+		t.Errorf("expected selected text %q, got %q", txt, got)
+	}
+	// The remainder of the hyphenated word is positioned as if it started the text.
+	rest := new(Editor)
+	rest.SetText("phenation")
+	rest.Layout(gtx, cache, font.Font{}, unit.Sp(10), op.CallOp{}, op.CallOp{})
+	const breakAt = len("appreciate hy")
+	for i := 0; i <= len("phenation"); i++ {
+		e.SetCaret(breakAt+i, breakAt+i)
+		rest.SetCaret(i, i)
+		if line, col := e.CaretPos(); line != 1 || col != i {
+			t.Errorf("rune %d: expected caret at line 1, column %d, got line %d, column %d", breakAt+i, i, line, col)
+		}
+		if got, want := e.CaretCoords().X, rest.CaretCoords().X; got != want {
+			t.Errorf("rune %d: expected caret at x=%v, got x=%v", breakAt+i, want, got)
+		}
+	}
+}
+
 // assertCaret asserts that the editor caret is at a particular line
 // and column, and that the byte position matches as well.
 func assertCaret(t *testing.T, e *Editor, line, col, bytes int) {
@@ -630,6 +1437,168 @@ func TestEditorCaretConsistency(t *testing.T) {
 	}
 }
 
+// TestEditorIncrementalLayout ensures that laying out the text by paragraph,
+// and reshaping only the edited paragraphs, lays out the text as shaping the
+// entire text does.
+func TestEditorIncrementalLayout(t *testing.T) {
+	collection := append(gofont.Collection(), arabicCollection...)
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(collection))
+	fontSize := unit.Sp(10)
+	font := font.Font{}
+	tests := []struct {
+		locale    system.Locale
+		alignment text.Alignment
+		mask      rune
+	}{
+		{locale: english, alignment: text.Start},
+		{locale: english, alignment: text.Middle},
+		{locale: english, alignment: text.End},
+		{locale: english, alignment: text.Justify},
+		{locale: english, alignment: text.Start, mask: '*'},
+		{locale: arabic, alignment: text.Start},
+		{locale: arabic, alignment: text.Justify},
+	}
+	for _, tc := range tests {
+		gtx := layout.Context{
+			Ops:         new(op.Ops),
+			Constraints: layout.Exact(image.Pt(100, 500)),
+			Locale:      tc.locale,
+		}
+		e := new(Editor)
+		e.Alignment = tc.alignment
+		e.Mask = tc.mask
+		e.SetText("The quick brown fox\njumps over the lazy dog.\n\nWe just need a few lines of text.\n")
+		e.Layout(gtx, cache, font, fontSize, op.CallOp{}, op.CallOp{})
+		inserts := []string{"", "a", "word ", "\n", "two\nlines", "\n\n", "a longer run of text that wraps", "الحب ", "mixed الحب text"}
+		rng := rand.New(rand.NewSource(1))
+		for i := 0; i < 100; i++ {
+			start := rng.Intn(e.Len() + 1)
+			end := min(start+rng.Intn(4), e.Len())
+			e.SetCaret(start, end)
+			e.Insert(inserts[rng.Intn(len(inserts))])
+			e.Layout(gtx, cache, font, fontSize, op.CallOp{}, op.CallOp{})
+			if got, want := e.text.paragraphs.sum().count, strings.Count(e.Text(), "\n")+1; got != want {
+				t.Fatalf("%+v, edit %d: split %q into %d paragraphs, want %d", tc, i, e.Text(), got, want)
+			}
+
+			got, gotGraphemes := flattenLayout(&e.text)
+			ref := textView{params: e.text.params, shaper: cache, Mask: tc.mask}
+			ref.SetSource(newStringSource(e.Text()))
+			ref.whole = true
+			ref.paragraphs.replace(0, 0, []paragraphLayout{{bytes: len(e.Text()), runes: e.Len()}})
+			ref.layoutText(cache)
+			want, wantGraphemes := flattenLayout(&ref)
+			if !reflect.DeepEqual(got.glyphs, want.glyphs) {
+				t.Fatalf("%+v, edit %d: glyphs of %q differ from a full layout", tc, i, e.Text())
+			}
+			if !reflect.DeepEqual(got.positions, want.positions) || !reflect.DeepEqual(got.lines, want.lines) {
+				t.Fatalf("%+v, edit %d: positions of %q differ from a full layout", tc, i, e.Text())
+			}
+			if !reflect.DeepEqual(gotGraphemes, wantGraphemes) {
+				t.Fatalf("%+v, edit %d: graphemes of %q differ from a full layout", tc, i, e.Text())
+			}
+			if got, want := e.text.FullDimensions(), ref.FullDimensions(); got != want {
+				t.Fatalf("%+v, edit %d: dimensions %v differ from %v", tc, i, got, want)
+			}
+		}
+	}
+}
+
+// flattenLayout lays out every paragraph of e, and returns their glyphs, caret
+// positions and lines, and their grapheme cluster boundaries, in the
+// coordinates of the text.
+func flattenLayout(e *textView) (glyphIndex, []int) {
+	e.makeValid()
+	n := e.paragraphs.sum().count
+	e.layoutRange(0, n-1)
+	var index glyphIndex
+	var graphemes []int
+	for i := 0; i < n; i++ {
+		// Paragraphs laid out later may realign the paragraphs before them.
+		p, before := e.paragraph(i)
+		for _, g := range p.shaped.index.glyphs {
+			g.Y += int32(before.height)
+			index.glyphs = append(index.glyphs, g)
+		}
+		for _, pos := range p.shaped.index.positions {
+			index.positions = append(index.positions, before.textPos(pos))
+		}
+		for _, l := range p.shaped.index.lines {
+			l.yOff += before.height
+			index.lines = append(index.lines, l)
+		}
+		for _, g := range e.graphemesOf(p, before) {
+			if g += before.runes; len(graphemes) == 0 || graphemes[len(graphemes)-1] != g {
+				graphemes = append(graphemes, g)
+			}
+		}
+	}
+	return index, graphemes
+}
+
+// TestEditorViewportLayout ensures that only the paragraphs displayed or
+// otherwise used are laid out.
+func TestEditorViewportLayout(t *testing.T) {
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(200, 100)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	fontSize := unit.Sp(10)
+	font := font.Font{}
+	const lines = 10000
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&b, "line %d of the text\n", i)
+	}
+	e := new(Editor)
+	e.SetText(b.String())
+	e.Layout(gtx, cache, font, fontSize, op.CallOp{}, op.CallOp{})
+	laidOut := func() int {
+		s := e.text.paragraphs.sum()
+		return s.count - s.unlaid
+	}
+	if n := laidOut(); n > 20 {
+		t.Errorf("laid out %d paragraphs of %d to display the start of the text", n, lines+1)
+	}
+	if got, want := e.Len(), utf8.RuneCountInString(b.String()); got != want {
+		t.Errorf("got length %d, want %d", got, want)
+	}
+
+	// Editing the displayed text reshapes the edited paragraph.
+	e.SetCaret(0, 0)
+	e.Insert("first ")
+	e.Layout(gtx, cache, font, fontSize, op.CallOp{}, op.CallOp{})
+	if n := laidOut(); n > 20 {
+		t.Errorf("laid out %d paragraphs after an edit", n)
+	}
+
+	// Moving the caret across the text lays out the paragraphs the caret
+	// moves to.
+	e.SetCaret(e.Len()/2, e.Len()/2)
+	e.text.ScrollToCaret()
+	e.Layout(gtx, cache, font, fontSize, op.CallOp{}, op.CallOp{})
+	if n := laidOut(); n > 40 {
+		t.Errorf("laid out %d paragraphs after moving to the middle of the text", n)
+	}
+	start, end := e.text.VisibleRunes()
+	if start > e.Len()/2 || end < e.Len()/2 {
+		t.Errorf("got visible runes [%d,%d), want the caret at %d displayed", start, end, e.Len()/2)
+	}
+	mid := e.text.caret.start
+	e.text.MoveLines(1, selectionClear)
+	if got := e.text.caret.start; got <= mid || got > mid+len("line 5000 of the text\n") {
+		t.Errorf("got caret at %d after moving down a line from %d", got, mid)
+	}
+
+	// Counting the lines before the caret lays out the paragraphs before it.
+	line, _ := e.CaretPos()
+	if want := strings.Count(e.Text()[:e.text.caret.start], "\n"); line != want {
+		t.Errorf("got caret on line %d, want %d", line, want)
+	}
+}
+
 func TestEditorMoveWord(t *testing.T) {
 	type Test struct {
 		Text  string
@@ -1283,3 +2252,78 @@ func textBaseline(e *Editor, lineNum int) float32 {
 	start := e.text.closestToLineCol(lineNum, 0)
 	return float32(start.y)
 }
+
+func TestEditorVisualCaret(t *testing.T) {
+	e := &Editor{CaretMovement: CaretVisual}
+	// The Arabic word is displayed right to left within left to right text.
+	e.SetText("ab سما cd")
+	r := new(input.Router)
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(1000, 100)),
+		Locale:      english,
+		Source:      r.Source(),
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(append(gofont.Collection(), arabicCollection...)))
+	layoutEditor := func() {
+		gtx.Ops.Reset()
+		e.Layout(gtx, cache, font.Font{}, 10, op.CallOp{}, op.CallOp{})
+		r.Frame(gtx.Ops)
+	}
+	gtx.Execute(key.FocusCmd{Tag: e})
+	layoutEditor()
+	layoutEditor()
+	// move presses the key until the caret stops moving in its direction, and
+	// returns the visited rune offsets.
+	move := func(name key.Name, mods key.Modifiers) []int {
+		var runes []int
+		x := e.CaretCoords().X
+		for i := 0; i < 20; i++ {
+			r.Queue(key.Event{State: key.Press, Name: name, Modifiers: mods})
+			layoutEditor()
+			got := e.CaretCoords().X
+			if name == key.NameRightArrow && got <= x || name == key.NameLeftArrow && got >= x {
+				break
+			}
+			x = got
+			caret, _ := e.Selection()
+			runes = append(runes, caret)
+		}
+		return runes
+	}
+
+	// Runes displayed at both edges of the Arabic word are visited twice.
+	if got, want := move(key.NameRightArrow, 0), []int{1, 2, 3, 5, 4, 3, 7, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Errorf("moving right: expected runes %v, got %v", want, got)
+	}
+	if got, want := move(key.NameLeftArrow, 0), []int{8, 7, 6, 4, 5, 6, 2, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("moving left: expected runes %v, got %v", want, got)
+	}
+
+	// Selections follow the caret visually.
+	for i := 0; i < 4; i++ {
+		r.Queue(key.Event{State: key.Press, Name: key.NameRightArrow, Modifiers: key.ModShift})
+	}
+	layoutEditor()
+	if start, end := e.Selection(); start != 5 || end != 0 {
+		t.Errorf("expected selection (5, 0), got (%d, %d)", start, end)
+	}
+	if got, want := e.SelectedText(), "ab سم"; got != want {
+		t.Errorf("expected selected text %q, got %q", want, got)
+	}
+
+	// Movement continues on adjacent lines.
+	e.SetText("ab\ncd")
+	e.SetCaret(2, 2)
+	layoutEditor()
+	r.Queue(key.Event{State: key.Press, Name: key.NameRightArrow})
+	layoutEditor()
+	if line, col := e.CaretPos(); line != 1 || col != 0 {
+		t.Errorf("expected caret at the start of the next line, got line %d column %d", line, col)
+	}
+	r.Queue(key.Event{State: key.Press, Name: key.NameLeftArrow})
+	layoutEditor()
+	if caret, _ := e.Selection(); caret != 2 {
+		t.Errorf("expected caret at the end of the previous line, got %d", caret)
+	}
+}
@@ -14,6 +14,9 @@ import (
 	"golang.org/x/image/math/fixed"
 )
 
+// lineInfo describes a line of text. The xOff and width of vertical lines
+// are measured along the Y axis, and their yOff is the X coordinate of their
+// center.
 type lineInfo struct {
 	xOff            fixed.Int26_6
 	yOff            int
@@ -25,6 +28,9 @@ type lineInfo struct {
 type glyphIndex struct {
 	// glyphs holds the glyphs processed.
 	glyphs []text.Glyph
+	// clusters holds the rune offset of the cluster of each glyph, which
+	// doesn't depend on the visual order of the glyphs.
+	clusters []int
 	// positions contain all possible caret positions, sorted by rune index.
 	positions []combinedPos
 	// lines contains metadata about the size and position of each line of
@@ -54,6 +60,7 @@ type glyphIndex struct {
 // reset prepares the index for reuse.
 func (g *glyphIndex) reset() {
 	g.glyphs = g.glyphs[:0]
+	g.clusters = g.clusters[:0]
 	g.positions = g.positions[:0]
 	g.lines = g.lines[:0]
 	g.currentLineMin = 0
@@ -95,6 +102,17 @@ type combinedPos struct {
 	// towardOrigin tracks whether this glyph's run is progressing toward the
 	// origin or away from it.
 	towardOrigin bool
+	// vertical tracks whether this position is within a vertical line, whose
+	// positions progress along the Y axis.
+	vertical bool
+}
+
+// inline returns the coordinate of pos along its line.
+func (pos combinedPos) inline() fixed.Int26_6 {
+	if pos.vertical {
+		return fixed.I(pos.y)
+	}
+	return pos.x
 }
 
 // incrementPosition returns the next position after pos (if any). Pos _must_ be
@@ -116,7 +134,11 @@ func (g *glyphIndex) insertPosition(pos combinedPos) {
 	lastIdx := len(g.positions) - 1
 	if lastIdx >= 0 {
 		lastPos := g.positions[lastIdx]
-		if lastPos.runes == pos.runes && (lastPos.y != pos.y || (lastPos.x == pos.x)) {
+		newLine, samePos := lastPos.y != pos.y, lastPos.x == pos.x
+		if pos.vertical {
+			newLine, samePos = lastPos.x != pos.x, lastPos.y == pos.y
+		}
+		if lastPos.runes == pos.runes && (newLine || samePos) {
 			// If we insert a consecutive position with the same logical position,
 			// overwrite the previous position with the new one.
 			g.positions[lastIdx] = pos
@@ -129,16 +151,24 @@ func (g *glyphIndex) insertPosition(pos combinedPos) {
 // Glyph indexes the provided glyph, generating text cursor positions for it.
 func (g *glyphIndex) Glyph(gl text.Glyph) {
 	g.glyphs = append(g.glyphs, gl)
+	// The runes of a cluster are counted at its last glyph.
+	g.clusters = append(g.clusters, g.pos.runes)
 	g.currentLineGlyphs++
 	if len(g.positions) == 0 {
 		// First-iteration setup.
 		g.currentLineMin = math.MaxInt32
 		g.currentLineMax = 0
 	}
-	if gl.X < g.currentLineMin {
-		g.currentLineMin = gl.X
+	vertical := gl.Flags&text.FlagVertical != 0
+	// start is the coordinate of gl along its line.
+	start := gl.X
+	if vertical {
+		start = fixed.I(int(gl.Y))
+	}
+	if start < g.currentLineMin {
+		g.currentLineMin = start
 	}
-	if end := gl.X + gl.Advance; end > g.currentLineMax {
+	if end := start + gl.Advance; end > g.currentLineMax {
 		g.currentLineMax = end
 	}
 
@@ -153,6 +183,7 @@ func (g *glyphIndex) Glyph(gl text.Glyph) {
 	// Get the text progression/direction right.
 	g.prog = gl.Flags & text.FlagTowardOrigin
 	g.pos.towardOrigin = g.prog == text.FlagTowardOrigin
+	g.pos.vertical = vertical
 	if !g.midCluster {
 		// Create the text position prior to the glyph.
 		g.pos.x = gl.X
@@ -177,8 +208,11 @@ func (g *glyphIndex) Glyph(gl text.Glyph) {
 		g.pos.runes += int(gl.Runes)
 	}
 	// Always track the cumulative advance added by the glyph, even if it
-	// doesn't terminate a cluster itself.
-	g.clusterAdvance += gl.Advance
+	// doesn't terminate a cluster itself. Inserted hyphens stand for no runes,
+	// so they don't widen any cluster.
+	if gl.Flags&text.FlagHyphen == 0 {
+		g.clusterAdvance += gl.Advance
+	}
 	if insertPositionsWithin {
 		// Construct the text positions _within_ gl.
 		g.pos.y = int(gl.Y)
@@ -202,7 +236,12 @@ func (g *glyphIndex) Glyph(gl text.Glyph) {
 			perRune = -perRune
 		}
 		for i := 1; i <= positionCount; i++ {
-			g.pos.x = gl.X + adjust + perRune*fixed.Int26_6(i)
+			if vertical {
+				g.pos.x = gl.X
+				g.pos.y = (start + adjust + perRune*fixed.Int26_6(i)).Round()
+			} else {
+				g.pos.x = gl.X + adjust + perRune*fixed.Int26_6(i)
+			}
 			g.pos.runes += runesPerPosition
 			g.pos.lineCol.col += runesPerPosition
 			g.insertPosition(g.pos)
@@ -213,9 +252,13 @@ func (g *glyphIndex) Glyph(gl text.Glyph) {
 		g.pos.runIndex++
 	}
 	if needsNewLine {
+		yOff := int(gl.Y)
+		if vertical {
+			yOff = gl.X.Round()
+		}
 		g.lines = append(g.lines, lineInfo{
 			xOff:    g.currentLineMin,
-			yOff:    int(gl.Y),
+			yOff:    yOff,
 			width:   g.currentLineMax - g.currentLineMin,
 			ascent:  g.positions[len(g.positions)-1].ascent,
 			descent: g.positions[len(g.positions)-1].descent,
@@ -273,6 +316,37 @@ func (g *glyphIndex) closestToLineCol(lineCol screenPos) combinedPos {
 	return next
 }
 
+// visualStep returns the position of line whose coordinate along the line is
+// nearest to from in the direction of increasing coordinates if forward, and of
+// decreasing coordinates otherwise. Positions are considered only if valid
+// reports true for their rune offset. Among positions displayed at the same
+// coordinate, such as those at the boundaries of bidi runs, visualStep prefers
+// the position logically closest to the rune offset near. It reports false if
+// there is no such position.
+func (g *glyphIndex) visualStep(line int, from fixed.Int26_6, near int, forward bool, valid func(runes int) bool) (combinedPos, bool) {
+	i := sort.Search(len(g.positions), func(i int) bool {
+		return g.positions[i].lineCol.line >= line
+	})
+	var best combinedPos
+	found := false
+	for ; i < len(g.positions) && g.positions[i].lineCol.line == line; i++ {
+		pos := g.positions[i]
+		inline := pos.inline()
+		if forward && inline <= from || !forward && inline >= from || !valid(pos.runes) {
+			continue
+		}
+		if found {
+			closer := forward && inline < best.inline() || !forward && inline > best.inline()
+			tie := inline == best.inline() && abs(pos.runes-near) < abs(best.runes-near)
+			if !closer && !tie {
+				continue
+			}
+		}
+		best, found = pos, true
+	}
+	return best, found
+}
+
 func dist(a, b fixed.Int26_6) fixed.Int26_6 {
 	if a > b {
 		return a - b
@@ -284,6 +358,9 @@ func (g *glyphIndex) closestToXY(x fixed.Int26_6, y int) combinedPos {
 	if len(g.positions) == 0 {
 		return combinedPos{}
 	}
+	if g.positions[0].vertical {
+		return g.closestToXYVertical(x, y)
+	}
 	i := sort.Search(len(g.positions), func(i int) bool {
 		pos := g.positions[i]
 		return pos.y+pos.descent.Round() >= y
@@ -318,13 +395,46 @@ func (g *glyphIndex) closestToXY(x fixed.Int26_6, y int) combinedPos {
 	return g.positions[closest]
 }
 
-// makeRegion creates a text-aligned rectangle from start to end. The vertical
-// dimensions of the rectangle are derived from the provided line's ascent and
-// descent, and the y offset of the line's baseline is provided as y.
-func makeRegion(line lineInfo, y int, start, end fixed.Int26_6) Region {
+// closestToXYVertical is like closestToXY for vertical text, whose lines are
+// stacked from right to left.
+func (g *glyphIndex) closestToXYVertical(x fixed.Int26_6, y int) combinedPos {
+	i := sort.Search(len(g.positions), func(i int) bool {
+		pos := g.positions[i]
+		return pos.x-pos.descent <= x
+	})
+	// If no line extends left of the provided X, return the last position.
+	if i == len(g.positions) {
+		return g.positions[i-1]
+	}
+	closest := i
+	closestDist := dist(fixed.I(g.positions[i].y), fixed.I(y))
+	line := g.positions[i].lineCol.line
+	for i := i + 1; i < len(g.positions) && g.positions[i].lineCol.line == line; i++ {
+		if distance := dist(fixed.I(g.positions[i].y), fixed.I(y)); distance < closestDist {
+			closestDist = distance
+			closest = i
+		}
+	}
+	return g.positions[closest]
+}
+
+// makeRegion creates a text-aligned rectangle from start to end along the line
+// containing pos. The dimensions of the rectangle across the line are derived
+// from the provided line's ascent and descent.
+func makeRegion(line lineInfo, pos combinedPos, start, end fixed.Int26_6) Region {
 	if start > end {
 		start, end = end, start
 	}
+	if pos.vertical {
+		x := pos.x.Round()
+		return Region{
+			Bounds: image.Rectangle{
+				Min: image.Pt(x-line.descent.Floor(), start.Round()),
+				Max: image.Pt(x+line.ascent.Ceil(), end.Round()),
+			},
+		}
+	}
+	y := pos.y
 	dotStart := image.Pt(start.Round(), y)
 	dotEnd := image.Pt(end.Round(), y)
 	return Region{
@@ -343,40 +453,49 @@ type Region struct {
 	// widget.
 	Bounds image.Rectangle
 	// Baseline is the quantity of vertical pixels between the baseline and
-	// the bottom of bounds.
+	// the bottom of bounds. It is zero for vertical text.
 	Baseline int
 }
 
-// locate returns highlight regions covering the glyphs that represent the runes in
-// [startRune,endRune). If the rects parameter is non-nil, locate will use it to
-// return results instead of allocating, provided that there is enough capacity.
-// The returned regions have their Bounds specified relative to the provided
-// viewport.
+// locate appends to rects the highlight regions covering the glyphs that
+// represent the runes in [startRune,endRune), and returns the result. The
+// returned regions have their Bounds specified relative to the provided
+// viewport. Runes before or after the indexed text select the entire first or
+// last line.
 func (g *glyphIndex) locate(viewport image.Rectangle, startRune, endRune int, rects []Region) []Region {
 	if startRune > endRune {
 		startRune, endRune = endRune, startRune
 	}
-	rects = rects[:0]
+	n := len(rects)
 	caretStart, _ := g.closestToRune(startRune)
 	caretEnd, _ := g.closestToRune(endRune)
+	before := startRune < 0
+	after := len(g.positions) > 0 && endRune > g.positions[len(g.positions)-1].runes
 
 	for lineIdx := caretStart.lineCol.line; lineIdx < len(g.lines); lineIdx++ {
 		if lineIdx > caretEnd.lineCol.line {
 			break
 		}
 		pos := g.closestToLineCol(screenPos{line: lineIdx})
-		if int(pos.y)+pos.descent.Ceil() < viewport.Min.Y {
+		if pos.vertical {
+			// Vertical lines are stacked from right to left.
+			if pos.x.Floor()-pos.descent.Ceil() > viewport.Max.X {
+				continue
+			}
+			if pos.x.Ceil()+pos.ascent.Ceil() < viewport.Min.X {
+				break
+			}
+		} else if int(pos.y)+pos.descent.Ceil() < viewport.Min.Y {
 			continue
-		}
-		if int(pos.y)-pos.ascent.Ceil() > viewport.Max.Y {
+		} else if int(pos.y)-pos.ascent.Ceil() > viewport.Max.Y {
 			break
 		}
 		line := g.lines[lineIdx]
-		if lineIdx > caretStart.lineCol.line && lineIdx < caretEnd.lineCol.line {
+		if (lineIdx > caretStart.lineCol.line || before) && (lineIdx < caretEnd.lineCol.line || after) {
 			startX := line.xOff
 			endX := startX + line.width
 			// The entire line is selected.
-			rects = append(rects, makeRegion(line, pos.y, startX, endX))
+			rects = append(rects, makeRegion(line, pos, startX, endX))
 			continue
 		}
 		selectionStart := caretStart
@@ -396,11 +515,11 @@ func (g *glyphIndex) locate(viewport image.Rectangle, startRune, endRune int, re
 		)
 	lineLoop:
 		for !eof {
-			startX = selectionStart.x
+			startX = selectionStart.inline()
 			if selectionStart.runIndex == selectionEnd.runIndex {
 				// Commit selection.
-				endX = selectionEnd.x
-				rects = append(rects, makeRegion(line, pos.y, startX, endX))
+				endX = selectionEnd.inline()
+				rects = append(rects, makeRegion(line, pos, startX, endX))
 				break
 			} else {
 				currentDirection := selectionStart.towardOrigin
@@ -412,28 +531,28 @@ func (g *glyphIndex) locate(viewport image.Rectangle, startRune, endRune int, re
 						previous = selectionStart
 						selectionStart, eof = g.incrementPosition(selectionStart)
 						if eof {
-							endX = selectionStart.x
-							rects = append(rects, makeRegion(line, pos.y, startX, endX))
+							endX = selectionStart.inline()
+							rects = append(rects, makeRegion(line, pos, startX, endX))
 							break runLoop
 						}
 					}
 					if selectionStart.towardOrigin != currentDirection {
-						endX = previous.x
-						rects = append(rects, makeRegion(line, pos.y, startX, endX))
+						endX = previous.inline()
+						rects = append(rects, makeRegion(line, pos, startX, endX))
 						break
 					}
 					if selectionStart.runIndex == selectionEnd.runIndex {
 						// Commit selection.
-						endX = selectionEnd.x
-						rects = append(rects, makeRegion(line, pos.y, startX, endX))
+						endX = selectionEnd.inline()
+						rects = append(rects, makeRegion(line, pos, startX, endX))
 						break lineLoop
 					}
 				}
 			}
 		}
 	}
-	for i := range rects {
-		rects[i].Bounds = rects[i].Bounds.Sub(viewport.Min)
+	for i := range rects[n:] {
+		rects[n+i].Bounds = rects[n+i].Bounds.Sub(viewport.Min)
 	}
 	return rects
 }
@@ -487,24 +606,30 @@ func (p *graphemeReader) next() ([]rune, bool) {
 // have been segmented).
 func (p *graphemeReader) Graphemes() []int {
 	var more bool
-	p.graphemes = p.graphemes[:0]
 	p.paragraph, more = p.next()
 	if len(p.paragraph) == 0 && !more {
 		return nil
 	}
-	p.Segmenter.Init(p.paragraph)
+	p.graphemes = p.segment(p.graphemes[:0], p.paragraph, p.runeOffset)
+	p.runeOffset += len(p.paragraph)
+	return p.graphemes
+}
+
+// segment appends the grapheme cluster boundaries of paragraph to graphemes,
+// offset by runeOffset.
+func (p *graphemeReader) segment(graphemes []int, paragraph []rune, runeOffset int) []int {
+	p.Segmenter.Init(paragraph)
 	iter := p.Segmenter.GraphemeIterator()
 	if iter.Next() {
 		graph := iter.Grapheme()
-		p.graphemes = append(p.graphemes,
-			p.runeOffset+graph.Offset,
-			p.runeOffset+graph.Offset+len(graph.Text),
+		graphemes = append(graphemes,
+			runeOffset+graph.Offset,
+			runeOffset+graph.Offset+len(graph.Text),
 		)
 	}
 	for iter.Next() {
 		graph := iter.Grapheme()
-		p.graphemes = append(p.graphemes, p.runeOffset+graph.Offset+len(graph.Text))
+		graphemes = append(graphemes, runeOffset+graph.Offset+len(graph.Text))
 	}
-	p.runeOffset += len(p.paragraph)
-	return p.graphemes
+	return graphemes
 }
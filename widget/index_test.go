@@ -2,12 +2,15 @@ package widget
 
 import (
 	"bytes"
+	"image"
 	"io"
+	"math"
 	"testing"
 
 	nsareg "eliasnaur.com/font/noto/sans/arabic/regular"
 	"gioui.org/font"
 	"gioui.org/font/opentype"
+	"gioui.org/io/system"
 	"gioui.org/text"
 	"golang.org/x/image/font/gofont/goregular"
 	"golang.org/x/image/math/fixed"
@@ -628,6 +631,57 @@ func TestIndexPositionRunes(t *testing.T) {
 		})
 	}
 }
+func TestIndexPositionVertical(t *testing.T) {
+	face, _ := opentype.Parse(goregular.TTF)
+	shaper := text.NewShaper(text.NoSystemFonts(), text.WithCollection([]font.FontFace{{Face: face}}))
+	const source = "vertical text\nin columns"
+	shaper.LayoutString(text.Parameters{
+		PxPerEm:  fixed.I(16),
+		MaxWidth: 100,
+		Locale:   system.Locale{Language: "ja", Direction: system.TTB},
+	}, source)
+	var gi glyphIndex
+	for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+		gi.Glyph(g)
+	}
+	if len(gi.lines) < 3 {
+		t.Fatalf("expected text to wrap into several lines, got %d", len(gi.lines))
+	}
+	for i := 1; i < len(gi.lines); i++ {
+		if gi.lines[i].yOff >= gi.lines[i-1].yOff {
+			t.Errorf("line %d: expected line left of x=%d, got x=%d", i, gi.lines[i-1].yOff, gi.lines[i].yOff)
+		}
+	}
+	for i, pos := range gi.positions {
+		if !pos.vertical {
+			t.Errorf("position %d: expected vertical position", i)
+		}
+		if line := gi.lines[pos.lineCol.line]; pos.x.Round() != line.yOff {
+			t.Errorf("position %d: expected x=%d of line %d, got x=%v", i, line.yOff, pos.lineCol.line, pos.x)
+		}
+		if i > 0 && gi.positions[i-1].lineCol.line == pos.lineCol.line && pos.y <= gi.positions[i-1].y {
+			t.Errorf("position %d: expected position below y=%d, got y=%d", i, gi.positions[i-1].y, pos.y)
+		}
+		if got := gi.closestToXY(pos.x, pos.y); got.runes != pos.runes {
+			t.Errorf("position %d: expected closest position to (%v, %d) at rune %d, got rune %d", i, pos.x, pos.y, pos.runes, got.runes)
+		}
+	}
+	viewport := image.Rectangle{Max: image.Pt(math.MaxInt, math.MaxInt)}
+	regions := gi.locate(viewport, 0, len([]rune(source)), nil)
+	if len(regions) != len(gi.lines) {
+		t.Fatalf("expected a region per line, got %d regions for %d lines", len(regions), len(gi.lines))
+	}
+	for i, r := range regions {
+		line := gi.lines[i]
+		if r.Bounds.Min.X > line.yOff || r.Bounds.Max.X < line.yOff {
+			t.Errorf("region %d: expected bounds %v to contain line at x=%d", i, r.Bounds, line.yOff)
+		}
+		if r.Bounds.Dy() <= 0 || r.Bounds.Min.Y < line.xOff.Floor() || r.Bounds.Max.Y > (line.xOff+line.width).Ceil() {
+			t.Errorf("region %d: expected bounds %v along the line from y=%v over %v", i, r.Bounds, line.xOff, line.width)
+		}
+	}
+}
+
 func printPositions(t *testing.T, positions []combinedPos) {
 	t.Helper()
 	for i, p := range positions {
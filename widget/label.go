@@ -4,10 +4,13 @@ package widget
 
 import (
 	"image"
+	"image/color"
+	"math"
 
 	"gioui.org/f32"
 	"gioui.org/font"
 	"gioui.org/io/semantic"
+	"gioui.org/io/system"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
@@ -36,6 +39,66 @@ type Label struct {
 	// LineHeightScale applies a scaling factor to the LineHeight. If zero, a
 	// sensible default will be used.
 	LineHeightScale float32
+	// TabWidth and TabStops position the text following tab characters. See
+	// [text.Parameters] for details. TabStops are measured from the start of
+	// each line.
+	TabWidth int
+	TabStops []unit.Sp
+	// LetterSpacing is extra space added between the grapheme clusters of the
+	// text. Negative values tighten the text.
+	LetterSpacing unit.Sp
+	// WordSpacing is extra space added to the spaces between words.
+	WordSpacing unit.Sp
+	// Features configures the OpenType features used to shape the text, such as
+	// tabular numbers or disabled ligatures.
+	Features []text.Feature
+	// Decoration selects the lines drawn along the text, such as underlines.
+	Decoration text.Decoration
+	// Outline configures a stroked outline drawn behind the glyphs.
+	Outline TextOutline
+	// Shadow configures a shadow drawn behind the glyphs and their outline.
+	Shadow TextShadow
+	// LCD configures the glyphs to be drawn with subpixel coverage, if the
+	// shaper is configured with a subpixel order by [text.WithSubpixel].
+	LCD TextLCD
+}
+
+// TextLCD configures text drawn with subpixel (LCD) coverage, which blends
+// each color channel of the glyphs separately with the background. The
+// renderers blend whole pixels, so the glyphs are blended with the color of
+// the background in advance. Subpixel coverage replaces the text material of
+// vector glyphs, and is only used for text without outline and shadow, drawn
+// without scaling or rotation over an opaque Background.
+type TextLCD struct {
+	// Color is the color of the text.
+	Color color.NRGBA
+	// Background is the opaque color behind the text.
+	Background color.NRGBA
+}
+
+// TextOutline configures the outline of text, stroked along the edges of its
+// glyphs. Outlines keep text legible over busy backgrounds such as video or
+// maps.
+type TextOutline struct {
+	// Width is the width of the stroke. Half of the stroke lies outside the
+	// glyphs, and the other half is covered by the glyphs. The outline is not
+	// drawn if Width is zero.
+	Width unit.Dp
+	// Material sets the paint material of the outline.
+	Material op.CallOp
+}
+
+// TextShadow configures the shadow of text, a copy of its glyphs and their outline
+// drawn behind them.
+type TextShadow struct {
+	// OffsetX and OffsetY are the offset of the shadow from the glyphs.
+	OffsetX, OffsetY unit.Dp
+	// Blur is the distance over which the shadow fades out beyond the edges of
+	// the glyphs. The shadow is sharp if Blur is zero.
+	Blur unit.Dp
+	// Material sets the paint material of the shadow. The shadow is not drawn
+	// if Material is the zero value.
+	Material op.CallOp
 }
 
 // Layout the label with the given shaper, font, size, text, and material.
@@ -54,27 +117,17 @@ type TextInfo struct {
 // Layout the label with the given shaper, font, size, text, and material, returning metadata about the shaped text.
 func (l Label) LayoutDetailed(gtx layout.Context, lt *text.Shaper, font font.Font, size unit.Sp, txt string, textMaterial op.CallOp) (layout.Dimensions, TextInfo) {
 	cs := gtx.Constraints
-	textSize := fixed.I(gtx.Sp(size))
-	lineHeight := fixed.I(gtx.Sp(l.LineHeight))
-	lt.LayoutString(text.Parameters{
-		Font:            font,
-		PxPerEm:         textSize,
-		MaxLines:        l.MaxLines,
-		Truncator:       l.Truncator,
-		Alignment:       l.Alignment,
-		WrapPolicy:      l.WrapPolicy,
-		MaxWidth:        cs.Max.X,
-		MinWidth:        cs.Min.X,
-		Locale:          gtx.Locale,
-		LineHeight:      lineHeight,
-		LineHeightScale: l.LineHeightScale,
-	}, txt)
+	lt.LayoutString(l.parameters(gtx, font, size), txt)
 	m := op.Record(gtx.Ops)
 	viewport := image.Rectangle{Max: cs.Max}
 	it := textIterator{
-		viewport: viewport,
-		maxLines: l.MaxLines,
-		material: textMaterial,
+		viewport:   viewport,
+		maxLines:   l.MaxLines,
+		material:   textMaterial,
+		decoration: l.Decoration,
+		outline:    l.Outline,
+		shadow:     l.Shadow,
+		lcd:        l.LCD,
 	}
 	semantic.LabelOp(txt).Add(gtx.Ops)
 	var glyphs [32]text.Glyph
@@ -86,9 +139,11 @@ func (l Label) LayoutDetailed(gtx layout.Context, lt *text.Shaper, font font.Fon
 		}
 	}
 	call := m.Stop()
+	it.padEffects(gtx)
 	viewport.Min = viewport.Min.Add(it.padding.Min)
 	viewport.Max = viewport.Max.Add(it.padding.Max)
 	clipStack := clip.Rect(viewport).Push(gtx.Ops)
+	it.paintEffects(gtx)
 	call.Add(gtx.Ops)
 	dims := layout.Dimensions{Size: it.bounds.Size()}
 	dims.Size = cs.Constrain(dims.Size)
@@ -97,6 +152,55 @@ func (l Label) LayoutDetailed(gtx layout.Context, lt *text.Shaper, font font.Fon
 	return dims, TextInfo{Truncated: it.truncated}
 }
 
+// Measure returns the dimensions of the label laid out with the given shaper,
+// font, size and text, without displaying it. The layout is cached by the shaper,
+// so displaying the label with the same arguments afterwards is cheap.
+func (l Label) Measure(gtx layout.Context, lt *text.Shaper, font font.Font, size unit.Sp, txt string) layout.Dimensions {
+	m := lt.Measure(l.parameters(gtx, font, size), txt)
+	dims := layout.Dimensions{Size: gtx.Constraints.Constrain(m.Bounds.Size())}
+	if len(m.Lines) > 0 {
+		baseline := m.Lines[0].Baseline
+		if gtx.Locale.Direction.Axis() == system.Vertical {
+			// The baseline of Layout is the position of the first glyph.
+			baseline = m.Lines[0].Bounds.Min.Y
+		}
+		dims.Baseline = dims.Size.Y - baseline
+	}
+	return dims
+}
+
+// parameters returns the parameters laying out the text of the label.
+func (l Label) parameters(gtx layout.Context, font font.Font, size unit.Sp) text.Parameters {
+	maxWidth, minWidth := lineLength(gtx.Constraints, gtx.Locale)
+	return text.Parameters{
+		Font:            font,
+		PxPerEm:         fixed.I(gtx.Sp(size)),
+		MaxLines:        l.MaxLines,
+		Truncator:       l.Truncator,
+		Alignment:       l.Alignment,
+		WrapPolicy:      l.WrapPolicy,
+		MaxWidth:        maxWidth,
+		MinWidth:        minWidth,
+		Locale:          gtx.Locale,
+		LineHeight:      fixed.I(gtx.Sp(l.LineHeight)),
+		LineHeightScale: l.LineHeightScale,
+		TabWidth:        l.TabWidth,
+		TabStops:        tabStops(gtx, l.TabStops, nil),
+		LetterSpacing:   fixed.I(gtx.Sp(l.LetterSpacing)),
+		WordSpacing:     fixed.I(gtx.Sp(l.WordSpacing)),
+		Features:        l.Features,
+	}
+}
+
+// lineLength returns the maximum and minimum length of the lines of text laid
+// out within cs. Lines of vertical text extend along the Y axis.
+func lineLength(cs layout.Constraints, lc system.Locale) (maxLen, minLen int) {
+	if lc.Direction.Axis() == system.Vertical {
+		return cs.Max.Y, cs.Min.Y
+	}
+	return cs.Max.X, cs.Min.X
+}
+
 // textIterator computes the bounding box of and paints text.
 type textIterator struct {
 	// viewport is the rectangle of document coordinates that the iterator is
@@ -108,6 +212,21 @@ type textIterator struct {
 	// the color of the glyphs is undefined and may change unpredictably if the
 	// text contains color glyphs.
 	material op.CallOp
+	// spanMaterials, if set, overrides material for the glyphs of each span of
+	// text laid out with text.Shaper.LayoutSpans, indexed by span.
+	spanMaterials []op.CallOp
+	// decoration selects the lines drawn along the glyphs, and spanDecorations,
+	// if set, overrides it for each span like spanMaterials.
+	decoration      text.Decoration
+	spanDecorations []text.Decoration
+	// outline and shadow configure the effects drawn behind the glyphs. The
+	// effects of all glyphs must be drawn before any glyph, so paintLine
+	// records the glyph paths to draw them with in effects.
+	outline TextOutline
+	shadow  TextShadow
+	effects []textEffect
+	// lcd configures the subpixel coverage of the glyphs.
+	lcd TextLCD
 	// truncated tracks the count of truncated runes in the text.
 	truncated int
 	// linesSeen tracks the quantity of line endings this iterator has seen.
@@ -143,32 +262,43 @@ func (it *textIterator) processGlyph(g text.Glyph, ok bool) (visibleOrBefore boo
 			return false
 		}
 	}
-	// Compute the maximum extent to which glyphs overhang on the horizontal
-	// axis.
-	if d := g.Bounds.Min.X.Floor(); d < it.padding.Min.X {
-		// If the distance between the dot and the left edge of this glyph is
-		// less than the current padding, increase the left padding.
+	// logical is the logical extent of the glyph relative to the dot.
+	logical := fixed.Rectangle26_6{
+		Min: fixed.Point26_6{Y: -g.Ascent},
+		Max: fixed.Point26_6{X: g.Advance, Y: g.Descent},
+	}
+	if g.Flags&text.FlagVertical != 0 {
+		// Vertical glyphs advance downward, and ascend to the right.
+		logical = fixed.Rectangle26_6{
+			Min: fixed.Point26_6{X: -g.Descent},
+			Max: fixed.Point26_6{X: g.Ascent, Y: g.Advance},
+		}
+	}
+	// Compute the maximum extent to which glyphs overhang their logical
+	// bounds.
+	if d := (g.Bounds.Min.X - logical.Min.X).Floor(); d < it.padding.Min.X {
+		// If the left edge of this glyph is left of its logical bounds by more
+		// than the current padding, increase the left padding.
 		it.padding.Min.X = d
 	}
-	if d := (g.Bounds.Max.X - g.Advance).Ceil(); d > it.padding.Max.X {
-		// If the distance between the dot and the right edge of this glyph
-		// minus the logical advance of this glyph is greater than the current
-		// padding, increase the right padding.
+	if d := (g.Bounds.Max.X - logical.Max.X).Ceil(); d > it.padding.Max.X {
+		// If the right edge of this glyph is right of its logical bounds by
+		// more than the current padding, increase the right padding.
 		it.padding.Max.X = d
 	}
-	if d := (g.Bounds.Min.Y + g.Ascent).Floor(); d < it.padding.Min.Y {
-		// If the distance between the dot and the top of this glyph is greater
-		// than the ascent of the glyph, increase the top padding.
+	if d := (g.Bounds.Min.Y - logical.Min.Y).Floor(); d < it.padding.Min.Y {
+		// If the top of this glyph is above its logical bounds by more than
+		// the current padding, increase the top padding.
 		it.padding.Min.Y = d
 	}
-	if d := (g.Bounds.Max.Y - g.Descent).Ceil(); d > it.padding.Max.Y {
-		// If the distance between the dot and the bottom of this glyph is greater
-		// than the descent of the glyph, increase the bottom padding.
+	if d := (g.Bounds.Max.Y - logical.Max.Y).Ceil(); d > it.padding.Max.Y {
+		// If the bottom of this glyph is below its logical bounds by more than
+		// the current padding, increase the bottom padding.
 		it.padding.Max.Y = d
 	}
 	logicalBounds := image.Rectangle{
-		Min: image.Pt(g.X.Floor(), int(g.Y)-g.Ascent.Ceil()),
-		Max: image.Pt((g.X + g.Advance).Ceil(), int(g.Y)+g.Descent.Ceil()),
+		Min: image.Pt((g.X + logical.Min.X).Floor(), int(g.Y)+logical.Min.Y.Floor()),
+		Max: image.Pt((g.X + logical.Max.X).Ceil(), int(g.Y)+logical.Max.Y.Ceil()),
 	}
 	if !it.first {
 		it.first = true
@@ -204,23 +334,134 @@ func fixedToFloat(i fixed.Int26_6) float32 {
 func (it *textIterator) paintGlyph(gtx layout.Context, shaper *text.Shaper, glyph text.Glyph, line []text.Glyph) ([]text.Glyph, bool) {
 	visibleOrBefore := it.processGlyph(glyph, true)
 	if it.visible {
+		perSpan := it.spanMaterials != nil || it.spanDecorations != nil
+		if len(line) > 0 && perSpan && line[len(line)-1].Span != glyph.Span {
+			// Spans may use different materials, so paint each separately.
+			line = it.paintLine(gtx, shaper, line)
+		}
 		if len(line) == 0 {
 			it.lineOff = f32.Point{X: fixedToFloat(glyph.X), Y: float32(glyph.Y)}.Sub(layout.FPt(it.viewport.Min))
 		}
 		line = append(line, glyph)
 	}
 	if glyph.Flags&text.FlagLineBreak != 0 || cap(line)-len(line) == 0 || !visibleOrBefore {
-		t := op.Affine(f32.Affine2D{}.Offset(it.lineOff)).Push(gtx.Ops)
-		path := shaper.Shape(line)
+		line = it.paintLine(gtx, shaper, line)
+	}
+	return line, visibleOrBefore
+}
+
+// paintLine paints the buffered glyphs of line, returning the emptied slice.
+func (it *textIterator) paintLine(gtx layout.Context, shaper *text.Shaper, line []text.Glyph) []text.Glyph {
+	material := it.material
+	if len(line) > 0 && line[0].Span < len(it.spanMaterials) {
+		material = it.spanMaterials[line[0].Span]
+	}
+	decoration := it.decoration
+	if len(line) > 0 && line[0].Span < len(it.spanDecorations) {
+		decoration = it.spanDecorations[line[0].Span]
+	}
+	t := op.Affine(f32.Affine2D{}.Offset(it.lineOff)).Push(gtx.Ops)
+	path := shaper.Shape(line)
+	if it.hasEffects() && len(line) > 0 {
+		it.effects = append(it.effects, textEffect{off: it.lineOff, path: path})
+	}
+	var lcd op.CallOp
+	if it.spanMaterials == nil && !it.hasEffects() {
+		lcd = shaper.LCD(line, it.lcd.Color, it.lcd.Background)
+	}
+	if lcd != (op.CallOp{}) {
+		lcd.Add(gtx.Ops)
+	} else {
 		outline := clip.Outline{Path: path}.Op().Push(gtx.Ops)
-		it.material.Add(gtx.Ops)
+		material.Add(gtx.Ops)
+		paint.PaintOp{}.Add(gtx.Ops)
+		outline.Pop()
+	}
+	if decoration != 0 {
+		outline := clip.Outline{Path: shaper.Decorations(line, decoration)}.Op().Push(gtx.Ops)
+		material.Add(gtx.Ops)
 		paint.PaintOp{}.Add(gtx.Ops)
 		outline.Pop()
-		if call := shaper.Bitmaps(line); call != (op.CallOp{}) {
-			call.Add(gtx.Ops)
+	}
+	shaper.PaintBitmaps(gtx.Ops, line, material)
+	t.Pop()
+	return line[:0]
+}
+
+// textEffect holds the path of glyphs painted by paintLine, and its offset.
+type textEffect struct {
+	off  f32.Point
+	path clip.PathSpec
+}
+
+// hasEffects reports whether the iterator draws outlines or shadows.
+func (it *textIterator) hasEffects() bool {
+	return it.outline.Width > 0 && it.outline.Material != (op.CallOp{}) || it.shadow.Material != (op.CallOp{})
+}
+
+// padEffects extends the padding of the iterator to include the outline and
+// shadow of the glyphs.
+func (it *textIterator) padEffects(gtx layout.Context) {
+	if !it.hasEffects() {
+		return
+	}
+	var pad image.Rectangle
+	if it.outline.Material != (op.CallOp{}) {
+		w := (gtx.Dp(it.outline.Width) + 1) / 2
+		pad = image.Rect(-w, -w, w, w)
+	}
+	if it.shadow.Material != (op.CallOp{}) {
+		blur := gtx.Dp(it.shadow.Blur)
+		off := image.Pt(gtx.Dp(it.shadow.OffsetX), gtx.Dp(it.shadow.OffsetY))
+		shadow := pad.Add(off).Inset(-blur)
+		pad.Min = image.Pt(min(pad.Min.X, shadow.Min.X), min(pad.Min.Y, shadow.Min.Y))
+		pad.Max = image.Pt(max(pad.Max.X, shadow.Max.X), max(pad.Max.Y, shadow.Max.Y))
+	}
+	it.padding.Min = it.padding.Min.Add(pad.Min)
+	it.padding.Max = it.padding.Max.Add(pad.Max)
+}
+
+// maxShadowLayers limits the number of layers approximating a blurred shadow.
+const maxShadowLayers = 8
+
+// paintEffects paints the shadow and the outline of the glyphs painted by
+// paintLine, to be covered by the glyphs.
+func (it *textIterator) paintEffects(gtx layout.Context) {
+	outline := float32(gtx.Dp(it.outline.Width))
+	if it.shadow.Material != (op.CallOp{}) {
+		// A blurred shadow is approximated by layers of strokes of decreasing
+		// width, whose opacities accumulate to fade the shadow out linearly.
+		blur := float32(gtx.Dp(it.shadow.Blur))
+		layers := min(int(math.Ceil(float64(blur)/2)), maxShadowLayers)
+		off := f32.Pt(float32(gtx.Dp(it.shadow.OffsetX)), float32(gtx.Dp(it.shadow.OffsetY)))
+		for _, e := range it.effects {
+			t := op.Affine(f32.Affine2D{}.Offset(e.off.Add(off))).Push(gtx.Ops)
+			for i := 0; i < layers; i++ {
+				width := outline + 2*blur*float32(layers-i)/float32(layers)
+				opacity := paint.PushOpacity(gtx.Ops, 1/float32(layers-i+1))
+				it.paintEffect(gtx, clip.Stroke{Path: e.path, Width: width}.Op(), it.shadow.Material)
+				opacity.Pop()
+			}
+			if outline > 0 {
+				it.paintEffect(gtx, clip.Stroke{Path: e.path, Width: outline}.Op(), it.shadow.Material)
+			}
+			it.paintEffect(gtx, clip.Outline{Path: e.path}.Op(), it.shadow.Material)
+			t.Pop()
 		}
-		t.Pop()
-		line = line[:0]
 	}
-	return line, visibleOrBefore
+	if outline > 0 && it.outline.Material != (op.CallOp{}) {
+		for _, e := range it.effects {
+			t := op.Affine(f32.Affine2D{}.Offset(e.off)).Push(gtx.Ops)
+			it.paintEffect(gtx, clip.Stroke{Path: e.path, Width: outline}.Op(), it.outline.Material)
+			t.Pop()
+		}
+	}
+}
+
+// paintEffect fills shape with material.
+func (it *textIterator) paintEffect(gtx layout.Context, shape clip.Op, material op.CallOp) {
+	s := shape.Push(gtx.Ops)
+	material.Add(gtx.Ops)
+	paint.PaintOp{}.Add(gtx.Ops)
+	s.Pop()
 }
@@ -5,7 +5,12 @@ import (
 	"math"
 	"testing"
 
+	"gioui.org/font"
+	"gioui.org/font/gofont"
+	"gioui.org/layout"
+	"gioui.org/op"
 	"gioui.org/text"
+	"gioui.org/unit"
 	"golang.org/x/image/math/fixed"
 )
 
@@ -227,3 +232,79 @@ func TestGlyphIteratorPadding(t *testing.T) {
 		})
 	}
 }
+
+// TestGlyphIteratorEffects ensures that the glyph iterator records the glyph paths
+// of outlines and shadows, and pads the text to include them.
+func TestGlyphIteratorEffects(t *testing.T) {
+	gtx := layout.Context{
+		Ops:    new(op.Ops),
+		Metric: unit.Metric{PxPerDp: 1, PxPerSp: 1},
+	}
+	shaper := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	material := op.Record(gtx.Ops).Stop()
+	paint := func(it *textIterator) {
+		shaper.LayoutString(text.Parameters{PxPerEm: fixed.I(16), MaxWidth: 1000}, "one\ntwo")
+		var glyphs [32]text.Glyph
+		line := glyphs[:0]
+		for g, ok := shaper.NextGlyph(); ok; g, ok = shaper.NextGlyph() {
+			line, _ = it.paintGlyph(gtx, shaper, g, line)
+		}
+		it.padEffects(gtx)
+		it.paintEffects(gtx)
+	}
+
+	plain := textIterator{viewport: image.Rectangle{Max: image.Pt(1000, 1000)}, material: material}
+	paint(&plain)
+	if len(plain.effects) != 0 {
+		t.Errorf("expected no effects without outline or shadow, got %d", len(plain.effects))
+	}
+
+	it := textIterator{
+		viewport: image.Rectangle{Max: image.Pt(1000, 1000)},
+		material: material,
+		outline:  TextOutline{Width: 4, Material: material},
+		shadow:   TextShadow{OffsetX: 3, OffsetY: -2, Blur: 5, Material: material},
+	}
+	paint(&it)
+	if len(it.effects) != 2 {
+		t.Errorf("expected the effects of 2 lines, got %d", len(it.effects))
+	}
+	// The outline extends 2px around the glyphs, and the shadow extends its
+	// outline by 5px of blur, offset by (3, -2).
+	want := image.Rect(-4, -9, 10, 5)
+	got := image.Rectangle{Min: it.padding.Min.Sub(plain.padding.Min), Max: it.padding.Max.Sub(plain.padding.Max)}
+	if got != want {
+		t.Errorf("expected padding %v beyond the glyphs, got %v", want, got)
+	}
+}
+
+// TestLabelMeasure ensures that labels are measured with the dimensions they are
+// laid out with.
+func TestLabelMeasure(t *testing.T) {
+	shaper := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	for _, tc := range []struct {
+		name  string
+		label Label
+		str   string
+	}{
+		{name: "empty"},
+		{name: "wrapped", str: "The quick brown fox jumps over the lazy dog"},
+		{name: "paragraphs", str: "one\n\ntwo\n"},
+		{name: "truncated", label: Label{MaxLines: 2}, str: "The quick brown fox jumps over the lazy dog"},
+		{name: "centered", label: Label{Alignment: text.Middle}, str: "one two three four"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gtx := layout.Context{
+				Ops:         new(op.Ops),
+				Metric:      unit.Metric{PxPerDp: 1, PxPerSp: 1},
+				Constraints: layout.Exact(image.Pt(100, 1000)),
+			}
+			gtx.Constraints.Min.Y = 0
+			measured := tc.label.Measure(gtx, shaper, font.Font{}, 16, tc.str)
+			laidOut := tc.label.Layout(gtx, shaper, font.Font{}, 16, tc.str, op.CallOp{})
+			if measured != laidOut {
+				t.Errorf("expected measured dimensions %+v, got %+v", laidOut, measured)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+// lineTree holds values attached to the lines of a text, which end after a
+// newline or at the end of the text. The values are stored in a balanced tree
+// ordered by the position of their lines, which keeps the measures of the
+// lines of every subtree, so that finding the line at a rune offset and
+// replacing lines cost time logarithmic in the number of lines.
+type lineTree[T lineValue] struct {
+	root *lineNode[T]
+	// seed is the state of the generator of node priorities.
+	seed uint32
+}
+
+// lineValue is a value attached to a line.
+type lineValue interface {
+	// measure returns the measures of the line.
+	measure() lineSum
+}
+
+// lineNode is a node of the treap of lines. Nodes are ordered by the position
+// of their lines, and every node has a higher priority than its children.
+type lineNode[T lineValue] struct {
+	value T
+	// sum measures the lines of the subtree.
+	sum         lineSum
+	priority    uint32
+	left, right *lineNode[T]
+}
+
+// lineSum measures a sequence of lines.
+type lineSum struct {
+	count        int
+	runes, bytes int
+	// stale is the number of lines whose values must be recomputed.
+	stale int
+}
+
+// add returns the measures of the lines of s followed by the lines of o.
+func (s lineSum) add(o lineSum) lineSum {
+	return lineSum{
+		count: s.count + o.count,
+		runes: s.runes + o.runes,
+		bytes: s.bytes + o.bytes,
+		stale: s.stale + o.stale,
+	}
+}
+
+// sum returns the measures of every line.
+func (t *lineTree[T]) sum() lineSum {
+	return t.root.sumOf()
+}
+
+// find returns the index of the first line for which f reports true when
+// given the measures of the lines up to and including it, the line and the
+// measures of the lines before it. f must report true for a sequence of lines
+// if it does for a shorter sequence. If f reports false for every line, find
+// returns the last line.
+func (t *lineTree[T]) find(f func(s lineSum) bool) (int, *T, lineSum) {
+	var before lineSum
+	n := t.root
+	for n != nil {
+		left := before.add(n.left.sumOf())
+		if n.left != nil && f(left) {
+			n = n.left
+			continue
+		}
+		through := left.add(n.value.measure())
+		if f(through) || n.right == nil {
+			return left.count, &n.value, left
+		}
+		before, n = through, n.right
+	}
+	return 0, nil, before
+}
+
+// atRune returns the index of the line containing the rune offset r, or of the
+// last line if r is the end of the text, the line and the measures of the lines
+// before it.
+func (t *lineTree[T]) atRune(r int) (int, *T, lineSum) {
+	return t.find(func(s lineSum) bool {
+		return s.runes > r
+	})
+}
+
+// at returns the line at index i and the measures of the lines before it.
+func (t *lineTree[T]) at(i int) (*T, lineSum) {
+	_, v, before := t.find(func(s lineSum) bool {
+		return s.count > i
+	})
+	return v, before
+}
+
+// update recomputes the measures of the subtrees containing the line at index
+// i, after its value changed.
+func (t *lineTree[T]) update(i int) {
+	t.updatePath(t.root, i)
+}
+
+// replace replaces the lines [i,j) with values.
+func (t *lineTree[T]) replace(i, j int, values []T) {
+	left, rest := t.split(t.root, i)
+	_, right := t.split(rest, j-i)
+	for _, v := range values {
+		left = t.merge(left, t.newNode(v))
+	}
+	t.root = t.merge(left, right)
+}
+
+// each calls f for every line in order, and updates the measures of the tree.
+func (t *lineTree[T]) each(f func(v *T)) {
+	t.walk(t.root, f)
+}
+
+func (t *lineTree[T]) walk(n *lineNode[T], f func(v *T)) {
+	if n == nil {
+		return
+	}
+	t.walk(n.left, f)
+	f(&n.value)
+	t.walk(n.right, f)
+	n.update()
+}
+
+// newNode returns a node for the line v.
+func (t *lineTree[T]) newNode(v T) *lineNode[T] {
+	// Generate priorities with a xorshift generator.
+	if t.seed == 0 {
+		t.seed = 2463534242
+	}
+	t.seed ^= t.seed << 13
+	t.seed ^= t.seed >> 17
+	t.seed ^= t.seed << 5
+	n := &lineNode[T]{value: v, priority: t.seed}
+	n.update()
+	return n
+}
+
+// split splits the subtree n into the subtrees of its first i lines and of the
+// remaining lines.
+func (t *lineTree[T]) split(n *lineNode[T], i int) (*lineNode[T], *lineNode[T]) {
+	if n == nil {
+		return nil, nil
+	}
+	l := n.left.sumOf().count
+	if i <= l {
+		left, right := t.split(n.left, i)
+		n.left = right
+		n.update()
+		return left, n
+	}
+	left, right := t.split(n.right, i-l-1)
+	n.right = left
+	n.update()
+	return n, right
+}
+
+// merge returns the subtree of the lines of l followed by the lines of r.
+func (t *lineTree[T]) merge(l, r *lineNode[T]) *lineNode[T] {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case l.priority > r.priority:
+		l.right = t.merge(l.right, r)
+		l.update()
+		return l
+	default:
+		r.left = t.merge(l, r.left)
+		r.update()
+		return r
+	}
+}
+
+// updatePath updates the measures of the subtrees of n containing the line at
+// index i of n.
+func (t *lineTree[T]) updatePath(n *lineNode[T], i int) {
+	if n == nil {
+		return
+	}
+	switch l := n.left.sumOf().count; {
+	case i < l:
+		t.updatePath(n.left, i)
+	case i > l:
+		t.updatePath(n.right, i-l-1)
+	}
+	n.update()
+}
+
+func (n *lineNode[T]) sumOf() lineSum {
+	if n == nil {
+		return lineSum{}
+	}
+	return n.sum
+}
+
+// update recomputes the measures of n from its line and its children.
+func (n *lineNode[T]) update() {
+	n.sum = n.left.sumOf().add(n.value.measure()).add(n.right.sumOf())
+}
@@ -3,12 +3,14 @@
 package material
 
 import (
+	"image"
 	"image/color"
 
 	"gioui.org/font"
 	"gioui.org/internal/f32color"
 	"gioui.org/layout"
 	"gioui.org/op"
+	"gioui.org/op/clip"
 	"gioui.org/op/paint"
 	"gioui.org/text"
 	"gioui.org/unit"
@@ -32,7 +34,10 @@ type EditorStyle struct {
 	HintColor color.NRGBA
 	// SelectionColor is the color of the background for selected text.
 	SelectionColor color.NRGBA
-	Editor         *widget.Editor
+	// MatchColor is the color of the background for the matches of the
+	// editor search.
+	MatchColor color.NRGBA
+	Editor     *widget.Editor
 
 	shaper *text.Shaper
 }
@@ -49,6 +54,7 @@ func Editor(th *Theme, editor *widget.Editor, hint string) EditorStyle {
 		Hint:           hint,
 		HintColor:      f32color.MulAlpha(th.Palette.Fg, 0xbb),
 		SelectionColor: f32color.MulAlpha(th.Palette.ContrastBg, 0x60),
+		MatchColor:     f32color.MulAlpha(th.Palette.ContrastBg, 0x30),
 	}
 }
 
@@ -75,6 +81,9 @@ func (e EditorStyle) Layout(gtx layout.Context) layout.Dimensions {
 		MaxLines:        maxlines,
 		LineHeight:      e.LineHeight,
 		LineHeightScale: e.LineHeightScale,
+		LetterSpacing:   e.Editor.LetterSpacing,
+		WordSpacing:     e.Editor.WordSpacing,
+		Features:        e.Editor.Features,
 	}
 	dims := tl.Layout(gtx, e.shaper, e.Font, e.TextSize, e.Hint, hintColor)
 	call := macro.Stop()
@@ -87,13 +96,37 @@ func (e EditorStyle) Layout(gtx layout.Context) layout.Dimensions {
 	}
 	e.Editor.LineHeight = e.LineHeight
 	e.Editor.LineHeightScale = e.LineHeightScale
+	macro = op.Record(gtx.Ops)
 	dims = e.Editor.Layout(gtx, e.shaper, e.Font, e.TextSize, textColor, selectionColor)
+	editor := macro.Stop()
+	// Paint the matches behind the text, at their positions after the editor
+	// processed its events.
+	e.paintMatches(gtx, dims.Size)
+	editor.Add(gtx.Ops)
 	if e.Editor.Len() == 0 {
 		call.Add(gtx.Ops)
 	}
 	return dims
 }
 
+// paintMatches paints the background of the visible matches of the editor
+// search.
+func (e EditorStyle) paintMatches(gtx layout.Context, size image.Point) {
+	matches := e.Editor.VisibleMatches()
+	if len(matches) == 0 {
+		return
+	}
+	defer clip.Rect{Max: size}.Push(gtx.Ops).Pop()
+	color := blendDisabledColor(!gtx.Enabled(), e.MatchColor)
+	var regions []widget.Region
+	for _, m := range matches {
+		regions = e.Editor.Regions(m.Start, m.End, regions)
+		for _, r := range regions {
+			paint.FillShape(gtx.Ops, color, clip.Rect(r.Bounds).Op())
+		}
+	}
+}
+
 func blendDisabledColor(disabled bool, c color.NRGBA) color.NRGBA {
 	if disabled {
 		return f32color.Disabled(c)
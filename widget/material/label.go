@@ -44,6 +44,27 @@ type LabelStyle struct {
 	// LineHeightScale applies a scaling factor to the LineHeight. If zero, a
 	// sensible default will be used.
 	LineHeightScale float32
+	// LetterSpacing is extra space added between the grapheme clusters of the
+	// text. Negative values tighten the text.
+	LetterSpacing unit.Sp
+	// WordSpacing is extra space added to the spaces between words.
+	WordSpacing unit.Sp
+	// Features configures the OpenType features used to shape the text, such as
+	// tabular numbers or disabled ligatures.
+	Features []text.Feature
+	// Decoration selects the lines drawn along the text, such as underlines.
+	Decoration text.Decoration
+	// OutlineColor and OutlineWidth configure an outline stroked along the
+	// edges of the glyphs, behind them. The outline is not drawn if
+	// OutlineWidth is zero.
+	OutlineColor color.NRGBA
+	OutlineWidth unit.Dp
+	// ShadowColor, ShadowOffsetX, ShadowOffsetY and ShadowBlur configure a
+	// shadow drawn behind the text. The shadow is not drawn if ShadowColor is
+	// transparent.
+	ShadowColor                  color.NRGBA
+	ShadowOffsetX, ShadowOffsetY unit.Dp
+	ShadowBlur                   unit.Dp
 
 	// Shaper is the text shaper used to display this labe. This field is automatically
 	// set using by all constructor functions. If constructing a LabelStyle literal, you
@@ -107,7 +128,9 @@ func Caption(th *Theme, txt string) LabelStyle {
 }
 
 func Overline(th *Theme, txt string) LabelStyle {
-	return Label(th, th.TextSize*10.0/16.0, txt)
+	label := Label(th, th.TextSize*10.0/16.0, txt)
+	label.LetterSpacing = th.TextSize * 1.5 / 16.0
+	return label
 }
 
 func Label(th *Theme, size unit.Sp, txt string) LabelStyle {
@@ -129,6 +152,25 @@ func (l LabelStyle) Layout(gtx layout.Context) layout.Dimensions {
 	selectColorMacro := op.Record(gtx.Ops)
 	paint.ColorOp{Color: l.SelectionColor}.Add(gtx.Ops)
 	selectColor := selectColorMacro.Stop()
+	var (
+		outline widget.TextOutline
+		shadow  widget.TextShadow
+	)
+	if l.OutlineWidth > 0 {
+		outlineColorMacro := op.Record(gtx.Ops)
+		paint.ColorOp{Color: l.OutlineColor}.Add(gtx.Ops)
+		outline = widget.TextOutline{Width: l.OutlineWidth, Material: outlineColorMacro.Stop()}
+	}
+	if l.ShadowColor.A > 0 {
+		shadowColorMacro := op.Record(gtx.Ops)
+		paint.ColorOp{Color: l.ShadowColor}.Add(gtx.Ops)
+		shadow = widget.TextShadow{
+			OffsetX:  l.ShadowOffsetX,
+			OffsetY:  l.ShadowOffsetY,
+			Blur:     l.ShadowBlur,
+			Material: shadowColorMacro.Stop(),
+		}
+	}
 
 	if l.State != nil {
 		if l.State.Text() != l.Text {
@@ -140,15 +182,40 @@ func (l LabelStyle) Layout(gtx layout.Context) layout.Dimensions {
 		l.State.WrapPolicy = l.WrapPolicy
 		l.State.LineHeight = l.LineHeight
 		l.State.LineHeightScale = l.LineHeightScale
+		l.State.LetterSpacing = l.LetterSpacing
+		l.State.WordSpacing = l.WordSpacing
+		l.State.Features = l.Features
+		l.State.Decoration = l.Decoration
+		l.State.Outline = outline
+		l.State.Shadow = shadow
 		return l.State.Layout(gtx, l.Shaper, l.Font, l.TextSize, textColor, selectColor)
 	}
-	tl := widget.Label{
+	tl := l.label()
+	tl.Outline = outline
+	tl.Shadow = shadow
+	return tl.Layout(gtx, l.Shaper, l.Font, l.TextSize, l.Text, textColor)
+}
+
+// Measure returns the dimensions of the label, without displaying it. The layout
+// is cached by the Shaper, so displaying the label afterwards is cheap. The
+// dimensions of selectable labels are measured as if they were not selectable.
+func (l LabelStyle) Measure(gtx layout.Context) layout.Dimensions {
+	return l.label().Measure(gtx, l.Shaper, l.Font, l.TextSize, l.Text)
+}
+
+// label returns the widget displaying the text of a label that isn't
+// selectable.
+func (l LabelStyle) label() widget.Label {
+	return widget.Label{
 		Alignment:       l.Alignment,
 		MaxLines:        l.MaxLines,
 		Truncator:       l.Truncator,
 		WrapPolicy:      l.WrapPolicy,
 		LineHeight:      l.LineHeight,
 		LineHeightScale: l.LineHeightScale,
+		LetterSpacing:   l.LetterSpacing,
+		WordSpacing:     l.WordSpacing,
+		Features:        l.Features,
+		Decoration:      l.Decoration,
 	}
-	return tl.Layout(gtx, l.Shaper, l.Font, l.TextSize, l.Text, textColor)
 }
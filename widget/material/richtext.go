@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package material
+
+import (
+	"image/color"
+
+	"gioui.org/font"
+	"gioui.org/internal/f32color"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"gioui.org/widget"
+)
+
+// SpanStyle describes the presentation of a span of text within a
+// RichTextStyle.
+type SpanStyle struct {
+	// Font defines the text style.
+	Font font.Font
+	// Size determines the size of the text glyphs.
+	Size unit.Sp
+	// Color is the text color.
+	Color color.NRGBA
+	// Content is the text of the span.
+	Content string
+	// Decoration selects the lines drawn along the span, such as underlines.
+	Decoration text.Decoration
+	// Interactive spans generate widget.SpanEvents when interacted with.
+	Interactive bool
+	// Widget, if non-nil, is displayed inline in place of Content.
+	Widget layout.Widget
+}
+
+// RichTextStyle configures the presentation of text composed of
+// individually styled spans.
+type RichTextStyle struct {
+	// State holds the selection and interaction state of the text.
+	State *widget.RichText
+	// Styles are the spans of text to display.
+	Styles []SpanStyle
+	// SelectionColor is the color of the background for selected text.
+	SelectionColor color.NRGBA
+
+	// Shaper is the text shaper used to display the text.
+	Shaper *text.Shaper
+}
+
+// RichText constructs a RichTextStyle from the provided spans. Spans with a
+// zero Typeface, Size or Color inherit them from the theme.
+func RichText(th *Theme, state *widget.RichText, spans ...SpanStyle) RichTextStyle {
+	styles := make([]SpanStyle, len(spans))
+	for i, sp := range spans {
+		if sp.Font.Typeface == "" {
+			sp.Font.Typeface = th.Face
+		}
+		if sp.Size == 0 {
+			sp.Size = th.TextSize
+		}
+		if sp.Color == (color.NRGBA{}) {
+			sp.Color = th.Palette.Fg
+		}
+		styles[i] = sp
+	}
+	return RichTextStyle{
+		State:          state,
+		Styles:         styles,
+		SelectionColor: f32color.MulAlpha(th.Palette.ContrastBg, 0x60),
+		Shaper:         th.Shaper,
+	}
+}
+
+func (r RichTextStyle) Layout(gtx layout.Context) layout.Dimensions {
+	spans := make([]widget.SpanStyle, 0, len(r.Styles))
+	for _, sp := range r.Styles {
+		colorMacro := op.Record(gtx.Ops)
+		paint.ColorOp{Color: sp.Color}.Add(gtx.Ops)
+		spans = append(spans, widget.SpanStyle{
+			Font:        sp.Font,
+			Size:        sp.Size,
+			Material:    colorMacro.Stop(),
+			Content:     sp.Content,
+			Decoration:  sp.Decoration,
+			Interactive: sp.Interactive,
+			Widget:      sp.Widget,
+		})
+	}
+	selectColorMacro := op.Record(gtx.Ops)
+	paint.ColorOp{Color: r.SelectionColor}.Add(gtx.Ops)
+	selectColor := selectColorMacro.Stop()
+	return r.State.Layout(gtx, r.Shaper, spans, selectColor)
+}
@@ -0,0 +1,247 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+import (
+	"math"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// paragraphTree holds the paragraphs of a textView. The paragraphs are stored
+// in a balanced tree ordered by their position within the text, which keeps
+// the measures of the paragraphs of every subtree, so that finding the
+// paragraph at a rune offset, line or coordinate and replacing paragraphs cost
+// time logarithmic in the number of paragraphs.
+type paragraphTree struct {
+	root *paragraph
+	// seed is the state of the generator of paragraph priorities.
+	seed uint32
+}
+
+// paragraph is a node of the treap of paragraphs. Nodes are ordered by their
+// position in the text, and every node has a higher priority than its
+// children.
+type paragraph struct {
+	paragraphLayout
+	// sum measures the paragraphs of the subtree.
+	sum         paragraphSum
+	priority    uint32
+	left, right *paragraph
+}
+
+// paragraphSum measures a sequence of paragraphs.
+type paragraphSum struct {
+	// count is the number of paragraphs, and unlaid the number of them that
+	// aren't laid out.
+	count, unlaid int
+	bytes, runes  int
+	// lines is the number of lines, and height the distance from the baseline
+	// before the paragraphs to the baseline of their last line.
+	lines, height int
+	// descent is the descent of the last line.
+	descent fixed.Int26_6
+	// minX and maxX bound the glyphs of the paragraphs that are laid out, and
+	// width is the natural width of their widest line.
+	minX, maxX, width int
+}
+
+// measure returns the measures of p alone.
+func (p *paragraphLayout) measure() paragraphSum {
+	s := paragraphSum{
+		count:   1,
+		bytes:   p.bytes,
+		runes:   p.runes,
+		lines:   p.lines,
+		height:  p.height,
+		descent: p.descent,
+		minX:    math.MaxInt,
+		maxX:    math.MinInt,
+	}
+	if !p.laidOut {
+		s.unlaid = 1
+		return s
+	}
+	s.minX, s.maxX, s.width = p.bounds.Min.X, p.bounds.Max.X, p.width
+	return s
+}
+
+// add returns the measures of the paragraphs of s followed by the paragraphs
+// of o.
+func (s paragraphSum) add(o paragraphSum) paragraphSum {
+	switch {
+	case s.count == 0:
+		return o
+	case o.count == 0:
+		return s
+	}
+	return paragraphSum{
+		count:   s.count + o.count,
+		unlaid:  s.unlaid + o.unlaid,
+		bytes:   s.bytes + o.bytes,
+		runes:   s.runes + o.runes,
+		lines:   s.lines + o.lines,
+		height:  s.height + o.height,
+		descent: o.descent,
+		minX:    min(s.minX, o.minX),
+		maxX:    max(s.maxX, o.maxX),
+		width:   max(s.width, o.width),
+	}
+}
+
+// textPos converts pos, relative to the paragraph after the paragraphs of s,
+// to a position in the text.
+func (s paragraphSum) textPos(pos combinedPos) combinedPos {
+	pos.runes += s.runes
+	pos.lineCol.line += s.lines
+	pos.y += s.height
+	return pos
+}
+
+// sum returns the measures of every paragraph.
+func (t *paragraphTree) sum() paragraphSum {
+	return t.root.sumOf()
+}
+
+// find returns the index of the first paragraph for which f reports true when
+// given the measures of the paragraphs up to and including it, the paragraph
+// and the measures of the paragraphs before it. f must report true for a
+// sequence of paragraphs if it does for a shorter sequence. If f reports false
+// for every paragraph, find returns the last paragraph.
+func (t *paragraphTree) find(f func(s paragraphSum) bool) (int, *paragraph, paragraphSum) {
+	var before paragraphSum
+	n := t.root
+	for n != nil {
+		left := before.add(n.left.sumOf())
+		if n.left != nil && f(left) {
+			n = n.left
+			continue
+		}
+		through := left.add(n.measure())
+		if f(through) || n.right == nil {
+			return left.count, n, left
+		}
+		before, n = through, n.right
+	}
+	return 0, nil, before
+}
+
+// at returns the paragraph at index i and the measures of the paragraphs
+// before it.
+func (t *paragraphTree) at(i int) (*paragraph, paragraphSum) {
+	_, p, before := t.find(func(s paragraphSum) bool {
+		return s.count > i
+	})
+	return p, before
+}
+
+// update recomputes the measures of the subtrees containing the paragraph at
+// index i, after its layout changed.
+func (t *paragraphTree) update(i int) {
+	t.updatePath(t.root, i)
+}
+
+// replace replaces the paragraphs [i,j) with layouts.
+func (t *paragraphTree) replace(i, j int, layouts []paragraphLayout) {
+	left, rest := t.split(t.root, i)
+	_, right := t.split(rest, j-i)
+	for _, l := range layouts {
+		left = t.merge(left, t.newParagraph(l))
+	}
+	t.root = t.merge(left, right)
+}
+
+// each calls f for every paragraph in order, and updates the measures of the
+// tree.
+func (t *paragraphTree) each(f func(p *paragraphLayout)) {
+	t.walk(t.root, f)
+}
+
+func (t *paragraphTree) walk(n *paragraph, f func(p *paragraphLayout)) {
+	if n == nil {
+		return
+	}
+	t.walk(n.left, f)
+	f(&n.paragraphLayout)
+	t.walk(n.right, f)
+	n.update()
+}
+
+// newParagraph returns a node for the paragraph l.
+func (t *paragraphTree) newParagraph(l paragraphLayout) *paragraph {
+	// Generate priorities with a xorshift generator.
+	if t.seed == 0 {
+		t.seed = 2463534242
+	}
+	t.seed ^= t.seed << 13
+	t.seed ^= t.seed >> 17
+	t.seed ^= t.seed << 5
+	n := &paragraph{paragraphLayout: l, priority: t.seed}
+	n.update()
+	return n
+}
+
+// split splits the subtree n into the subtrees of its first i paragraphs and
+// of the remaining paragraphs.
+func (t *paragraphTree) split(n *paragraph, i int) (*paragraph, *paragraph) {
+	if n == nil {
+		return nil, nil
+	}
+	l := n.left.sumOf().count
+	if i <= l {
+		left, right := t.split(n.left, i)
+		n.left = right
+		n.update()
+		return left, n
+	}
+	left, right := t.split(n.right, i-l-1)
+	n.right = left
+	n.update()
+	return n, right
+}
+
+// merge returns the subtree of the paragraphs of l followed by the paragraphs
+// of r.
+func (t *paragraphTree) merge(l, r *paragraph) *paragraph {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case l.priority > r.priority:
+		l.right = t.merge(l.right, r)
+		l.update()
+		return l
+	default:
+		r.left = t.merge(l, r.left)
+		r.update()
+		return r
+	}
+}
+
+// updatePath updates the measures of the subtrees of n containing the
+// paragraph at index i of n.
+func (t *paragraphTree) updatePath(n *paragraph, i int) {
+	if n == nil {
+		return
+	}
+	switch l := n.left.sumOf().count; {
+	case i < l:
+		t.updatePath(n.left, i)
+	case i > l:
+		t.updatePath(n.right, i-l-1)
+	}
+	n.update()
+}
+
+func (n *paragraph) sumOf() paragraphSum {
+	if n == nil {
+		return paragraphSum{}
+	}
+	return n.sum
+}
+
+// update recomputes the measures of n from its paragraph and its children.
+func (n *paragraph) update() {
+	n.sum = n.left.sumOf().add(n.measure()).add(n.right.sumOf())
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+// rangeTree holds style ranges in a balanced tree ordered by their start,
+// which keeps the largest end of the ranges of every subtree, so that finding
+// the ranges overlapping an interval costs time logarithmic in the number of
+// ranges, plus the number of ranges found. Edits move the ranges after them
+// lazily, by shifting whole subtrees.
+type rangeTree struct {
+	root *rangeNode
+	// seed is the state of the generator of node priorities.
+	seed uint32
+}
+
+// rangeNode is a node of the treap of ranges. Nodes are ordered by the start
+// of their ranges, and every node has a higher priority than its children.
+type rangeNode struct {
+	r StyleRange
+	// order is the index of the range in the ranges of SetStyles.
+	order int
+	// maxEnd is the largest end of the ranges of the subtree.
+	maxEnd int
+	// shift moves the ranges of the children, and is applied to them before
+	// they are accessed.
+	shift       int
+	priority    uint32
+	left, right *rangeNode
+}
+
+// insert adds the range r with the order of order.
+func (t *rangeTree) insert(r StyleRange, order int) {
+	left, right := t.split(t.root, r.Start)
+	t.root = t.merge(t.merge(left, t.newNode(r, order)), right)
+}
+
+// edit moves and shrinks the ranges after the runes [start,end) were replaced
+// by the runes [start,newEnd), and removes the ranges that become empty.
+func (t *rangeTree) edit(start, end, newEnd int) {
+	left, right := t.split(t.root, end)
+	// The ranges starting after the replaced runes move as a whole.
+	right.apply(newEnd - end)
+	// The ranges overlapping the replaced runes, or ending after them, shrink
+	// or move their end. The starts that move collapse to newEnd, which keeps
+	// the ranges ordered.
+	adjust := func(pos int) int {
+		switch {
+		case newEnd < pos && pos <= end:
+			pos = newEnd
+		case end < pos:
+			pos += newEnd - end
+		}
+		return pos
+	}
+	t.visit(left, min(end, newEnd), func(n *rangeNode) {
+		n.r.Start, n.r.End = adjust(n.r.Start), adjust(n.r.End)
+	})
+	// Ranges become empty at newEnd, and only ranges starting there can be
+	// empty.
+	left, mid := t.split(left, newEnd-1)
+	var kept []*rangeNode
+	t.walk(mid, func(n *rangeNode) {
+		if n.r.Start < n.r.End {
+			kept = append(kept, n)
+		}
+	})
+	for _, n := range kept {
+		n.left, n.right = nil, nil
+		n.update()
+		left = t.merge(left, n)
+	}
+	t.root = t.merge(left, right)
+}
+
+// overlapping calls f for the ranges overlapping the runes [start,end) in the
+// order of their start.
+func (t *rangeTree) overlapping(start, end int, f func(r StyleRange, order int)) {
+	t.overlap(t.root, start, end, f)
+}
+
+func (t *rangeTree) overlap(n *rangeNode, start, end int, f func(r StyleRange, order int)) {
+	if n == nil || n.maxEnd <= start {
+		return
+	}
+	n.push()
+	t.overlap(n.left, start, end, f)
+	if n.r.Start >= end {
+		return
+	}
+	if n.r.End > start {
+		f(n.r, n.order)
+	}
+	t.overlap(n.right, start, end, f)
+}
+
+// each calls f for every range in the order of their start.
+func (t *rangeTree) each(f func(r StyleRange, order int)) {
+	t.walk(t.root, func(n *rangeNode) {
+		f(n.r, n.order)
+	})
+}
+
+func (t *rangeTree) walk(n *rangeNode, f func(n *rangeNode)) {
+	if n == nil {
+		return
+	}
+	n.push()
+	// Read the children first, in case f detaches n.
+	left, right := n.left, n.right
+	t.walk(left, f)
+	f(n)
+	t.walk(right, f)
+}
+
+// visit calls f for the ranges of the subtree n that end after pos, and updates
+// the subtree.
+func (t *rangeTree) visit(n *rangeNode, pos int, f func(n *rangeNode)) {
+	if n == nil || n.maxEnd <= pos {
+		return
+	}
+	n.push()
+	t.visit(n.left, pos, f)
+	if n.r.End > pos {
+		f(n)
+	}
+	t.visit(n.right, pos, f)
+	n.update()
+}
+
+// newNode returns a node for the range r.
+func (t *rangeTree) newNode(r StyleRange, order int) *rangeNode {
+	// Generate priorities with a xorshift generator.
+	if t.seed == 0 {
+		t.seed = 2463534242
+	}
+	t.seed ^= t.seed << 13
+	t.seed ^= t.seed >> 17
+	t.seed ^= t.seed << 5
+	n := &rangeNode{r: r, order: order, priority: t.seed}
+	n.update()
+	return n
+}
+
+// split splits the subtree n into the subtrees of the ranges starting at or
+// before pos, and of the ranges starting after pos.
+func (t *rangeTree) split(n *rangeNode, pos int) (*rangeNode, *rangeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	n.push()
+	if n.r.Start > pos {
+		left, right := t.split(n.left, pos)
+		n.left = right
+		n.update()
+		return left, n
+	}
+	left, right := t.split(n.right, pos)
+	n.right = left
+	n.update()
+	return n, right
+}
+
+// merge returns the subtree of the ranges of l followed by the ranges of r.
+func (t *rangeTree) merge(l, r *rangeNode) *rangeNode {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case l.priority > r.priority:
+		l.push()
+		l.right = t.merge(l.right, r)
+		l.update()
+		return l
+	default:
+		r.push()
+		r.left = t.merge(l, r.left)
+		r.update()
+		return r
+	}
+}
+
+// apply moves the ranges of the subtree n by d.
+func (n *rangeNode) apply(d int) {
+	if n == nil || d == 0 {
+		return
+	}
+	n.r.Start += d
+	n.r.End += d
+	n.maxEnd += d
+	n.shift += d
+}
+
+// push applies the shift of n to its children.
+func (n *rangeNode) push() {
+	n.left.apply(n.shift)
+	n.right.apply(n.shift)
+	n.shift = 0
+}
+
+// update recomputes the largest end of n from its range and its children,
+// whose shifts must be applied.
+func (n *rangeNode) update() {
+	n.maxEnd = n.r.End
+	if n.left != nil {
+		n.maxEnd = max(n.maxEnd, n.left.maxEnd)
+	}
+	if n.right != nil {
+		n.maxEnd = max(n.maxEnd, n.right.maxEnd)
+	}
+}
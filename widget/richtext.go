@@ -0,0 +1,345 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+import (
+	"image"
+	"strings"
+	"time"
+
+	"gioui.org/font"
+	"gioui.org/gesture"
+	"gioui.org/io/event"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/text"
+	"gioui.org/unit"
+	"golang.org/x/image/math/fixed"
+)
+
+// SpanStyle describes the appearance and behavior of a span of text
+// within a RichText.
+type SpanStyle struct {
+	// Font describes the typeface of the span.
+	Font font.Font
+	// Size is the size of the span's glyphs.
+	Size unit.Sp
+	// Material sets the paint material of the span's glyphs.
+	Material op.CallOp
+	// Content is the text of the span.
+	Content string
+	// Decoration selects the lines drawn along the span, such as the
+	// underline of a hyperlink.
+	Decoration text.Decoration
+	// Interactive spans display a pointer cursor and generate SpanEvents
+	// when interacted with.
+	Interactive bool
+	// Widget, if non-nil, is displayed inline in place of Content. It is laid
+	// out with no minimum size, and its baseline is aligned with the baseline
+	// of the text. The text of the span is the single rune U+FFFC. Widgets of
+	// zero size are omitted.
+	Widget layout.Widget
+}
+
+// SpanEventKind describes the kind of interaction reported by a SpanEvent.
+type SpanEventKind uint8
+
+const (
+	// SpanClick is reported when an interactive span is clicked.
+	SpanClick SpanEventKind = iota
+	// SpanHover is reported when a pointer enters an interactive span.
+	SpanHover
+	// SpanUnhover is reported when a pointer leaves an interactive span.
+	SpanUnhover
+	// SpanLongPress is reported when an interactive span has been pressed
+	// for longer than the LongPressDuration of the RichText. A long press
+	// is not followed by a SpanClick when the pointer is released.
+	SpanLongPress
+)
+
+// SpanEvent describes an interaction with an interactive span.
+type SpanEvent struct {
+	Kind SpanEventKind
+	// Span is the index of the span within the spans most recently
+	// provided to RichText.Layout.
+	Span int
+	// Position is the position of the pointer relative to the RichText.
+	// It is only valid for SpanClick events.
+	Position image.Point
+}
+
+// defaultLongPressDuration is the default press duration for long presses.
+const defaultLongPressDuration = 500 * time.Millisecond
+
+// RichText displays selectable text composed of spans that may each have
+// their own style. The spans wrap as a single body of text, and individual
+// spans can be made interactive to implement hyperlinks.
+type RichText struct {
+	// Alignment controls the alignment of the text.
+	Alignment text.Alignment
+	// MaxLines is the maximum number of lines of text to be displayed.
+	MaxLines int
+	// Truncator is the symbol to use at the end of the final line of text
+	// if text was cut off. Defaults to "…" if left empty.
+	Truncator string
+	// WrapPolicy configures how displayed text will be broken into lines.
+	WrapPolicy text.WrapPolicy
+	// LineHeight controls the distance between the baselines of lines of text.
+	// If zero, a sensible default will be used.
+	LineHeight unit.Sp
+	// LineHeightScale applies a scaling factor to the LineHeight. If zero, a
+	// sensible default will be used.
+	LineHeightScale float32
+	// LetterSpacing is extra space added between the grapheme clusters of the
+	// text. Negative values tighten the text.
+	LetterSpacing unit.Sp
+	// WordSpacing is extra space added to the spaces between words.
+	WordSpacing unit.Sp
+	// Features configures the OpenType features used to shape the text, such as
+	// tabular numbers or disabled ligatures.
+	Features []text.Feature
+	// LongPressDuration is how long an interactive span must be pressed to
+	// generate a SpanLongPress event. If zero, a sensible default will be used.
+	LongPressDuration time.Duration
+
+	// sel provides text selection and the underlying textView.
+	sel Selectable
+	// content holds the concatenated content of the spans.
+	content strings.Builder
+	// spans, materials and decorations hold the shaping style, paint material
+	// and decoration of each span.
+	spans       []text.Span
+	materials   []op.CallOp
+	decorations []text.Decoration
+	// objects holds the recorded operations of the inline widget of each
+	// span, if any.
+	objects []op.CallOp
+	// interactive holds the interaction state of each span.
+	interactive []spanState
+	regions     []Region
+}
+
+// spanState tracks the interaction state of a span.
+type spanState struct {
+	click       gesture.Click
+	hovered     bool
+	pressed     bool
+	pressStart  time.Time
+	longPressed bool
+}
+
+// Focused returns whether the text is focused or not.
+func (r *RichText) Focused() bool {
+	return r.sel.Focused()
+}
+
+// Selection returns the start and end of the selection, as rune offsets.
+// start can be > end.
+func (r *RichText) Selection() (start, end int) {
+	return r.sel.Selection()
+}
+
+// SetCaret moves the caret to start, and sets the selection end to end. start
+// and end are in runes, and represent offsets into the text.
+func (r *RichText) SetCaret(start, end int) {
+	r.sel.SetCaret(start, end)
+}
+
+// SelectedText returns the currently selected text (if any).
+func (r *RichText) SelectedText() string {
+	return r.sel.SelectedText()
+}
+
+// ClearSelection clears the selection, by setting the selection end equal to
+// the selection start.
+func (r *RichText) ClearSelection() {
+	r.sel.ClearSelection()
+}
+
+// Text returns the concatenated contents of the spans.
+func (r *RichText) Text() string {
+	return r.sel.Text()
+}
+
+// Truncated returns whether the text has been truncated by the text shaper to
+// fit within available constraints.
+func (r *RichText) Truncated() bool {
+	return r.sel.Truncated()
+}
+
+// Regions returns visible regions covering the rune range [start,end).
+func (r *RichText) Regions(start, end int, regions []Region) []Region {
+	return r.sel.Regions(start, end, regions)
+}
+
+func (r *RichText) longPressDuration() time.Duration {
+	if r.LongPressDuration > 0 {
+		return r.LongPressDuration
+	}
+	return defaultLongPressDuration
+}
+
+// Update the state of the text in response to input events, returning the next
+// interaction with an interactive span, if any. To fully update the state of the
+// text, callers should call Update until it returns false.
+func (r *RichText) Update(gtx layout.Context) (SpanEvent, bool) {
+	r.sel.Update(gtx)
+	for i := range r.interactive {
+		st := &r.interactive[i]
+		for {
+			ev, ok := st.click.Update(gtx.Source)
+			if !ok {
+				break
+			}
+			switch ev.Kind {
+			case gesture.KindPress:
+				st.pressed = true
+				st.pressStart = gtx.Now
+				st.longPressed = false
+			case gesture.KindClick:
+				st.pressed = false
+				if !st.longPressed {
+					return SpanEvent{Kind: SpanClick, Span: i, Position: ev.Position}, true
+				}
+			case gesture.KindCancel:
+				st.pressed = false
+			}
+		}
+		if st.pressed && !st.longPressed && gtx.Now.Sub(st.pressStart) >= r.longPressDuration() {
+			st.longPressed = true
+			return SpanEvent{Kind: SpanLongPress, Span: i}, true
+		}
+		if hovered := st.click.Hovered(); hovered != st.hovered {
+			st.hovered = hovered
+			kind := SpanUnhover
+			if hovered {
+				kind = SpanHover
+			}
+			return SpanEvent{Kind: kind, Span: i}, true
+		}
+	}
+	return SpanEvent{}, false
+}
+
+// Layout lays out, paints and configures input handling for the provided spans,
+// painting selected text with the background selectionMaterial.
+func (r *RichText) Layout(gtx layout.Context, lt *text.Shaper, spans []SpanStyle, selectionMaterial op.CallOp) layout.Dimensions {
+	if len(r.interactive) != len(spans) {
+		r.interactive = make([]spanState, len(spans))
+	}
+	for {
+		_, ok := r.Update(gtx)
+		if !ok {
+			break
+		}
+	}
+	r.content.Reset()
+	r.spans = r.spans[:0]
+	r.materials = r.materials[:0]
+	r.decorations = r.decorations[:0]
+	r.objects = r.objects[:0]
+	for _, sp := range spans {
+		span := text.Span{
+			Font:    sp.Font,
+			PxPerEm: fixed.I(gtx.Sp(sp.Size)),
+			Content: sp.Content,
+		}
+		var object op.CallOp
+		if sp.Widget != nil {
+			span.Content = ""
+			span.Placeholder, object = r.layoutObject(gtx, sp.Widget)
+			if span.Placeholder != (text.Placeholder{}) {
+				span.Content = objectReplacement
+			}
+		}
+		r.content.WriteString(span.Content)
+		r.spans = append(r.spans, span)
+		r.materials = append(r.materials, sp.Material)
+		r.decorations = append(r.decorations, sp.Decoration)
+		r.objects = append(r.objects, object)
+	}
+	var (
+		defaultFont font.Font
+		defaultSize unit.Sp
+	)
+	if len(spans) > 0 {
+		defaultFont, defaultSize = spans[0].Font, spans[0].Size
+	}
+	r.sel.SetText(r.content.String())
+	r.sel.text.SetSpans(r.spans)
+	r.sel.text.Alignment = r.Alignment
+	r.sel.text.MaxLines = r.MaxLines
+	r.sel.text.Truncator = r.Truncator
+	r.sel.text.WrapPolicy = r.WrapPolicy
+	r.sel.text.LineHeight = r.LineHeight
+	r.sel.text.LineHeightScale = r.LineHeightScale
+	r.sel.text.LetterSpacing = r.LetterSpacing
+	r.sel.text.WordSpacing = r.WordSpacing
+	r.sel.text.Features = r.Features
+	r.sel.text.Layout(gtx, lt, defaultFont, defaultSize)
+	dims := r.sel.text.Dimensions()
+	defer clip.Rect(image.Rectangle{Max: dims.Size}).Push(gtx.Ops).Pop()
+	pointer.CursorText.Add(gtx.Ops)
+	event.Op(gtx.Ops, &r.sel)
+	r.sel.clicker.Add(gtx.Ops)
+	r.sel.dragger.Add(gtx.Ops)
+
+	r.sel.paintSelection(gtx, selectionMaterial)
+	r.sel.text.PaintSpans(gtx, op.CallOp{}, r.materials, r.decorations)
+	r.sel.text.PaintObjects(gtx, r.objects)
+
+	runes := 0
+	for i, sp := range spans {
+		start := runes
+		runes += len([]rune(r.spans[i].Content))
+		if !sp.Interactive {
+			continue
+		}
+		st := &r.interactive[i]
+		if st.pressed && !st.longPressed {
+			gtx.Execute(op.InvalidateCmd{At: st.pressStart.Add(r.longPressDuration())})
+		}
+		r.regions = r.sel.Regions(start, runes, r.regions)
+		for _, region := range r.regions {
+			area := clip.Rect(region.Bounds).Push(gtx.Ops)
+			pointer.CursorPointer.Add(gtx.Ops)
+			st.click.Add(gtx.Ops)
+			area.Pop()
+		}
+	}
+	return dims
+}
+
+// objectReplacement is the text of the spans of inline widgets.
+const objectReplacement = "\uFFFC"
+
+// layoutObject records the operations of an inline widget, and returns them
+// along with the space the widget occupies within the text.
+func (r *RichText) layoutObject(gtx layout.Context, w layout.Widget) (text.Placeholder, op.CallOp) {
+	gtx.Constraints.Min = image.Point{}
+	macro := op.Record(gtx.Ops)
+	dims := w(gtx)
+	call := macro.Stop()
+	return text.Placeholder{
+		Width:   fixed.I(dims.Size.X),
+		Ascent:  fixed.I(dims.Size.Y - dims.Baseline),
+		Descent: fixed.I(dims.Baseline),
+	}, call
+}
+
+func (k SpanEventKind) String() string {
+	switch k {
+	case SpanClick:
+		return "SpanClick"
+	case SpanHover:
+		return "SpanHover"
+	case SpanUnhover:
+		return "SpanUnhover"
+	case SpanLongPress:
+		return "SpanLongPress"
+	default:
+		panic("invalid SpanEventKind")
+	}
+}
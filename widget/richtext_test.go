@@ -0,0 +1,171 @@
+package widget
+
+import (
+	"image"
+	"testing"
+
+	"gioui.org/f32"
+	"gioui.org/font/gofont"
+	"gioui.org/gesture"
+	"gioui.org/io/input"
+	"gioui.org/io/pointer"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/text"
+)
+
+func TestRichTextSelection(t *testing.T) {
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(1000, 1000)),
+		Locale:      english,
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	spans := []SpanStyle{
+		{Size: 10, Content: "Visit "},
+		{Size: 14, Content: "the link", Interactive: true},
+		{Size: 10, Content: " now"},
+	}
+	var r RichText
+	r.Layout(gtx, cache, spans, op.CallOp{})
+	if got, want := r.Text(), "Visit the link now"; got != want {
+		t.Errorf("expected text %q, got %q", want, got)
+	}
+	r.SetCaret(4, 10)
+	if got, want := r.SelectedText(), "t the "; got != want {
+		t.Errorf("expected selected text %q, got %q", want, got)
+	}
+}
+
+func TestRichTextSpanClick(t *testing.T) {
+	router := new(input.Router)
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(1000, 1000)),
+		Locale:      english,
+		Source:      router.Source(),
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	spans := []SpanStyle{
+		{Size: 10, Content: "Visit "},
+		{Size: 10, Content: "the link", Interactive: true},
+		{Size: 10, Content: " now"},
+	}
+	var r RichText
+	r.Layout(gtx, cache, spans, op.CallOp{})
+	router.Frame(gtx.Ops)
+
+	regions := r.Regions(6, 14, nil)
+	if len(regions) != 1 {
+		t.Fatalf("expected 1 region for the link, got %d", len(regions))
+	}
+	center := regions[0].Bounds.Min.Add(regions[0].Bounds.Max).Div(2)
+	pos := f32.Pt(float32(center.X), float32(center.Y))
+	router.Queue(
+		pointer.Event{Kind: pointer.Press, Buttons: pointer.ButtonPrimary, Position: pos},
+		pointer.Event{Kind: pointer.Release, Buttons: pointer.ButtonPrimary, Position: pos},
+	)
+	var kinds []SpanEventKind
+	for {
+		ev, ok := r.Update(gtx)
+		if !ok {
+			break
+		}
+		if ev.Span != 1 {
+			t.Errorf("expected event for span 1, got %d", ev.Span)
+		}
+		kinds = append(kinds, ev.Kind)
+	}
+	clicked := false
+	for _, k := range kinds {
+		if k == SpanClick {
+			clicked = true
+		}
+	}
+	if !clicked {
+		t.Errorf("expected a SpanClick event, got %v", kinds)
+	}
+
+	// A click outside of the link must not be reported.
+	outside := f32.Pt(1, float32(center.Y))
+	router.Queue(
+		pointer.Event{Kind: pointer.Press, Buttons: pointer.ButtonPrimary, Position: outside},
+		pointer.Event{Kind: pointer.Release, Buttons: pointer.ButtonPrimary, Position: outside},
+	)
+	for {
+		ev, ok := r.Update(gtx)
+		if !ok {
+			break
+		}
+		if ev.Kind == SpanClick {
+			t.Errorf("unexpected click event outside of the link: %+v", ev)
+		}
+	}
+}
+
+func TestRichTextWidget(t *testing.T) {
+	router := new(input.Router)
+	gtx := layout.Context{
+		Ops:         new(op.Ops),
+		Constraints: layout.Exact(image.Pt(1000, 1000)),
+		Locale:      english,
+		Source:      router.Source(),
+	}
+	cache := text.NewShaper(text.NoSystemFonts(), text.WithCollection(gofont.Collection()))
+	var click gesture.Click
+	size := image.Pt(20, 30)
+	spans := []SpanStyle{
+		{Size: 10, Content: "Visit "},
+		{Size: 10, Widget: func(gtx layout.Context) layout.Dimensions {
+			for {
+				if _, ok := click.Update(gtx.Source); !ok {
+					break
+				}
+			}
+			defer clip.Rect{Max: size}.Push(gtx.Ops).Pop()
+			click.Add(gtx.Ops)
+			return layout.Dimensions{Size: size}
+		}},
+		{Size: 10, Content: " now"},
+	}
+	var r RichText
+	dims := r.Layout(gtx, cache, spans, op.CallOp{})
+	router.Frame(gtx.Ops)
+	if got, want := r.Text(), "Visit \uFFFC now"; got != want {
+		t.Errorf("expected text %q, got %q", want, got)
+	}
+	if dims.Size.Y < size.Y {
+		t.Errorf("expected the text to contain the widget, got height %d", dims.Size.Y)
+	}
+	regions := r.Regions(6, 7, nil)
+	if len(regions) != 1 || regions[0].Bounds.Dx() != size.X {
+		t.Fatalf("expected a region of width %d for the widget, got %+v", size.X, regions)
+	}
+
+	// The widget rests on the baseline.
+	baseline := regions[0].Bounds.Max.Y - regions[0].Baseline
+	clicked := func(x, y int) bool {
+		pos := f32.Pt(float32(x), float32(y))
+		router.Queue(
+			pointer.Event{Kind: pointer.Press, Buttons: pointer.ButtonPrimary, Position: pos},
+			pointer.Event{Kind: pointer.Release, Buttons: pointer.ButtonPrimary, Position: pos},
+		)
+		clicked := false
+		for {
+			ev, ok := click.Update(gtx.Source)
+			if !ok {
+				break
+			}
+			clicked = clicked || ev.Kind == gesture.KindClick
+		}
+		return clicked
+	}
+	x := regions[0].Bounds.Min.X + size.X/2
+	if !clicked(x, baseline-size.Y/2) {
+		t.Error("expected the widget to be displayed above the baseline")
+	}
+	if clicked(x, baseline+1) {
+		t.Error("unexpected click below the widget")
+	}
+}
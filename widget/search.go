@@ -0,0 +1,425 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"unicode/utf8"
+)
+
+// Search describes the text to find in an Editor.
+type Search struct {
+	// Query is the text to find. An empty Query matches nothing.
+	Query string
+	// Regexp interprets Query as a regular expression in the syntax of the
+	// regexp package. Replacements of its matches may refer to submatches, as
+	// described by [regexp.Regexp.Expand].
+	Regexp bool
+	// IgnoreCase matches letters regardless of their case.
+	IgnoreCase bool
+}
+
+// Match is a match of a Search, covering the runes [Start,End) of the
+// editor contents.
+type Match struct {
+	Start, End int
+}
+
+// editorSearch tracks the matches of the search of an Editor.
+//
+// Matches of most queries don't span line breaks, and are searched by
+// paragraph: the paragraphs are searched lazily, and an edit only discards the
+// matches of the paragraphs it changes. Queries that may match line breaks, or
+// depend on the start or end of the contents, search the whole contents.
+type editorSearch struct {
+	query Search
+	re    *regexp.Regexp
+	// whole reports whether the whole contents are searched at once.
+	whole bool
+	// paragraphs are the paragraphs of the contents, and empty until they are
+	// searched.
+	paragraphs lineTree[searchParagraph]
+	// matches caches all matches while valid is set.
+	matches []Match
+	valid   bool
+	// visible holds the matches returned by VisibleMatches.
+	visible []Match
+	scratch []byte
+}
+
+// searchParagraph is a paragraph of the contents of an Editor, which ends after
+// a line break or at the end of the contents.
+type searchParagraph struct {
+	runes, bytes int
+	// matches are the matches within the paragraph, relative to its start, if
+	// searched is set.
+	matches  []Match
+	searched bool
+}
+
+func (p searchParagraph) measure() lineSum {
+	s := lineSum{count: 1, runes: p.runes, bytes: p.bytes}
+	if !p.searched {
+		s.stale = 1
+	}
+	return s
+}
+
+// SetSearch configures the text to find in the editor, replacing any previous
+// search. It returns an error and clears the search if the query is an invalid
+// regular expression.
+func (e *Editor) SetSearch(s Search) error {
+	e.initBuffer()
+	old := e.search
+	e.search = editorSearch{query: s, matches: old.matches[:0], visible: old.visible[:0], scratch: old.scratch}
+	if s.Query == "" {
+		return nil
+	}
+	expr := s.Query
+	if !s.Regexp {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if s.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		e.search.query = Search{}
+		return err
+	}
+	e.search.re = re
+	// Parsing succeeds for every expression that compiles.
+	tree, _ := syntax.Parse(expr, syntax.Perl)
+	e.search.whole = spansParagraphs(tree)
+	// Keep the paragraphs, but not their matches.
+	e.search.paragraphs = old.paragraphs
+	e.search.paragraphs.each(func(p *searchParagraph) {
+		p.searched = false
+	})
+	return nil
+}
+
+// spansParagraphs reports whether matches of re may contain line breaks, or
+// depend on the start or end of the text.
+func spansParagraphs(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpBeginText, syntax.OpEndText:
+		return true
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == '\n' {
+				return true
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '\n' && '\n' <= re.Rune[i+1] {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if spansParagraphs(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns the search configured by SetSearch.
+func (e *Editor) Search() Search {
+	return e.search.query
+}
+
+// Matches returns the non-empty matches of the search, ordered by their
+// position. The returned slice must not be modified, and is valid until the
+// next change of the contents or the search.
+func (e *Editor) Matches() []Match {
+	e.initBuffer()
+	s := &e.search
+	if s.valid {
+		return s.matches
+	}
+	s.valid = true
+	s.matches = s.matches[:0]
+	switch {
+	case s.re == nil:
+	case s.whole:
+		s.scratch = e.text.Text(s.scratch)
+		s.matches = appendMatches(s.matches, s.scratch, s.re.FindAllIndex(s.scratch, -1), 0)
+	default:
+		e.splitSearch()
+		runes, off := 0, 0
+		s.paragraphs.each(func(p *searchParagraph) {
+			e.searchParagraph(p, off)
+			for _, m := range p.matches {
+				s.matches = append(s.matches, Match{Start: runes + m.Start, End: runes + m.End})
+			}
+			runes, off = runes+p.runes, off+p.bytes
+		})
+	}
+	return s.matches
+}
+
+// VisibleMatches returns the matches of the search that overlap the lines
+// within the viewport, ordered by their position. Unlike Matches, it only
+// searches the paragraphs of those lines. The returned slice must not be
+// modified, and is valid until the next call to VisibleMatches or the next
+// change of the contents or the search.
+func (e *Editor) VisibleMatches() []Match {
+	e.initBuffer()
+	s := &e.search
+	if s.re == nil {
+		return nil
+	}
+	start, end := e.text.VisibleRunes()
+	if !s.valid && !s.whole {
+		s.visible = e.appendParagraphMatches(s.visible[:0], start, end)
+		return s.visible
+	}
+	matches := e.Matches()
+	i := sort.Search(len(matches), func(i int) bool {
+		return matches[i].End > start
+	})
+	j := sort.Search(len(matches), func(i int) bool {
+		return matches[i].Start >= end
+	})
+	return matches[i:max(i, j)]
+}
+
+// appendParagraphMatches appends the matches overlapping the runes [start,end)
+// to ms, searching the paragraphs containing them if necessary.
+func (e *Editor) appendParagraphMatches(ms []Match, start, end int) []Match {
+	s := &e.search
+	e.splitSearch()
+	i, _, _ := s.paragraphs.atRune(start)
+	for n := s.paragraphs.sum().count; i < n; i++ {
+		p, before := s.paragraphs.at(i)
+		if before.runes >= end {
+			break
+		}
+		if !p.searched {
+			e.searchParagraph(p, before.bytes)
+			s.paragraphs.update(i)
+		}
+		for _, m := range p.matches {
+			m = Match{Start: before.runes + m.Start, End: before.runes + m.End}
+			if m.End > start && m.Start < end {
+				ms = append(ms, m)
+			}
+		}
+	}
+	return ms
+}
+
+// splitSearch splits the contents into the paragraphs of the search, if they
+// aren't already.
+func (e *Editor) splitSearch() {
+	s := &e.search
+	if s.paragraphs.root == nil {
+		s.scratch = e.text.Text(s.scratch)
+		s.paragraphs.replace(0, 0, appendParagraphs(nil, s.scratch, true))
+	}
+}
+
+// searchParagraph finds the matches of the paragraph p at the byte offset off,
+// unless they are known.
+func (e *Editor) searchParagraph(p *searchParagraph, off int) {
+	if p.searched {
+		return
+	}
+	text := e.readSearch(off, off+p.bytes)
+	p.matches = appendMatches(p.matches[:0], text, e.search.re.FindAllIndex(text, -1), 0)
+	p.searched = true
+}
+
+// searchEdited discards the matches of the search after the runes [start,end)
+// of the contents were replaced with n runes. Only the paragraphs containing
+// the replaced runes are searched again.
+func (e *Editor) searchEdited(start, end, n int) {
+	s := &e.search
+	s.valid = false
+	if s.paragraphs.root == nil {
+		return
+	}
+	// Replace the paragraphs from the one containing start to the one
+	// containing end, which a removed line break joins with the paragraph
+	// before it.
+	i, _, before := s.paragraphs.atRune(start)
+	j, p, pBefore := s.paragraphs.atRune(end)
+	endRunes := pBefore.runes + p.runes + n - (end - start)
+	text := e.readSearch(before.bytes, int(e.text.ByteOffset(endRunes)))
+	last := j == s.paragraphs.sum().count-1
+	s.paragraphs.replace(i, j+1, appendParagraphs(nil, text, last))
+}
+
+// searchText returns the text searched for the matches starting at rune r, and
+// its rune offset. The text is valid until the next call.
+func (e *Editor) searchText(r int) ([]byte, int) {
+	s := &e.search
+	if s.whole {
+		s.scratch = e.text.Text(s.scratch)
+		return s.scratch, 0
+	}
+	_, p, before := s.paragraphs.atRune(r)
+	return e.readSearch(before.bytes, before.bytes+p.bytes), before.runes
+}
+
+// readSearch returns the bytes [start,end) of the contents, valid until the
+// next call.
+func (e *Editor) readSearch(start, end int) []byte {
+	s := &e.search
+	if n := end - start; cap(s.scratch) < n {
+		s.scratch = make([]byte, n)
+	}
+	s.scratch = s.scratch[:end-start]
+	n, _ := e.text.ReadAt(s.scratch, int64(start))
+	return s.scratch[:n]
+}
+
+// appendParagraphs appends the paragraphs of text to ps. The text of
+// paragraphs other than the last ends with a line break, and last reports
+// whether text ends with the last paragraph, which doesn't.
+func appendParagraphs(ps []searchParagraph, text []byte, last bool) []searchParagraph {
+	for {
+		n := bytes.IndexByte(text, '\n') + 1
+		if n == 0 {
+			if last {
+				ps = append(ps, searchParagraph{runes: utf8.RuneCount(text), bytes: len(text)})
+			}
+			return ps
+		}
+		ps = append(ps, searchParagraph{runes: utf8.RuneCount(text[:n]), bytes: n})
+		text = text[n:]
+	}
+}
+
+// appendMatches appends the non-empty matches at the byte offsets locs of text
+// to ms, as rune offsets from runes.
+func appendMatches(ms []Match, text []byte, locs [][]int, runes int) []Match {
+	off := 0
+	for _, m := range locs {
+		if m[0] == m[1] {
+			continue
+		}
+		runes += utf8.RuneCount(text[off:m[0]])
+		start := runes
+		runes += utf8.RuneCount(text[m[0]:m[1]])
+		off = m[1]
+		ms = append(ms, Match{Start: start, End: runes})
+	}
+	return ms
+}
+
+// FindNext selects the first match after the selection, wrapping around to
+// the first match of the contents. It reports whether there is a match.
+func (e *Editor) FindNext() bool {
+	matches := e.Matches()
+	if len(matches) == 0 {
+		return false
+	}
+	start, end := e.Selection()
+	from := max(start, end)
+	i := sort.Search(len(matches), func(i int) bool {
+		return matches[i].Start >= from
+	})
+	if i == len(matches) {
+		i = 0
+	}
+	e.SetCaret(matches[i].End, matches[i].Start)
+	return true
+}
+
+// FindPrevious selects the last match before the selection, wrapping around to
+// the last match of the contents. It reports whether there is a match.
+func (e *Editor) FindPrevious() bool {
+	matches := e.Matches()
+	if len(matches) == 0 {
+		return false
+	}
+	start, end := e.Selection()
+	to := min(start, end)
+	i := sort.Search(len(matches), func(i int) bool {
+		return matches[i].End > to
+	})
+	if i == 0 {
+		i = len(matches)
+	}
+	e.SetCaret(matches[i-1].End, matches[i-1].Start)
+	return true
+}
+
+// ReplaceMatch replaces the selected match with s and selects the next match.
+// If the selection is not a match, it only selects the next match. It reports
+// whether a match was replaced.
+func (e *Editor) ReplaceMatch(s string) bool {
+	matches := e.Matches()
+	start, end := e.Selection()
+	sel := Match{Start: min(start, end), End: max(start, end)}
+	i := sort.Search(len(matches), func(i int) bool {
+		return matches[i].Start >= sel.Start
+	})
+	if i == len(matches) || matches[i] != sel {
+		e.FindNext()
+		return false
+	}
+	e.replaceMatches(s, i, i+1)
+	e.FindNext()
+	return true
+}
+
+// ReplaceAll replaces every match with s, as a single modification to undo. It
+// returns the number of matches replaced.
+func (e *Editor) ReplaceAll(s string) int {
+	n := len(e.Matches())
+	if n > 0 {
+		e.BeginGroup()
+		e.replaceMatches(s, 0, n)
+		e.EndGroup()
+	}
+	return n
+}
+
+// replaceMatches replaces the matches [from,to) with s, expanded for each match
+// if the search is a regular expression.
+func (e *Editor) replaceMatches(s string, from, to int) {
+	matches := append([]Match(nil), e.Matches()[from:to]...)
+	replacements := make([]string, len(matches))
+	for i := range replacements {
+		replacements[i] = s
+	}
+	if e.search.query.Regexp {
+		// Expand the replacements before the contents change, with the
+		// submatches of the search of the paragraph of each match.
+		var (
+			text     []byte
+			locs     [][]int
+			runes    int
+			off, end int
+		)
+		for i, m := range matches {
+			if text == nil || m.Start >= end {
+				text, runes = e.searchText(m.Start)
+				end = runes + utf8.RuneCount(text)
+				locs = e.search.re.FindAllSubmatchIndex(text, -1)
+				off = 0
+			}
+			for ; len(locs) > 0; locs = locs[1:] {
+				loc := locs[0]
+				runes += utf8.RuneCount(text[off:loc[0]])
+				off = loc[0]
+				if runes == m.Start && loc[0] != loc[1] {
+					replacements[i] = string(e.search.re.Expand(nil, []byte(s), text, loc))
+					break
+				}
+			}
+		}
+	}
+	// Replace from the end, so that earlier matches keep their positions.
+	for i := len(matches) - 1; i >= 0; i-- {
+		e.replace(matches[i].Start, matches[i].End, replacements[i], true)
+	}
+}
@@ -67,8 +67,23 @@ type Selectable struct {
 	// LineHeightScale applies a scaling factor to the LineHeight. If zero, a
 	// sensible default will be used.
 	LineHeightScale float32
-	initialized     bool
-	source          stringSource
+	// LetterSpacing is extra space added between the grapheme clusters of the
+	// text. Negative values tighten the text.
+	LetterSpacing unit.Sp
+	// WordSpacing is extra space added to the spaces between words.
+	WordSpacing unit.Sp
+	// Features configures the OpenType features used to shape the text, such as
+	// tabular numbers or disabled ligatures.
+	Features []text.Feature
+	// Decoration selects the lines drawn along the text, such as underlines.
+	Decoration text.Decoration
+	// Outline configures a stroked outline drawn behind the glyphs.
+	Outline TextOutline
+	// Shadow configures a shadow drawn behind the glyphs and their outline.
+	Shadow TextShadow
+
+	initialized bool
+	source      stringSource
 	// scratch is a buffer reused to efficiently read text out of the
 	// textView.
 	scratch   []byte
@@ -189,6 +204,12 @@ func (l *Selectable) Layout(gtx layout.Context, lt *text.Shaper, font font.Font,
 	l.text.MaxLines = l.MaxLines
 	l.text.Truncator = l.Truncator
 	l.text.WrapPolicy = l.WrapPolicy
+	l.text.LetterSpacing = l.LetterSpacing
+	l.text.WordSpacing = l.WordSpacing
+	l.text.Features = l.Features
+	l.text.Decoration = l.Decoration
+	l.text.Outline = l.Outline
+	l.text.Shadow = l.Shadow
 	l.text.Layout(gtx, lt, font, size)
 	dims := l.text.Dimensions()
 	defer clip.Rect(image.Rectangle{Max: dims.Size}).Push(gtx.Ops).Pop()
@@ -0,0 +1,479 @@
+// SPDX-License-Identifier: Unlicense OR MIT
+
+package widget
+
+import (
+	"bytes"
+	"image/color"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"gioui.org/font"
+	"gioui.org/layout"
+	"gioui.org/op"
+	"gioui.org/op/clip"
+	"gioui.org/op/paint"
+	"gioui.org/text"
+	"golang.org/x/exp/slices"
+)
+
+// TextStyle describes the appearance of a range of text in an Editor. Zero
+// fields leave the appearance of the text unchanged.
+type TextStyle struct {
+	// Color fills the glyphs of the text.
+	Color color.NRGBA
+	// Background fills the area behind the text.
+	Background color.NRGBA
+	// Weight and Style replace the weight and style of the editor font. Ranges
+	// that change the font reshape the paragraphs they cover when they
+	// change.
+	Weight font.Weight
+	Style  font.Style
+}
+
+// StyleRange styles the runes [Start,End) of the contents of an Editor. The
+// offsets are in runes rather than bytes, like the caret, selection and match
+// offsets of the Editor, so that ranges can't split a UTF-8 encoded rune.
+type StyleRange struct {
+	Start, End int
+	Style      TextStyle
+}
+
+// Tokenizer styles the contents of an Editor line by line, for example to
+// highlight syntax. Only the lines changed since the previous tokenization are
+// tokenized again, along with the lines following them until the state at the
+// start of a line is unchanged.
+type Tokenizer interface {
+	// Tokenize appends the style ranges of line, without its newline, to ranges
+	// and returns them. The offsets of the ranges are in runes from the start
+	// of line. state is the state returned for the previous line, or nil for the
+	// first line, and Tokenize returns the state at the end of line. States must
+	// be comparable with ==.
+	Tokenize(line string, state any, ranges []StyleRange) ([]StyleRange, any)
+}
+
+// editorStyles tracks the style ranges of an Editor.
+type editorStyles struct {
+	// ranges holds the ranges of SetStyles, and list caches them in their
+	// order while listed is set.
+	ranges    rangeTree
+	list      []StyleRange
+	listed    bool
+	tokenizer Tokenizer
+	// lines caches the tokenization of the lines of the contents.
+	lines lineTree[tokenLine]
+	// segments and materials are scratch space for painting, and
+	// fontSegments for shaping.
+	segments     []StyleRange
+	fontSegments []StyleRange
+	materials    []op.CallOp
+	regions      []Region
+}
+
+// tokenLine is the tokenization of a line, which ends after a newline or at
+// the end of the text.
+type tokenLine struct {
+	runes int
+	// in and out are the states at the start and end of the line.
+	in, out any
+	// ranges holds the style ranges of the line, relative to its start.
+	ranges []StyleRange
+	// dirty marks content that must be split into lines and tokenized.
+	dirty bool
+}
+
+func (l tokenLine) measure() lineSum {
+	s := lineSum{count: 1, runes: l.runes}
+	if l.dirty {
+		s.stale = 1
+	}
+	return s
+}
+
+// SetStyles replaces the style ranges of the editor. The ranges move and shrink
+// with edits of the contents, and are removed when they become empty. Where
+// ranges overlap, the non-zero fields of the styles of later ranges apply, and
+// ranges apply after the ranges of the tokenizer.
+func (e *Editor) SetStyles(ranges []StyleRange) {
+	e.initBuffer()
+	s := &e.styles
+	var list []StyleRange
+	for _, r := range ranges {
+		if r.Start > r.End {
+			r.Start, r.End = r.End, r.Start
+		}
+		if r.Start < r.End {
+			list = append(list, r)
+		}
+	}
+	old := e.Styles()
+	if slices.Equal(old, list) {
+		return
+	}
+	// Reshape the text of the ranges that changed the font, and of the
+	// ranges that change it.
+	e.invalidateFonts(old)
+	e.invalidateFonts(list)
+	s.ranges = rangeTree{}
+	for i, r := range list {
+		s.ranges.insert(r, i)
+	}
+	s.list, s.listed = list, true
+}
+
+// Styles returns the style ranges of the editor, as moved by the edits since
+// they were set. The returned slice must not be modified.
+func (e *Editor) Styles() []StyleRange {
+	s := &e.styles
+	if !s.listed {
+		type orderedRange struct {
+			StyleRange
+			order int
+		}
+		var ranges []orderedRange
+		s.ranges.each(func(r StyleRange, order int) {
+			ranges = append(ranges, orderedRange{StyleRange: r, order: order})
+		})
+		sort.Slice(ranges, func(i, j int) bool {
+			return ranges[i].order < ranges[j].order
+		})
+		s.list = s.list[:0]
+		for _, r := range ranges {
+			s.list = append(s.list, r.StyleRange)
+		}
+		s.listed = true
+	}
+	return s.list
+}
+
+// SetTokenizer replaces the tokenizer of the editor. A nil tokenizer removes
+// the style ranges of the previous tokenizer.
+func (e *Editor) SetTokenizer(t Tokenizer) {
+	s := &e.styles
+	off := 0
+	s.lines.each(func(l *tokenLine) {
+		if changesFont(l.ranges) {
+			e.text.invalidateRunes(off, off+l.runes)
+		}
+		off += l.runes
+	})
+	s.tokenizer = t
+	s.lines = lineTree[tokenLine]{}
+}
+
+// editStyles moves the style ranges after the runes [start,end) were replaced
+// by inserted runes.
+func (e *Editor) editStyles(start, end, inserted int) {
+	s := &e.styles
+	if s.ranges.root != nil {
+		s.ranges.edit(start, end, start+inserted)
+		s.listed = false
+	}
+	if s.lines.root != nil {
+		// Replace the lines containing the replaced runes with a line to split
+		// and tokenize.
+		i, _, before := s.lines.atRune(start)
+		j, l, lBefore := s.lines.atRune(max(start, end-1))
+		runes := lBefore.runes + l.runes - before.runes
+		s.lines.replace(i, j+1, []tokenLine{{runes: runes - (end - start) + inserted, dirty: true}})
+	}
+}
+
+// tokenize tokenizes the lines changed since the previous tokenization.
+func (e *Editor) tokenize() {
+	s := &e.styles
+	if s.tokenizer == nil {
+		return
+	}
+	if s.lines.root == nil {
+		s.lines.replace(0, 0, []tokenLine{{runes: e.text.Len(), dirty: true}})
+	}
+	for s.lines.sum().stale > 0 {
+		i, l, before := s.lines.find(func(s lineSum) bool {
+			return s.stale > 0
+		})
+		var state any
+		if i > 0 {
+			prev, _ := s.lines.at(i - 1)
+			state = prev.out
+		}
+		// Only the final line of the text ends without a newline.
+		n := s.lines.sum().count
+		j, runes := i+1, l.runes
+		for ; j < n && !e.endsLine(before.runes+runes); j++ {
+			next, _ := s.lines.at(j)
+			runes += next.runes
+		}
+		if runes == 0 && n > 1 {
+			s.lines.replace(i, j, nil)
+			e.retokenize(i, state)
+			continue
+		}
+		fonts := changesFont(l.ranges)
+		start := e.text.runeOffset(before.runes)
+		content := make([]byte, e.text.runeOffset(before.runes+runes)-start)
+		c, _ := e.text.ReadAt(content, int64(start))
+		content = content[:c]
+		var lines []tokenLine
+		for first := true; first || len(content) > 0; first = false {
+			end := len(content)
+			if idx := bytes.IndexByte(content, '\n'); idx != -1 {
+				end = idx + 1
+			}
+			line := string(content[:end])
+			t := tokenLine{runes: utf8.RuneCountInString(line), in: state}
+			t.ranges, state = s.tokenizer.Tokenize(strings.TrimSuffix(line, "\n"), state, nil)
+			t.out = state
+			fonts = fonts || changesFont(t.ranges)
+			lines = append(lines, t)
+			content = content[end:]
+		}
+		s.lines.replace(i, j, lines)
+		e.retokenize(i+len(lines), state)
+		if fonts {
+			e.text.invalidateRunes(before.runes, before.runes+runes)
+		}
+	}
+}
+
+// retokenize marks the line at index i to be tokenized if it doesn't start
+// with state.
+func (e *Editor) retokenize(i int, state any) {
+	s := &e.styles
+	if i >= s.lines.sum().count {
+		return
+	}
+	if l, _ := s.lines.at(i); !l.dirty && l.in != state {
+		l.dirty = true
+		s.lines.update(i)
+	}
+}
+
+// endsLine reports whether the rune before the rune offset off is a newline.
+func (e *Editor) endsLine(off int) bool {
+	if off == 0 {
+		return false
+	}
+	r, _, _ := e.text.ReadRuneBefore(int64(e.text.runeOffset(off)))
+	return r == '\n'
+}
+
+// styleSegments returns the styles of the runes [start,end), combined where
+// ranges overlap, as sorted and non-overlapping ranges. Unstyled runes are
+// omitted.
+func (e *Editor) styleSegments(start, end int, segments []StyleRange) []StyleRange {
+	// ranges are ordered by priority until they are sorted.
+	type prioRange struct {
+		StyleRange
+		prio int
+	}
+	var ranges []prioRange
+	add := func(r StyleRange) {
+		if r.Start < end && r.End > start {
+			ranges = append(ranges, prioRange{StyleRange: r, prio: len(ranges)})
+		}
+	}
+	lines := &e.styles.lines
+	if lines.root != nil {
+		i, _, _ := lines.atRune(start)
+		for n := lines.sum().count; i < n; i++ {
+			l, before := lines.at(i)
+			if before.runes >= end {
+				break
+			}
+			for _, r := range l.ranges {
+				r.Start += before.runes
+				r.End += before.runes
+				add(r)
+			}
+		}
+	}
+	// The ranges of SetStyles apply after the ranges of the tokenizer, in
+	// their order.
+	base := len(ranges)
+	e.styles.ranges.overlapping(start, end, func(r StyleRange, order int) {
+		ranges = append(ranges, prioRange{StyleRange: r, prio: base + order})
+	})
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Start < ranges[j].Start
+	})
+	// Sweep the ranges, tracking the active ranges ordered by priority.
+	segments = segments[:0]
+	var active []prioRange
+	next := 0
+	for pos := start; pos < end; {
+		for ; next < len(ranges) && ranges[next].Start <= pos; next++ {
+			r := ranges[next]
+			i := sort.Search(len(active), func(i int) bool {
+				return active[i].prio > r.prio
+			})
+			active = slices.Insert(active, i, r)
+		}
+		n := 0
+		segEnd := end
+		if next < len(ranges) {
+			segEnd = ranges[next].Start
+		}
+		var style TextStyle
+		for _, r := range active {
+			if r.End > pos {
+				active[n] = r
+				n++
+				segEnd = min(segEnd, r.End)
+				style = style.combine(r.Style)
+			}
+		}
+		active = active[:n]
+		switch k := len(segments); {
+		case style == TextStyle{}:
+		case k > 0 && segments[k-1].End == pos && segments[k-1].Style == style:
+			segments[k-1].End = segEnd
+		default:
+			segments = append(segments, StyleRange{Start: pos, End: segEnd, Style: style})
+		}
+		pos = segEnd
+	}
+	return segments
+}
+
+// combine returns s with the non-zero fields of o.
+func (s TextStyle) combine(o TextStyle) TextStyle {
+	if o.Color != (color.NRGBA{}) {
+		s.Color = o.Color
+	}
+	if o.Background != (color.NRGBA{}) {
+		s.Background = o.Background
+	}
+	if o.Weight != 0 {
+		s.Weight = o.Weight
+	}
+	if o.Style != 0 {
+		s.Style = o.Style
+	}
+	return s
+}
+
+// changesFont reports whether any of ranges changes the font.
+func changesFont(ranges []StyleRange) bool {
+	for _, r := range ranges {
+		if r.Style.Weight != 0 || r.Style.Style != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateFonts reshapes the text of the ranges that change the font.
+func (e *Editor) invalidateFonts(ranges []StyleRange) {
+	for _, r := range ranges {
+		if r.Style.Weight != 0 || r.Style.Style != 0 {
+			e.text.invalidateRunes(r.Start, r.End)
+		}
+	}
+}
+
+// fontSpans splits content, the runes of the contents from the rune offset
+// start, into spans shaped with the fonts of the style ranges. It returns nil
+// if no range changes the font of content.
+func (e *Editor) fontSpans(start int, content string) []text.Span {
+	if !e.hasStyles() {
+		return nil
+	}
+	s := &e.styles
+	s.fontSegments = e.styleSegments(start, start+utf8.RuneCountInString(content), s.fontSegments)
+	fnt := e.text.params.Font
+	var spans []text.Span
+	addSpan := func(f font.Font, content string) {
+		if n := len(spans); n > 0 && equalFonts(spans[n-1].Font, f) {
+			spans[n-1].Content += content
+			return
+		}
+		spans = append(spans, text.Span{Font: f, Content: content})
+	}
+	off, runes := 0, start
+	// advance returns the content of the runes up to the rune offset end.
+	advance := func(end int) string {
+		from := off
+		for ; runes < end && off < len(content); runes++ {
+			_, n := utf8.DecodeRuneInString(content[off:])
+			off += n
+		}
+		return content[from:off]
+	}
+	changed := false
+	for _, seg := range s.fontSegments {
+		if seg.Style.Weight == 0 && seg.Style.Style == 0 {
+			continue
+		}
+		changed = true
+		if c := advance(seg.Start); c != "" {
+			addSpan(fnt, c)
+		}
+		f := fnt
+		if seg.Style.Weight != 0 {
+			f.Weight = seg.Style.Weight
+		}
+		if seg.Style.Style != 0 {
+			f.Style = seg.Style.Style
+		}
+		addSpan(f, advance(seg.End))
+	}
+	if !changed {
+		return nil
+	}
+	if off < len(content) {
+		addSpan(fnt, content[off:])
+	}
+	return spans
+}
+
+// hasStyles reports whether any ranges style the editor contents.
+func (e *Editor) hasStyles() bool {
+	return e.styles.ranges.root != nil || e.styles.tokenizer != nil
+}
+
+// paintBackgrounds paints the backgrounds of the visible style ranges.
+func (e *Editor) paintBackgrounds(gtx layout.Context) {
+	if !e.hasStyles() {
+		return
+	}
+	s := &e.styles
+	start, end := e.text.VisibleRunes()
+	s.segments = e.styleSegments(start, end, s.segments)
+	for _, seg := range s.segments {
+		if seg.Style.Background == (color.NRGBA{}) {
+			continue
+		}
+		s.regions = e.text.Regions(seg.Start, seg.End, s.regions)
+		for _, r := range s.regions {
+			paint.FillShape(gtx.Ops, seg.Style.Background, clip.Rect(r.Bounds).Op())
+		}
+	}
+}
+
+// paintStyledText paints the visible text, filling the glyphs of the style
+// ranges with their colors.
+func (e *Editor) paintStyledText(gtx layout.Context, material op.CallOp) {
+	s := &e.styles
+	start, end := e.text.VisibleRunes()
+	s.segments = e.styleSegments(start, end, s.segments)
+	s.materials = s.materials[:0]
+	var ranges []runeStyle
+	var colors []color.NRGBA
+	for _, seg := range s.segments {
+		if seg.Style.Color == (color.NRGBA{}) {
+			continue
+		}
+		idx := slices.Index(colors, seg.Style.Color)
+		if idx == -1 {
+			idx = len(colors)
+			colors = append(colors, seg.Style.Color)
+			m := op.Record(gtx.Ops)
+			paint.ColorOp{Color: seg.Style.Color}.Add(gtx.Ops)
+			s.materials = append(s.materials, m.Stop())
+		}
+		ranges = append(ranges, runeStyle{start: seg.Start, end: seg.End, style: idx})
+	}
+	e.text.PaintRanges(gtx, material, ranges, s.materials)
+}
@@ -2,15 +2,18 @@ package widget
 
 import (
 	"bufio"
+	"bytes"
 	"image"
 	"io"
 	"math"
 	"sort"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 
 	"gioui.org/f32"
 	"gioui.org/font"
+	"gioui.org/io/system"
 	"gioui.org/layout"
 	"gioui.org/op"
 	"gioui.org/op/clip"
@@ -61,66 +64,126 @@ type textView struct {
 	Truncator string
 	// WrapPolicy configures how displayed text will be broken into lines.
 	WrapPolicy text.WrapPolicy
+	// TabWidth and TabStops position the text following tab characters. See
+	// [text.Parameters] for details. TabStops are measured from the start of
+	// each line.
+	TabWidth int
+	TabStops []unit.Sp
+	// LetterSpacing is extra space added between the grapheme clusters of the
+	// text. Negative values tighten the text.
+	LetterSpacing unit.Sp
+	// WordSpacing is extra space added to the spaces between words.
+	WordSpacing unit.Sp
+	// Features configures the OpenType features used to shape the text, such as
+	// tabular numbers or disabled ligatures.
+	Features []text.Feature
+	// Decoration selects the lines drawn along the text, such as underlines.
+	Decoration text.Decoration
+	// Outline and Shadow configure the effects drawn behind the glyphs.
+	Outline TextOutline
+	Shadow  TextShadow
 	// Mask replaces the visual display of each rune in the contents with the given rune.
 	// Newline characters are not masked. When non-zero, the unmasked contents
 	// are accessed by Len, Text, and SetText.
 	Mask rune
 
-	params     text.Parameters
-	shaper     *text.Shaper
-	seekCursor int64
-	rr         textSource
-	maskReader maskReader
-	// graphemes tracks the indices of grapheme cluster boundaries within rr.
-	graphemes []int
-	// paragraphReader is used to populate graphemes.
+	params text.Parameters
+	shaper *text.Shaper
+	// spans, if non-empty, styles the contents of rr. The Content of the
+	// spans must match the contents of rr.
+	spans []text.Span
+	// paragraphSpans, if non-nil, returns the spans shaping content, the
+	// runes of the text from the rune offset start, or nil to shape content
+	// with the font of the text. The Content of the spans must add up to
+	// content.
+	paragraphSpans func(start int, content string) []text.Span
+	seekCursor     int64
+	rr             textSource
+	maskReader     maskReader
+	// paragraphReader segments the text into grapheme clusters.
 	paragraphReader graphemeReader
 	lastMask        rune
 	viewSize        image.Point
 	valid           bool
 	regions         []Region
-	dims            layout.Dimensions
 
 	// offIndex is an index of rune index to byte offsets.
 	offIndex []offEntry
+	// paragraphs holds the paragraphs of the text and their layouts. Only the
+	// paragraphs displayed or otherwise used are laid out.
+	paragraphs paragraphTree
+	// whole records that the text is laid out as a single paragraph, because
+	// it can't be laid out by paragraph.
+	whole bool
+	// scratch holds the text of paragraphs being read.
+	scratch []byte
 
-	index glyphIndex
-
-	caret struct {
-		// xoff is the offset to the current position when moving between lines.
-		xoff fixed.Int26_6
-		// start is the current caret position in runes, and also the start position of
-		// selected text. end is the end position of selected text. If start
-		// == end, then there's no selection. Note that it's possible (and
-		// common) that the caret (start) is after the end, e.g. after
-		// Shift-DownArrow.
-		start int
-		end   int
-	}
+	caret textCaret
 
 	scrollOff image.Point
 }
 
+// textCaret is the state of a caret and its selection.
+type textCaret struct {
+	// xoff is the offset to the current position when moving between lines.
+	xoff fixed.Int26_6
+	// start is the current caret position in runes, and also the start position of
+	// selected text. end is the end position of selected text. If start
+	// == end, then there's no selection. Note that it's possible (and
+	// common) that the caret (start) is after the end, e.g. after
+	// Shift-DownArrow.
+	start int
+	end   int
+	// visual is the position of the caret after a visual movement, which
+	// disambiguates runes displayed at two positions, such as at the
+	// boundaries of bidi runs. It is valid while hasVisual is set and
+	// visual is at start.
+	visual    combinedPos
+	hasVisual bool
+}
+
 func (e *textView) Changed() bool {
 	return e.rr.Changed()
 }
 
 // Dimensions returns the dimensions of the visible text.
 func (e *textView) Dimensions() layout.Dimensions {
-	basePos := e.dims.Size.Y - e.dims.Baseline
+	dims := e.FullDimensions()
+	basePos := dims.Size.Y - dims.Baseline
 	return layout.Dimensions{Size: e.viewSize, Baseline: e.viewSize.Y - basePos}
 }
 
 // FullDimensions returns the dimensions of all shaped text, including
-// text that isn't visible within the current viewport.
+// text that isn't visible within the current viewport. The height of the
+// paragraphs that aren't laid out is estimated.
 func (e *textView) FullDimensions() layout.Dimensions {
-	return e.dims
+	sum := e.paragraphs.sum()
+	if sum.count == 0 {
+		return layout.Dimensions{}
+	}
+	first, _ := e.paragraphs.at(0)
+	last, before := e.paragraphs.at(sum.count - 1)
+	b := image.Rectangle{
+		Min: image.Pt(0, first.bounds.Min.Y),
+		Max: image.Pt(0, before.height+last.bounds.Max.Y),
+	}
+	if sum.minX <= sum.maxX {
+		b.Min.X, b.Max.X = sum.minX, sum.maxX
+	}
+	dims := layout.Dimensions{Size: b.Size()}
+	baseline := 0
+	if first.shaped != nil && len(first.shaped.index.glyphs) > 0 {
+		baseline = int(first.shaped.index.glyphs[0].Y)
+	}
+	dims.Baseline = dims.Size.Y - baseline
+	return dims
 }
 
 // SetSource initializes the underlying data source for the Text. This
 // must be done before invoking any other methods on Text.
 func (e *textView) SetSource(source textSource) {
 	e.rr = source
+	e.paragraphs.root = nil
 	e.invalidate()
 	e.seekCursor = 0
 }
@@ -151,28 +214,43 @@ func (e *textView) ReadRuneBefore(off int64) (rune, int, error) {
 	return r, s, err
 }
 
+// makeValid splits the text into paragraphs if needed, and lays out the
+// first, last and displayed paragraphs.
 func (e *textView) makeValid() {
 	if e.valid {
 		return
 	}
-	e.layoutText(e.shaper)
 	e.valid = true
+	e.splitParagraphs()
+	// The dimensions of the text are measured from its first and last
+	// paragraphs.
+	e.paragraph(0)
+	e.paragraph(e.paragraphs.sum().count - 1)
+	e.layoutViewport()
 }
 
 func (e *textView) closestToRune(runeIdx int) combinedPos {
 	e.makeValid()
-	pos, _ := e.index.closestToRune(runeIdx)
-	return pos
+	_, p, before := e.paragraphAtRune(runeIdx)
+	pos, _ := p.shaped.index.closestToRune(runeIdx - before.runes)
+	return before.textPos(pos)
 }
 
 func (e *textView) closestToLineCol(line, col int) combinedPos {
 	e.makeValid()
-	return e.index.closestToLineCol(screenPos{line: line, col: col})
+	_, p, before := e.layoutFind(func(s paragraphSum) bool {
+		return s.lines > line
+	})
+	pos := p.shaped.index.closestToLineCol(screenPos{line: line - before.lines, col: col})
+	return before.textPos(pos)
 }
 
 func (e *textView) closestToXY(x fixed.Int26_6, y int) combinedPos {
 	e.makeValid()
-	return e.index.closestToXY(x, y)
+	_, p, before := e.layoutFind(func(s paragraphSum) bool {
+		return s.height+s.descent.Round() >= y
+	})
+	return before.textPos(p.shaped.index.closestToXY(x, y-before.height))
 }
 
 func (e *textView) closestToXYGraphemes(x fixed.Int26_6, y int) combinedPos {
@@ -207,13 +285,28 @@ func absFixed(i fixed.Int26_6) fixed.Int26_6 {
 // MaxLines moves the cursor the specified number of lines vertically, ensuring
 // that the resulting position is aligned to a grapheme cluster.
 func (e *textView) MoveLines(distance int, selAct selectionAction) {
-	caretStart := e.closestToRune(e.caret.start)
+	caretStart := e.caretPos()
+	// Lay out the paragraphs moved across, whose lines are counted by
+	// estimate until then.
+	for {
+		i, _, _ := e.paragraphs.find(func(s paragraphSum) bool {
+			return s.runes > caretStart.runes
+		})
+		j, _, _ := e.paragraphs.find(func(s paragraphSum) bool {
+			return s.lines > caretStart.lineCol.line+distance
+		})
+		if !e.layoutRange(min(i, j), max(i, j)) {
+			break
+		}
+		caretStart = e.caretPos()
+	}
 	x := caretStart.x + e.caret.xoff
 	// Seek to line.
 	pos := e.closestToLineCol(caretStart.lineCol.line+distance, 0)
 	pos = e.closestToXYGraphemes(x, pos.y)
 	e.caret.start = pos.runes
 	e.caret.xoff = x - pos.x
+	e.caret.hasVisual = false
 	e.updateSelection(selAct)
 }
 
@@ -221,13 +314,24 @@ func (e *textView) MoveLines(distance int, selAct selectionAction) {
 // ensuring that even if there is no text content, some space is reserved
 // for the caret.
 func (e *textView) calculateViewSize(gtx layout.Context) image.Point {
-	base := e.dims.Size
+	base := e.FullDimensions().Size
 	if caretWidth := e.caretWidth(gtx); base.X < caretWidth {
 		base.X = caretWidth
 	}
 	return gtx.Constraints.Constrain(base)
 }
 
+// SetSpans configures the styled spans used to shape the text. The content of the
+// spans must match the contents of the source. Passing no spans shapes the
+// entire source with the font and size provided to Layout.
+func (e *textView) SetSpans(spans []text.Span) {
+	if slices.EqualFunc(spans, e.spans, equalSpans) {
+		return
+	}
+	e.spans = append(e.spans[:0], spans...)
+	e.invalidate()
+}
+
 // Layout the text, reshaping it as necessary.
 func (e *textView) Layout(gtx layout.Context, lt *text.Shaper, font font.Font, size unit.Sp) {
 	if e.params.Locale != gtx.Locale {
@@ -235,16 +339,17 @@ func (e *textView) Layout(gtx layout.Context, lt *text.Shaper, font font.Font, s
 		e.invalidate()
 	}
 	textSize := fixed.I(gtx.Sp(size))
-	if e.params.Font != font || e.params.PxPerEm != textSize {
+	if !equalFonts(e.params.Font, font) || e.params.PxPerEm != textSize {
 		e.invalidate()
+		vars := e.params.Font.Variations[:0]
 		e.params.Font = font
+		e.params.Font.Variations = append(vars, font.Variations...)
 		e.params.PxPerEm = textSize
 	}
-	maxWidth := gtx.Constraints.Max.X
+	maxWidth, minWidth := lineLength(gtx.Constraints, gtx.Locale)
 	if e.SingleLine {
 		maxWidth = math.MaxInt
 	}
-	minWidth := gtx.Constraints.Min.X
 	if maxWidth != e.params.MaxWidth {
 		e.params.MaxWidth = maxWidth
 		e.invalidate()
@@ -277,6 +382,26 @@ func (e *textView) Layout(gtx layout.Context, lt *text.Shaper, font font.Font, s
 		e.params.WrapPolicy = e.WrapPolicy
 		e.invalidate()
 	}
+	if ls := fixed.I(gtx.Sp(e.LetterSpacing)); ls != e.params.LetterSpacing {
+		e.params.LetterSpacing = ls
+		e.invalidate()
+	}
+	if ws := fixed.I(gtx.Sp(e.WordSpacing)); ws != e.params.WordSpacing {
+		e.params.WordSpacing = ws
+		e.invalidate()
+	}
+	if e.TabWidth != e.params.TabWidth {
+		e.params.TabWidth = e.TabWidth
+		e.invalidate()
+	}
+	if !equalTabStops(gtx, e.TabStops, e.params.TabStops) {
+		e.params.TabStops = tabStops(gtx, e.TabStops, e.params.TabStops[:0])
+		e.invalidate()
+	}
+	if !slices.Equal(e.Features, e.params.Features) {
+		e.params.Features = append(e.params.Features[:0], e.Features...)
+		e.invalidate()
+	}
 	if lh := fixed.I(gtx.Sp(e.LineHeight)); lh != e.params.LineHeight {
 		e.params.LineHeight = lh
 		e.invalidate()
@@ -289,19 +414,59 @@ func (e *textView) Layout(gtx layout.Context, lt *text.Shaper, font font.Font, s
 	e.makeValid()
 
 	if viewSize := e.calculateViewSize(gtx); viewSize != e.viewSize {
+		// The layouts don't depend on the size of the viewport, but the
+		// paragraphs displayed do.
 		e.viewSize = viewSize
-		e.invalidate()
+		e.valid = false
 	}
 	e.makeValid()
 }
 
+// tabStops appends stops, converted to pixels, to px.
+func tabStops(gtx layout.Context, stops []unit.Sp, px []fixed.Int26_6) []fixed.Int26_6 {
+	for _, s := range stops {
+		px = append(px, fixed.I(gtx.Sp(s)))
+	}
+	return px
+}
+
+// equalTabStops reports whether stops converted to pixels equal px.
+func equalTabStops(gtx layout.Context, stops []unit.Sp, px []fixed.Int26_6) bool {
+	if len(stops) != len(px) {
+		return false
+	}
+	for i, s := range stops {
+		if fixed.I(gtx.Sp(s)) != px[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalFonts reports whether two fonts are the same.
+func equalFonts(a, b font.Font) bool {
+	return a.Typeface == b.Typeface && a.Style == b.Style && a.Weight == b.Weight &&
+		slices.Equal(a.Variations, b.Variations)
+}
+
+// equalSpans reports whether two spans are the same.
+func equalSpans(a, b text.Span) bool {
+	return a.Content == b.Content && a.PxPerEm == b.PxPerEm &&
+		a.Placeholder == b.Placeholder && equalFonts(a.Font, b.Font)
+}
+
 // PaintSelection clips and paints the visible text selection rectangles using
 // the provided material to fill the rectangles.
 func (e *textView) PaintSelection(gtx layout.Context, material op.CallOp) {
+	e.paintSelection(gtx, material, e.caret.start, e.caret.end)
+}
+
+// paintSelection paints the selection of the runes between start and end.
+func (e *textView) paintSelection(gtx layout.Context, material op.CallOp, start, end int) {
 	localViewport := image.Rectangle{Max: e.viewSize}
 	docViewport := image.Rectangle{Max: e.viewSize}.Add(e.scrollOff)
 	defer clip.Rect(localViewport).Push(gtx.Ops).Pop()
-	e.regions = e.index.locate(docViewport, e.caret.start, e.caret.end, e.regions)
+	e.regions = e.locate(docViewport, start, end, e.regions[:0])
 	for _, region := range e.regions {
 		area := clip.Rect(region.Bounds).Push(gtx.Ops)
 		material.Add(gtx.Ops)
@@ -313,39 +478,163 @@ func (e *textView) PaintSelection(gtx layout.Context, material op.CallOp) {
 // PaintText clips and paints the visible text glyph outlines using the provided
 // material to fill the glyphs.
 func (e *textView) PaintText(gtx layout.Context, material op.CallOp) {
+	e.PaintSpans(gtx, material, nil, nil)
+}
+
+// PaintSpans is like PaintText, but fills the glyphs of each span with the
+// material at the span's index in spanMaterials, and decorates them with the
+// decoration at the span's index in spanDecorations, if any.
+func (e *textView) PaintSpans(gtx layout.Context, material op.CallOp, spanMaterials []op.CallOp, spanDecorations []text.Decoration) {
+	e.paint(gtx, textIterator{
+		material:        material,
+		spanMaterials:   spanMaterials,
+		spanDecorations: spanDecorations,
+	}, nil)
+}
+
+// runeStyle assigns the style at index style to the runes [start,end).
+type runeStyle struct {
+	start, end int
+	style      int
+}
+
+// PaintRanges is like PaintText, but fills the glyphs of the runes of each of
+// ranges with the material at the index of its style in materials, regardless
+// of the span the glyphs were shaped from. ranges must be sorted and must not
+// overlap.
+func (e *textView) PaintRanges(gtx layout.Context, material op.CallOp, ranges []runeStyle, materials []op.CallOp) {
+	e.paint(gtx, textIterator{
+		material:      material,
+		spanMaterials: materials,
+	}, ranges)
+}
+
+// paint paints the visible glyphs with it. If ranges is non-nil, the span of
+// each glyph is the style of the range containing the first rune of its
+// cluster.
+func (e *textView) paint(gtx layout.Context, it textIterator, ranges []runeStyle) {
+	first := e.firstVisible()
 	m := op.Record(gtx.Ops)
 	viewport := image.Rectangle{
 		Min: e.scrollOff,
 		Max: e.viewSize.Add(e.scrollOff),
 	}
-	it := textIterator{
-		viewport: viewport,
-		material: material,
-	}
+	it.viewport = viewport
+	it.decoration = e.Decoration
+	it.outline = e.Outline
+	it.shadow = e.Shadow
 
-	startGlyph := 0
-	for _, line := range e.index.lines {
-		if line.descent.Ceil()+line.yOff >= viewport.Min.Y {
-			break
-		}
-		startGlyph += line.glyphs
-	}
 	var glyphs [32]text.Glyph
 	line := glyphs[:0]
-	for _, g := range e.index.glyphs[startGlyph:] {
-		var ok bool
-		if line, ok = it.paintGlyph(gtx, e.shaper, g, line); !ok {
-			break
+paint:
+	for i, n := first, e.paragraphs.sum().count; i < n; i++ {
+		p, before := e.paragraph(i)
+		index := &p.shaped.index
+		startGlyph := 0
+		for _, line := range index.lines {
+			if e.params.Locale.Direction.Axis() == system.Vertical {
+				// Vertical lines don't progress along the Y axis.
+				break
+			}
+			if line.descent.Ceil()+line.yOff+before.height >= viewport.Min.Y {
+				break
+			}
+			startGlyph += line.glyphs
+		}
+		for k, g := range index.glyphs[startGlyph:] {
+			g.Y += int32(before.height)
+			if ranges != nil {
+				g.Span = rangeStyle(ranges, before.runes+index.clusters[startGlyph+k], len(it.spanMaterials))
+			}
+			var ok bool
+			if line, ok = it.paintGlyph(gtx, e.shaper, g, line); !ok {
+				break paint
+			}
 		}
 	}
 
 	call := m.Stop()
+	it.padEffects(gtx)
 	viewport.Min = viewport.Min.Add(it.padding.Min)
 	viewport.Max = viewport.Max.Add(it.padding.Max)
 	defer clip.Rect(viewport.Sub(e.scrollOff)).Push(gtx.Ops).Pop()
+	it.paintEffects(gtx)
 	call.Add(gtx.Ops)
 }
 
+// rangeStyle returns the style of the range of ranges containing the rune
+// offset r, or none if no range contains it.
+func rangeStyle(ranges []runeStyle, r, none int) int {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].end > r
+	})
+	if i < len(ranges) && ranges[i].start <= r {
+		return ranges[i].style
+	}
+	return none
+}
+
+// VisibleRunes returns the range of runes of the lines within the viewport.
+func (e *textView) VisibleRunes() (start, end int) {
+	e.makeValid()
+	if e.params.Locale.Direction.Axis() == system.Vertical {
+		return 0, e.Len()
+	}
+	i := e.firstVisible()
+	viewport := image.Rectangle{
+		Min: e.scrollOff,
+		Max: e.viewSize.Add(e.scrollOff),
+	}
+	p, before := e.paragraph(i)
+	index := &p.shaped.index
+	first := len(index.lines)
+	for j, line := range index.lines {
+		if line.descent.Ceil()+line.yOff+before.height >= viewport.Min.Y {
+			first = j
+			break
+		}
+	}
+	start = before.runes + index.closestToLineCol(screenPos{line: first}).runes
+	for n := e.paragraphs.sum().count; i < n; i++ {
+		p, before := e.paragraph(i)
+		index := &p.shaped.index
+		for j, line := range index.lines {
+			if line.yOff+before.height-line.ascent.Ceil() > viewport.Max.Y {
+				return start, before.runes + index.closestToLineCol(screenPos{line: j}).runes
+			}
+		}
+	}
+	return start, e.Len()
+}
+
+// PaintObjects adds the operations of the inline objects of placeholder spans,
+// positioned at the top left of their glyphs. objects is indexed by span, and
+// operations of spans without placeholders are ignored.
+func (e *textView) PaintObjects(gtx layout.Context, objects []op.CallOp) {
+	first := e.firstVisible()
+	bottom := e.scrollOff.Y + e.viewSize.Y
+	for i, n := first, e.paragraphs.sum().count; i < n; i++ {
+		p, before := e.paragraph(i)
+		if i > first && before.height+p.bounds.Min.Y > bottom {
+			break
+		}
+		for _, g := range p.shaped.index.glyphs {
+			if g.Flags&text.FlagPlaceholder == 0 || g.Span >= len(objects) {
+				continue
+			}
+			g.Y += int32(before.height)
+			pos := image.Pt((g.X + g.Bounds.Min.X).Round(), int(g.Y)+g.Bounds.Min.Y.Round())
+			if g.Flags&text.FlagVertical != 0 {
+				// The object extends to the left of the dot by its descent.
+				pos = image.Pt((g.X - g.Bounds.Max.Y).Round(), int(g.Y))
+			}
+			t := op.Offset(pos.Sub(e.scrollOff)).Push(gtx.Ops)
+			objects[g.Span].Add(gtx.Ops)
+			t.Pop()
+		}
+	}
+}
+
 // caretWidth returns the width occupied by the caret for the current
 // gtx.
 func (e *textView) caretWidth(gtx layout.Context) int {
@@ -359,13 +648,27 @@ func (e *textView) caretWidth(gtx layout.Context) int {
 // PaintCaret clips and paints the caret rectangle, adding material immediately
 // before painting to set the appropriate paint material.
 func (e *textView) PaintCaret(gtx layout.Context, material op.CallOp) {
+	e.paintCaret(gtx, material, e.caretPos())
+}
+
+// paintCaret paints a caret at pos.
+func (e *textView) paintCaret(gtx layout.Context, material op.CallOp, pos combinedPos) {
 	carWidth2 := e.caretWidth(gtx)
-	caretPos, carAsc, carDesc := e.CaretInfo()
+	caretPos := image.Pt(pos.x.Round(), pos.y).Sub(e.scrollOff)
+	carAsc, carDesc := pos.ascent.Ceil(), pos.descent.Ceil()
 
 	carRect := image.Rectangle{
 		Min: caretPos.Sub(image.Pt(carWidth2, carAsc)),
 		Max: caretPos.Add(image.Pt(carWidth2, carDesc)),
 	}
+	if pos.vertical {
+		// The caret of vertical text lies across the line, from its descent
+		// on the left to its ascent on the right.
+		carRect = image.Rectangle{
+			Min: caretPos.Sub(image.Pt(carDesc, carWidth2)),
+			Max: caretPos.Add(image.Pt(carAsc, carWidth2)),
+		}
+	}
 	cl := image.Rectangle{Max: e.viewSize}
 	carRect = cl.Intersect(carRect)
 	if !carRect.Empty() {
@@ -376,7 +679,7 @@ func (e *textView) PaintCaret(gtx layout.Context, material op.CallOp) {
 }
 
 func (e *textView) CaretInfo() (pos image.Point, ascent, descent int) {
-	caretStart := e.closestToRune(e.caret.start)
+	caretStart := e.caretPos()
 
 	ascent = caretStart.ascent.Ceil()
 	descent = caretStart.descent.Ceil()
@@ -392,13 +695,26 @@ func (e *textView) CaretInfo() (pos image.Point, ascent, descent int) {
 // ByteOffset returns the start byte of the rune at the given
 // rune offset, clamped to the size of the text.
 func (e *textView) ByteOffset(runeOffset int) int64 {
-	return int64(e.runeOffset(e.closestToRune(runeOffset).runes))
+	return int64(e.runeOffset(e.clampRune(runeOffset)))
 }
 
 // Len is the length of the editor contents, in runes.
 func (e *textView) Len() int {
 	e.makeValid()
-	return e.closestToRune(math.MaxInt).runes
+	if e.whole {
+		return e.closestToRune(math.MaxInt).runes
+	}
+	return e.paragraphs.sum().runes
+}
+
+// clampRune returns the rune offset of the caret position closest to r.
+func (e *textView) clampRune(r int) int {
+	e.makeValid()
+	if e.whole {
+		// Truncated text has no positions for the runes truncated.
+		return e.closestToRune(r).runes
+	}
+	return min(max(r, 0), e.paragraphs.sum().runes)
 }
 
 // Text returns the contents of the editor. If the provided buf is large enough, it will
@@ -418,17 +734,18 @@ func (e *textView) Text(buf []byte) []byte {
 
 func (e *textView) ScrollBounds() image.Rectangle {
 	var b image.Rectangle
+	dims := e.FullDimensions()
 	if e.SingleLine {
-		if len(e.index.lines) > 0 {
-			line := e.index.lines[0]
+		if p, _ := e.paragraphs.at(0); p != nil && p.shaped != nil && len(p.shaped.index.lines) > 0 {
+			line := p.shaped.index.lines[0]
 			b.Min.X = line.xOff.Floor()
 			if b.Min.X > 0 {
 				b.Min.X = 0
 			}
 		}
-		b.Max.X = e.dims.Size.X + b.Min.X - e.viewSize.X
+		b.Max.X = dims.Size.X + b.Min.X - e.viewSize.X
 	} else {
-		b.Max.Y = e.dims.Size.Y - e.viewSize.Y
+		b.Max.Y = dims.Size.Y - e.viewSize.Y
 	}
 	return b
 }
@@ -467,31 +784,432 @@ func (e *textView) MoveCoord(pos image.Point) {
 	y := pos.Y + e.scrollOff.Y
 	e.caret.start = e.closestToXYGraphemes(x, y).runes
 	e.caret.xoff = 0
+	e.caret.hasVisual = false
 }
 
 // Truncated returns whether the text in the textView is currently
 // truncated due to a restriction on the number of lines.
 func (e *textView) Truncated() bool {
-	return e.index.truncated
+	if !e.whole {
+		return false
+	}
+	p, _ := e.paragraphs.at(0)
+	return p != nil && p.shaped != nil && p.shaped.index.truncated
+}
+
+// paragraphLayout is the layout of a paragraph of text, which ends after a
+// newline or at the end of the text. A text ending with a newline ends with an
+// empty paragraph.
+type paragraphLayout struct {
+	// bytes and runes measure the paragraph.
+	bytes, runes int
+	// lines, height and descent measure the lines of the paragraph as
+	// paragraphSum does, and bounds bounds its glyphs relative to the
+	// baseline of the last line of the previous paragraph. They are estimated
+	// until the paragraph is laid out.
+	lines, height int
+	descent       fixed.Int26_6
+	bounds        image.Rectangle
+	// width is the natural width of the widest line of the paragraph, and
+	// align the width its lines are aligned within, if the position of the
+	// lines depends on the widest line of the text.
+	width, align int
+	// graphemes holds the grapheme cluster boundaries of the paragraph, in
+	// runes relative to its start. It is segmented when first needed.
+	graphemes []int
+	// shaped holds the glyphs of the paragraph, once laid out.
+	shaped *shapedParagraph
+	// first records whether the paragraph was laid out as the first paragraph
+	// of the text.
+	first bool
+	// laidOut marks paragraphs whose layout is valid.
+	laidOut bool
+}
+
+// shapedParagraph holds the glyphs of a paragraph.
+type shapedParagraph struct {
+	// index indexes the glyphs of the paragraph. Its rune offsets and line
+	// numbers are relative to the start of the paragraph, and its Y
+	// coordinates to the baseline of the last line of the previous paragraph.
+	index glyphIndex
+	// end is the glyph after the final newline of the paragraph, which starts
+	// the empty paragraph ending the text.
+	end text.Glyph
+}
+
+// incremental reports whether the text can be laid out by paragraph, laying
+// out only the paragraphs displayed or otherwise used. It can unless the text
+// is truncated, styled by spans or vertical.
+func (e *textView) incremental() bool {
+	return len(e.spans) == 0 && e.params.MaxLines == 0 &&
+		e.params.Locale.Direction.Axis() == system.Horizontal
+}
+
+// aligned reports whether the position of the lines of the text depends on the
+// widest line of the text.
+func (e *textView) aligned() bool {
+	return e.params.Alignment != text.Start ||
+		e.params.Locale.Direction.Progression() == system.TowardOrigin
+}
+
+// alignWidth returns the width the lines of the text are aligned within: the
+// minimum width, or the width of the widest line of the paragraphs laid out.
+func (e *textView) alignWidth() int {
+	return max(e.params.MinWidth, e.paragraphs.sum().width)
+}
+
+// current reports whether the layout of p is valid and aligned like the text.
+func (e *textView) current(p *paragraph) bool {
+	return p.laidOut && (e.whole || !e.aligned() || p.align == e.alignWidth())
+}
+
+// splitParagraphs splits the text into paragraphs, unless it is split already.
+// Text that can't be laid out by paragraph is kept as a single paragraph.
+func (e *textView) splitParagraphs() {
+	whole := !e.incremental()
+	if e.paragraphs.root != nil && whole == e.whole {
+		return
+	}
+	e.whole = whole
+	ps := e.readParagraphs(0, int(e.rr.Size()), true)
+	if whole {
+		var p paragraphLayout
+		for _, q := range ps {
+			p.bytes += q.bytes
+			p.runes += q.runes
+		}
+		ps = append(ps[:0], p)
+	}
+	e.paragraphs.root = nil
+	e.paragraphs.replace(0, 0, ps)
+}
+
+// readParagraphs splits the size bytes of the text at byte offset off into
+// paragraphs with estimated layouts. If final is set, the bytes end the text,
+// which ends with an empty paragraph if it is empty or ends with a newline.
+func (e *textView) readParagraphs(off, size int, final bool) []paragraphLayout {
+	var ps []paragraphLayout
+	var p paragraphLayout
+	var buf [4096]byte
+	// carry is the length of the incomplete rune ending the previous chunk.
+	carry := 0
+	for read := 0; read < size; {
+		n, _ := e.rr.ReadAt(buf[carry:min(len(buf), carry+size-read)], int64(off+read))
+		if n == 0 {
+			break
+		}
+		read += n
+		chunk := buf[:carry+n]
+		carry = 0
+		if read < size {
+			// Count an incomplete rune with the next chunk.
+			for k := 1; k < utf8.UTFMax && k <= len(chunk); k++ {
+				if utf8.RuneStart(chunk[len(chunk)-k]) {
+					if !utf8.FullRune(chunk[len(chunk)-k:]) {
+						carry = k
+					}
+					break
+				}
+			}
+		}
+		b := chunk[:len(chunk)-carry]
+		for {
+			i := bytes.IndexByte(b, '\n')
+			if i == -1 {
+				break
+			}
+			p.bytes += i + 1
+			p.runes += utf8.RuneCount(b[:i+1])
+			ps = append(ps, e.estimate(p))
+			p = paragraphLayout{}
+			b = b[i+1:]
+		}
+		p.bytes += len(b)
+		p.runes += utf8.RuneCount(b)
+		copy(buf[:], chunk[len(chunk)-carry:])
+	}
+	if final || p.bytes > 0 {
+		ps = append(ps, e.estimate(p))
+	}
+	return ps
+}
+
+// estimate returns p with estimated measures for its lines.
+func (e *textView) estimate(p paragraphLayout) paragraphLayout {
+	lineHeight := e.params.LineHeight
+	if lineHeight == 0 {
+		lineHeight = e.params.PxPerEm
+	}
+	scale := e.params.LineHeightScale
+	if scale == 0 {
+		scale = 1.2
+	}
+	h := max(int(float32(lineHeight.Ceil())*scale), 1)
+	// Assume glyphs half an em wide.
+	perLine := max(e.params.MaxWidth/max(e.params.PxPerEm.Ceil()/2, 1), 1)
+	p.lines = max((p.runes+perLine-1)/perLine, 1)
+	p.height = p.lines * h
+	p.descent = fixed.I(h / 4)
+	p.bounds = image.Rect(0, 0, 0, p.height+p.descent.Ceil())
+	return p
+}
+
+// paragraph returns the paragraph at index i and the measures of the
+// paragraphs before it, laying out the paragraph if needed.
+func (e *textView) paragraph(i int) (*paragraph, paragraphSum) {
+	p, before := e.paragraphs.at(i)
+	if !e.current(p) {
+		e.layoutParagraph(i)
+	}
+	return p, before
+}
+
+// layoutFind is paragraphTree.find for the measures of the paragraphs as laid
+// out: it lays out the paragraph found, and finds again until the paragraph
+// found is laid out.
+func (e *textView) layoutFind(f func(s paragraphSum) bool) (int, *paragraph, paragraphSum) {
+	for {
+		i, p, before := e.paragraphs.find(f)
+		if e.current(p) {
+			return i, p, before
+		}
+		e.layoutParagraph(i)
+	}
+}
+
+// paragraphAtRune returns the paragraph containing the rune offset r, laid
+// out, its index and the measures of the paragraphs before it.
+func (e *textView) paragraphAtRune(r int) (int, *paragraph, paragraphSum) {
+	return e.layoutFind(func(s paragraphSum) bool {
+		return s.runes > r
+	})
+}
+
+// firstVisible returns the index of the first paragraph displayed in the
+// viewport, laying it out.
+func (e *textView) firstVisible() int {
+	i, _, _ := e.layoutFind(func(s paragraphSum) bool {
+		return s.height+s.descent.Ceil() >= e.scrollOff.Y
+	})
+	return i
+}
+
+// layoutViewport lays out the paragraphs displayed in the viewport.
+func (e *textView) layoutViewport() {
+	first := e.firstVisible()
+	bottom := e.scrollOff.Y + e.viewSize.Y
+	for i, n := first+1, e.paragraphs.sum().count; i < n; i++ {
+		if p, before := e.paragraphs.at(i); before.height+p.bounds.Min.Y > bottom {
+			break
+		}
+		e.paragraph(i)
+	}
+}
+
+// layoutRange lays out the paragraphs between the indices i and j, and
+// reports whether any wasn't laid out.
+func (e *textView) layoutRange(i, j int) bool {
+	i, j = max(i, 0), min(j, e.paragraphs.sum().count-1)
+	laid := false
+	for i <= j {
+		_, before := e.paragraphs.at(i)
+		// Skip to the next paragraph that isn't laid out.
+		k, p, _ := e.paragraphs.find(func(s paragraphSum) bool {
+			return s.unlaid > before.unlaid
+		})
+		if p.laidOut || k > j {
+			break
+		}
+		e.layoutParagraph(k)
+		laid = true
+		i = k + 1
+	}
+	return laid
+}
+
+// layoutParagraph lays out the paragraph at index i, or the entire text if it
+// can't be laid out by paragraph.
+func (e *textView) layoutParagraph(i int) {
+	if e.whole {
+		e.layoutText(e.shaper)
+		return
+	}
+	p, before := e.paragraphs.at(i)
+	bottom := before.height + p.height + p.descent.Ceil()
+	height := p.height
+	// Align the paragraph within the other paragraphs.
+	p.laidOut = false
+	e.paragraphs.update(i)
+	if p.shaped == nil {
+		p.shaped = new(shapedParagraph)
+	}
+	p.shaped.index.reset()
+	p.first = i == 0
+	p.width, p.align = 0, 0
+	if p.runes == 0 && i > 0 && e.shaper != nil {
+		// The empty paragraph ending the text starts at the glyph after the
+		// final newline.
+		prev, _ := e.paragraph(i - 1)
+		g := prev.shaped.end
+		g.Y -= int32(prev.height)
+		p.shaped.index.Glyph(g)
+		p.width, p.align = 0, prev.align
+	} else {
+		e.shapeParagraph(&p.paragraphLayout, before)
+	}
+	if e.shaper != nil {
+		// Text laid out without a shaper keeps the estimated measures.
+		it := textIterator{viewport: image.Rectangle{Max: image.Point{X: math.MaxInt, Y: math.MaxInt}}}
+		for _, g := range p.shaped.index.glyphs {
+			it.processGlyph(g, true)
+		}
+		p.bounds = it.bounds
+		lines := p.shaped.index.lines
+		p.lines, p.height, p.descent = len(lines), 0, 0
+		if n := len(lines); n > 0 {
+			p.height, p.descent = lines[n-1].yOff, lines[n-1].descent
+		}
+	}
+	p.laidOut = true
+	e.paragraphs.update(i)
+	if bottom < e.scrollOff.Y {
+		// Keep the displayed text in place when the estimated height of a
+		// paragraph above it is replaced.
+		e.scrollOff.Y += p.height - height
+	}
+	if n := e.paragraphs.sum().count; i == n-2 {
+		// The empty paragraph ending the text starts after this paragraph.
+		if last, _ := e.paragraphs.at(n - 1); last.runes == 0 && last.laidOut {
+			last.laidOut = false
+			e.paragraphs.update(n - 1)
+		}
+	}
+}
+
+// shapeParagraph shapes and indexes the paragraph p, which follows the
+// paragraphs measured by before. Unless it is the first paragraph of the text, it is shaped
+// after an empty paragraph standing in for the previous paragraph, which
+// positions it as it would be within the entire text.
+func (e *textView) shapeParagraph(p *paragraphLayout, before paragraphSum) {
+	if cap(e.scratch) < p.bytes {
+		e.scratch = make([]byte, p.bytes)
+	}
+	content := e.scratch[:p.bytes]
+	n, _ := e.rr.ReadAt(content, int64(before.bytes))
+	str := string(content[:n])
+	if p.graphemes == nil {
+		p.graphemes = e.segment(str)
+	}
+	if e.shaper == nil {
+		// Make a fake glyph for every rune of the paragraph.
+		for range str {
+			p.shaped.index.Glyph(text.Glyph{Runes: 1, Flags: text.FlagClusterBreak})
+		}
+		return
+	}
+	if e.Mask != 0 {
+		str = strings.Map(func(r rune) rune {
+			if r == '\n' {
+				return r
+			}
+			return e.Mask
+		}, str)
+	}
+	hasEnd := strings.HasSuffix(str, "\n")
+	var spans []text.Span
+	if e.paragraphSpans != nil {
+		spans = e.paragraphSpans(before.runes, str)
+	}
+	if !p.first {
+		str = "\n" + str
+		if len(spans) > 0 {
+			spans[0].Content = "\n" + spans[0].Content
+		}
+	}
+	params := e.params
+	if e.aligned() {
+		// Align the lines within the widest line of the paragraphs laid out.
+		natural := params
+		natural.Alignment, natural.MinWidth = text.Start, 0
+		var m text.Measurement
+		if len(spans) > 0 {
+			m = e.shaper.MeasureSpans(natural, spans)
+		} else {
+			m = e.shaper.Measure(natural, str)
+		}
+		for _, l := range m.Lines {
+			p.width = max(p.width, l.Width.Ceil())
+		}
+		p.align = max(e.alignWidth(), p.width)
+		params.MinWidth = p.align
+	}
+	if len(spans) > 0 {
+		e.shaper.LayoutSpans(params, spans)
+	} else {
+		e.shaper.LayoutString(params, str)
+	}
+	var base int32
+	skip := !p.first
+	var last text.Glyph
+	hasLast := false
+	for g, ok := e.shaper.NextGlyph(); ok; g, ok = e.shaper.NextGlyph() {
+		if skip {
+			// The newline of the empty paragraph is at the baseline of the
+			// previous paragraph.
+			base = g.Y
+			skip = g.Flags&text.FlagParagraphBreak == 0
+			continue
+		}
+		g.Y -= base
+		if hasLast {
+			p.shaped.index.Glyph(last)
+		}
+		last, hasLast = g, true
+	}
+	switch {
+	case !hasLast:
+	case hasEnd:
+		p.shaped.end = last
+	default:
+		p.shaped.index.Glyph(last)
+	}
 }
 
+// layoutText lays out the entire text as its single paragraph.
 func (e *textView) layoutText(lt *text.Shaper) {
+	p, _ := e.paragraphs.at(0)
+	if p.shaped == nil {
+		p.shaped = new(shapedParagraph)
+	}
+	index := &p.shaped.index
+	index.reset()
 	e.Seek(0, io.SeekStart)
 	var r io.Reader = e
 	if e.Mask != 0 {
 		e.maskReader.Reset(e, e.Mask)
 		r = &e.maskReader
 	}
-	e.index.reset()
 	it := textIterator{viewport: image.Rectangle{Max: image.Point{X: math.MaxInt, Y: math.MaxInt}}}
 	if lt != nil {
-		lt.Layout(e.params, r)
+		switch {
+		case len(e.spans) > 0:
+			lt.LayoutSpans(e.params, e.spans)
+		case e.paragraphSpans != nil:
+			content, _ := io.ReadAll(r)
+			if spans := e.paragraphSpans(0, string(content)); len(spans) > 0 {
+				lt.LayoutSpans(e.params, spans)
+			} else {
+				lt.LayoutString(e.params, string(content))
+			}
+		default:
+			lt.Layout(e.params, r)
+		}
 		for {
 			g, ok := lt.NextGlyph()
 			if !it.processGlyph(g, ok) {
 				break
 			}
-			e.index.Glyph(g)
+			index.Glyph(g)
 		}
 	} else {
 		// Make a fake glyph for every rune in the reader.
@@ -499,24 +1217,72 @@ func (e *textView) layoutText(lt *text.Shaper) {
 		for _, _, err := b.ReadRune(); err != io.EOF; _, _, err = b.ReadRune() {
 			g := text.Glyph{Runes: 1, Flags: text.FlagClusterBreak}
 			_ = it.processGlyph(g, true)
-			e.index.Glyph(g)
+			index.Glyph(g)
 		}
 	}
-	e.paragraphReader.SetSource(e.rr)
-	e.graphemes = e.graphemes[:0]
-	for g := e.paragraphReader.Graphemes(); len(g) > 0; g = e.paragraphReader.Graphemes() {
-		if len(e.graphemes) > 0 && g[0] == e.graphemes[len(e.graphemes)-1] {
-			g = g[1:]
+	p.bounds = it.bounds
+	p.lines, p.height, p.descent = len(index.lines), 0, 0
+	if n := len(index.lines); n > 0 {
+		p.height, p.descent = index.lines[n-1].yOff, index.lines[n-1].descent
+	}
+	p.first = true
+	p.laidOut = true
+	e.paragraphs.update(0)
+}
+
+// graphemesOf returns the grapheme cluster boundaries of the paragraph p,
+// which starts at the measures before, segmenting it if needed.
+func (e *textView) graphemesOf(p *paragraph, before paragraphSum) []int {
+	if p.graphemes != nil {
+		return p.graphemes
+	}
+	if e.whole {
+		e.paragraphReader.SetSource(e.rr)
+		graphemes := make([]int, 0, p.runes+1)
+		for g := e.paragraphReader.Graphemes(); len(g) > 0; g = e.paragraphReader.Graphemes() {
+			if len(graphemes) > 0 && g[0] == graphemes[len(graphemes)-1] {
+				g = g[1:]
+			}
+			graphemes = append(graphemes, g...)
 		}
-		e.graphemes = append(e.graphemes, g...)
+		p.graphemes = graphemes
+		return p.graphemes
+	}
+	if cap(e.scratch) < p.bytes {
+		e.scratch = make([]byte, p.bytes)
+	}
+	content := e.scratch[:p.bytes]
+	n, _ := e.rr.ReadAt(content, int64(before.bytes))
+	p.graphemes = e.segment(string(content[:n]))
+	return p.graphemes
+}
+
+// segment returns the grapheme cluster boundaries of the paragraph str, in
+// runes. An empty paragraph has a boundary at its start.
+func (e *textView) segment(str string) []int {
+	graphemes := e.paragraphReader.segment(nil, []rune(str), 0)
+	if len(graphemes) == 0 {
+		graphemes = []int{0}
+	}
+	return graphemes
+}
+
+// endsParagraph reports whether the byte before off is a newline.
+func (e *textView) endsParagraph(off int) bool {
+	if off == 0 {
+		return false
 	}
-	dims := layout.Dimensions{Size: it.bounds.Size()}
-	dims.Baseline = dims.Size.Y - it.baseline
-	e.dims = dims
+	var b [1]byte
+	n, _ := e.rr.ReadAt(b[:], int64(off-1))
+	return n == 1 && b[0] == '\n'
 }
 
-// CaretPos returns the line & column numbers of the caret.
+// CaretPos returns the line & column numbers of the caret. It lays out the
+// paragraphs before the caret to count their lines.
 func (e *textView) CaretPos() (line, col int) {
+	e.makeValid()
+	i, _, _ := e.paragraphAtRune(e.caret.start)
+	e.layoutRange(0, i)
 	pos := e.closestToRune(e.caret.start)
 	return pos.lineCol.line, pos.lineCol.col
 }
@@ -524,7 +1290,7 @@ func (e *textView) CaretPos() (line, col int) {
 // CaretCoords returns the coordinates of the caret, relative to the
 // editor itself.
 func (e *textView) CaretCoords() f32.Point {
-	pos := e.closestToRune(e.caret.start)
+	pos := e.caretPos()
 	return f32.Pt(float32(pos.x)/64-float32(e.scrollOff.X), float32(pos.y-e.scrollOff.Y))
 }
 
@@ -550,6 +1316,15 @@ func (e *textView) indexRune(r int) offEntry {
 func (e *textView) runeOffset(r int) int {
 	const runesPerIndexEntry = 50
 	entry := e.indexRune(r)
+	if !e.whole && e.paragraphs.root != nil {
+		// Start from the paragraph of r, if it is closer.
+		_, _, before := e.paragraphs.find(func(s paragraphSum) bool {
+			return s.runes > r
+		})
+		if before.runes > entry.runes && before.runes <= r {
+			entry = offEntry{runes: before.runes, bytes: before.bytes}
+		}
+	}
 	lastEntry := e.offIndex[len(e.offIndex)-1].runes
 	for entry.runes < r {
 		if entry.runes > lastEntry && entry.runes%runesPerIndexEntry == runesPerIndexEntry-1 {
@@ -562,25 +1337,119 @@ func (e *textView) runeOffset(r int) int {
 	return entry.bytes
 }
 
+// invalidate invalidates the layouts of the paragraphs, and estimates their
+// measures again.
 func (e *textView) invalidate() {
 	e.offIndex = e.offIndex[:0]
+	e.paragraphs.each(func(p *paragraphLayout) {
+		*p = e.estimate(*p)
+		p.laidOut = false
+	})
 	e.valid = false
 }
 
+// invalidateRunes invalidates the layouts of the paragraphs containing the
+// runes [start,end), such as after their spans changed.
+func (e *textView) invalidateRunes(start, end int) {
+	if e.paragraphs.root == nil {
+		return
+	}
+	e.valid = false
+	if e.whole {
+		p, _ := e.paragraphs.at(0)
+		p.laidOut = false
+		e.paragraphs.update(0)
+		return
+	}
+	first, _, _ := e.paragraphs.find(func(s paragraphSum) bool {
+		return s.runes > start
+	})
+	last, _, _ := e.paragraphs.find(func(s paragraphSum) bool {
+		return s.runes > max(end-1, start)
+	})
+	for i := first; i <= last; i++ {
+		if p, _ := e.paragraphs.at(i); p.laidOut {
+			p.laidOut = false
+			e.paragraphs.update(i)
+		}
+	}
+}
+
+// invalidateEdit invalidates the layout after the bytes [start,end) of the
+// text were replaced by n bytes. The paragraphs containing the edit are split
+// again, and the layouts of the other paragraphs remain valid.
+func (e *textView) invalidateEdit(start, end, n int) {
+	e.valid = false
+	i := sort.Search(len(e.offIndex), func(i int) bool {
+		return e.offIndex[i].bytes > start
+	})
+	e.offIndex = e.offIndex[:i]
+	if e.paragraphs.root == nil {
+		return
+	}
+	if e.whole {
+		e.paragraphs.root = nil
+		return
+	}
+	count := e.paragraphs.sum().count
+	first, _, before := e.paragraphs.find(func(s paragraphSum) bool {
+		return s.bytes > start
+	})
+	last, p, beforeLast := e.paragraphs.find(func(s paragraphSum) bool {
+		return s.bytes > max(end-1, start)
+	})
+	replaced := beforeLast.add(p.measure())
+	size := replaced.bytes - before.bytes - (end - start) + n
+	// Only the final paragraph of the text ends without a newline.
+	for last < count-1 && !e.endsParagraph(before.bytes+size) {
+		last++
+		next, _ := e.paragraphs.at(last)
+		replaced = replaced.add(next.measure())
+		size += next.bytes
+	}
+	ps := e.readParagraphs(before.bytes, size, last == count-1)
+	if len(ps) == 1 {
+		// Estimate the paragraph from the paragraphs it replaces, which it
+		// most likely resembles.
+		ps[0].lines = replaced.lines - before.lines
+		ps[0].height = replaced.height - before.height
+		ps[0].descent = replaced.descent
+		ps[0].bounds = image.Rect(0, 0, 0, ps[0].height+ps[0].descent.Ceil())
+	}
+	e.paragraphs.replace(first, last+1, ps)
+	if p, _ := e.paragraphs.at(0); p.laidOut && !p.first {
+		// The first paragraph is laid out differently.
+		p.laidOut = false
+		e.paragraphs.update(0)
+	}
+	if count := e.paragraphs.sum().count; count > 1 {
+		prev, _ := e.paragraphs.at(count - 2)
+		if last, _ := e.paragraphs.at(count - 1); last.runes == 0 && last.laidOut && !prev.laidOut {
+			// The empty paragraph ending the text starts after the paragraph
+			// before it.
+			last.laidOut = false
+			e.paragraphs.update(count - 1)
+		}
+	}
+}
+
 // Replace the text between start and end with s. Indices are in runes.
 // It returns the number of runes inserted.
 func (e *textView) Replace(start, end int, s string) int {
 	if start > end {
 		start, end = end, start
 	}
-	startPos := e.closestToRune(start)
-	endPos := e.closestToRune(end)
+	startPos := combinedPos{runes: e.clampRune(start)}
+	endPos := combinedPos{runes: e.clampRune(end)}
 	startOff := e.runeOffset(startPos.runes)
+	endOff := e.runeOffset(endPos.runes)
 	replaceSize := endPos.runes - startPos.runes
 	sc := utf8.RuneCountInString(s)
 	newEnd := startPos.runes + sc
 
+	size := e.rr.Size()
 	e.rr.ReplaceRunes(int64(startOff), int64(replaceSize), s)
+	inserted := int(e.rr.Size()-size) + endOff - startOff
 	adjust := func(pos int) int {
 		switch {
 		case newEnd < pos && pos <= endPos.runes:
@@ -593,33 +1462,52 @@ func (e *textView) Replace(start, end int, s string) int {
 	}
 	e.caret.start = adjust(e.caret.start)
 	e.caret.end = adjust(e.caret.end)
-	e.invalidate()
+	e.caret.hasVisual = false
+	e.invalidateEdit(startOff, endOff, inserted)
 	return sc
 }
 
 // MovePages moves the caret position by vertical pages of text, ensuring that
 // the final position is aligned to a grapheme cluster boundary.
 func (e *textView) MovePages(pages int, selAct selectionAction) {
-	caret := e.closestToRune(e.caret.start)
+	caret := e.caretPos()
 	x := caret.x + e.caret.xoff
 	y := caret.y + pages*e.viewSize.Y
 	pos := e.closestToXYGraphemes(x, y)
 	e.caret.start = pos.runes
 	e.caret.xoff = x - pos.x
+	e.caret.hasVisual = false
 	e.updateSelection(selAct)
 }
 
 // moveByGraphemes returns the rune index resulting from moving the
 // specified number of grapheme clusters from startRuneidx.
 func (e *textView) moveByGraphemes(startRuneidx, graphemes int) int {
-	if len(e.graphemes) == 0 {
+	e.makeValid()
+	i, p, before := e.paragraphs.find(func(s paragraphSum) bool {
+		return s.runes > startRuneidx
+	})
+	boundaries := e.graphemesOf(p, before)
+	if len(boundaries) == 0 {
 		return startRuneidx
 	}
-	startGraphemeIdx, _ := slices.BinarySearch(e.graphemes, startRuneidx)
-	startGraphemeIdx = max(startGraphemeIdx+graphemes, 0)
-	startGraphemeIdx = min(startGraphemeIdx, len(e.graphemes)-1)
-	startRuneIdx := e.graphemes[startGraphemeIdx]
-	return e.closestToRune(startRuneIdx).runes
+	idx, _ := slices.BinarySearch(boundaries, startRuneidx-before.runes)
+	idx += graphemes
+	// Continue across paragraphs, which share the boundary between them.
+	for idx < 0 && i > 0 {
+		i--
+		p, before = e.paragraphs.at(i)
+		boundaries = e.graphemesOf(p, before)
+		idx += len(boundaries) - 1
+	}
+	for count := e.paragraphs.sum().count; idx >= len(boundaries) && i < count-1; {
+		idx -= len(boundaries) - 1
+		i++
+		p, before = e.paragraphs.at(i)
+		boundaries = e.graphemesOf(p, before)
+	}
+	idx = min(max(idx, 0), len(boundaries)-1)
+	return e.clampRune(before.runes + boundaries[idx])
 }
 
 // clampCursorToGraphemes ensures that the final start/end positions of
@@ -635,16 +1523,110 @@ func (e *textView) clampCursorToGraphemes() {
 // better match the expectations of users than runes.
 func (e *textView) MoveCaret(startDelta, endDelta int) {
 	e.caret.xoff = 0
+	e.caret.hasVisual = false
 	e.caret.start = e.moveByGraphemes(e.caret.start, startDelta)
 	e.caret.end = e.moveByGraphemes(e.caret.end, endDelta)
 }
 
+// MoveVisual moves the caret by distance grapheme clusters in visual order,
+// toward increasing coordinates along the line for positive distances and
+// toward decreasing coordinates for negative distances, regardless of the
+// direction of the text. Movement beyond the edge of a line continues from
+// the opposite edge of the adjacent line in the direction of the text.
+func (e *textView) MoveVisual(distance int, selAct selectionAction) {
+	forward := distance > 0
+	for i := 0; i < abs(distance); i++ {
+		pos, ok := e.visualNeighbor(forward)
+		if !ok {
+			break
+		}
+		e.caret.start = pos.runes
+		e.caret.visual = pos
+		e.caret.hasVisual = true
+	}
+	e.caret.xoff = 0
+	e.updateSelection(selAct)
+}
+
+// visualNeighbor returns the caret position visually adjacent to the caret,
+// as moved to by MoveVisual, and reports whether there is one.
+func (e *textView) visualNeighbor(forward bool) (combinedPos, bool) {
+	e.makeValid()
+	// Lay out the paragraphs next to the caret, whose lines are counted by
+	// estimate until then.
+	i, _, _ := e.paragraphAtRune(e.caret.start)
+	e.layoutRange(i-1, i+1)
+	caret := e.caretPos()
+	if pos, ok := e.visualStep(caret.lineCol.line, caret.inline(), caret.runes, forward); ok {
+		return pos, true
+	}
+	line := caret.lineCol.line - 1
+	if forward == (e.params.Locale.Direction.Progression() == system.FromOrigin) {
+		line = caret.lineCol.line + 1
+	}
+	if line < 0 {
+		return combinedPos{}, false
+	}
+	// Enter the adjacent line from its far edge.
+	from := fixed.Int26_6(math.MinInt32)
+	if !forward {
+		from = math.MaxInt32
+	}
+	return e.visualStep(line, from, caret.runes, forward)
+}
+
+// visualStep is glyphIndex.visualStep for the lines of the text.
+func (e *textView) visualStep(line int, from fixed.Int26_6, near int, forward bool) (combinedPos, bool) {
+	_, p, before := e.layoutFind(func(s paragraphSum) bool {
+		return s.lines > line
+	})
+	isBoundary := func(runes int) bool {
+		return e.isGraphemeBoundary(before.runes + runes)
+	}
+	pos, ok := p.shaped.index.visualStep(line-before.lines, from, near-before.runes, forward, isBoundary)
+	return before.textPos(pos), ok
+}
+
+// isGraphemeBoundary reports whether the rune offset runes is at the boundary
+// of a grapheme cluster.
+func (e *textView) isGraphemeBoundary(runes int) bool {
+	_, p, before := e.paragraphs.find(func(s paragraphSum) bool {
+		return s.runes > runes
+	})
+	boundaries := e.graphemesOf(p, before)
+	if len(boundaries) == 0 {
+		return true
+	}
+	_, found := slices.BinarySearch(boundaries, runes-before.runes)
+	return found
+}
+
+// caretPos returns the position of the caret, preferring the position moved to
+// by MoveVisual when the caret's rune is displayed at several positions.
+func (e *textView) caretPos() combinedPos {
+	e.makeValid()
+	_, p, before := e.paragraphAtRune(e.caret.start)
+	index := &p.shaped.index
+	local, i := index.closestToRune(e.caret.start - before.runes)
+	pos := before.textPos(local)
+	if !e.caret.hasVisual || e.caret.visual.runes != pos.runes {
+		return pos
+	}
+	for ; i < len(index.positions) && index.positions[i].runes == local.runes; i++ {
+		if before.textPos(index.positions[i]) == e.caret.visual {
+			return e.caret.visual
+		}
+	}
+	return pos
+}
+
 // MoveTextStart moves the caret to the start of the text.
 func (e *textView) MoveTextStart(selAct selectionAction) {
 	caret := e.closestToRune(e.caret.end)
 	e.caret.start = 0
 	e.caret.end = caret.runes
 	e.caret.xoff = -caret.x
+	e.caret.hasVisual = false
 	e.updateSelection(selAct)
 	e.clampCursorToGraphemes()
 }
@@ -654,6 +1636,7 @@ func (e *textView) MoveTextEnd(selAct selectionAction) {
 	caret := e.closestToRune(math.MaxInt)
 	e.caret.start = caret.runes
 	e.caret.xoff = fixed.I(e.params.MaxWidth) - caret.x
+	e.caret.hasVisual = false
 	e.updateSelection(selAct)
 	e.clampCursorToGraphemes()
 }
@@ -665,6 +1648,7 @@ func (e *textView) MoveLineStart(selAct selectionAction) {
 	caret = e.closestToLineCol(caret.lineCol.line, 0)
 	e.caret.start = caret.runes
 	e.caret.xoff = -caret.x
+	e.caret.hasVisual = false
 	e.updateSelection(selAct)
 	e.clampCursorToGraphemes()
 }
@@ -676,6 +1660,7 @@ func (e *textView) MoveLineEnd(selAct selectionAction) {
 	caret = e.closestToLineCol(caret.lineCol.line, math.MaxInt)
 	e.caret.start = caret.runes
 	e.caret.xoff = fixed.I(e.params.MaxWidth) - caret.x
+	e.caret.hasVisual = false
 	e.updateSelection(selAct)
 	e.clampCursorToGraphemes()
 }
@@ -727,7 +1712,7 @@ func (e *textView) MoveWord(distance int, selAct selectionAction) {
 }
 
 func (e *textView) ScrollToCaret() {
-	caret := e.closestToRune(e.caret.start)
+	caret := e.caretPos()
 	if e.SingleLine {
 		var dist int
 		if d := caret.x.Floor() - e.scrollOff.X; d < 0 {
@@ -765,8 +1750,9 @@ func (e *textView) Selection() (start, end int) {
 // the two ends are clamped to the nearest grapheme cluster boundary. start
 // and end are in runes, and represent offsets into the editor text.
 func (e *textView) SetCaret(start, end int) {
-	e.caret.start = e.closestToRune(start).runes
-	e.caret.end = e.closestToRune(end).runes
+	e.caret.start = e.clampRune(start)
+	e.caret.end = e.clampRune(end)
+	e.caret.hasVisual = false
 	e.clampCursorToGraphemes()
 }
 
@@ -841,5 +1827,33 @@ func (e *textView) Regions(start, end int, regions []Region) []Region {
 		Min: e.scrollOff,
 		Max: e.viewSize.Add(e.scrollOff),
 	}
-	return e.index.locate(viewport, start, end, regions)
+	return e.locate(viewport, start, end, regions[:0])
+}
+
+// locate is glyphIndex.locate for the paragraphs of the text displayed in
+// viewport.
+func (e *textView) locate(viewport image.Rectangle, start, end int, regions []Region) []Region {
+	if start > end {
+		start, end = end, start
+	}
+	start, end = e.clampRune(start), e.clampRune(end)
+	i, _, _ := e.paragraphAtRune(start)
+	if e.params.Locale.Direction.Axis() == system.Horizontal {
+		// Skip the paragraphs above the viewport.
+		first, _, _ := e.layoutFind(func(s paragraphSum) bool {
+			return s.height+s.descent.Ceil() >= viewport.Min.Y
+		})
+		i = max(i, first)
+	}
+	for n := e.paragraphs.sum().count; i < n; i++ {
+		p, before := e.paragraph(i)
+		if before.runes > end || before.height+p.bounds.Min.Y > viewport.Max.Y {
+			break
+		}
+		// The regions relative to the viewport in the coordinates of the
+		// paragraph are relative to viewport.
+		local := viewport.Sub(image.Pt(0, before.height))
+		regions = p.shaped.index.locate(local, start-before.runes, end-before.runes, regions)
+	}
+	return regions
 }